@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"slices"
 	"sync"
+	"time"
 )
 
 type App struct {
@@ -12,18 +17,69 @@ type App struct {
 	Commands        CommandLog
 	commandHandlers []CommandHandler
 	queryHandlers   []QueryHandler
+
+	Snapshots    SnapshotStore
+	snapshotters map[string]Snapshotter
+	commandSet   string
+
+	// versioned tracks the persistent states of each command handler.
+	versioned map[CommandHandler][]VersionedState
+
+	subscriptions commandSubscriptions
+	modules       moduleRegistry
+
+	// Quarantine makes Replay skip commands that fail to replay and
+	// quarantine them in the command log, so that the next replay succeeds.
+	Quarantine     bool
+	replayFailures []*ReplayFailure
+
+	// Primary receives all commands if set, making this app a follower
+	// that only replays the commands the primary appended.
+	Primary CommandForwarder
+
+	// replayed is set once Replay succeeded; replayError is the error of the last Replay.
+	replayed    bool
+	replayError error
+
+	Metrics *Metrics
+	Logger  *slog.Logger
 }
 
 func NewApp(log CommandLog) *App {
-	return &App{
+	app := &App{
 		version:         0,
 		Commands:        log,
-		commandHandlers: []CommandHandler{&SkipHandler{}},
+		commandHandlers: []CommandHandler{},
 		queryHandlers:   []QueryHandler{},
+		snapshotters:    map[string]Snapshotter{},
+		commandSet:      DefaultCommandRegistry.Fingerprint(),
+		versioned:       map[CommandHandler][]VersionedState{},
+		Metrics:         NewMetrics(),
+		Logger:          slog.Default(),
+	}
+	if err := app.Mount(&SkipHandler{}); err != nil {
+		panic(err)
 	}
+	return app
 }
 
-func (app *App) Mount(m interface{}) *App {
+// Mount adds m to the modules handling commands and queries.
+//
+// Modules handling commands or queries need to declare which ones they
+// own; mounting fails if another module owns any of them already.
+func (app *App) Mount(m interface{}) error {
+	_, handlesCommands := m.(CommandHandler)
+	_, handlesQueries := m.(QueryHandler)
+	if handlesCommands || handlesQueries {
+		owner, ok := m.(Owner)
+		if !ok {
+			return fmt.Errorf("failed to mount %T: %w", m, ErrUndeclaredOwnership)
+		}
+		if err := app.declare(owner.Ownership()); err != nil {
+			return fmt.Errorf("failed to mount %T: %w", m, err)
+		}
+	}
+
 	if commandHandler, ok := m.(CommandHandler); ok {
 		app.commandHandlers = append(app.commandHandlers, commandHandler)
 	}
@@ -32,64 +88,371 @@ func (app *App) Mount(m interface{}) *App {
 		app.queryHandlers = append(app.queryHandlers, queryHandler)
 	}
 
-	return app
+	if inspectable, ok := m.(interface{ Inspect() map[string]any }); ok {
+		for name, state := range inspectable.Inspect() {
+			if snapshotter, ok := state.(Snapshotter); ok {
+				app.snapshotters[name] = snapshotter
+			}
+			if versioned, ok := state.(VersionedState); ok {
+				if commandHandler, ok := m.(CommandHandler); ok {
+					app.versioned[commandHandler] = append(app.versioned[commandHandler], versioned)
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
 func (app *App) Replay(skipErrors bool) error {
 	app.lock.Lock()
 	defer app.lock.Unlock()
 
+	started, from := time.Now(), app.version
+	err := app.replay(skipErrors)
+	app.replayError = err
+	if err != nil {
+		app.Logger.Error("failed to replay commands", "version", app.version, "error", err)
+		return err
+	}
+	app.replayed = true
+	if app.version != from {
+		app.Logger.Info("replayed commands", "from", from, "to", app.version, "duration", time.Since(started))
+	}
+	return nil
+}
+
+// replay applies all commands the app has not seen yet.  The caller must hold the lock.
+func (app *App) replay(skipErrors bool) error {
+	handledUpTo := map[CommandHandler]int{}
+	if app.version == 0 {
+		if err := app.restoreLatestSnapshot(); err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		var err error
+		handledUpTo, err = app.persistedVersions()
+		if err != nil {
+			return fmt.Errorf("failed to replay commands: %w", err)
+		}
+		for _, version := range handledUpTo {
+			app.version = min(app.version, version)
+		}
+	}
+
+	start := app.version
 	commands, err := app.Commands.After(app.version)
 	if err != nil {
 		return fmt.Errorf("failed to replay commands: %w", err)
 	}
+	toQuarantine := []*ReplayFailure{}
+	unrecorded := map[int][]Event{}
 	for command := range commands {
+		if undecodable, ok := command.Message.(*UndecodableCommand); ok {
+			app.recordReplayFailure(command, nil, undecodable.Err)
+			return fmt.Errorf("failed to decode command %d: %w", command.ID, undecodable.Err)
+		}
+		events, skipped, recorded := []Event{}, false, command.Events != nil
 		for _, handler := range app.commandHandlers {
-			err := handler.HandleCommand(command.Message)
+			if command.ID <= handledUpTo[handler] {
+				skipped = true
+				continue
+			}
+			// Persistent states only keep changes of commands whose events
+			// have been recorded, like they do when the command is appended.
+			var record func(events []Event) error
+			if !recorded && len(app.versioned[handler]) > 0 {
+				record = func(events []Event) error {
+					recorded = true
+					return app.recordEvents(map[int][]Event{command.ID: append([]Event{}, events...)})
+				}
+			}
+			emitted, err := app.handle(handler, command.ID, command.Message, record)
 			if err == ErrCommandNotAccepted {
 				continue
 			}
 			if err != nil {
-				fmt.Printf("failed to replay command: %s", err)
-				if !skipErrors {
-					return err
+				failure := app.recordReplayFailure(command, handler, err)
+				if app.Quarantine {
+					toQuarantine = append(toQuarantine, failure)
+				} else if !skipErrors {
+					return fmt.Errorf("failed to replay command %d: %w", command.ID, err)
 				}
+				continue
+			}
+			events = append(events, emitted...)
+		}
+		// Commands appended before events were recorded get them now.  If
+		// a handler's persistent state had already applied them, they
+		// cannot be derived anymore, but background processes waiting for
+		// them must not wait forever.
+		if !recorded {
+			if skipped {
+				app.Logger.Warn("recording no events for command applied before", "command_id", command.ID)
+				events = []Event{}
 			}
+			unrecorded[command.ID] = events
 		}
 		app.version = command.ID
 	}
+	if err := app.quarantine(toQuarantine); err != nil {
+		return err
+	}
+	if err := app.recordEvents(unrecorded); err != nil {
+		return err
+	}
+
+	if app.version != start {
+		return app.recordVersion(nil)
+	}
+	return nil
+}
+
+// persistedVersions returns the version of every command handler whose
+// state survives restarts.
+//
+// The version of all other command handlers is the app's version.
+func (app *App) persistedVersions() (map[CommandHandler]int, error) {
+	result := map[CommandHandler]int{}
+	for _, handler := range app.commandHandlers {
+		result[handler] = app.version
+	}
+	if len(app.versioned) == 0 {
+		return result, nil
+	}
+
+	length, err := app.Commands.Length()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine length of command log: %w", err)
+	}
+	for handler, states := range app.versioned {
+		for i, state := range states {
+			version, err := state.Version()
+			if err != nil {
+				return nil, fmt.Errorf("failed to determine version of %T: %w", state, err)
+			}
+			if version > length {
+				return nil, fmt.Errorf("%T is at version %d, but command log ends at %d", state, version, length)
+			}
+			if i == 0 || version < result[handler] {
+				result[handler] = version
+			}
+		}
+	}
+	return result, nil
+}
+
+// handle hands message to handler and returns the events it emitted.
+//
+// The changes handler makes to its persistent states are committed
+// together with id as their version, or discarded if handler fails.
+// If record is set, it is called with the events before committing, so
+// that no persistent state keeps changes whose events were lost.
+func (app *App) handle(handler CommandHandler, id int, message Command, record func(events []Event) error) ([]Event, error) {
+	states := app.versioned[handler]
+	rollback := func() {
+		for _, state := range states {
+			state.Rollback()
+		}
+	}
+	for _, state := range states {
+		if err := state.Begin(); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to begin changes of %T: %w", state, err)
+		}
+	}
+
+	err := handler.HandleCommand(message)
+	events := takeEvents(handler)
+	if err != nil {
+		rollback()
+		return events, err
+	}
+	if record != nil {
+		if err := record(events); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	for _, state := range states {
+		if err := state.Commit(id); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to commit changes of %T: %w", state, err)
+		}
+	}
+	return events, nil
+}
+
+// recordVersion stores the app's version in the persistent states of handler,
+// or in all persistent states if handler is nil.
+func (app *App) recordVersion(handler CommandHandler) error {
+	for h, states := range app.versioned {
+		if handler != nil && h != handler {
+			continue
+		}
+		for _, state := range states {
+			if err := state.SetVersion(app.version); err != nil {
+				return fmt.Errorf("failed to record version of %T: %w", state, err)
+			}
+		}
+	}
 	return nil
 }
 
 func (app *App) HandleCommand(message Command) error {
+	return app.HandleCommandWithMetadata(message, nil)
+}
+
+// maxAppendAttempts limits how often HandleCommandWithMetadata catches up
+// with commands appended by other processes before giving up.
+const maxAppendAttempts = 10
+
+// HandleCommandWithMetadata handles message like HandleCommand and stores
+// metadata alongside it in the command log.
+//
+// The time the command is recorded at is filled in if metadata does not specify it.
+//
+// The command is validated against the app's current state and only
+// appended if no other process has appended commands in the meantime.
+// Otherwise the missing commands are replayed and the command is validated
+// again, so that two processes sharing a log cannot both accept
+// conflicting commands.
+//
+// If the app is a follower, the command is forwarded to the primary instead.
+func (app *App) HandleCommandWithMetadata(message Command, metadata *CommandMetadata) error {
+	var err error
+	started, id := time.Now(), 0
+	if app.Primary != nil {
+		err = app.forward(message, metadata)
+	} else {
+		id, err = app.appendCommand(message, metadata)
+	}
+	result := "accepted"
+	if err != nil {
+		result = "rejected"
+	}
+	app.Metrics.Count("orange_commands_handled_total", "Commands handled, by type and whether they were accepted.",
+		"command", message.CommandName(), "result", result)
+	app.Logger.Debug("handled command", "command_id", id, "command_name", message.CommandName(),
+		"result", result, "duration", time.Since(started), "error", err)
+	return err
+}
+
+// appendCommand appends message to the command log, applies it and returns its ID.
+func (app *App) appendCommand(message Command, metadata *CommandMetadata) (int, error) {
 	app.lock.Lock()
 	defer app.lock.Unlock()
 
+	recorded := CommandMetadata{}
+	if metadata != nil {
+		recorded = *metadata
+	}
+	if recorded.RecordedAt.IsZero() {
+		recorded.RecordedAt = time.Now()
+	}
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		acceptedBy, err := app.validate(message)
+		if err != nil {
+			// The command might be valid given commands the app has not seen yet.
+			if caughtUp, catchUpErr := app.catchUp(); catchUpErr != nil {
+				return 0, fmt.Errorf("failed to catch up with command log: %w", catchUpErr)
+			} else if caughtUp {
+				continue
+			}
+			return 0, fmt.Errorf("failed to handle command: %w", err)
+		}
+
+		err = app.Commands.AppendIfVersion(app.version, message, &recorded)
+		if errors.Is(err, ErrVersionConflict) {
+			if _, err := app.catchUp(); err != nil {
+				return 0, fmt.Errorf("failed to catch up with command log: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to append command: %w", err)
+		}
+
+		app.version += 1
+		// Subscribers are woken only after the command's events have been recorded.
+		err = app.apply(app.version, message, acceptedBy)
+		app.wakeSubscribers()
+		return app.version, err
+	}
+
+	return 0, fmt.Errorf("failed to append command after %d attempts: %w", maxAppendAttempts, ErrVersionConflict)
+}
+
+// catchUp replays commands appended by other processes and reports whether there were any.
+func (app *App) catchUp() (bool, error) {
+	length, err := app.Commands.Length()
+	if err != nil {
+		return false, fmt.Errorf("failed to determine length of command log: %w", err)
+	}
+	if length <= app.version {
+		return false, nil
+	}
+	return true, app.replay(false)
+}
+
+// validate returns the command handler that accepts message, or nil if no
+// handler that can validate commands accepts it.
+func (app *App) validate(message Command) (CommandHandler, error) {
 	for _, handler := range app.commandHandlers {
-		err := handler.HandleCommand(message)
-		if err == ErrCommandNotAccepted {
+		validator, ok := handler.(CommandValidator)
+		if !ok {
 			continue
 		}
-		if err == nil {
-			break
+		err := validator.ValidateCommand(message)
+		if err == ErrCommandNotAccepted {
+			continue
 		}
-		if err != ErrCommandNotAccepted {
-			return fmt.Errorf("failed to handle command: %w", err)
+		if err != nil {
+			return nil, err
 		}
+		return handler, nil
 	}
+	return nil, nil
+}
 
-	if err := app.Commands.Append(message); err != nil {
-		return fmt.Errorf("failed to append command: %w", err)
+// apply hands an appended message to the handler that accepted it and
+// records the events it emitted next to the message.
+//
+// Messages no handler validated are offered to the command handlers that
+// cannot validate commands, if any.
+func (app *App) apply(id int, message Command, acceptedBy CommandHandler) error {
+	handlers := []CommandHandler{acceptedBy}
+	if acceptedBy == nil {
+		handlers = []CommandHandler{}
+		for _, handler := range app.commandHandlers {
+			if _, ok := handler.(CommandValidator); !ok {
+				handlers = append(handlers, handler)
+			}
+		}
 	}
 
-	app.version += 1
-
-	return nil
+	for _, handler := range handlers {
+		_, err := app.handle(handler, id, message, func(events []Event) error {
+			return app.recordEvents(map[int][]Event{id: append([]Event{}, events...)})
+		})
+		if err == ErrCommandNotAccepted {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to handle command: %w", err)
+		}
+		return nil
+	}
+	return app.recordEvents(map[int][]Event{id: {}})
 }
 
 func (app *App) HandleQuery(query Query) error {
 	app.lock.RLock()
 	defer app.lock.RUnlock()
+	defer func(start time.Time) {
+		app.Metrics.Observe("orange_query_duration_seconds", "Time spent handling queries, by type.",
+			time.Since(start).Seconds(), "query", query.QueryName())
+	}(time.Now())
 
 	for _, handler := range app.queryHandlers {
 		err := handler.HandleQuery(query)
@@ -104,6 +467,51 @@ func (app *App) HandleQuery(query Query) error {
 	return ErrQueryNotAccepted
 }
 
+// ReviseCommands replaces the commands with the given ids as the command log's
+// CommandReviser does, and drops all snapshots covering any of them, since
+// those were derived from the history before the revision.
+func (app *App) ReviseCommands(ids []int, as func(id int) Command) error {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	reviser, ok := app.Commands.(CommandReviser)
+	if !ok {
+		return fmt.Errorf("Command log type %T does not support revision", app.Commands)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := reviser.ReviseCommands(ids, as); err != nil {
+		return err
+	}
+	if app.Snapshots == nil {
+		return nil
+	}
+	if err := app.Snapshots.DropFrom(slices.Min(ids)); err != nil {
+		return fmt.Errorf("failed to drop snapshots: %w", err)
+	}
+	return nil
+}
+
+// Close releases the resources held by the command log and all persistent states.
+func (app *App) Close() error {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	errs := []error{}
+	if closer, ok := app.Commands.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	for _, states := range app.versioned {
+		for _, state := range states {
+			if closer, ok := state.(io.Closer); ok {
+				errs = append(errs, closer.Close())
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (app *App) ExposeState(globals map[string]any) {
 	for _, handler := range app.queryHandlers {
 		if state, ok := handler.(interface{ Inspect() map[string]any }); ok {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event is a fact that resulted from handling a command, e.g. that a
+// comment was posted in reply to another user's comment.
+//
+// Unlike commands, events carry what was derived from the state at the
+// time the command was handled, so that background processes do not
+// need to derive it again from the raw command.
+type Event interface {
+	EventName() string
+}
+
+// EventEmitter is implemented by command handlers that emit events
+// while handling commands.
+type EventEmitter interface {
+	// TakeEvents returns the events emitted since it was last called.
+	TakeEvents() []Event
+}
+
+// EventBuffer collects the events a module emits until the App takes them.
+//
+// Modules embed it to implement EventEmitter.
+type EventBuffer struct {
+	pending []Event
+}
+
+func (b *EventBuffer) Emit(event Event) {
+	b.pending = append(b.pending, event)
+}
+
+func (b *EventBuffer) TakeEvents() []Event {
+	events := b.pending
+	b.pending = nil
+	return events
+}
+
+// EventRecorder is implemented by command logs that store events next to
+// the command they resulted from.
+//
+// Commands whose events have not been recorded yet have nil Events when
+// read from the log; commands without events have an empty list.
+type EventRecorder interface {
+	// RecordEvents stores the events of each command, by ID, replacing any recorded earlier.
+	RecordEvents(events map[int][]Event) error
+}
+
+type NewEvent func() Event
+
+type EventRegistry map[string]NewEvent
+
+func (s EventRegistry) Register(name string, newEvent NewEvent) {
+	s[name] = newEvent
+}
+
+var DefaultEventRegistry = make(EventRegistry)
+
+// UndecodableEvent stands in for a recorded event that could not be decoded.
+type UndecodableEvent struct {
+	Raw []byte
+	Err error
+}
+
+func (e *UndecodableEvent) EventName() string { return "UndecodableEvent" }
+
+// EncodeEvents encodes events as a JSON list of their types and payloads.
+func EncodeEvents(events []Event) (json.RawMessage, error) {
+	encoded := make([]RawJSONMessage, len(events))
+	for i, event := range events {
+		if undecodable, ok := event.(*UndecodableEvent); ok {
+			if err := json.Unmarshal(undecodable.Raw, &encoded[i]); err != nil {
+				return nil, fmt.Errorf("failed to encode %s: %w", event.EventName(), err)
+			}
+			continue
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", event.EventName(), err)
+		}
+		encoded[i] = RawJSONMessage{Type: event.EventName(), Message: payload}
+	}
+	return json.Marshal(encoded)
+}
+
+// DecodeEvents decodes events encoded by EncodeEvents.
+//
+// It returns nil for empty data, i.e. events that have not been recorded.
+// Events of an unknown type are returned as UndecodableEvent.
+func DecodeEvents(data []byte) ([]Event, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal events: %w", err)
+	}
+	events := make([]Event, len(raw))
+	for i, data := range raw {
+		events[i] = decodeEvent(data)
+	}
+	return events, nil
+}
+
+func decodeEvent(data json.RawMessage) Event {
+	m := &RawJSONMessage{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return &UndecodableEvent{Raw: data, Err: err}
+	}
+	newEvent, ok := DefaultEventRegistry[m.Type]
+	if !ok {
+		return &UndecodableEvent{Raw: data, Err: fmt.Errorf("%q: %w", m.Type, ErrUnknownEventType)}
+	}
+	event := newEvent()
+	if err := json.Unmarshal(m.Message, event); err != nil {
+		return &UndecodableEvent{Raw: data, Err: fmt.Errorf("failed to unmarshal %s: %w", m.Type, err)}
+	}
+	return event
+}
+
+var ErrUnknownEventType = fmt.Errorf("unknown event type")
+
+// takeEvents returns the events handler emitted, or nil if it does not emit events.
+func takeEvents(handler CommandHandler) []Event {
+	if emitter, ok := handler.(EventEmitter); ok {
+		return emitter.TakeEvents()
+	}
+	return nil
+}
+
+// recordEvents stores the events of commands in the command log, if it can store them.
+//
+// Followers leave recording events to the primary.
+func (app *App) recordEvents(events map[int][]Event) error {
+	recorder, ok := app.Commands.(EventRecorder)
+	if !ok || app.Primary != nil || len(events) == 0 {
+		return nil
+	}
+	if err := recorder.RecordEvents(events); err != nil {
+		return fmt.Errorf("failed to record events: %w", err)
+	}
+	return nil
+}
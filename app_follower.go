@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// CommandForwarder hands commands to the primary process, which appends
+// them to the command log on behalf of followers.
+type CommandForwarder interface {
+	// ForwardCommand returns the ID the primary appended command with.
+	ForwardCommand(command Command, metadata *CommandMetadata) (int, error)
+}
+
+// ErrRejectedByPrimary is returned for commands the primary did not append.
+var ErrRejectedByPrimary = errors.New("rejected by primary")
+
+// ErrNotLoopback is returned when the primary would accept commands from other hosts.
+var ErrNotLoopback = errors.New("address is not on the loopback interface")
+
+// ForwardedCommandTimeout limits how long a follower waits for its replay
+// to reach a command the primary appended.
+var ForwardedCommandTimeout = 10 * time.Second
+
+// followerPollInterval is how often a follower checks the command log
+// while waiting for a forwarded command.
+const followerPollInterval = 10 * time.Millisecond
+
+// forward validates message against the follower's state, so that callers
+// get the same errors as on the primary, and hands it to the primary.
+//
+// It returns once the follower has replayed the appended command, so that
+// subsequent queries observe it.
+func (app *App) forward(message Command, metadata *CommandMetadata) error {
+	app.lock.Lock()
+	_, err := app.catchUp()
+	if err != nil {
+		app.lock.Unlock()
+		return fmt.Errorf("failed to catch up with command log: %w", err)
+	}
+	_, err = app.validate(message)
+	app.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to handle command: %w", err)
+	}
+
+	id, err := app.Primary.ForwardCommand(message, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to forward command: %w", err)
+	}
+	return app.waitForVersion(id)
+}
+
+// waitForVersion replays the command log until the app has seen the command with the given ID.
+func (app *App) waitForVersion(id int) error {
+	deadline := time.Now().Add(ForwardedCommandTimeout)
+	for {
+		app.lock.Lock()
+		_, err := app.catchUp()
+		reached := app.version >= id
+		app.lock.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to catch up with command log: %w", err)
+		}
+		if reached {
+			app.wakeSubscribers()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("command %d did not show up in the command log within %s", id, ForwardedCommandTimeout)
+		}
+		time.Sleep(followerPollInterval)
+	}
+}
+
+// forwardedCommand is the body of a request forwarding a command to the primary.
+type forwardedCommand struct {
+	Message  json.RawMessage  `json:"message"`
+	Metadata *CommandMetadata `json:"metadata,omitempty"`
+}
+
+// forwardedCommandResult is the primary's response to a forwarded command.
+type forwardedCommandResult struct {
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// HTTPCommandForwarder forwards commands to a CommandReceiver, either over
+// TCP (http://host:port) or a unix socket (unix:///path/to/socket).
+type HTTPCommandForwarder struct {
+	client     *http.Client
+	endpoint   string
+	serializer Serializer
+}
+
+func NewHTTPCommandForwarder(primary *url.URL, serializer Serializer) *HTTPCommandForwarder {
+	forwarder := &HTTPCommandForwarder{
+		client:     &http.Client{Timeout: ForwardedCommandTimeout},
+		endpoint:   primary.JoinPath("commands").String(),
+		serializer: serializer,
+	}
+	if primary.Scheme == "unix" {
+		socket := toFilePath(primary)
+		forwarder.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+		forwarder.endpoint = "http://primary/commands"
+	}
+	return forwarder
+}
+
+func (f *HTTPCommandForwarder) ForwardCommand(command Command, metadata *CommandMetadata) (int, error) {
+	message, err := f.serializer.Encode(command)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s: %w", command.CommandName(), err)
+	}
+	body, err := json.Marshal(&forwardedCommand{Message: message, Metadata: metadata})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s: %w", command.CommandName(), err)
+	}
+	res, err := f.client.Post(f.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	result := &forwardedCommandResult{}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return 0, fmt.Errorf("failed to decode response (%s): %w", res.Status, err)
+	}
+	if result.Error != "" {
+		return 0, fmt.Errorf("%w: %s", ErrRejectedByPrimary, result.Error)
+	}
+	return result.ID, nil
+}
+
+// CommandReceiver appends the commands forwarded by followers to the primary's command log.
+type CommandReceiver struct {
+	app        *App
+	serializer Serializer
+	logger     *slog.Logger
+}
+
+func NewCommandReceiver(app *App, serializer Serializer, logger *slog.Logger) *CommandReceiver {
+	return &CommandReceiver{app: app, serializer: serializer, logger: logger}
+}
+
+func (r *CommandReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		r.respond(w, http.StatusMethodNotAllowed, &forwardedCommandResult{Error: "method not allowed"})
+		return
+	}
+	forwarded := &forwardedCommand{}
+	if err := json.NewDecoder(req.Body).Decode(forwarded); err != nil {
+		r.respond(w, http.StatusBadRequest, &forwardedCommandResult{Error: err.Error()})
+		return
+	}
+	var command Command
+	if err := r.serializer.Decode(forwarded.Message, &command); err != nil {
+		r.respond(w, http.StatusBadRequest, &forwardedCommandResult{Error: err.Error()})
+		return
+	}
+	id, err := r.app.appendCommand(command, forwarded.Metadata)
+	if err != nil {
+		r.logger.Warn("rejected command", "command_name", command.CommandName(), "error", err)
+		r.respond(w, http.StatusConflict, &forwardedCommandResult{Error: err.Error()})
+		return
+	}
+	r.respond(w, http.StatusOK, &forwardedCommandResult{ID: id})
+}
+
+func (r *CommandReceiver) respond(w http.ResponseWriter, status int, result *forwardedCommandResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// ListenForFollowers listens for commands forwarded by followers on the address of primary.
+//
+// The CommandReceiver does not authenticate followers, so TCP addresses
+// need to be on the loopback interface; followers on other hosts can use
+// a tunnel to it.
+func ListenForFollowers(primary *url.URL) (net.Listener, error) {
+	network, address := "tcp", primary.Host
+	if primary.Scheme != "unix" && !isLoopback(primary.Hostname()) {
+		return nil, fmt.Errorf("refusing to accept commands on %s: %w", primary, ErrNotLoopback)
+	}
+	if primary.Scheme == "unix" {
+		network, address = "unix", toFilePath(primary)
+		// A socket left behind by a previous primary prevents listening.
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", primary, err)
+	}
+	return listener, nil
+}
+
+// isLoopback reports whether host only resolves to the loopback interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
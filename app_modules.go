@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Ownership lists by name the commands and queries a module is
+// responsible for, the events it emits, and the commands and events
+// owned by other modules it reacts to.
+type Ownership struct {
+	Module   string
+	Commands []string
+	Queries  []string
+	Events   []string
+	Consumes []string
+}
+
+// Owner is implemented by modules that declare their Ownership.
+//
+// Every module mounted in an App to handle commands or queries needs to be an Owner.
+type Owner interface {
+	Ownership() *Ownership
+}
+
+var (
+	ErrUndeclaredOwnership = errors.New("module does not declare the commands and queries it owns")
+	ErrOwnershipConflict   = errors.New("owned by another module")
+)
+
+// moduleRegistry keeps track of which module owns which command and query.
+type moduleRegistry struct {
+	modules       []*Ownership
+	commandOwners map[string]string
+	queryOwners   map[string]string
+	eventOwners   map[string]string
+}
+
+// Declare records the ownership of a module that is not mounted,
+// e.g. a background process consuming the command log on its own.
+func (app *App) Declare(owner Owner) error {
+	return app.declare(owner.Ownership())
+}
+
+// declare records ownership, unless a command, query or event is already
+// owned by another module, or a command or event is not registered.
+func (app *App) declare(ownership *Ownership) error {
+	registry := &app.modules
+	if registry.commandOwners == nil {
+		registry.commandOwners = map[string]string{}
+		registry.queryOwners = map[string]string{}
+		registry.eventOwners = map[string]string{}
+	}
+
+	errs := []error{}
+	for _, name := range ownership.Commands {
+		if _, ok := DefaultCommandRegistry[name]; !ok {
+			errs = append(errs, fmt.Errorf("command %s: %w", name, ErrUnknownCommandType))
+		}
+	}
+	for _, name := range ownership.Events {
+		if _, ok := DefaultEventRegistry[name]; !ok {
+			errs = append(errs, fmt.Errorf("event %s: %w", name, ErrUnknownEventType))
+		}
+	}
+	for _, name := range ownership.Consumes {
+		_, isCommand := DefaultCommandRegistry[name]
+		_, isEvent := DefaultEventRegistry[name]
+		if !isCommand && !isEvent {
+			errs = append(errs, fmt.Errorf("command or event %s: %w", name, ErrUnknownCommandType))
+		}
+	}
+	for _, name := range ownership.Commands {
+		if owner, ok := registry.commandOwners[name]; ok {
+			errs = append(errs, fmt.Errorf("command %s: %w %s", name, ErrOwnershipConflict, owner))
+		}
+	}
+	for _, name := range ownership.Queries {
+		if owner, ok := registry.queryOwners[name]; ok {
+			errs = append(errs, fmt.Errorf("query %s: %w %s", name, ErrOwnershipConflict, owner))
+		}
+	}
+	for _, name := range ownership.Events {
+		if owner, ok := registry.eventOwners[name]; ok {
+			errs = append(errs, fmt.Errorf("event %s: %w %s", name, ErrOwnershipConflict, owner))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("module %s: %w", ownership.Module, err)
+	}
+
+	for _, name := range ownership.Commands {
+		registry.commandOwners[name] = ownership.Module
+	}
+	for _, name := range ownership.Queries {
+		registry.queryOwners[name] = ownership.Module
+	}
+	for _, name := range ownership.Events {
+		registry.eventOwners[name] = ownership.Module
+	}
+	registry.modules = append(registry.modules, ownership)
+	return nil
+}
+
+// Modules returns the ownership of all mounted and declared modules, in the order they were added.
+func (app *App) Modules() []*Ownership {
+	return slices.Clone(app.modules.modules)
+}
+
+// UnownedCommands returns the names of registered commands no module owns.
+func (app *App) UnownedCommands() []string {
+	unowned := []string{}
+	for name := range DefaultCommandRegistry {
+		if _, ok := app.modules.commandOwners[name]; !ok {
+			unowned = append(unowned, name)
+		}
+	}
+	slices.Sort(unowned)
+	return unowned
+}
+
+// WriteTo prints the ownership of every module, one line per kind.
+func (o *Ownership) WriteTo(out io.Writer) (int64, error) {
+	written := int64(0)
+	n, err := fmt.Fprintf(out, "%s\n", o.Module)
+	written += int64(n)
+	for _, kind := range []struct {
+		name  string
+		names []string
+	}{
+		{"commands", o.Commands},
+		{"queries", o.Queries},
+		{"events", o.Events},
+		{"consumes", o.Consumes},
+	} {
+		if err != nil || len(kind.names) == 0 {
+			continue
+		}
+		names := slices.Sorted(slices.Values(kind.names))
+		n, err = fmt.Fprintf(out, "  %s: %s\n", kind.name, strings.Join(names, ", "))
+		written += int64(n)
+	}
+	return written, err
+}
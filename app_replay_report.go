@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ReplayFailure describes a command that a handler failed to apply during replay.
+type ReplayFailure struct {
+	CommandID   int
+	CommandName string
+	// Handler is the type of the command handler that failed, or empty if the command could not be decoded.
+	Handler     string
+	Error       string
+	FailedAt    time.Time
+	Quarantined bool
+}
+
+func (f *ReplayFailure) String() string {
+	handler := f.Handler
+	if handler == "" {
+		handler = "decoding"
+	}
+	result := fmt.Sprintf("#%d %s in %s: %s", f.CommandID, f.CommandName, handler, f.Error)
+	if f.Quarantined {
+		result += " (quarantined)"
+	}
+	return result
+}
+
+// QuarantinedCommand takes the place of a command that failed to replay,
+// until an operator has reviewed it.
+//
+// The original command stays in the log: unskipping the entry restores it.
+type QuarantinedCommand struct {
+	// Command is the name of the quarantined command.
+	Command       string
+	Handler       string
+	Error         string
+	QuarantinedAt time.Time
+}
+
+func (cmd *QuarantinedCommand) CommandName() string { return "QuarantinedCommand" }
+
+func init() {
+	DefaultCommandRegistry.Register("QuarantinedCommand", func() Command { return &QuarantinedCommand{} })
+}
+
+// recordReplayFailure adds a failure to the replay report.  The caller must hold the lock.
+func (app *App) recordReplayFailure(command *PersistedCommand, handler CommandHandler, err error) *ReplayFailure {
+	failure := &ReplayFailure{
+		CommandID:   command.ID,
+		CommandName: command.Message.CommandName(),
+		Error:       err.Error(),
+		FailedAt:    time.Now(),
+	}
+	if handler != nil {
+		failure.Handler = fmt.Sprintf("%T", handler)
+	}
+	app.replayFailures = append(app.replayFailures, failure)
+	app.Logger.Error("failed to replay command", "command_id", failure.CommandID, "command_name", failure.CommandName, "handler", failure.Handler, "error", failure.Error)
+	return failure
+}
+
+// quarantine replaces the commands that failed to replay with QuarantinedCommands,
+// so that they are skipped from now on.  The caller must hold the lock.
+func (app *App) quarantine(failures []*ReplayFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	reviser, ok := app.Commands.(CommandReviser)
+	if !ok {
+		return fmt.Errorf("command log type %T does not support quarantining commands", app.Commands)
+	}
+	byID := map[int]*ReplayFailure{}
+	ids := []int{}
+	for _, failure := range failures {
+		if _, found := byID[failure.CommandID]; found {
+			continue
+		}
+		byID[failure.CommandID] = failure
+		ids = append(ids, failure.CommandID)
+	}
+	err := reviser.ReviseCommands(ids, func(id int) Command {
+		failure := byID[id]
+		return &QuarantinedCommand{
+			Command:       failure.CommandName,
+			Handler:       failure.Handler,
+			Error:         failure.Error,
+			QuarantinedAt: failure.FailedAt,
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to quarantine commands: %w", err)
+	}
+	for _, failure := range failures {
+		failure.Quarantined = true
+	}
+	return nil
+}
+
+// ReplayFailures returns all failures encountered while replaying commands since the app was started.
+func (app *App) ReplayFailures() []*ReplayFailure {
+	app.lock.RLock()
+	defer app.lock.RUnlock()
+
+	result := make([]*ReplayFailure, len(app.replayFailures))
+	copy(result, app.replayFailures)
+	return result
+}
+
+// QuarantinedCommands returns all entries of the command log that are currently quarantined.
+func (app *App) QuarantinedCommands() ([]*PersistedCommand, error) {
+	commands, err := app.Commands.After(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	result := []*PersistedCommand{}
+	for command := range commands {
+		if _, ok := command.Message.(*QuarantinedCommand); ok {
+			result = append(result, command)
+		}
+	}
+	return result, nil
+}
+
+// replayInMemory replays the command log stored in filename into fresh, in-memory modules.
+//
+// The app only gets a read-only view of the log, so that replaying leaves
+// the file untouched.  The returned app needs to be closed, even if
+// replaying fails.
+func replayInMemory(filename string, skipErrors bool) (*App, error) {
+	config := DefaultPlatformConfig()
+	config.CommandLog = &url.URL{Scheme: "file", Path: "/" + filename}
+	config.Snapshots = parseURL("none://", "Snapshots")
+	app, _ := HackerNews(config)
+	app.Commands = readOnly(app.Commands)
+	if err := app.Replay(skipErrors); err != nil {
+		return app, fmt.Errorf("failed to replay %s: %w", filename, err)
+	}
+	return app, nil
+}
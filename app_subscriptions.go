@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CommandPollInterval is how often background processes check the
+// command log for commands they have not been notified about.
+//
+// Commands handled by the App wake subscribers immediately, so polling
+// only matters for commands appended by another process, e.g. `orange do`.
+var CommandPollInterval = time.Second
+
+// commandSubscriptions keeps track of everyone waiting for new commands.
+type commandSubscriptions struct {
+	lock        sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+// Subscribe returns a channel that receives a value whenever App.HandleCommand
+// appended a command to the log.
+//
+// Notifications are coalesced: a subscriber that is busy while several
+// commands are appended is woken only once, and is expected to read all
+// new commands from the log.
+//
+// Call unsubscribe to stop receiving notifications.
+func (app *App) Subscribe() (wake <-chan struct{}, unsubscribe func()) {
+	ch := make(chan struct{}, 1)
+	app.subscriptions.lock.Lock()
+	defer app.subscriptions.lock.Unlock()
+	if app.subscriptions.subscribers == nil {
+		app.subscriptions.subscribers = map[chan struct{}]struct{}{}
+	}
+	app.subscriptions.subscribers[ch] = struct{}{}
+	return ch, func() {
+		app.subscriptions.lock.Lock()
+		defer app.subscriptions.lock.Unlock()
+		delete(app.subscriptions.subscribers, ch)
+	}
+}
+
+// wakeSubscribers notifies all subscribers without waiting for them.
+func (app *App) wakeSubscribers() {
+	app.subscriptions.lock.Lock()
+	defer app.subscriptions.lock.Unlock()
+	for ch := range app.subscriptions.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WaitForCommands returns a channel that receives a value when new
+// commands might be available: either because App.HandleCommand appended
+// one, or because CommandPollInterval has passed.
+//
+// The returned stop function releases all resources.
+func (app *App) WaitForCommands() (wake <-chan struct{}, stop func()) {
+	subscription, unsubscribe := app.Subscribe()
+	ticker := time.NewTicker(CommandPollInterval)
+	done := make(chan struct{})
+	ch := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-subscription:
+			case <-ticker.C:
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, func() {
+		unsubscribe()
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Subscribe_WakesSubscriber_WhenCommandIsHandled(t *testing.T) {
+	scenario := setup(t)
+	wake, unsubscribe := scenario.App.Subscribe()
+	defer unsubscribe()
+
+	scenario.must(scenario.postLink("https://example.com", "first"))
+	scenario.must(scenario.postLink("https://example.com", "second"))
+
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatalf("expected subscriber to be woken")
+	}
+
+	select {
+	case <-wake:
+		t.Fatalf("expected notifications to be coalesced")
+	default:
+	}
+}
+
+func Test_WaitForCommands_FallsBackToPolling(t *testing.T) {
+	defer func(interval time.Duration) { CommandPollInterval = interval }(CommandPollInterval)
+	CommandPollInterval = 10 * time.Millisecond
+
+	scenario := setup(t)
+	wake, stop := scenario.App.WaitForCommands()
+	defer stop()
+
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatalf("expected to be woken without any handled commands")
+	}
+}
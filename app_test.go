@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math/rand"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupSharingCommandLog returns two scenarios whose apps append to the same sqlite3 command log,
+// like two processes would.
+func setupSharingCommandLog(t *testing.T) (*TestContext, *TestContext) {
+	config := NewPlatformConfigForTest()
+	config.CommandLog = parseURL("file:///"+filepath.Join(t.TempDir(), "commands.db"), "CommandLog")
+	first, second := setupWithConfig(t, config), setupWithConfig(t, config)
+	t.Cleanup(func() {
+		first.App.Close()
+		second.App.Close()
+	})
+	return first, second
+}
+
+func Test_App_RejectsConflictingCommand_AppendedByAnotherApp(t *testing.T) {
+	first, second := setupSharingCommandLog(t)
+	first.must(first.postLink("https://example.com", "Shared"))
+	first.must(first.upvote("post-1", "viewer"))
+
+	second.mustFailWith(second.upvote("post-1", "viewer"), ErrAlreadyVoted)
+
+	if length, err := second.App.Commands.Length(); err != nil || length != 2 {
+		t.Fatalf("expected log to contain 2 commands, got %d (%v)", length, err)
+	}
+}
+
+func Test_App_RetriesCommand_AfterCatchingUpWithAnotherApp(t *testing.T) {
+	first, second := setupSharingCommandLog(t)
+	second.PostIDs = []string{"post-1"}
+	first.must(first.postLink("https://example.com", "First"))
+
+	second.must(second.postLink("https://example.org", "Second"))
+
+	if act, exp := second.App.version, 2; act != exp {
+		t.Fatalf("expected version %d, got %d", exp, act)
+	}
+	if act, exp := len(second.frontpage()), 2; act != exp {
+		t.Fatalf("expected %d submissions, got %d", exp, act)
+	}
+
+	first.must(first.upvote("post-2", "viewer"))
+	if err := second.App.Replay(false); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	if act, exp := mustFind(second.frontpage(), "Title", "Second").VoteCount, 1; act != exp {
+		t.Fatalf("expected %d votes, got %d", exp, act)
+	}
+}
+
+// setupFollower makes the second app a follower of the first one.
+func setupFollower(t *testing.T) (primary, follower *TestContext) {
+	primary, follower = setupSharingCommandLog(t)
+	server := httptest.NewServer(NewCommandReceiver(primary.App, DefaultSerializer, discardLogger()))
+	t.Cleanup(server.Close)
+	primaryURL, _ := url.Parse(server.URL)
+	follower.App.Primary = NewHTTPCommandForwarder(primaryURL, DefaultSerializer)
+	return primary, follower
+}
+
+func Test_App_Follower_ForwardsCommandsToPrimary(t *testing.T) {
+	primary, follower := setupFollower(t)
+	follower.must(follower.postLink("https://example.com", "Forwarded"))
+
+	if act, exp := follower.App.version, 1; act != exp {
+		t.Fatalf("expected follower to have replayed up to %d, got %d", exp, act)
+	}
+	mustFind(follower.frontpage(), "Title", "Forwarded")
+	mustFind(primary.frontpage(), "Title", "Forwarded")
+}
+
+func Test_App_Follower_ReportsErrorsOfRejectedCommands(t *testing.T) {
+	primary, follower := setupFollower(t)
+	primary.must(primary.postLink("https://example.com", "Shared"))
+	follower.must(follower.upvote("post-1", "viewer"))
+
+	follower.mustFailWith(follower.upvote("post-1", "viewer"), ErrAlreadyVoted)
+
+	if length, err := primary.App.Commands.Length(); err != nil || length != 2 {
+		t.Fatalf("expected log to contain 2 commands, got %d (%v)", length, err)
+	}
+}
+
+func Test_ListenForFollowers_RefusesAddressesOtherHostsCanReach(t *testing.T) {
+	for _, primary := range []string{"http://:0", "http://0.0.0.0:0", "http://[::]:0", "http://example.com:0"} {
+		if _, err := ListenForFollowers(parseURL(primary, "Primary")); !errors.Is(err, ErrNotLoopback) {
+			t.Fatalf("expected %s to be refused, got %v", primary, err)
+		}
+	}
+	for _, primary := range []string{"http://127.0.0.1:0", "http://localhost:0"} {
+		listener, err := ListenForFollowers(parseURL(primary, "Primary"))
+		if err != nil {
+			t.Fatalf("expected to listen on %s, got %s", primary, err)
+		}
+		listener.Close()
+	}
+}
+
+func Test_App_ReportsVersionConflict_WhenLogKeepsGrowing(t *testing.T) {
+	scenario := setup(t)
+	scenario.App.Commands = &growingCommandLog{InMemoryCommandLog: NewInMemoryCommandLog()}
+
+	err := scenario.do(scenario.postLink("https://example.com", "Contended"))
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected %s, got %v", ErrVersionConflict, err)
+	}
+}
+
+// growingCommandLog simulates another process that appends a command
+// whenever the app tries to append one.
+type growingCommandLog struct {
+	*InMemoryCommandLog
+}
+
+func (l *growingCommandLog) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
+	l.Append(&SkipCommand{}, nil)
+	return ErrVersionConflict
+}
+
+func Test_App_Replay_RefusesToStart_WithUndecodableCommands(t *testing.T) {
+	config := NewPlatformConfigForTest()
+	config.CommandLog = parseURL("file:///"+filepath.Join(t.TempDir(), "commands.db"), "CommandLog")
+	scenario := setupWithConfig(t, config)
+	defer scenario.App.Close()
+	scenario.must(scenario.postLink("https://example.com", "Decodable"))
+	log := scenario.App.Commands.(*FileCommandLog)
+	if _, err := log.db.Exec(`INSERT INTO commands (message) VALUES ('{"type":"RemovedCommand","message":{}}')`); err != nil {
+		t.Fatalf("failed to insert command: %s", err)
+	}
+
+	restarted := setupWithConfig(t, config)
+	defer restarted.App.Close()
+	if err := restarted.App.Replay(true); !errors.Is(err, ErrUnknownCommandType) {
+		t.Fatalf("expected %s, got %v", ErrUnknownCommandType, err)
+	}
+
+	out := &strings.Builder{}
+	if err := NewDefaultShell(restarted.App).VerifyLog(out); err == nil {
+		t.Fatalf("expected verify-log to fail")
+	}
+	if act, exp := out.String(), "2 commands, 1 failed to decode"; !strings.Contains(act, exp) || !strings.Contains(act, "RemovedCommand") {
+		t.Fatalf("expected report to mention %q and RemovedCommand, got:\n%s", exp, act)
+	}
+}
+
+// setupWithFailingCommand returns a config whose command log contains a comment on a missing submission.
+func setupWithFailingCommand(t *testing.T) *PlatformConfig {
+	config := NewPlatformConfigForTest()
+	config.CommandLog = parseURL("file:///"+filepath.Join(t.TempDir(), "commands.db"), "CommandLog")
+	scenario := setupWithConfig(t, config)
+	defer scenario.App.Close()
+	scenario.must(scenario.postLink("https://example.com", "Existing"))
+	log := scenario.App.Commands.(*FileCommandLog)
+	if err := log.Append(scenario.commentOn("missing", "orphan"), nil); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+	return config
+}
+
+func Test_App_Replay_ReportsFailures(t *testing.T) {
+	scenario := setupWithConfig(t, setupWithFailingCommand(t))
+	defer scenario.App.Close()
+
+	if err := scenario.App.Replay(true); err != nil {
+		t.Fatalf("expected replay to skip errors, got %s", err)
+	}
+
+	failures := scenario.App.ReplayFailures()
+	if act, exp := len(failures), 1; act != exp {
+		t.Fatalf("expected %d failures, got %d", exp, act)
+	}
+	if act, exp := failures[0].String(), "#2 PostComment in *main.Content: "; !strings.HasPrefix(act, exp) {
+		t.Fatalf("expected failure to start with %q, got %q", exp, act)
+	}
+}
+
+func Test_App_Replay_QuarantinesFailingCommands(t *testing.T) {
+	config := setupWithFailingCommand(t)
+	scenario := setupWithConfig(t, config)
+	scenario.App.Quarantine = true
+	if err := scenario.App.Replay(false); err != nil {
+		t.Fatalf("expected replay to quarantine errors, got %s", err)
+	}
+	if failures := scenario.App.ReplayFailures(); len(failures) != 1 || !failures[0].Quarantined {
+		t.Fatalf("expected one quarantined failure, got %v", failures)
+	}
+	scenario.App.Close()
+
+	restarted := setupWithConfig(t, config)
+	defer restarted.App.Close()
+	if err := restarted.App.Replay(false); err != nil {
+		t.Fatalf("expected next replay to be clean, got %s", err)
+	}
+	out := &strings.Builder{}
+	if err := NewDefaultShell(restarted.App).ReplayReport(out); err != nil {
+		t.Fatalf("failed to report: %s", err)
+	}
+	if act, exp := out.String(), "1 quarantined commands\n  #2 PostComment in *main.Content at "; !strings.Contains(act, exp) {
+		t.Fatalf("expected report to contain %q, got:\n%s", exp, act)
+	}
+}
+
+func Test_HistoricalApp_DerivesStateAsOfCommand(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://example.com", "First"))
+	scenario.must(scenario.upvote("post-1", "viewer"))
+
+	past, err := HistoricalApp(scenario.App.Commands, 1)
+	if err != nil {
+		t.Fatalf("failed to build historical app: %s", err)
+	}
+	defer past.Close()
+
+	q := NewFrontpageQuery(nil)
+	if err := past.HandleQuery(q); err != nil {
+		t.Fatalf("failed to query frontpage: %s", err)
+	}
+	if act, exp := mustFind(q.Submissions, "Title", "First").VoteCount, 0; act != exp {
+		t.Fatalf("expected %d votes at command 1, got %d", exp, act)
+	}
+	if err := past.HandleCommand(&UpvoteSubmission{ItemID: "post-1", Voter: "other"}); !errors.Is(err, ErrReadOnlyCommandLog) {
+		t.Fatalf("expected command to be rejected with %v, got %v", ErrReadOnlyCommandLog, err)
+	}
+}
+
+func Test_App_Mount_RejectsModulesOwningTheSameCommands(t *testing.T) {
+	app := NewApp(NewInMemoryCommandLog())
+	if err := app.Mount(NewDefaultContent()); err != nil {
+		t.Fatalf("failed to mount: %s", err)
+	}
+	if err := app.Mount(NewDefaultContent()); !errors.Is(err, ErrOwnershipConflict) {
+		t.Fatalf("expected %s, got %v", ErrOwnershipConflict, err)
+	}
+	if err := app.Mount(&undeclaredModule{}); !errors.Is(err, ErrUndeclaredOwnership) {
+		t.Fatalf("expected %s, got %v", ErrUndeclaredOwnership, err)
+	}
+}
+
+type undeclaredModule struct{}
+
+func (m *undeclaredModule) HandleCommand(command Command) error { return ErrCommandNotAccepted }
+
+func Test_App_Modules_RejectCommandsTheyDoNotOwn(t *testing.T) {
+	modules := map[string]CommandValidator{
+		"system":  &SkipHandler{},
+		"auth":    NewDefaultAuth(),
+		"content": NewDefaultContent(),
+	}
+	app := setup(t).App
+	for _, ownership := range app.Modules() {
+		validator, ok := modules[ownership.Module]
+		if !ok {
+			continue
+		}
+		for name, newCommand := range DefaultCommandRegistry {
+			if slices.Contains(ownership.Commands, name) {
+				continue
+			}
+			if err := validator.ValidateCommand(newCommand()); err != ErrCommandNotAccepted {
+				t.Errorf("%s accepts %s, which it does not own: %v", ownership.Module, name, err)
+			}
+		}
+	}
+}
+
+func Test_App_Modules_OwnEveryRegisteredCommandExactlyOnce(t *testing.T) {
+	app := setup(t).App
+	owners := map[string][]string{}
+	for _, ownership := range app.Modules() {
+		for _, name := range ownership.Commands {
+			owners[name] = append(owners[name], ownership.Module)
+		}
+	}
+	for name := range DefaultCommandRegistry {
+		if len(owners[name]) != 1 {
+			t.Errorf("expected command %s to be owned by exactly one module, got %v", name, owners[name])
+		}
+	}
+	if unowned := app.UnownedCommands(); len(unowned) != 0 {
+		t.Errorf("expected every command to be owned, got %v", unowned)
+	}
+}
+
+func lastCommand(t *testing.T, log CommandLog) *PersistedCommand {
+	t.Helper()
+	length, err := log.Length()
+	if err != nil {
+		t.Fatalf("failed to determine length: %s", err)
+	}
+	commands, err := log.After(length - 1)
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	for command := range commands {
+		return command
+	}
+	t.Fatalf("log is empty")
+	return nil
+}
+
+func Test_App_RecordsEventsNextToCommands(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://example.com", "First"))
+	scenario.must(scenario.commentOn("post-1", "first reply"))
+
+	events := lastCommand(t, scenario.App.Commands).Events
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %v", events)
+	}
+	posted, ok := events[0].(*CommentPosted)
+	if !ok {
+		t.Fatalf("expected CommentPosted, got %T", events[0])
+	}
+	if posted.SubmissionID != "post-1" || posted.ParentAuthor != scenario.Submitter || posted.Author != scenario.Viewer {
+		t.Fatalf("expected reply by %s to %s on post-1, got %+v", scenario.Viewer, scenario.Submitter, posted)
+	}
+	if act, exp := posted.CommentID.String(), NewTreeID("post-1").And(0).String(); act != exp {
+		t.Fatalf("expected comment ID %s, got %s", exp, act)
+	}
+
+	scenario.must(scenario.upvote("post-1", "viewer"))
+	if events := lastCommand(t, scenario.App.Commands).Events; events == nil || len(events) != 0 {
+		t.Fatalf("expected no events to be recorded for upvote, got %v", events)
+	}
+}
+
+func Test_App_Replay_RecordsMissingEvents(t *testing.T) {
+	log := NewInMemoryCommandLog()
+	log.Append(&PostLink{ItemID: "post-1", Submitter: "submitter", Url: "https://example.com", Title: "First"}, nil)
+	app := NewApp(log)
+	if err := app.Mount(NewDefaultContent()); err != nil {
+		t.Fatalf("failed to mount: %s", err)
+	}
+	if err := app.Replay(false); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+
+	events := lastCommand(t, log).Events
+	if len(events) != 1 || events[0].(*SubmissionPosted).ItemID != "post-1" {
+		t.Fatalf("expected SubmissionPosted for post-1, got %v", events)
+	}
+}
+
+// syntheticCommandLog returns a log of n commands: one link in ten,
+// six upvotes and three comments on random earlier links.
+func syntheticCommandLog(b *testing.B, n int) *InMemoryCommandLog {
+	b.Helper()
+	random := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := make([]*PersistedCommand, 0, n)
+	itemIDs := []string{}
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		var command Command
+		switch kind := i % 10; {
+		case kind == 0 || len(itemIDs) == 0:
+			itemID := fmt.Sprintf("item-%d", i)
+			itemIDs = append(itemIDs, itemID)
+			command = &PostLink{ItemID: itemID, Submitter: "bench", Url: "https://example.com", Title: "Benchmark", SubmittedAt: at}
+		case kind <= 6:
+			itemID := itemIDs[random.Intn(len(itemIDs))]
+			command = &UpvoteSubmission{ItemID: itemID, Voter: fmt.Sprintf("voter-%d", i), VotedAt: at}
+		default:
+			itemID := itemIDs[random.Intn(len(itemIDs))]
+			command = &PostComment{ParentID: NewTreeID(itemID), Author: "bench", PostedAt: at, Content: "Benchmark"}
+		}
+		entries = append(entries, &PersistedCommand{Message: command})
+	}
+	log := NewInMemoryCommandLog()
+	if err := log.AppendBatch(entries); err != nil {
+		b.Fatalf("failed to append: %s", err)
+	}
+	return log
+}
+
+func BenchmarkApp_Replay(b *testing.B) {
+	log := syntheticCommandLog(b, 100_000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		app, _ := HackerNews(NewPlatformConfigForTest())
+		app.Commands = log
+		app.Logger = discardLogger()
+		b.StartTimer()
+		if err := app.Replay(false); err != nil {
+			b.Fatalf("failed to replay: %s", err)
+		}
+	}
+}
+
+// crashingCommandLog panics after replay consumed n commands, like a process killed halfway through.
+type crashingCommandLog struct {
+	CommandLog
+	n int
+}
+
+func (l *crashingCommandLog) After(id int) (iter.Seq[*PersistedCommand], error) {
+	commands, err := l.CommandLog.After(id)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(*PersistedCommand) bool) {
+		consumed := 0
+		for command := range commands {
+			if consumed == l.n {
+				panic("killed")
+			}
+			if !yield(command) {
+				return
+			}
+			consumed++
+		}
+	}, nil
+}
+
+func Test_App_Replay_ResumesAfterBeingKilledHalfway(t *testing.T) {
+	config := NewPlatformConfigForTest()
+	config.AuthStore = authBackends["sqlite"](t)
+	config.ContentStore = contentBackends["sqlite"](t)
+	log := NewInMemoryCommandLog()
+	for i := 0; i < 10; i++ {
+		log.Append(&SignUpUser{Username: fmt.Sprintf("user-%d", i)}, nil)
+		log.Append(&PostLink{ItemID: fmt.Sprintf("item-%d", i), Submitter: fmt.Sprintf("user-%d", i), Url: "https://example.com", Title: "Killed", SubmittedAt: time.Now()}, nil)
+	}
+
+	killed := setupWithConfig(t, config)
+	killed.App.Commands = &crashingCommandLog{CommandLog: log, n: 11}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected replay to be killed")
+			}
+		}()
+		killed.App.Replay(false)
+	}()
+	killed.App.Close()
+
+	restarted := setupWithConfig(t, config)
+	defer restarted.App.Close()
+	restarted.App.Commands = log
+	if err := restarted.App.Replay(false); err != nil {
+		t.Fatalf("expected replay to resume, got %s", err)
+	}
+	if err := restarted.App.HandleQuery(NewFindUserByName("user-9")); err != nil {
+		t.Fatalf("expected all users to be signed up, got %s", err)
+	}
+	if act, exp := len(restarted.frontpage()), 10; act != exp {
+		t.Fatalf("expected %d submissions, got %d", exp, act)
+	}
+}
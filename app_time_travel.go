@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ErrReadOnlyCommandLog is returned when appending to a command log that is read-only.
+var ErrReadOnlyCommandLog = errors.New("command log is read-only")
+
+// commandLogUntil is a read-only view of the commands in log up to and including until.
+//
+// Events recorded while replaying are kept in memory instead of being
+// written to log, and are filled in for commands without recorded events.
+type commandLogUntil struct {
+	log    CommandLog
+	until  int
+	events map[int][]Event
+	// owned makes Close close log.
+	owned bool
+}
+
+// readOnly returns a read-only view of all commands in log, which is closed together with the view.
+func readOnly(log CommandLog) *commandLogUntil {
+	return &commandLogUntil{log: log, until: math.MaxInt, owned: true}
+}
+
+func (l *commandLogUntil) Append(command Command, metadata *CommandMetadata) error {
+	return ErrReadOnlyCommandLog
+}
+
+func (l *commandLogUntil) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
+	return ErrReadOnlyCommandLog
+}
+
+func (l *commandLogUntil) Length() (int, error) {
+	length, err := l.log.Length()
+	return min(length, l.until), err
+}
+
+func (l *commandLogUntil) After(id int) (iter.Seq[*PersistedCommand], error) {
+	commands, err := l.log.After(id)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(*PersistedCommand) bool) {
+		for command := range commands {
+			if command.ID > l.until {
+				return
+			}
+			if events, ok := l.events[command.ID]; ok && command.Events == nil {
+				withEvents := *command
+				withEvents.Events = events
+				command = &withEvents
+			}
+			if !yield(command) {
+				return
+			}
+		}
+	}, nil
+}
+
+func (l *commandLogUntil) RecordEvents(events map[int][]Event) error {
+	if l.events == nil {
+		l.events = map[int][]Event{}
+	}
+	for id, recorded := range events {
+		l.events[id] = recorded
+	}
+	return nil
+}
+
+func (l *commandLogUntil) Close() error {
+	if closer, ok := l.log.(io.Closer); ok && l.owned {
+		return closer.Close()
+	}
+	return nil
+}
+
+// HistoricalApp returns an isolated app whose state is derived from the
+// commands in log up to and including the command with ID at.
+//
+// Queries can be run against the returned app, while commands are rejected
+// and log is left untouched.
+func HistoricalApp(log CommandLog, at int) (*App, error) {
+	config := DefaultPlatformConfig()
+	config.CommandLog = parseURL("memory://", "CommandLog")
+	config.Snapshots = parseURL("none://", "Snapshots")
+	app, _ := HackerNews(config)
+	app.Commands = &commandLogUntil{log: log, until: at}
+	if err := app.Replay(true); err != nil {
+		app.Close()
+		return nil, fmt.Errorf("failed to replay commands up to %d: %w", at, err)
+	}
+	return app, nil
+}
+
+// ResolveCommandID returns the ID of the command identified by at: either
+// a command ID, or a time in RFC 3339 format, identifying the last command
+// recorded at or before that time.
+func ResolveCommandID(log CommandLog, at string) (int, error) {
+	if id, err := strconv.Atoi(at); err == nil {
+		return id, nil
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a command ID nor a time: %w", at, err)
+	}
+	commands, err := log.After(0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read commands: %w", err)
+	}
+	id := 0
+	for command := range commands {
+		if command.Metadata != nil && command.Metadata.RecordedAt.After(t) {
+			break
+		}
+		id = command.ID
+	}
+	return id, nil
+}
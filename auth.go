@@ -67,6 +67,7 @@ func (s *Session) IsActive(now time.Time) bool {
 }
 
 type Auth struct {
+	EventBuffer
 	state AuthState
 }
 
@@ -78,6 +79,43 @@ func NewDefaultAuth() *Auth {
 	return NewAuth(NewInMemoryAuthState())
 }
 
+func (self *Auth) Ownership() *Ownership {
+	return &Ownership{
+		Module: "auth",
+		Commands: []string{
+			"SignUpUser", "LogInUser", "ChangeUsernamePolicy", "LinkVerifiedEmailToUser", "SetAdminUsers",
+			"RequestMagicLinkLogin", "LogInWithMagic", "SetMagicDomains", "RequestPasswordReset", "ResetPassword",
+		},
+		Queries: []string{
+			"FindUserByName", "FindSession", "FindUserBySessionID", "FindUserPasswordHash", "FindUserByEmail", "GetUserRoles",
+		},
+		Events: []string{"UserSignedUp"},
+	}
+}
+
+// ValidateCommand checks whether HandleCommand would accept cmd, without changing any state.
+func (self *Auth) ValidateCommand(cmd Command) error {
+	switch cmd := cmd.(type) {
+	case *SignUpUser:
+		return self.validateSignUpUser(cmd)
+	case *LogInUser:
+		return self.validateLogInUser(cmd)
+	case *LinkVerifiedEmailToUser:
+		return self.validateLinkVerifiedEmailToUser(cmd)
+	case *RequestMagicLinkLogin:
+		return self.validateRequestMagicLinkLogin(cmd)
+	case *LogInWithMagic:
+		return self.validateLogInUserWithMagic(cmd)
+	case *RequestPasswordReset:
+		return self.validateRequestPasswordReset(cmd)
+	case *ResetPassword:
+		return self.validateResetPassword(cmd)
+	case *ChangeUsernamePolicy, *SetAdminUsers, *SetMagicDomains:
+		return nil
+	}
+	return ErrCommandNotAccepted
+}
+
 func (self *Auth) HandleCommand(cmd Command) error {
 	switch cmd := cmd.(type) {
 	case *SignUpUser:
@@ -19,6 +19,17 @@ func init() {
 	DefaultCommandRegistry.Register("LinkVerifiedEmailToUser", func() Command { return &LinkVerifiedEmailToUser{} })
 }
 
+func (self *Auth) validateLinkVerifiedEmailToUser(cmd *LinkVerifiedEmailToUser) error {
+	user, err := self.state.FindUser(cmd.Username)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 func (self *Auth) linkVerifiedEmailToUser(cmd *LinkVerifiedEmailToUser) error {
 	user, err := self.state.FindUser(cmd.Username)
 	if err != nil {
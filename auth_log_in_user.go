@@ -23,7 +23,7 @@ func init() {
 	DefaultCommandRegistry.Register("LogInUser", func() Command { return &LogInUser{} })
 }
 
-func (self *Auth) handleLogInUser(cmd *LogInUser) error {
+func (self *Auth) validateLogInUser(cmd *LogInUser) error {
 	user, err := self.state.FindUser(cmd.Username)
 	if err != nil {
 		return err
@@ -35,6 +35,13 @@ func (self *Auth) handleLogInUser(cmd *LogInUser) error {
 	if cmd.PasswordHash.String() != user.PasswordHash {
 		return ErrInvalidCredentials
 	}
+	return nil
+}
+
+func (self *Auth) handleLogInUser(cmd *LogInUser) error {
+	if err := self.validateLogInUser(cmd); err != nil {
+		return err
+	}
 	if err := self.state.SetSession(&Session{
 		ID:         cmd.SessionID,
 		Username:   cmd.Username,
@@ -19,6 +19,11 @@ func init() {
 	DefaultCommandRegistry.Register("LogInWithMagic", func() Command { return new(LogInWithMagic) })
 }
 
+func (self *Auth) validateLogInUserWithMagic(cmd *LogInWithMagic) error {
+	_, err := self.state.FindUserByMagic(cmd.Magic)
+	return err
+}
+
 func (self *Auth) handleLogInUserWithMagic(cmd *LogInWithMagic) error {
 	user, err := self.state.FindUserByMagic(cmd.Magic)
 	if err != nil {
@@ -32,12 +37,11 @@ func (self *Auth) handleLogInUserWithMagic(cmd *LogInWithMagic) error {
 		ActiveTo:   cmd.AttemptedAt.Add(time.Hour),
 	})
 
-	// erase the magic link after it's been used
-	user.Magic = ""
-
 	if err != nil {
 		return fmt.Errorf("Failed to persist session: %w", err)
 	}
 
-	return nil
+	// erase the magic link after it's been used
+	user.Magic = ""
+	return self.state.SetUser(user)
 }
@@ -33,6 +33,18 @@ func init() {
 	DefaultCommandRegistry.Register("RequestMagicLinkLogin", func() Command { return new(RequestMagicLinkLogin) })
 }
 
+func (self *Auth) validateRequestMagicLinkLogin(cmd *RequestMagicLinkLogin) error {
+	magicDomains, err := self.state.GetMagicDomains()
+	if err != nil {
+		return err
+	}
+	_, err = self.state.FindUserByEmail(cmd.Email)
+	if errors.Is(err, ErrUserNotFound) && cmd.DomainIsMagic(magicDomains) {
+		return nil
+	}
+	return err
+}
+
 func (self *Auth) handleRequestMagicLinkLogin(cmd *RequestMagicLinkLogin) error {
 	magicDomains, err := self.state.GetMagicDomains()
 	if err != nil {
@@ -55,5 +67,48 @@ func (self *Auth) signUpUserByMagic(cmd *RequestMagicLinkLogin) error {
 		Magic:         cmd.Magic,
 		VerifiedEmail: cmd.Email,
 	}
-	return self.state.SetUser(user)
+	if err := self.state.SetUser(user); err != nil {
+		return err
+	}
+	self.Emit(&UserSignedUp{Username: user.Username, SignedUpAt: cmd.RequestedAt})
+	return nil
+}
+
+func init() {
+	DefaultCompactionRules.Register("RequestMagicLinkLogin", func(now time.Time) CompactionRule {
+		return &expiredMagicLinkRule{
+			before:  now.Add(-EmailRequestLifetime),
+			byEmail: map[string][]int{},
+			used:    map[string]bool{},
+		}
+	})
+}
+
+// expiredMagicLinkRule drops expired magic link requests that were never
+// used and have been replaced by a later request for the same email address.
+//
+// The first request for an email address is kept, since it might have
+// signed up the user.
+type expiredMagicLinkRule struct {
+	before  time.Time
+	byEmail map[string][]int
+	used    map[string]bool
+}
+
+func (r *expiredMagicLinkRule) Observe(command *PersistedCommand) {
+	switch cmd := command.Message.(type) {
+	case *RequestMagicLinkLogin:
+		r.byEmail[cmd.Email] = append(r.byEmail[cmd.Email], command.ID)
+	case *LogInWithMagic:
+		r.used[cmd.Magic] = true
+	}
+}
+
+func (r *expiredMagicLinkRule) Keep(command *PersistedCommand) bool {
+	cmd := command.Message.(*RequestMagicLinkLogin)
+	if r.used[cmd.Magic] || !cmd.RequestedAt.Before(r.before) {
+		return true
+	}
+	requests := r.byEmail[cmd.Email]
+	return command.ID == requests[0] || command.ID == requests[len(requests)-1]
 }
@@ -17,16 +17,30 @@ func init() {
 	DefaultCommandRegistry.Register("RequestPasswordReset", func() Command { return new(RequestPasswordReset) })
 }
 
-func (self *Auth) handleRequestPasswordReset(cmd *RequestPasswordReset) error {
+// findUserRequestingPasswordReset returns the user whose password should be reset.
+func (self *Auth) findUserRequestingPasswordReset(cmd *RequestPasswordReset) (*User, error) {
 	user, err := self.state.FindUser(cmd.Username)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if user == nil {
-		return ErrUserNotFound
+		return nil, ErrUserNotFound
 	}
 	if user.VerifiedEmail != cmd.Email {
-		return ErrUserNotFound
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (self *Auth) validateRequestPasswordReset(cmd *RequestPasswordReset) error {
+	_, err := self.findUserRequestingPasswordReset(cmd)
+	return err
+}
+
+func (self *Auth) handleRequestPasswordReset(cmd *RequestPasswordReset) error {
+	user, err := self.findUserRequestingPasswordReset(cmd)
+	if err != nil {
+		return err
 	}
 	user.PasswordResetToken = cmd.Token
 	user.PasswordResetRequestedAt = cmd.RequestedAt
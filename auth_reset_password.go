@@ -21,14 +21,28 @@ func init() {
 	DefaultCommandRegistry.Register("ResetPassword", func() Command { return new(ResetPassword) })
 }
 
-func (self *Auth) handleResetPassword(cmd *ResetPassword) error {
+// findUserResettingPassword returns the user whose password reset token is used by cmd.
+func (self *Auth) findUserResettingPassword(cmd *ResetPassword) (*User, error) {
 	user, err := self.state.FindUserByPasswordResetToken(cmd.Token)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if cmd.AttemptedAt.Sub(user.PasswordResetRequestedAt) >= 30*time.Minute {
-		return ErrPasswordResetExpired
+		return nil, ErrPasswordResetExpired
+	}
+	return user, nil
+}
+
+func (self *Auth) validateResetPassword(cmd *ResetPassword) error {
+	_, err := self.findUserResettingPassword(cmd)
+	return err
+}
+
+func (self *Auth) handleResetPassword(cmd *ResetPassword) error {
+	user, err := self.findUserResettingPassword(cmd)
+	if err != nil {
+		return err
 	}
 
 	user.PasswordHash = cmd.NewPassword.String()
@@ -18,11 +18,21 @@ func (cmd *SignUpUser) CommandName() string {
 	return "SignUpUser"
 }
 
+// UserSignedUp is emitted when a user account is created, either by
+// signing up or by logging in with a magic link for the first time.
+type UserSignedUp struct {
+	Username   string
+	SignedUpAt time.Time
+}
+
+func (e *UserSignedUp) EventName() string { return "UserSignedUp" }
+
 func init() {
 	DefaultCommandRegistry.Register("SignUpUser", func() Command { return &SignUpUser{} })
+	DefaultEventRegistry.Register("UserSignedUp", func() Event { return new(UserSignedUp) })
 }
 
-func (self *Auth) handleSignUpUser(cmd *SignUpUser) error {
+func (self *Auth) validateSignUpUser(cmd *SignUpUser) error {
 	user, err := self.state.FindUser(cmd.Username)
 	if err != nil {
 		return err
@@ -41,8 +51,21 @@ func (self *Auth) handleSignUpUser(cmd *SignUpUser) error {
 		return ErrUsernameNotAllowed
 	}
 
-	return self.state.SetUser(&User{
+	return nil
+}
+
+func (self *Auth) handleSignUpUser(cmd *SignUpUser) error {
+	if err := self.validateSignUpUser(cmd); err != nil {
+		return err
+	}
+
+	err := self.state.SetUser(&User{
 		Username:     cmd.Username,
 		PasswordHash: cmd.PasswordHash.String(),
 	})
+	if err != nil {
+		return err
+	}
+	self.Emit(&UserSignedUp{Username: cmd.Username, SignedUpAt: cmd.CreatedAt})
+	return nil
 }
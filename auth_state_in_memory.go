@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"maps"
 	"slices"
 )
 
-var _ AuthState = &InMemoryAuthState{}
+var (
+	_ AuthState   = &InMemoryAuthState{}
+	_ Snapshotter = &InMemoryAuthState{}
+)
 
 type InMemoryAuthState struct {
 	UsernamePolicy *UsernamePolicy
@@ -108,3 +113,19 @@ func (state *InMemoryAuthState) FindUserByPasswordResetToken(token string) (*Use
 	}
 	return nil, ErrUserNotFound
 }
+
+func (state *InMemoryAuthState) Snapshot() ([]byte, error) {
+	return json.Marshal(state)
+}
+
+// Restore replaces the entire state with the one found in data.
+//
+// The state is left untouched if data cannot be decoded.
+func (state *InMemoryAuthState) Restore(data []byte) error {
+	restored := NewInMemoryAuthState()
+	if err := json.Unmarshal(data, restored); err != nil {
+		return fmt.Errorf("failed to decode auth state: %w", err)
+	}
+	*state = *restored
+	return nil
+}
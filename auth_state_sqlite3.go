@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	_ AuthState      = (*PersistentAuthState)(nil)
+	_ VersionedState = (*PersistentAuthState)(nil)
+)
+
+// PersistentAuthState stores users, sessions and auth settings in a sqlite3 database.
+//
+// The ID of the last applied command is stored alongside the state,
+// so that after a restart only newer commands need to be replayed.
+type PersistentAuthState struct {
+	sqliteState
+	filename string
+}
+
+func NewPersistentAuthState(filename string) *PersistentAuthState {
+	return &PersistentAuthState{sqliteState: sqliteState{versionTable: "auth_version"}, filename: filename}
+}
+
+func (self *PersistentAuthState) conninfo() string {
+	return fmt.Sprintf("file:%s?_journal=wal&_busy_timeout=5000", self.filename)
+}
+
+func (self *PersistentAuthState) Setup() error {
+	db, err := sql.Open("sqlite3", self.conninfo())
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	schema := []string{
+		"CREATE TABLE IF NOT EXISTS auth_version (id INTEGER PRIMARY KEY CHECK (id = 1), version INTEGER NOT NULL);",
+		"CREATE TABLE IF NOT EXISTS username_policy (id INTEGER PRIMARY KEY CHECK (id = 1), min_length INTEGER, max_length INTEGER, blacklist TEXT);",
+		"CREATE TABLE IF NOT EXISTS admin_users (username TEXT PRIMARY KEY);",
+		"CREATE TABLE IF NOT EXISTS magic_domains (domain TEXT PRIMARY KEY);",
+		`CREATE TABLE IF NOT EXISTS users (
+       username TEXT PRIMARY KEY,
+       password_hash TEXT NOT NULL DEFAULT '',
+       verified_email TEXT NOT NULL DEFAULT '',
+       magic TEXT NOT NULL DEFAULT '',
+       password_reset_token TEXT NOT NULL DEFAULT '',
+       password_reset_requested_at TIMESTAMP
+     );`,
+		"CREATE INDEX IF NOT EXISTS users_by_verified_email ON users (verified_email);",
+		"CREATE INDEX IF NOT EXISTS users_by_magic ON users (magic);",
+		"CREATE INDEX IF NOT EXISTS users_by_password_reset_token ON users (password_reset_token);",
+		"CREATE TABLE IF NOT EXISTS sessions (id TEXT PRIMARY KEY, username TEXT, active_from TIMESTAMP, active_to TIMESTAMP);",
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, stmt := range schema {
+		if _, err := tx.Exec(stmt); err != nil {
+			db.Close()
+			return fmt.Errorf("failed to create database schema: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to commit schema: %w", err)
+	}
+	self.db = db
+	return nil
+}
+
+func (self *PersistentAuthState) GetPolicy(policy *UsernamePolicy) error {
+	blacklist := ""
+	err := self.handle().QueryRow("SELECT min_length, max_length, blacklist FROM username_policy WHERE id = 1").Scan(
+		&policy.MinLength, &policy.MaxLength, &blacklist)
+	if errors.Is(err, sql.ErrNoRows) {
+		*policy = UsernamePolicy{
+			MinLength: 0,
+			MaxLength: 20,
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query username policy: %w", err)
+	}
+	policy.Blacklist = nil
+	if err := json.Unmarshal([]byte(blacklist), &policy.Blacklist); err != nil {
+		return fmt.Errorf("failed to decode username blacklist: %w", err)
+	}
+	return nil
+}
+
+func (self *PersistentAuthState) PutPolicy(policy *UsernamePolicy) error {
+	blacklist, err := json.Marshal(policy.Blacklist)
+	if err != nil {
+		return fmt.Errorf("failed to encode username blacklist: %w", err)
+	}
+	if _, err := self.handle().Exec(`INSERT OR REPLACE INTO username_policy (id, min_length, max_length, blacklist) VALUES (1, ?, ?, ?)`,
+		policy.MinLength, policy.MaxLength, string(blacklist)); err != nil {
+		return fmt.Errorf("failed to store username policy: %w", err)
+	}
+	return nil
+}
+
+// replaceAll replaces all rows of a single-column table with values.
+func (self *PersistentAuthState) replaceAll(table, column string, values []string) error {
+	return self.update(func(tx sqlHandle) error {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+		for _, value := range values {
+			if _, err := tx.Exec(fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (?)", table, column), value); err != nil {
+				return fmt.Errorf("failed to insert into %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (self *PersistentAuthState) SetAdminUsers(users []string) error {
+	return self.replaceAll("admin_users", "username", users)
+}
+
+func (self *PersistentAuthState) IsAdmin(username string) (bool, error) {
+	found := 0
+	if err := self.handle().QueryRow("SELECT count(*) FROM admin_users WHERE username = ?", username).Scan(&found); err != nil {
+		return false, fmt.Errorf("failed to query admin users: %w", err)
+	}
+	return found > 0, nil
+}
+
+func (self *PersistentAuthState) SetMagicDomains(domains []string) error {
+	return self.replaceAll("magic_domains", "domain", domains)
+}
+
+func (self *PersistentAuthState) GetMagicDomains() ([]string, error) {
+	rows, err := self.handle().Query("SELECT domain FROM magic_domains ORDER BY domain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query magic domains: %w", err)
+	}
+	defer rows.Close()
+	domains := []string{}
+	for rows.Next() {
+		domain := ""
+		if err := rows.Scan(&domain); err != nil {
+			return nil, fmt.Errorf("failed to scan magic domain: %w", err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+func (self *PersistentAuthState) SetUser(user *User) error {
+	var requestedAt *time.Time
+	if !user.PasswordResetRequestedAt.IsZero() {
+		requestedAt = &user.PasswordResetRequestedAt
+	}
+	if _, err := self.handle().Exec(`INSERT OR REPLACE INTO users
+    (username, password_hash, verified_email, magic, password_reset_token, password_reset_requested_at)
+    VALUES (?, ?, ?, ?, ?, ?)`,
+		user.Username, user.PasswordHash, user.VerifiedEmail, user.Magic, user.PasswordResetToken, requestedAt); err != nil {
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+// findUserBy returns the first user where column matches value, or nil if there is none.
+func (self *PersistentAuthState) findUserBy(column string, value string) (*User, error) {
+	user := &User{}
+	requestedAt := sql.NullTime{}
+	err := self.handle().QueryRow(fmt.Sprintf(`SELECT username, password_hash, verified_email, magic, password_reset_token, password_reset_requested_at
+    FROM users WHERE %s = ? LIMIT 1`, column), value).Scan(
+		&user.Username, &user.PasswordHash, &user.VerifiedEmail, &user.Magic, &user.PasswordResetToken, &requestedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user by %s: %w", column, err)
+	}
+	if requestedAt.Valid {
+		user.PasswordResetRequestedAt = requestedAt.Time
+	}
+	return user, nil
+}
+
+// findExistingUserBy is like findUserBy, but returns ErrUserNotFound if no user matches.
+func (self *PersistentAuthState) findExistingUserBy(column string, value string) (*User, error) {
+	user, err := self.findUserBy(column, value)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (self *PersistentAuthState) FindUser(username string) (*User, error) {
+	return self.findUserBy("username", username)
+}
+
+func (self *PersistentAuthState) FindUserByEmail(email string) (*User, error) {
+	if email == "" {
+		return nil, ErrUserNotFound
+	}
+	return self.findExistingUserBy("verified_email", email)
+}
+
+func (self *PersistentAuthState) FindUserByMagic(magic string) (*User, error) {
+	if magic == "" {
+		return nil, ErrUserNotFound
+	}
+	return self.findExistingUserBy("magic", magic)
+}
+
+func (self *PersistentAuthState) FindUserByPasswordResetToken(token string) (*User, error) {
+	if token == "" {
+		return nil, ErrUserNotFound
+	}
+	return self.findExistingUserBy("password_reset_token", token)
+}
+
+func (self *PersistentAuthState) SetSession(session *Session) error {
+	if _, err := self.handle().Exec(`INSERT OR REPLACE INTO sessions (id, username, active_from, active_to) VALUES (?, ?, ?, ?)`,
+		session.ID, session.Username, session.ActiveFrom, session.ActiveTo); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+func (self *PersistentAuthState) FindSession(sessionID string) (*Session, error) {
+	session := &Session{}
+	err := self.handle().QueryRow(`SELECT id, username, active_from, active_to FROM sessions WHERE id = ?`, sessionID).Scan(
+		&session.ID, &session.Username, &session.ActiveFrom, &session.ActiveTo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session: %w", err)
+	}
+	return session, nil
+}
@@ -2,174 +2,231 @@ package main
 
 import (
 	"errors"
+	"net/url"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
-func Test_ASignedUpUser_CanLogIn_WithTheirPassword(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("test-user", "test-password"))
-	_, err := scenario.findPasswordHash("test-user", "test-password")
-	if err != nil {
-		t.Fatalf("failed to find password hash: %s", err)
+var authBackends = map[string]func(t *testing.T) *url.URL{
+	"memory": func(t *testing.T) *url.URL { return parseURL("memory://", "AuthStore") },
+	"sqlite": func(t *testing.T) *url.URL {
+		return parseURL("file:///"+filepath.Join(t.TempDir(), "auth.db"), "AuthStore")
+	},
+}
+
+// withEachAuthBackend runs test once for every supported AuthState implementation.
+func withEachAuthBackend(t *testing.T, test func(t *testing.T, scenario *TestContext)) {
+	for name, authStore := range authBackends {
+		t.Run(name, func(t *testing.T) {
+			config := NewPlatformConfigForTest()
+			config.AuthStore = authStore(t)
+			test(t, setupWithConfig(t, config))
+		})
 	}
-	scenario.must(scenario.login("test-user", "test-password"))
+}
+
+func Test_ASignedUpUser_CanLogIn_WithTheirPassword(t *testing.T) {
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("test-user", "test-password"))
+		_, err := scenario.findPasswordHash("test-user", "test-password")
+		if err != nil {
+			t.Fatalf("failed to find password hash: %s", err)
+		}
+		scenario.must(scenario.login("test-user", "test-password"))
+	})
 }
 
 func Test_ASignedUpUser_CannotLogIn_WithWrongPassword(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("test-user", "test-password"))
-	_, err := scenario.findPasswordHash("test-user", "wrong-password")
-	if !errors.Is(err, ErrPasswordMismatch) {
-		t.Fatalf("expected ErrPasswordMismatch, got %s", err)
-	}
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("test-user", "test-password"))
+		_, err := scenario.findPasswordHash("test-user", "wrong-password")
+		if !errors.Is(err, ErrPasswordMismatch) {
+			t.Fatalf("expected ErrPasswordMismatch, got %s", err)
+		}
+	})
 }
 
 func Test_ASignedUpUser_CannotSignUpAgain(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.mustFailWith(scenario.signup("admin", "admin"), ErrUserExists)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.mustFailWith(scenario.signup("admin", "admin"), ErrUserExists)
+	})
 }
 
 func Test_ASignedUpUser_MustRespectTheUsernamePolicy(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("a", "admin"))
-	scenario.must(scenario.setUsernamePolicy(2, 100))
-	scenario.mustFailWith(scenario.signup("b", "password"), ErrUsernameNotAllowed)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("a", "admin"))
+		scenario.must(scenario.setUsernamePolicy(2, 100))
+		scenario.mustFailWith(scenario.signup("b", "password"), ErrUsernameNotAllowed)
+	})
 }
 
 func Test_UsernamePolicy_allows_excluding_names(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.forbidUsername("guest", "admin"))
-	scenario.mustFailWith(scenario.signup("admin", "safe-password"), ErrUsernameNotAllowed)
-	scenario.mustFailWith(scenario.signup("guest", "safe-password"), ErrUsernameNotAllowed)
-	scenario.must(scenario.signup("regular-user", "safe-password"))
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.forbidUsername("guest", "admin"))
+		scenario.mustFailWith(scenario.signup("admin", "safe-password"), ErrUsernameNotAllowed)
+		scenario.mustFailWith(scenario.signup("guest", "safe-password"), ErrUsernameNotAllowed)
+		scenario.must(scenario.signup("regular-user", "safe-password"))
+	})
 }
 
 func Test_LinkVerifiedEmailToUser_UpdatesTheUserObject(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	user, err := scenario.findUserByEmail("admin@example.com")
-	if err != nil {
-		t.Fatalf("failed to find user by email: %s", err)
-	}
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		user, err := scenario.findUserByEmail("admin@example.com")
+		if err != nil {
+			t.Fatalf("failed to find user by email: %s", err)
+		}
 
-	if user.VerifiedEmail != "admin@example.com" {
-		t.Fatalf("expected verified email to be %q, got %q", "admin@example.com", user.VerifiedEmail)
-	}
+		if user.VerifiedEmail != "admin@example.com" {
+			t.Fatalf("expected verified email to be %q, got %q", "admin@example.com", user.VerifiedEmail)
+		}
+	})
 }
 
 func Test_FindUserByEmail_ReturnsError_WhenEmailIsNotLinked(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	_, err := scenario.findUserByEmail("admin@example.com")
-	if !errors.Is(err, ErrUserNotFound) {
-		t.Fatalf("expected %s, got %s", ErrUserNotFound, err)
-	}
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		_, err := scenario.findUserByEmail("admin@example.com")
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected %s, got %s", ErrUserNotFound, err)
+		}
+	})
 }
 
 func Test_LinkVerifiedEmailToUser_ReturnsError_WhenUserDoesNotExist(t *testing.T) {
-	scenario := setup(t)
-	scenario.mustFailWith(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"), ErrUserNotFound)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.mustFailWith(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"), ErrUserNotFound)
+	})
 }
 
 func Test_RequestMagicLinkLogin_ReturnsError_WhenUserDoesNotExist(t *testing.T) {
-	scenario := setup(t)
-	scenario.mustFailWith(scenario.requestMagicLinkLogin("test@gmail.com", "magic-string"), ErrUserNotFound)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.mustFailWith(scenario.requestMagicLinkLogin("test@gmail.com", "magic-string"), ErrUserNotFound)
+	})
 }
 
 func Test_RequestMagicLinkLogin_UpdatesMagic(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
 
-	user, err := scenario.findUserByEmail("admin@example.com")
-	if err != nil {
-		t.Fatalf("failed to find user by email: %s", err)
-	}
+		user, err := scenario.findUserByEmail("admin@example.com")
+		if err != nil {
+			t.Fatalf("failed to find user by email: %s", err)
+		}
 
-	if user.Magic != "magic-string" {
-		t.Fatalf("expected magic to be %q, got %q", "magic-string", user.Magic)
-	}
+		if user.Magic != "magic-string" {
+			t.Fatalf("expected magic to be %q, got %q", "magic-string", user.Magic)
+		}
+	})
 }
 
 func Test_RequestMagicLinkLogin_UpdatesMagic_IfUserAlreadyHasMagic(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
-	scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "super-magic-string"))
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
+		scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "super-magic-string"))
 
-	user, err := scenario.findUserByEmail("admin@example.com")
-	if err != nil {
-		t.Fatalf("failed to find user by email: %s", err)
-	}
+		user, err := scenario.findUserByEmail("admin@example.com")
+		if err != nil {
+			t.Fatalf("failed to find user by email: %s", err)
+		}
 
-	if user.Magic != "super-magic-string" {
-		t.Fatalf("expected magic to be %q, got %q", "super-magic-string", user.Magic)
-	}
+		if user.Magic != "super-magic-string" {
+			t.Fatalf("expected magic to be %q, got %q", "super-magic-string", user.Magic)
+		}
+	})
 }
 
 func Test_LogInWithMagic_Succeeds_WhenUserExistsAndMagicMatches(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		scenario.must(scenario.requestMagicLinkLogin("admin@example.com", "magic-string"))
 
-	user, err := scenario.findUserByEmail("admin@example.com")
-	if err != nil {
-		t.Fatalf("failed to find user by email: %s", err)
-	}
+		user, err := scenario.findUserByEmail("admin@example.com")
+		if err != nil {
+			t.Fatalf("failed to find user by email: %s", err)
+		}
 
-	scenario.must(scenario.loginWithMagic(user.Magic))
+		scenario.must(scenario.loginWithMagic(user.Magic))
+	})
 }
 
 func Test_LogInWithMagic_ReturnsError_WhenMagicIsForbidden(t *testing.T) {
-	scenario := setup(t)
-	scenario.mustFailWith(scenario.loginWithMagic("magic-string"), ErrUserNotFound)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.mustFailWith(scenario.loginWithMagic("magic-string"), ErrUserNotFound)
+	})
 }
 
 func Test_LogInWithMagic_CreatesNewUser_WhenDomainIsMagic(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.setMagicDomains("bolt.eu"))
-	scenario.must(scenario.requestMagicLinkLogin("dario.hamidi@bolt.eu", "magic-string"))
-	scenario.must(scenario.loginWithMagic("magic-string"))
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.setMagicDomains("bolt.eu"))
+		scenario.must(scenario.requestMagicLinkLogin("dario.hamidi@bolt.eu", "magic-string"))
+		scenario.must(scenario.loginWithMagic("magic-string"))
 
-	user, err := scenario.findUserByEmail("dario.hamidi@bolt.eu")
-	if err != nil {
-		t.Fatalf("failed to find user by email: %s", err)
-	}
+		user, err := scenario.findUserByEmail("dario.hamidi@bolt.eu")
+		if err != nil {
+			t.Fatalf("failed to find user by email: %s", err)
+		}
 
-	if act, exp := user.Username, "dario.hamidi"; act != exp {
-		t.Fatalf("expected username to be %q, got %q", exp, act)
-	}
+		if act, exp := user.Username, "dario.hamidi"; act != exp {
+			t.Fatalf("expected username to be %q, got %q", exp, act)
+		}
+	})
 }
 
 func Test_RequestPasswordReset_ReturnsErrorIfEmailIsNotVerified(t *testing.T) {
-	scenario := setup(t)
-	scenario.mustFailWith(scenario.requestPasswordReset("admin", "admin@example.com"), ErrUserNotFound)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.mustFailWith(scenario.requestPasswordReset("admin", "admin@example.com"), ErrUserNotFound)
 
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	scenario.must(scenario.requestPasswordReset("admin", "admin@example.com"))
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		scenario.must(scenario.requestPasswordReset("admin", "admin@example.com"))
+	})
 }
 
 func Test_ResetPasswordFails_ifNoPasswordResetWasRequested(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.signup("admin", "admin"))
-	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	scenario.mustFailWith(scenario.resetPassword("token", "new-password"), ErrUserNotFound)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		scenario.mustFailWith(scenario.resetPassword("token", "new-password"), ErrUserNotFound)
+	})
 }
 
 func Test_ResetPasswordFails_ifRequestIsOlderThan30Minutes(t *testing.T) {
-	scenario := setup(t)
+	withEachAuthBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.signup("admin", "admin"))
+		scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
+		request := scenario.requestPasswordReset("admin", "admin@example.com").(*RequestPasswordReset)
+		request.RequestedAt = request.RequestedAt.Add(-31 * time.Minute)
+		scenario.must(request)
+
+		scenario.mustFailWith(scenario.resetPassword(request.Token, "new-password"), ErrPasswordResetExpired)
+	})
+}
+
+func Test_PersistentAuthState_ResumesReplay_AfterLastAppliedCommand(t *testing.T) {
+	config := NewPlatformConfigForTest()
+	config.AuthStore = authBackends["sqlite"](t)
+	scenario := setupWithConfig(t, config)
 	scenario.must(scenario.signup("admin", "admin"))
 	scenario.must(scenario.linkVerifiedEmailToUser("admin", "admin@example.com"))
-	request := scenario.requestPasswordReset("admin", "admin@example.com").(*RequestPasswordReset)
-	request.RequestedAt = request.RequestedAt.Add(-31 * time.Minute)
-	scenario.must(request)
 
-	scenario.mustFailWith(scenario.resetPassword(request.Token, "new-password"), ErrPasswordResetExpired)
+	restarted := setupWithConfig(t, config)
+	restarted.App.Commands = scenario.App.Commands
+	if err := restarted.App.Replay(false); err != nil {
+		t.Fatalf("expected replay to skip applied commands, got %s", err)
+	}
+	if act, exp := restarted.App.version, 2; act != exp {
+		t.Fatalf("expected version %d, got %d", exp, act)
+	}
+	if _, err := restarted.findUserByEmail("admin@example.com"); err != nil {
+		t.Fatalf("failed to find user by email: %s", err)
+	}
 }
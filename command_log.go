@@ -3,19 +3,32 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"iter"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
-	_ CommandLog     = &FileCommandLog{}
-	_ CommandReviser = &FileCommandLog{}
+	_ CommandLog           = &FileCommandLog{}
+	_ CommandReviser       = &FileCommandLog{}
+	_ CommandBatchAppender = &FileCommandLog{}
+	_ EventRecorder        = &FileCommandLog{}
+	_ io.Closer            = &FileCommandLog{}
 )
 
+// FileCommandLog stores commands in a sqlite3 database.
+//
+// The database is opened once in Setup and stays open until Close is called.
 type FileCommandLog struct {
 	filename   string
 	serializer Serializer
+
+	db     *sql.DB
+	append *sql.Stmt
+	length *sql.Stmt
+	after  *sql.Stmt
 }
 
 func NewFileCommandLog(filename string, serializer Serializer) *FileCommandLog {
@@ -26,7 +39,7 @@ func NewFileCommandLog(filename string, serializer Serializer) *FileCommandLog {
 }
 
 func (f *FileCommandLog) conninfo() string {
-	return fmt.Sprintf("file:%s?_journal=wal&_txlock=immediate", f.filename)
+	return fmt.Sprintf("file:%s?_journal=wal&_txlock=immediate&_busy_timeout=5000", f.filename)
 }
 
 func (f *FileCommandLog) Setup() error {
@@ -34,100 +47,245 @@ func (f *FileCommandLog) Setup() error {
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
-	defer db.Close()
 	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS commands (id INTEGER PRIMARY KEY, message TEXT, process_as TEXT)"); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to create commands table: %w", err)
 	}
+	if err := addMissingColumns(db, "commands", metadataColumns); err != nil {
+		db.Close()
+		return err
+	}
+	if err := addMissingColumns(db, "commands", map[string]string{"events": "TEXT"}); err != nil {
+		db.Close()
+		return err
+	}
+
+	f.db = db
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&f.append, `INSERT INTO commands (message, recorded_at, actor, origin, correlation_id, causation_id)
+      VALUES (?, ?, ?, ?, ?, ?)`},
+		{&f.length, "SELECT coalesce(max(id), 0) FROM commands"},
+		{&f.after, `SELECT id, message, process_as, recorded_at, actor, origin, correlation_id, causation_id, events
+      FROM commands WHERE id > ? ORDER BY id`},
+	}
+	for _, s := range statements {
+		if *s.stmt, err = db.Prepare(s.query); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to prepare %q: %w", s.query, err)
+		}
+	}
 
 	return nil
 }
 
-func (f *FileCommandLog) Append(command Command) error {
-	db, err := sql.Open("sqlite3", f.conninfo())
+// metadataColumns are the columns of the commands table that store CommandMetadata.
+var metadataColumns = map[string]string{
+	"recorded_at":    "TIMESTAMP",
+	"actor":          "TEXT",
+	"origin":         "TEXT",
+	"correlation_id": "TEXT",
+	"causation_id":   "INTEGER",
+}
+
+// addMissingColumns adds all columns to table which are not there yet.
+func addMissingColumns(db *sql.DB, table string, columns map[string]string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		name := ""
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+
+	for name, definition := range columns {
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition)); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", name, table, err)
+		}
+	}
+	return nil
+}
+
+// Close releases all prepared statements and closes the database.
+func (f *FileCommandLog) Close() error {
+	for _, stmt := range []*sql.Stmt{f.append, f.length, f.after} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	f.append, f.length, f.after = nil, nil, nil
+	if f.db == nil {
+		return nil
 	}
-	defer db.Close()
+	err := f.db.Close()
+	f.db = nil
+	return err
+}
+
+func (f *FileCommandLog) Append(command Command, metadata *CommandMetadata) error {
+	return f.AppendBatch([]*PersistedCommand{{Message: command, Metadata: metadata}})
+}
 
+// AppendBatch appends all entries in a single transaction.
+//
+// Either all entries are appended, or none are.
+func (f *FileCommandLog) AppendBatch(entries []*PersistedCommand) error {
+	encoded := make([][]byte, len(entries))
+	for i, entry := range entries {
+		var err error
+		if encoded[i], err = f.serializer.Encode(entry.Message); err != nil {
+			return fmt.Errorf("failed to encode command: %w", err)
+		}
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	insert := tx.Stmt(f.append)
+	for i, message := range encoded {
+		metadata := entries[i].Metadata
+		if metadata == nil {
+			metadata = &CommandMetadata{}
+		}
+		var recordedAt *time.Time
+		if !metadata.RecordedAt.IsZero() {
+			recordedAt = &metadata.RecordedAt
+		}
+		if _, err := insert.Exec(message, recordedAt, metadata.Actor, metadata.Origin, metadata.CorrelationID, metadata.CausationID); err != nil {
+			return fmt.Errorf("failed to insert command: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// AppendIfVersion appends command in a transaction that first checks
+// that the log ends at expected.
+//
+// Transactions take the write lock immediately, so no other process can
+// append between the check and the insert.
+func (f *FileCommandLog) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
 	encoded, err := f.serializer.Encode(command)
 	if err != nil {
 		return fmt.Errorf("failed to encode command: %w", err)
 	}
+	if metadata == nil {
+		metadata = &CommandMetadata{}
+	}
+	var recordedAt *time.Time
+	if !metadata.RecordedAt.IsZero() {
+		recordedAt = &metadata.RecordedAt
+	}
 
-	tx, err := db.Begin()
+	tx, err := f.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec("INSERT INTO commands (message) VALUES (?)", encoded); err != nil {
+	var length int
+	if err := tx.Stmt(f.length).QueryRow().Scan(&length); err != nil {
+		return fmt.Errorf("failed to query length: %w", err)
+	}
+	if length != expected {
+		return fmt.Errorf("expected version %d, log is at %d: %w", expected, length, ErrVersionConflict)
+	}
+	if _, err := tx.Stmt(f.append).Exec(encoded, recordedAt, metadata.Actor, metadata.Origin, metadata.CorrelationID, metadata.CausationID); err != nil {
 		return fmt.Errorf("failed to insert command: %w", err)
 	}
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
-
 	return nil
 }
 
 func (f *FileCommandLog) Length() (int, error) {
-	db, err := sql.Open("sqlite3", f.conninfo())
-	if err != nil {
-		return 0, fmt.Errorf("failed to open database: %w", err)
-	}
-	defer db.Close()
 	var length int
-	if err := db.QueryRow("SELECT max(id) FROM commands").Scan(&length); err != nil {
+	if err := f.length.QueryRow().Scan(&length); err != nil {
 		return 0, fmt.Errorf("failed to query length: %w", err)
 	}
 	return length, nil
 }
 
 func (f *FileCommandLog) After(ID int) (iter.Seq[*PersistedCommand], error) {
-	db, err := sql.Open("sqlite3", f.conninfo())
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
 	return func(yield func(*PersistedCommand) bool) {
-		defer db.Close()
-
-		query := `SELECT id, message, process_as FROM commands WHERE id > ? ORDER BY id`
-		rows, err := db.Query(query, ID)
+		rows, err := f.after.Query(ID)
 		if err != nil {
 			panic(fmt.Errorf("failed to query commands: %w", err))
 		}
+		defer rows.Close()
 
 		for rows.Next() {
 			var (
-				id        int
-				message   []byte
-				processAs []byte
+				id            int
+				message       []byte
+				processAs     []byte
+				recordedAt    sql.NullTime
+				actor         sql.NullString
+				origin        sql.NullString
+				correlationID sql.NullString
+				causationID   sql.NullInt64
+				events        []byte
 			)
 
-			if err := rows.Scan(&id, &message, &processAs); err != nil {
+			if err := rows.Scan(&id, &message, &processAs, &recordedAt, &actor, &origin, &correlationID, &causationID, &events); err != nil {
 				panic(fmt.Errorf("failed to scan row: %w", err))
 			}
 
-			cmd := new(Command)
+			cmd := f.decode(message)
+			var original Command
 			if len(processAs) > 0 {
-				message = processAs
+				original, cmd = cmd, f.decode(processAs)
+			}
+			metadata := &CommandMetadata{
+				RecordedAt:    recordedAt.Time,
+				Actor:         actor.String,
+				Origin:        origin.String,
+				CorrelationID: correlationID.String,
+				CausationID:   int(causationID.Int64),
 			}
-			if err := f.serializer.Decode(message, cmd); err != nil {
-				panic(fmt.Errorf("failed to decode command: %w (raw: %s)", err, message))
+			decodedEvents, err := DecodeEvents(events)
+			if err != nil {
+				panic(fmt.Errorf("failed to decode events of command %d: %w", id, err))
 			}
-			if shouldContinue := yield(&PersistedCommand{ID: id, Message: *cmd}); shouldContinue == false {
+			entry := &PersistedCommand{ID: id, Message: cmd, Metadata: metadata, Original: original, Events: decodedEvents}
+			if shouldContinue := yield(entry); shouldContinue == false {
 				break
 			}
 		}
 	}, nil
 }
 
-func (f *FileCommandLog) ReviseCommands(ids []int, as func(id int) Command) error {
-	db, err := sql.Open("sqlite3", f.conninfo())
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+// decode decodes message, or returns an UndecodableCommand if that fails.
+func (f *FileCommandLog) decode(message []byte) Command {
+	var cmd Command
+	if err := f.serializer.Decode(message, &cmd); err != nil {
+		return &UndecodableCommand{Raw: message, Err: err}
 	}
-	defer db.Close()
-	tx, err := db.Begin()
+	return cmd
+}
+
+func (f *FileCommandLog) ReviseCommands(ids []int, as func(id int) Command) error {
+	tx, err := f.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -156,3 +314,30 @@ func (f *FileCommandLog) ReviseCommands(ids []int, as func(id int) Command) erro
 	}
 	return nil
 }
+
+// RecordEvents stores the events of all commands in a single transaction.
+func (f *FileCommandLog) RecordEvents(events map[int][]Event) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	update, err := tx.Prepare("UPDATE commands SET events = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare update: %w", err)
+	}
+	defer update.Close()
+	for id, recorded := range events {
+		encoded, err := EncodeEvents(recorded)
+		if err != nil {
+			return fmt.Errorf("command %d: %w", id, err)
+		}
+		if _, err := update.Exec([]byte(encoded), id); err != nil {
+			return fmt.Errorf("failed to record events of command %d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
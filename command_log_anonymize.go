@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Anonymizer rewrites the personal data in commands: usernames, email
+// addresses, magic domains, password hashes, session IDs, magic links,
+// password reset tokens and the data passed to email templates.
+//
+// Values are replaced by pseudonyms derived from a secret key, so that
+// the same value is replaced by the same pseudonym in every command.
+// All password hashes are replaced by hashes of a single known password.
+//
+// Commands need to be anonymized in the order they appear in the log.
+type Anonymizer struct {
+	key      []byte
+	password string
+
+	magicDomains []string
+	emails       map[string]string
+	hashes       map[string]PasswordHash
+}
+
+func NewAnonymizer(key []byte, password string) *Anonymizer {
+	return &Anonymizer{
+		key:      key,
+		password: password,
+		emails:   map[string]string{},
+		hashes:   map[string]PasswordHash{},
+	}
+}
+
+func (a *Anonymizer) mac(kind, value string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "\x00" + value))
+	return mac.Sum(nil)
+}
+
+// token replaces a session ID, magic link or password reset token.
+func (a *Anonymizer) token(value string) string {
+	if value == "" {
+		return ""
+	}
+	id, _ := uuid.FromBytes(a.mac("token", value)[:16])
+	return id.String()
+}
+
+// username replaces a username.  The pseudonym is short enough for the
+// default username policy.
+func (a *Anonymizer) username(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "user-" + hex.EncodeToString(a.mac("username", value)[:6])
+}
+
+// usernames replaces a list of usernames.
+func (a *Anonymizer) usernames(values []string) []string {
+	replaced := make([]string, len(values))
+	for i, value := range values {
+		replaced[i] = a.username(value)
+	}
+	return replaced
+}
+
+// email replaces an email address.
+//
+// Users signing up with a magic link are named after the part of their
+// email address before the @, which is replaced by the pseudonym of that
+// username in that case, followed by the pseudonym of the magic domain
+// they signed up with.
+func (a *Anonymizer) email(value string, magicDomain string) string {
+	if value == "" {
+		return ""
+	}
+	if pseudonym, found := a.emails[value]; found {
+		return pseudonym
+	}
+	pseudonym := "user-" + hex.EncodeToString(a.mac("email", value)[:8]) + "@example.com"
+	if magicDomain != "" {
+		pseudonym = a.username(strings.Split(value, "@")[0]) + "@" + a.domain(magicDomain)
+	}
+	a.emails[value] = pseudonym
+	return pseudonym
+}
+
+// domain replaces a magic domain.
+func (a *Anonymizer) domain(value string) string {
+	return "magic-" + hex.EncodeToString(a.mac("domain", value)[:4]) + ".example.com"
+}
+
+// templateData replaces every value passed to an email template.
+//
+// Names are replaced by the pseudonyms of the usernames, and tokens in
+// the action URLs of magic link and password reset emails by their
+// pseudonyms.  Other strings are replaced by a pseudonym of their own and
+// values of other types are dropped.
+func (a *Anonymizer) templateData(template string, data map[string]any) map[string]any {
+	if data == nil {
+		return nil
+	}
+	replaced := make(map[string]any, len(data))
+	for key, value := range data {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "name" || key == "username":
+			replaced[key] = a.username(s)
+		case key == "action_url" && (template == "magic-login" || template == "password-reset"):
+			i := strings.LastIndex(s, "/")
+			replaced[key] = s[:i+1] + a.token(s[i+1:])
+		default:
+			replaced[key] = "value-" + hex.EncodeToString(a.mac("template", s)[:8])
+		}
+	}
+	return replaced
+}
+
+// magicDomain returns the magic domain cmd signs up a new user with, if any.
+func (a *Anonymizer) magicDomain(cmd *RequestMagicLinkLogin) string {
+	if _, known := a.emails[cmd.Email]; known {
+		return ""
+	}
+	for _, domain := range a.magicDomains {
+		if cmd.DomainIsMagic([]string{domain}) {
+			return domain
+		}
+	}
+	return ""
+}
+
+// passwordHash replaces a password hash by a hash of the anonymizer's password.
+func (a *Anonymizer) passwordHash(hash PasswordHash) (PasswordHash, error) {
+	original := hash.String()
+	if replacement, found := a.hashes[original]; found {
+		return replacement, nil
+	}
+	salt := a.mac("salt", original)[:16]
+	checksum, err := scrypt.Key([]byte(a.password), salt, hash.HashIterations, hash.HashBlockSize, hash.HashParallelism, hash.HashKeySize)
+	if err != nil {
+		return hash, fmt.Errorf("failed to hash password: %w", err)
+	}
+	hash.SaltByteSize = len(salt)
+	hash.Salt = base64.StdEncoding.EncodeToString(salt)
+	hash.Checksum = base64.StdEncoding.EncodeToString(checksum)
+	a.hashes[original] = hash
+	return hash, nil
+}
+
+// AnonymizeMetadata returns a copy of metadata without the name of the actor.
+func (a *Anonymizer) AnonymizeMetadata(metadata *CommandMetadata) *CommandMetadata {
+	if metadata == nil {
+		return nil
+	}
+	c := *metadata
+	c.Actor = a.username(c.Actor)
+	return &c
+}
+
+// Anonymize returns a copy of command without personal data.
+// Commands without personal data are returned unchanged.
+func (a *Anonymizer) Anonymize(command Command) (Command, error) {
+	var err error
+	switch cmd := command.(type) {
+	case *SetMagicDomains:
+		a.magicDomains = cmd.Domains
+		c := *cmd
+		c.Domains = make([]string, len(cmd.Domains))
+		for i, domain := range cmd.Domains {
+			c.Domains[i] = a.domain(domain)
+		}
+		return &c, nil
+	case *SetAdminUsers:
+		c := *cmd
+		c.Users = a.usernames(c.Users)
+		return &c, nil
+	case *SignUpUser:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		c.PasswordHash, err = a.passwordHash(c.PasswordHash)
+		return &c, err
+	case *LogInUser:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		c.SessionID = a.token(c.SessionID)
+		c.PasswordHash, err = a.passwordHash(c.PasswordHash)
+		return &c, err
+	case *LinkVerifiedEmailToUser:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		c.Email = a.email(c.Email, "")
+		return &c, nil
+	case *RequestMagicLinkLogin:
+		c := *cmd
+		c.Email = a.email(c.Email, a.magicDomain(&c))
+		c.Magic = a.token(c.Magic)
+		return &c, nil
+	case *LogInWithMagic:
+		c := *cmd
+		c.SessionID = a.token(c.SessionID)
+		c.Magic = a.token(c.Magic)
+		return &c, nil
+	case *RequestPasswordReset:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		c.Email = a.email(c.Email, "")
+		c.Token = a.token(c.Token)
+		return &c, nil
+	case *ResetPassword:
+		c := *cmd
+		c.Token = a.token(c.Token)
+		c.NewPassword, err = a.passwordHash(c.NewPassword)
+		return &c, err
+	case *QueueEmail:
+		c := *cmd
+		c.Recipients = a.email(c.Recipients, "")
+		c.TemplateData = a.templateData(c.TemplateName, c.TemplateData)
+		return &c, nil
+	case *PostLink:
+		c := *cmd
+		c.Submitter = a.username(c.Submitter)
+		return &c, nil
+	case *PostText:
+		c := *cmd
+		c.Submitter = a.username(c.Submitter)
+		return &c, nil
+	case *PostComment:
+		c := *cmd
+		c.Author = a.username(c.Author)
+		return &c, nil
+	case *EditComment:
+		c := *cmd
+		c.EditedBy = a.username(c.EditedBy)
+		return &c, nil
+	case *EditSubmissionTitle:
+		c := *cmd
+		c.EditedBy = a.username(c.EditedBy)
+		return &c, nil
+	case *DeleteOwnComment:
+		c := *cmd
+		c.DeletedBy = a.username(c.DeletedBy)
+		return &c, nil
+	case *UpvoteSubmission:
+		c := *cmd
+		c.Voter = a.username(c.Voter)
+		return &c, nil
+	case *DownvoteSubmission:
+		c := *cmd
+		c.Voter = a.username(c.Voter)
+		return &c, nil
+	case *UpvoteComment:
+		c := *cmd
+		c.Voter = a.username(c.Voter)
+		return &c, nil
+	case *DownvoteComment:
+		c := *cmd
+		c.Voter = a.username(c.Voter)
+		return &c, nil
+	case *RetractVote:
+		c := *cmd
+		c.Voter = a.username(c.Voter)
+		return &c, nil
+	case *HideSubmission:
+		c := *cmd
+		c.HiddenBy = a.username(c.HiddenBy)
+		return &c, nil
+	case *UnhideSubmission:
+		c := *cmd
+		c.UnhiddenBy = a.username(c.UnhiddenBy)
+		return &c, nil
+	case *HideComment:
+		c := *cmd
+		c.HiddenBy = a.username(c.HiddenBy)
+		return &c, nil
+	case *UnhideComment:
+		c := *cmd
+		c.UnhiddenBy = a.username(c.UnhiddenBy)
+		return &c, nil
+	case *EnableSubscriptions:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		return &c, nil
+	case *DisableSubscriptions:
+		c := *cmd
+		c.Username = a.username(c.Username)
+		return &c, nil
+	case *SetRankingPolicy:
+		c := *cmd
+		c.SetBy = a.username(c.SetBy)
+		return &c, nil
+	case *UndecodableCommand:
+		return nil, fmt.Errorf("cannot anonymize undecodable command: %w", cmd.Err)
+	}
+	return command, nil
+}
+
+// AnonymizeCommandLog writes an anonymized copy of source to a new
+// command log in filename and checks that it replays cleanly.
+//
+// Commands keep their IDs, metadata and revisions.
+func AnonymizeCommandLog(source CommandLog, anonymizer *Anonymizer, filename string) (int, error) {
+	if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("%s already exists", filename)
+	}
+	target := NewFileCommandLog(filename, DefaultSerializer)
+	if err := target.Setup(); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", filename, err)
+	}
+	defer target.Close()
+
+	commands, err := source.After(0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read commands: %w", err)
+	}
+	written := 0
+	batch := []*PersistedCommand{}
+	flush := func() error {
+		entries := []*ExportedCommand{}
+		for _, command := range batch {
+			entry, err := exportCommand(command, DefaultSerializer)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		if err := target.insertExported(entries); err != nil {
+			return err
+		}
+		written += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for command := range commands {
+		anonymized := &PersistedCommand{ID: command.ID, Metadata: anonymizer.AnonymizeMetadata(command.Metadata)}
+		if command.Original != nil {
+			if anonymized.Original, err = anonymizer.Anonymize(command.Original); err != nil {
+				return written, fmt.Errorf("command %d: %w", command.ID, err)
+			}
+		}
+		if anonymized.Message, err = anonymizer.Anonymize(command.Message); err != nil {
+			return written, fmt.Errorf("command %d: %w", command.ID, err)
+		}
+		batch = append(batch, anonymized)
+		if len(batch) == 1000 {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return written, err
+	}
+
+	replayed, err := replayInMemory(filename, false)
+	replayed.Close()
+	if err != nil {
+		return written, fmt.Errorf("anonymized log does not replay cleanly: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_AnonymizeCommandLog_RemovesPersonalDataConsistently(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.signup("alice", "secret"))
+	login := scenario.login("alice", "secret").(*LogInUser)
+	scenario.must(login)
+	scenario.must(scenario.linkVerifiedEmailToUser("alice", "alice@private.com"))
+	reset := scenario.requestPasswordReset("alice", "alice@private.com").(*RequestPasswordReset)
+	scenario.must(reset)
+	scenario.must(scenario.resetPassword(reset.Token, "new-secret"))
+	scenario.must(scenario.setMagicDomains("corp.com"))
+	magic := scenario.requestMagicLinkLogin("bob@corp.com", "magic-token").(*RequestMagicLinkLogin)
+	scenario.must(magic)
+	scenario.must(&QueueEmail{
+		InternalID:   "email-1",
+		Recipients:   "bob@corp.com",
+		TemplateName: "magic-login",
+		TemplateData: map[string]any{"name": "bob", "action_url": "http://localhost/login/magic-token", "title": "A private title", "count": 3},
+	})
+	scenario.must(scenario.loginWithMagic("magic-token"))
+	scenario.Submitter, scenario.Viewer = "alice", "alice"
+	post := scenario.postLink("https://example.com", "Public")
+	if err := scenario.App.HandleCommandWithMetadata(post, &CommandMetadata{Actor: "alice"}); err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	scenario.must(scenario.commentOn(post.ItemID, "first"))
+	scenario.must(scenario.upvote(post.ItemID, "bob"))
+
+	anonymize := func(filename string) string {
+		t.Helper()
+		if _, err := AnonymizeCommandLog(scenario.App.Commands, NewAnonymizer([]byte("key"), "password"), filename); err != nil {
+			t.Fatalf("failed to anonymize: %s", err)
+		}
+		log := NewFileCommandLog(filename, DefaultSerializer)
+		if err := log.Setup(); err != nil {
+			t.Fatalf("failed to open anonymized log: %s", err)
+		}
+		defer log.Close()
+		out := &bytes.Buffer{}
+		if _, err := ExportCommands(log, DefaultSerializer, &ExportFilter{}, out); err != nil {
+			t.Fatalf("failed to export: %s", err)
+		}
+		return out.String()
+	}
+	dir := t.TempDir()
+	anonymized := anonymize(filepath.Join(dir, "first.db"))
+
+	for _, secret := range []string{"alice", "bob", "corp.com", login.SessionID, login.PasswordHash.Checksum, reset.Token, "magic-token", "private title"} {
+		if strings.Contains(anonymized, secret) {
+			t.Errorf("expected anonymized log not to contain %q", secret)
+		}
+	}
+	if act := anonymize(filepath.Join(dir, "second.db")); act != anonymized {
+		t.Fatalf("expected anonymization to be deterministic")
+	}
+
+	replayed, err := replayInMemory(filepath.Join(dir, "first.db"), false)
+	defer replayed.Close()
+	if err != nil {
+		t.Fatalf("failed to replay anonymized log: %s", err)
+	}
+	anonymizer := NewAnonymizer([]byte("key"), "password")
+	if err := replayed.HandleQuery(NewFindUserPasswordHash(anonymizer.username("alice"), "password")); err != nil {
+		t.Fatalf("expected alice to log in with the anonymized password: %s", err)
+	}
+	bob := anonymizer.username("bob")
+	findBob := NewFindUserByName(bob)
+	if err := replayed.HandleQuery(findBob); err != nil || !strings.HasPrefix(findBob.User.VerifiedEmail, bob+"@magic-") {
+		t.Fatalf("expected bob to be signed up by magic link with a pseudonymous domain, got %v (%v)", findBob.User, err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// CompactionRule decides which commands of one type are still needed to
+// derive the current state.
+//
+// A new rule is created for every compaction.  It observes all commands
+// in the log, oldest first, before it is asked about any of them.
+type CompactionRule interface {
+	Observe(command *PersistedCommand)
+	Keep(command *PersistedCommand) bool
+}
+
+// CompactionRules maps command names to their compaction rules.
+//
+// Commands without a rule are always kept.
+type CompactionRules map[string]func(now time.Time) CompactionRule
+
+func (r CompactionRules) Register(name string, newRule func(now time.Time) CompactionRule) {
+	r[name] = newRule
+}
+
+var DefaultCompactionRules = make(CompactionRules)
+
+// ErrCompactionChangesState is returned if replaying the compacted log
+// does not result in the same state as replaying the original log.
+var ErrCompactionChangesState = errors.New("compacted log results in different state")
+
+// CompactionReport summarizes a compaction.
+type CompactionReport struct {
+	Kept     int
+	Archived map[string]int
+}
+
+// selectCommands returns the IDs of the commands in log that rules consider still needed.
+//
+// The last command is always kept, so that the log's version does not change.
+func selectCommands(log CommandLog, rules CompactionRules, now time.Time) (map[int]bool, *CompactionReport, error) {
+	instances := map[string]CompactionRule{}
+	for name, newRule := range rules {
+		instances[name] = newRule(now)
+	}
+
+	commands, err := log.After(0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	last := 0
+	for command := range commands {
+		if undecodable, ok := command.Message.(*UndecodableCommand); ok {
+			return nil, nil, fmt.Errorf("command %d cannot be decoded, run verify-log: %w", command.ID, undecodable.Err)
+		}
+		for _, rule := range instances {
+			rule.Observe(command)
+		}
+		last = command.ID
+	}
+
+	keep := map[int]bool{}
+	report := &CompactionReport{Archived: map[string]int{}}
+	commands, err = log.After(0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	for command := range commands {
+		name := command.Message.CommandName()
+		rule, found := instances[name]
+		if !found || command.ID == last || rule.Keep(command) {
+			keep[command.ID] = true
+			report.Kept++
+		} else {
+			report.Archived[name]++
+		}
+	}
+	return keep, report, nil
+}
+
+// copyCommands copies the commands for which include returns true into dest,
+// together with their IDs, metadata, events and whether they are skipped.
+//
+// Commands dest already contains are left alone.
+func (f *FileCommandLog) copyCommands(dest *FileCommandLog, include func(id int) bool) error {
+	rows, err := f.db.Query(`SELECT id, message, process_as, recorded_at, actor, origin, correlation_id, causation_id, events
+    FROM commands ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to query commands: %w", err)
+	}
+	defer rows.Close()
+
+	tx, err := dest.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	insert, err := tx.Prepare(`INSERT OR IGNORE INTO commands (id, message, process_as, recorded_at, actor, origin, correlation_id, causation_id, events)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer insert.Close()
+
+	row := make([]any, 9)
+	pointers := make([]any, len(row))
+	for i := range row {
+		pointers[i] = &row[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		id, _ := row[0].(int64)
+		if !include(int(id)) {
+			continue
+		}
+		if _, err := insert.Exec(row...); err != nil {
+			return fmt.Errorf("failed to copy command %d: %w", id, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read commands: %w", err)
+	}
+	return tx.Commit()
+}
+
+// replayedState replays the command log stored in filename into fresh,
+// in-memory modules and returns their serialized state.
+//
+// Next to the mounted modules, it includes the snapshots of the mailer
+// and the notifier after catching up with the log.
+func replayedState(filename string) (map[string]json.RawMessage, error) {
+	app, err := replayInMemory(filename, false)
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+	defer app.Close()
+
+	app.lock.RLock()
+	defer app.lock.RUnlock()
+	states, err := app.moduleStates()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mailer := NewMailer(nil, logger, app)
+	mailer.catchUp()
+	if states["mailer"], err = mailer.Snapshot(); err != nil {
+		return nil, err
+	}
+	notifier := NewNotifier(app, app.Commands, logger, nil)
+	notifier.catchUp()
+	if states["notifier"], err = notifier.Snapshot(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// verifyCompaction checks that replaying compacted results in the same state as replaying original.
+func verifyCompaction(original, compacted string) error {
+	expected, err := replayedState(original)
+	if err != nil {
+		return err
+	}
+	actual, err := replayedState(compacted)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompactionChangesState, err)
+	}
+	names := []string{}
+	for name := range expected {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if !bytes.Equal(expected[name], actual[name]) {
+			return fmt.Errorf("module %s: %w", name, ErrCompactionChangesState)
+		}
+	}
+	return nil
+}
+
+// CompactCommandLogFile replaces the command log stored in filename with
+// one containing only the commands rules consider still needed.
+//
+// The compacted log is written to filename.compacted and verified by
+// replaying both logs.  Only then are the remaining commands moved into
+// the archive database filename.archive and the compacted log swapped in.
+// The original log is kept as filename.bak.
+//
+// The application must not be running while the log is compacted.
+func CompactCommandLogFile(filename string, rules CompactionRules, now time.Time, out io.Writer) error {
+	source := NewFileCommandLog(filename, DefaultSerializer)
+	if err := source.Setup(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer source.Close()
+
+	keep, report, err := selectCommands(source, rules, now)
+	if err != nil {
+		return err
+	}
+
+	compactedFilename := filename + ".compacted"
+	if err := removeDatabase(compactedFilename); err != nil {
+		return err
+	}
+	compacted := NewFileCommandLog(compactedFilename, DefaultSerializer)
+	if err := compacted.Setup(); err != nil {
+		return fmt.Errorf("failed to create %s: %w", compactedFilename, err)
+	}
+	err = source.copyCommands(compacted, func(id int) bool { return keep[id] })
+	if closeErr := compacted.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", compactedFilename, err)
+	}
+
+	if err := verifyCompaction(filename, compactedFilename); err != nil {
+		return fmt.Errorf("%s left in place for inspection: %w", compactedFilename, err)
+	}
+
+	archiveFilename := filename + ".archive"
+	archive := NewFileCommandLog(archiveFilename, DefaultSerializer)
+	if err := archive.Setup(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", archiveFilename, err)
+	}
+	err = source.copyCommands(archive, func(id int) bool { return !keep[id] })
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to archive commands: %w", err)
+	}
+
+	if err := source.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", filename, err)
+	}
+	if err := os.Rename(filename, filename+".bak"); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", filename, err)
+	}
+	if err := os.Rename(compactedFilename, filename); err != nil {
+		return fmt.Errorf("failed to swap in %s: %w", compactedFilename, err)
+	}
+
+	archived := 0
+	names := []string{}
+	for name, n := range report.Archived {
+		archived += n
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fmt.Fprintf(out, "kept %d commands, archived %d in %s\n", report.Kept, archived, archiveFilename)
+	for _, name := range names {
+		fmt.Fprintf(out, "% 8d %s\n", report.Archived[name], name)
+	}
+	fmt.Fprintf(out, "the original log was moved to %s\n", filename+".bak")
+	return nil
+}
+
+// removeDatabase removes the sqlite3 database stored in filename, if there is one.
+func removeDatabase(filename string) error {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(filename + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", filename+suffix, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCompactionLog writes commands to a new command log, each recorded at the given time.
+func newCompactionLog(t *testing.T, commands []Command, recordedAt []time.Time) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "commands.db")
+	log := NewFileCommandLog(filename, DefaultSerializer)
+	if err := log.Setup(); err != nil {
+		t.Fatalf("failed to set up command log: %s", err)
+	}
+	defer log.Close()
+	for i, command := range commands {
+		if err := log.Append(command, &CommandMetadata{RecordedAt: recordedAt[i]}); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	return filename
+}
+
+func commandIDs(t *testing.T, filename string) string {
+	t.Helper()
+	log := NewFileCommandLog(filename, DefaultSerializer)
+	if err := log.Setup(); err != nil {
+		t.Fatalf("failed to open %s: %s", filename, err)
+	}
+	defer log.Close()
+	commands, err := log.After(0)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", filename, err)
+	}
+	ids := []int{}
+	for command := range commands {
+		ids = append(ids, command.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func Test_CompactCommandLogFile_ArchivesCommandsNoLongerNeeded(t *testing.T) {
+	now := time.Now()
+	hoursAgo := func(n int) time.Time { return now.Add(time.Duration(-n) * time.Hour) }
+	commands := []Command{
+		/* 1 */ &SetNotifierConfig{Enabled: true},
+		/* 2 */ &SetNotifierConfig{Enabled: false},
+		/* 3 */ &PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: hoursAgo(3)},
+		/* 4 */ &SetNotifierConfig{Enabled: true},
+		/* 5 */ &SetMagicDomains{Domains: []string{"example.com"}},
+		/* 6 */ &RequestMagicLinkLogin{Email: "bob@example.com", Magic: "m1", RequestedAt: hoursAgo(3)},
+		/* 7 */ &RequestMagicLinkLogin{Email: "bob@example.com", Magic: "m2", RequestedAt: hoursAgo(3)},
+		/* 8 */ &RequestMagicLinkLogin{Email: "bob@example.com", Magic: "m3", RequestedAt: hoursAgo(2)},
+		/* 9 */ &LogInWithMagic{SessionID: "session-1", Magic: "m3", AttemptedAt: hoursAgo(2)},
+		/* 10 */ &RequestMagicLinkLogin{Email: "bob@example.com", Magic: "m4", RequestedAt: now},
+		/* 11 */ &QueueEmail{InternalID: "e1", TemplateName: "password-reset"},
+		/* 12 */ &SetEmailDeliveryStatus{InternalID: "e1", Status: StatusDelivered},
+		/* 13 */ &QueueEmail{InternalID: "post-1:bob", TemplateName: "content-notification"},
+		/* 14 */ &SetEmailDeliveryStatus{InternalID: "post-1:bob", Status: StatusDelivered},
+		/* 15 */ &QueueEmail{InternalID: "e3", TemplateName: "magic-login"},
+		/* 16 */ &SetEmailDeliveryStatus{InternalID: "e3", Status: StatusDelivered},
+	}
+	recordedAt := make([]time.Time, len(commands))
+	for i := range recordedAt {
+		recordedAt[i] = hoursAgo(2)
+	}
+	recordedAt[14], recordedAt[15] = now, now
+	filename := newCompactionLog(t, commands, recordedAt)
+
+	out := &strings.Builder{}
+	if err := CompactCommandLogFile(filename, DefaultCompactionRules, now, out); err != nil {
+		t.Fatalf("failed to compact: %s\n%s", err, out)
+	}
+
+	if act, exp := commandIDs(t, filename), "[2 3 4 5 6 8 9 10 13 14 15 16]"; act != exp {
+		t.Fatalf("expected compacted log to contain %s, got %s", exp, act)
+	}
+	if act, exp := commandIDs(t, filename+".archive"), "[1 7 11 12]"; act != exp {
+		t.Fatalf("expected archive to contain %s, got %s", exp, act)
+	}
+	if act, exp := commandIDs(t, filename+".bak"), "[1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16]"; act != exp {
+		t.Fatalf("expected original log to contain %s, got %s", exp, act)
+	}
+	if act, exp := out.String(), "kept 12 commands, archived 4"; !strings.Contains(act, exp) {
+		t.Fatalf("expected report to contain %q, got:\n%s", exp, act)
+	}
+}
+
+func Test_CompactCommandLogFile_LeavesLogUntouched_WhenStateWouldChange(t *testing.T) {
+	commands := []Command{
+		&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()},
+		&SetNotifierConfig{Enabled: true},
+	}
+	filename := newCompactionLog(t, commands, []time.Time{time.Now(), time.Now()})
+	rules := CompactionRules{}
+	rules.Register("PostLink", func(now time.Time) CompactionRule { return &dropEverythingRule{} })
+
+	err := CompactCommandLogFile(filename, rules, time.Now(), &strings.Builder{})
+	if !errors.Is(err, ErrCompactionChangesState) {
+		t.Fatalf("expected %s, got %v", ErrCompactionChangesState, err)
+	}
+	if act, exp := commandIDs(t, filename), "[1 2]"; act != exp {
+		t.Fatalf("expected original log to contain %s, got %s", exp, act)
+	}
+	if _, err := os.Stat(filename + ".archive"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no archive to be written, got %v", err)
+	}
+}
+
+func Test_CompactCommandLogFile_DetectsChangesToMailerAndNotifier(t *testing.T) {
+	for name, command := range map[string]Command{
+		"QueueEmail":        &QueueEmail{InternalID: "e1", TemplateName: "password-reset"},
+		"SetNotifierConfig": &SetNotifierConfig{Enabled: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			commands := []Command{command, &PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()}}
+			filename := newCompactionLog(t, commands, []time.Time{time.Now(), time.Now()})
+			rules := CompactionRules{}
+			rules.Register(name, func(now time.Time) CompactionRule { return &dropEverythingRule{} })
+
+			if err := CompactCommandLogFile(filename, rules, time.Now(), &strings.Builder{}); !errors.Is(err, ErrCompactionChangesState) {
+				t.Fatalf("expected %s, got %v", ErrCompactionChangesState, err)
+			}
+		})
+	}
+}
+
+type dropEverythingRule struct{}
+
+func (r *dropEverythingRule) Observe(command *PersistedCommand)   {}
+func (r *dropEverythingRule) Keep(command *PersistedCommand) bool { return false }
+
+func Test_ReplayReport_And_Compaction_LeaveEventsOfSourceLogAlone(t *testing.T) {
+	commands := []Command{
+		&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()},
+		&SetNotifierConfig{Enabled: true},
+	}
+	filename := newCompactionLog(t, commands, []time.Time{time.Now(), time.Now()})
+	exported := func(filename string) string {
+		log := NewFileCommandLog(filename, DefaultSerializer)
+		if err := log.Setup(); err != nil {
+			t.Fatalf("failed to open %s: %s", filename, err)
+		}
+		defer log.Close()
+		return exportAll(t, log, &ExportFilter{})
+	}
+	before := exported(filename)
+	if strings.Contains(before, `"events"`) {
+		t.Fatalf("expected no events to be recorded yet, got:\n%s", before)
+	}
+
+	report, err := replayInMemory(filename, true)
+	report.Close()
+	if err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	if act := exported(filename); act != before {
+		t.Fatalf("expected replay report to leave log untouched, got:\n%s", act)
+	}
+
+	if err := CompactCommandLogFile(filename, DefaultCompactionRules, time.Now(), &strings.Builder{}); err != nil {
+		t.Fatalf("failed to compact: %s", err)
+	}
+	if act := exported(filename + ".bak"); act != before {
+		t.Fatalf("expected compaction to leave original log untouched, got:\n%s", act)
+	}
+}
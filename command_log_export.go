@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// ExportedCommand is a single line of a command log export.
+//
+// Message and ProcessAs are encoded by the command log's Serializer.
+// ProcessAs is only present for revised commands, e.g. skipped ones.
+// Events is only present once the events of a command have been recorded.
+type ExportedCommand struct {
+	ID        int              `json:"id"`
+	Message   json.RawMessage  `json:"message"`
+	ProcessAs json.RawMessage  `json:"process_as,omitempty"`
+	Metadata  *CommandMetadata `json:"metadata,omitempty"`
+	Events    json.RawMessage  `json:"events,omitempty"`
+}
+
+// ExportFilter selects which commands to export.
+type ExportFilter struct {
+	// After and Until limit the IDs of exported commands; Until is ignored if zero.
+	After int
+	Until int
+	// Types limits exported commands to the given command names, if not empty.
+	// Revised commands are matched by their original name.
+	Types []string
+}
+
+func (f *ExportFilter) matches(command *PersistedCommand) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	original := command.Message
+	if command.Original != nil {
+		original = command.Original
+	}
+	return slices.Contains(f.Types, original.CommandName())
+}
+
+// ExportCommands writes the commands of log matching filter to out as JSON Lines.
+func ExportCommands(log CommandLog, serializer Serializer, filter *ExportFilter, out io.Writer) (int, error) {
+	commands, err := log.After(filter.After)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read commands: %w", err)
+	}
+	encoder := json.NewEncoder(out)
+	exported := 0
+	for command := range commands {
+		if filter.Until != 0 && command.ID > filter.Until {
+			break
+		}
+		if !filter.matches(command) {
+			continue
+		}
+		entry, err := exportCommand(command, serializer)
+		if err != nil {
+			return exported, err
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return exported, fmt.Errorf("failed to write command %d: %w", command.ID, err)
+		}
+		exported++
+	}
+	return exported, nil
+}
+
+func exportCommand(command *PersistedCommand, serializer Serializer) (*ExportedCommand, error) {
+	encode := func(message Command) (json.RawMessage, error) {
+		if undecodable, ok := message.(*UndecodableCommand); ok {
+			return undecodable.Raw, nil
+		}
+		data, err := serializer.Encode(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode command %d: %w", command.ID, err)
+		}
+		return data, nil
+	}
+
+	entry := &ExportedCommand{ID: command.ID, Metadata: command.Metadata}
+	message, processAs := command.Message, Command(nil)
+	if command.Original != nil {
+		message, processAs = command.Original, command.Message
+	}
+	var err error
+	if entry.Message, err = encode(message); err != nil {
+		return nil, err
+	}
+	if processAs != nil {
+		if entry.ProcessAs, err = encode(processAs); err != nil {
+			return nil, err
+		}
+	}
+	if command.Events != nil {
+		if entry.Events, err = EncodeEvents(command.Events); err != nil {
+			return nil, fmt.Errorf("command %d: %w", command.ID, err)
+		}
+	}
+	return entry, nil
+}
+
+// maxImportLine is the length of the longest line ImportCommands accepts.
+const maxImportLine = 16 * 1024 * 1024
+
+// ImportCommands appends the commands exported to in to target.
+//
+// Every command is first handled by a scratch app, which has replayed
+// target beforehand and is set up like config, so commands are checked
+// against the same edit window and downvote karma as on the platform.
+// Revised commands are handled as their revision. If any command is
+// rejected, nothing is imported.
+//
+// Commands are read, checked and inserted one line at a time, so the
+// export does not need to fit in memory.  They keep their IDs, which need
+// to be increasing and come after the last command in target. Target is
+// locked for writing during the whole import, so no command can be
+// appended in between.
+func ImportCommands(target *FileCommandLog, config *PlatformConfig, in io.Reader) (int, error) {
+	inserter, err := target.beginInsertExported()
+	if err != nil {
+		return 0, err
+	}
+	defer inserter.Rollback()
+	scratch, err := newImportScratch(target, config, inserter.last)
+	if err != nil {
+		return 0, err
+	}
+	defer scratch.Close()
+
+	imported := 0
+	lines := bufio.NewScanner(in)
+	lines.Buffer(make([]byte, 64*1024), maxImportLine)
+	lineNumber := 1
+	for ; lines.Scan(); lineNumber++ {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		entry := &ExportedCommand{}
+		if err := json.Unmarshal(lines.Bytes(), entry); err != nil {
+			return 0, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := validateImport(scratch, target.serializer, inserter.last, entry); err != nil {
+			return 0, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if err := inserter.Insert(entry); err != nil {
+			return 0, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		imported++
+	}
+	if err := lines.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return 0, fmt.Errorf("line %d is longer than %d bytes: %w", lineNumber, maxImportLine, err)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read commands: %w", err)
+	}
+	if err := inserter.Commit(); err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// newImportScratch creates an app set up like config, which has replayed the first last commands of target.
+func newImportScratch(target *FileCommandLog, config *PlatformConfig, last int) (*App, error) {
+	scratchConfig := *config
+	scratchConfig.CommandLog = parseURL("memory://", "CommandLog")
+	scratchConfig.Snapshots = parseURL("none://", "Snapshots")
+	scratchConfig.ContentStore = parseURL("memory://", "ContentStore")
+	scratchConfig.AuthStore = parseURL("memory://", "AuthStore")
+	scratchConfig.EmailSender = parseURL("memory://", "EmailSender")
+	scratchConfig.Follower = false
+	scratchConfig.QuarantineReplayErrors = false
+	scratch, _ := HackerNews(&scratchConfig)
+
+	existing, err := target.After(0)
+	if err != nil {
+		scratch.Close()
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	for command := range existing {
+		if command.ID > last {
+			break
+		}
+		if err := scratch.Commands.Append(command.Message, command.Metadata); err != nil {
+			scratch.Close()
+			return nil, fmt.Errorf("failed to copy command %d: %w", command.ID, err)
+		}
+	}
+	if err := scratch.Replay(false); err != nil {
+		scratch.Close()
+		return nil, fmt.Errorf("failed to replay existing commands: %w", err)
+	}
+	return scratch, nil
+}
+
+// validateImport handles entry in scratch, unless it does not come after command last.
+func validateImport(scratch *App, serializer Serializer, last int, entry *ExportedCommand) error {
+	if entry.ID <= last {
+		return fmt.Errorf("command %d does not come after command %d", entry.ID, last)
+	}
+	effective := entry.Message
+	if len(entry.ProcessAs) > 0 {
+		effective = entry.ProcessAs
+	}
+	var command Command
+	if err := serializer.Decode(effective, &command); err != nil {
+		return fmt.Errorf("command %d: %w", entry.ID, err)
+	}
+	if err := scratch.HandleCommandWithMetadata(command, entry.Metadata); err != nil {
+		return fmt.Errorf("command %d: %w", entry.ID, err)
+	}
+	return nil
+}
+
+// exportedInserter inserts exported commands into a FileCommandLog in a
+// single transaction, keeping their IDs.
+//
+// The transaction holds the write lock from the start.
+type exportedInserter struct {
+	tx     *sql.Tx
+	insert *sql.Stmt
+	// last is the ID of the last command in the log, including the ones inserted so far.
+	last int
+}
+
+func (f *FileCommandLog) beginInsertExported() (*exportedInserter, error) {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	inserter := &exportedInserter{tx: tx}
+	if err := tx.Stmt(f.length).QueryRow().Scan(&inserter.last); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to query length: %w", err)
+	}
+	inserter.insert, err = tx.Prepare(`INSERT INTO commands (id, message, process_as, recorded_at, actor, origin, correlation_id, causation_id, events)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	return inserter, nil
+}
+
+func (i *exportedInserter) Insert(entry *ExportedCommand) error {
+	metadata := entry.Metadata
+	if metadata == nil {
+		metadata = &CommandMetadata{}
+	}
+	var (
+		processAs  []byte
+		events     []byte
+		recordedAt *time.Time
+	)
+	if len(entry.ProcessAs) > 0 {
+		processAs = entry.ProcessAs
+	}
+	if len(entry.Events) > 0 {
+		events = entry.Events
+	}
+	if !metadata.RecordedAt.IsZero() {
+		recordedAt = &metadata.RecordedAt
+	}
+	_, err := i.insert.Exec(entry.ID, []byte(entry.Message), processAs, recordedAt,
+		metadata.Actor, metadata.Origin, metadata.CorrelationID, metadata.CausationID, events)
+	if err != nil {
+		return fmt.Errorf("failed to insert command %d: %w", entry.ID, err)
+	}
+	i.last = max(i.last, entry.ID)
+	return nil
+}
+
+func (i *exportedInserter) Commit() error {
+	i.insert.Close()
+	if err := i.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards all inserted commands, unless they have been committed already.
+func (i *exportedInserter) Rollback() error {
+	i.insert.Close()
+	return i.tx.Rollback()
+}
+
+// insertExported inserts entries in a single transaction, keeping their IDs.
+func (f *FileCommandLog) insertExported(entries []*ExportedCommand) error {
+	inserter, err := f.beginInsertExported()
+	if err != nil {
+		return err
+	}
+	defer inserter.Rollback()
+	for _, entry := range entries {
+		if err := inserter.Insert(entry); err != nil {
+			return err
+		}
+	}
+	return inserter.Commit()
+}
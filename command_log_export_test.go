@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newExportTestLog(t *testing.T) *FileCommandLog {
+	t.Helper()
+	log := NewFileCommandLog(filepath.Join(t.TempDir(), "commands.db"), DefaultSerializer)
+	if err := log.Setup(); err != nil {
+		t.Fatalf("failed to set up command log: %s", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func exportAll(t *testing.T, log CommandLog, filter *ExportFilter) string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	if _, err := ExportCommands(log, DefaultSerializer, filter, out); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	return out.String()
+}
+
+func Test_ExportCommands_ImportCommands_RoundTrip(t *testing.T) {
+	source := newExportTestLog(t)
+	recordedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, command := range []Command{
+		&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: recordedAt},
+		&UpvoteSubmission{ItemID: "post-1", Voter: "bob", VotedAt: recordedAt},
+		&PostLink{ItemID: "post-2", Submitter: "alice", Url: "https://example.org", Title: "Skipped", SubmittedAt: recordedAt},
+	} {
+		if err := source.Append(command, &CommandMetadata{RecordedAt: recordedAt, Actor: "alice", Origin: "web"}); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	if err := source.ReviseCommands([]int{3}, func(id int) Command { return &SkipCommand{} }); err != nil {
+		t.Fatalf("failed to skip command: %s", err)
+	}
+	exported := exportAll(t, source, &ExportFilter{})
+
+	target := newExportTestLog(t)
+	n, err := ImportCommands(target, NewPlatformConfigForTest(), strings.NewReader(exported))
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	if act, exp := n, 3; act != exp {
+		t.Fatalf("expected %d commands to be imported, got %d", exp, act)
+	}
+	if act, exp := exportAll(t, target, &ExportFilter{}), exported; act != exp {
+		t.Fatalf("expected re-export to match:\n%s\ngot:\n%s", exp, act)
+	}
+	if act, exp := strings.Count(exported, `"process_as":{"type":"SkipCommand"`), 1; act != exp {
+		t.Fatalf("expected %d skipped command in export, got %d:\n%s", exp, act, exported)
+	}
+}
+
+func Test_ExportCommands_FiltersByIDAndType(t *testing.T) {
+	log := newExportTestLog(t)
+	for i := 1; i <= 5; i++ {
+		if err := log.Append(benchmarkCommand(i), nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	if err := log.Append(&SetNotifierConfig{Enabled: true}, nil); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+
+	exported := exportAll(t, log, &ExportFilter{After: 1, Until: 3})
+	if act, exp := strings.Count(exported, "\n"), 2; act != exp {
+		t.Fatalf("expected %d commands, got %d:\n%s", exp, act, exported)
+	}
+	exported = exportAll(t, log, &ExportFilter{Types: []string{"SetNotifierConfig"}})
+	if !strings.HasPrefix(exported, `{"id":6,`) || strings.Count(exported, "\n") != 1 {
+		t.Fatalf("expected only command 6, got:\n%s", exported)
+	}
+}
+
+func Test_ImportCommands_RejectsInvalidCommands(t *testing.T) {
+	source := newExportTestLog(t)
+	source.Append(&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()}, nil)
+	source.Append(&UpvoteSubmission{ItemID: "post-1", Voter: "bob", VotedAt: time.Now()}, nil)
+	source.Append(&UpvoteSubmission{ItemID: "post-1", Voter: "bob", VotedAt: time.Now()}, nil)
+
+	target := newExportTestLog(t)
+	if _, err := ImportCommands(target, NewPlatformConfigForTest(), strings.NewReader(exportAll(t, source, &ExportFilter{}))); err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+		t.Fatalf("expected import to fail on line 3, got %v", err)
+	}
+	if length, err := target.Length(); err != nil || length != 0 {
+		t.Fatalf("expected nothing to be imported, got %d commands (%v)", length, err)
+	}
+}
+
+func Test_ImportCommands_ReportsLineTooLong(t *testing.T) {
+	source := newExportTestLog(t)
+	source.Append(&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()}, nil)
+	tooLong := `{"id":2,"message":"` + strings.Repeat("x", maxImportLine) + `"}` + "\n"
+
+	target := newExportTestLog(t)
+	_, err := ImportCommands(target, NewPlatformConfigForTest(), strings.NewReader(exportAll(t, source, &ExportFilter{})+tooLong))
+	if !errors.Is(err, bufio.ErrTooLong) || !strings.HasPrefix(err.Error(), "line 2 ") {
+		t.Fatalf("expected line 2 to be too long, got %v", err)
+	}
+	if length, err := target.Length(); err != nil || length != 0 {
+		t.Fatalf("expected nothing to be imported, got %d commands (%v)", length, err)
+	}
+}
+
+func Test_ImportCommands_ValidatesWithPlatformConfig(t *testing.T) {
+	source := newExportTestLog(t)
+	now := time.Now()
+	for _, command := range []Command{
+		&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: now},
+		&PostLink{ItemID: "post-2", Submitter: "bob", Url: "https://example.org", Title: "Other", SubmittedAt: now},
+		&UpvoteSubmission{ItemID: "post-1", Voter: "bob", VotedAt: now},
+		&DownvoteSubmission{ItemID: "post-2", Voter: "alice", VotedAt: now},
+	} {
+		if err := source.Append(command, nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	exported := exportAll(t, source, &ExportFilter{})
+
+	if _, err := ImportCommands(newExportTestLog(t), NewPlatformConfigForTest(), strings.NewReader(exported)); !errors.Is(err, ErrDownvotesDisabled) {
+		t.Fatalf("expected downvote to be rejected with downvotes disabled, got %v", err)
+	}
+
+	config := NewPlatformConfigForTest()
+	config.DownvoteKarma = 1
+	target := newExportTestLog(t)
+	if _, err := ImportCommands(target, config, strings.NewReader(exported)); err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	if act, exp := exportAll(t, target, &ExportFilter{}), exported; act != exp {
+		t.Fatalf("expected re-export to match:\n%s\ngot:\n%s", exp, act)
+	}
+}
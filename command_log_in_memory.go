@@ -1,16 +1,25 @@
 package main
 
-import "iter"
+import (
+	"iter"
+	"slices"
+	"sync"
+)
 
+// InMemoryCommandLog keeps commands in memory; it is safe for concurrent use,
+// so that background processes can read it while the app appends to it.
 type InMemoryCommandLog struct {
+	lock     sync.RWMutex
 	messages []*PersistedCommand
 }
 
 func (self *InMemoryCommandLog) After(id int) (iter.Seq[*PersistedCommand], error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
 	if len(self.messages) < id {
 		id = len(self.messages) - 1
 	}
-	messages := self.messages[id:]
+	messages := slices.Clone(self.messages[id:])
 	after := func(yield func(*PersistedCommand) bool) {
 		for _, c := range messages {
 			if yield(c) == false {
@@ -22,11 +31,78 @@ func (self *InMemoryCommandLog) After(id int) (iter.Seq[*PersistedCommand], erro
 }
 
 // Append implements CommandLog.
-func (self *InMemoryCommandLog) Append(command Command) error {
+func (self *InMemoryCommandLog) Append(command Command, metadata *CommandMetadata) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.append(command, metadata)
+	return nil
+}
+
+func (self *InMemoryCommandLog) append(command Command, metadata *CommandMetadata) {
 	id := len(self.messages)
 	id += 1
-	newEntry := &PersistedCommand{ID: id, Message: command}
+	if metadata == nil {
+		metadata = &CommandMetadata{}
+	}
+	newEntry := &PersistedCommand{ID: id, Message: command, Metadata: metadata}
 	self.messages = append(self.messages, newEntry)
+}
+
+// AppendIfVersion implements CommandLog.
+func (self *InMemoryCommandLog) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.messages) != expected {
+		return ErrVersionConflict
+	}
+	self.append(command, metadata)
+	return nil
+}
+
+// AppendBatch implements CommandBatchAppender.
+func (self *InMemoryCommandLog) AppendBatch(entries []*PersistedCommand) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, entry := range entries {
+		self.append(entry.Message, entry.Metadata)
+	}
+	return nil
+}
+
+// RecordEvents implements EventRecorder.
+func (self *InMemoryCommandLog) RecordEvents(events map[int][]Event) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, recorded := range events {
+		if id < 1 || id > len(self.messages) {
+			continue
+		}
+		entry := *self.messages[id-1]
+		entry.Events = recorded
+		self.messages[id-1] = &entry
+	}
+	return nil
+}
+
+// ReviseCommands implements CommandReviser.
+func (self *InMemoryCommandLog) ReviseCommands(ids []int, as func(id int) Command) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, id := range ids {
+		if id < 1 || id > len(self.messages) {
+			continue
+		}
+		entry := *self.messages[id-1]
+		original := entry.Message
+		if entry.Original != nil {
+			original = entry.Original
+		}
+		entry.Message, entry.Original = original, nil
+		if command := as(id); command != nil {
+			entry.Message, entry.Original = command, original
+		}
+		self.messages[id-1] = &entry
+	}
 	return nil
 }
 
@@ -37,5 +113,7 @@ func NewInMemoryCommandLog() *InMemoryCommandLog {
 }
 
 func (self *InMemoryCommandLog) Length() (int, error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
 	return len(self.messages), nil
 }
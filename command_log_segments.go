@@ -0,0 +1,900 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	_ CommandLog           = &SegmentCommandLog{}
+	_ CommandReviser       = &SegmentCommandLog{}
+	_ CommandBatchAppender = &SegmentCommandLog{}
+	_ EventRecorder        = &SegmentCommandLog{}
+	_ io.Closer            = &SegmentCommandLog{}
+)
+
+// SyncPolicy decides when SegmentCommandLog flushes appended commands to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes after every append, so that no appended command is lost in a crash.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes on append if SyncInterval has passed since the last flush.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+// ErrCorruptRecord is returned for records whose checksum does not match
+// their contents, or whose header holds an impossible length.
+var ErrCorruptRecord = errors.New("corrupt record")
+
+// segmentRecordHeaderSize is the size of the length and checksum preceding every record.
+const segmentRecordHeaderSize = 8
+
+// maxSegmentRecordLength limits the length read from a record header,
+// which the checksum does not cover.
+const maxSegmentRecordLength = 64 << 20
+
+var segmentChecksum = crc32.MakeTable(crc32.Castagnoli)
+
+// SegmentCommandLog stores commands in a directory of append-only segment files.
+//
+// Every record is an ExportedCommand encoded as JSON, preceded by its
+// length and CRC-32C checksum.  A segment is named after the ID of its
+// first command; a new segment is started once the last one has grown
+// past SegmentSize.  Next to each segment, a sparse index stores the offset
+// of every IndexInterval-th record, so that reading the tail of the log
+// does not require scanning whole segments.
+//
+// Revisions are appended to revisions.log and override the commands they
+// revise.  Likewise, events recorded after a command was appended are
+// appended to events.log.
+//
+// Appending takes an exclusive lock on the directory, so that several
+// processes can share the log.  A record that was only partially written
+// when a process crashed is cut off by Setup, or overwritten by the next append.
+type SegmentCommandLog struct {
+	dir        string
+	serializer Serializer
+
+	SegmentSize   int64
+	IndexInterval int
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+
+	lock     sync.Mutex
+	lockFile *os.File
+	segments []*segment
+	length   int
+	lastSync time.Time
+
+	// revisions maps IDs to the command they are processed as; an empty
+	// revision undoes earlier ones.
+	revisions    map[int]json.RawMessage
+	revisionsEnd int64
+	// events maps IDs to the events recorded for them in events.log.
+	events    map[int]json.RawMessage
+	eventsEnd int64
+}
+
+// segment describes a segment file up to its last complete record.
+type segment struct {
+	first int
+	last  int
+	end   int64
+	index []segmentIndexEntry
+	// unindexed counts the records after the last indexed one.
+	unindexed int
+}
+
+// segmentIndexEntry locates the record of a command in its segment.
+type segmentIndexEntry struct {
+	ID     int
+	Offset int64
+}
+
+// tornRecords decides what happens when reading a segment stops at an incomplete record.
+type tornRecords int
+
+const (
+	// waitForTornRecords treats them as still being written by another process.
+	waitForTornRecords tornRecords = iota
+	// truncateTornRecords cuts them off, because they were left behind by a crash.
+	truncateTornRecords
+)
+
+func NewSegmentCommandLog(dir string, serializer Serializer) *SegmentCommandLog {
+	return &SegmentCommandLog{
+		dir:           dir,
+		serializer:    serializer,
+		SegmentSize:   64 << 20,
+		IndexInterval: 128,
+		Sync:          SyncAlways,
+		SyncInterval:  time.Second,
+		revisions:     map[int]json.RawMessage{},
+		events:        map[int]json.RawMessage{},
+	}
+}
+
+func (l *SegmentCommandLog) segmentPath(first int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d.log", first))
+}
+
+func (l *SegmentCommandLog) indexPath(first int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d.idx", first))
+}
+
+func (l *SegmentCommandLog) revisionsPath() string {
+	return filepath.Join(l.dir, "revisions.log")
+}
+
+func (l *SegmentCommandLog) eventsPath() string {
+	return filepath.Join(l.dir, "events.log")
+}
+
+// Setup opens the log, cutting off records that were only partially written.
+func (l *SegmentCommandLog) Setup() error {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", l.dir, err)
+	}
+	lockFile, err := os.OpenFile(filepath.Join(l.dir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+	l.lockFile = lockFile
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.lockExclusive(); err != nil {
+		return err
+	}
+	defer l.unlockExclusive()
+
+	if err := l.findSegments(0); err != nil {
+		return err
+	}
+	for i, seg := range l.segments {
+		// Only the last segment can end in a torn record.
+		handling := waitForTornRecords
+		if i == len(l.segments)-1 {
+			handling = truncateTornRecords
+		}
+		if err := l.scanSegment(seg, handling); err != nil {
+			return err
+		}
+		if handling == truncateTornRecords {
+			if err := l.writeIndex(seg); err != nil {
+				return err
+			}
+		}
+		l.length = seg.last
+	}
+	return l.scanSideLogs(truncateTornRecords)
+}
+
+// Close releases the lock file.
+func (l *SegmentCommandLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lockFile == nil {
+		return nil
+	}
+	err := l.lockFile.Close()
+	l.lockFile = nil
+	return err
+}
+
+func (l *SegmentCommandLog) lockExclusive() error {
+	if err := syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", l.dir, err)
+	}
+	return nil
+}
+
+func (l *SegmentCommandLog) unlockExclusive() {
+	syscall.Flock(int(l.lockFile.Fd()), syscall.LOCK_UN)
+}
+
+// findSegments adds the segments starting after the command with the given ID, with their indexes.
+func (l *SegmentCommandLog) findSegments(after int) error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments: %w", err)
+	}
+	found := []*segment{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".log")
+		if !ok {
+			continue
+		}
+		first, err := strconv.Atoi(name)
+		if err != nil || first <= after {
+			continue
+		}
+		seg := &segment{first: first, last: first - 1}
+		if err := l.readIndex(seg); err != nil {
+			return err
+		}
+		found = append(found, seg)
+	}
+	slices.SortFunc(found, func(a, b *segment) int { return a.first - b.first })
+	l.segments = append(l.segments, found...)
+	return nil
+}
+
+// readIndex reads the sparse index of seg, ignoring a partially written entry at its end.
+func (l *SegmentCommandLog) readIndex(seg *segment) error {
+	data, err := os.ReadFile(l.indexPath(seg.first))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index of segment %d: %w", seg.first, err)
+	}
+	for ; len(data) >= 16; data = data[16:] {
+		seg.index = append(seg.index, segmentIndexEntry{
+			ID:     int(binary.BigEndian.Uint64(data[0:8])),
+			Offset: int64(binary.BigEndian.Uint64(data[8:16])),
+		})
+	}
+	return nil
+}
+
+// writeIndex replaces the index file of seg with the entries in memory.
+func (l *SegmentCommandLog) writeIndex(seg *segment) error {
+	if err := os.WriteFile(l.indexPath(seg.first), encodeSegmentIndex(seg.index), 0644); err != nil {
+		return fmt.Errorf("failed to write index of segment %d: %w", seg.first, err)
+	}
+	return nil
+}
+
+func encodeSegmentIndex(entries []segmentIndexEntry) []byte {
+	data := make([]byte, 0, 16*len(entries))
+	for _, entry := range entries {
+		data = binary.BigEndian.AppendUint64(data, uint64(entry.ID))
+		data = binary.BigEndian.AppendUint64(data, uint64(entry.Offset))
+	}
+	return data
+}
+
+// addRecord updates seg after a record was read or written at offset,
+// and returns the index entry for it, if it needs one.
+func (l *SegmentCommandLog) addRecord(seg *segment, id int, offset, size int64) *segmentIndexEntry {
+	seg.last = id
+	seg.end = offset + size
+	if len(seg.index) > 0 && seg.unindexed < l.IndexInterval {
+		seg.unindexed++
+		return nil
+	}
+	seg.unindexed = 1
+	seg.index = append(seg.index, segmentIndexEntry{ID: id, Offset: offset})
+	return &seg.index[len(seg.index)-1]
+}
+
+// scanSegment reads the records of seg appended since it was last scanned.
+//
+// When scanning a segment for the first time, it resumes from the last
+// entry of its index that still points to a complete record.
+func (l *SegmentCommandLog) scanSegment(seg *segment, handling tornRecords) error {
+	path := l.segmentPath(seg.first)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to inspect segment %d: %w", seg.first, err)
+	}
+	if info.Size() == seg.end {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %d: %w", seg.first, err)
+	}
+	defer file.Close()
+
+	if seg.end == 0 {
+		for len(seg.index) > 0 {
+			entry := seg.index[len(seg.index)-1]
+			seg.index = seg.index[:len(seg.index)-1]
+			if record, _, err := readSegmentRecordAt(file, entry.Offset); err == nil && record.ID == entry.ID {
+				// The entry is added again once its record has been read.
+				seg.end = entry.Offset
+				break
+			}
+		}
+		seg.unindexed = l.IndexInterval
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, seg.end, info.Size()-seg.end))
+	for offset := seg.end; ; {
+		record, size, err := readSegmentRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if torn, tornErr := isTornRecord(file, offset, size, info.Size()); tornErr != nil {
+				return fmt.Errorf("failed to inspect segment %d: %w", seg.first, tornErr)
+			} else if !torn {
+				return fmt.Errorf("segment %d at offset %d: %w", seg.first, offset, err)
+			}
+			if handling == waitForTornRecords {
+				return nil
+			}
+			if err := os.Truncate(path, offset); err != nil {
+				return fmt.Errorf("failed to cut off torn record in segment %d: %w", seg.first, err)
+			}
+			return nil
+		}
+		l.addRecord(seg, record.ID, offset, size)
+		offset += size
+	}
+}
+
+// scanSideLogs reads the revisions and events appended since they were last scanned.
+func (l *SegmentCommandLog) scanSideLogs(handling tornRecords) error {
+	err := l.scanSideLog(l.revisionsPath(), &l.revisionsEnd, handling, func(record *ExportedCommand) {
+		l.revisions[record.ID] = record.ProcessAs
+	})
+	if err != nil {
+		return err
+	}
+	return l.scanSideLog(l.eventsPath(), &l.eventsEnd, handling, func(record *ExportedCommand) {
+		l.events[record.ID] = record.Events
+	})
+}
+
+// scanSideLog passes the records appended to the file at path after end to add.
+func (l *SegmentCommandLog) scanSideLog(path string, end *int64, handling tornRecords, add func(*ExportedCommand)) error {
+	name := filepath.Base(path)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", name, err)
+	}
+	if info.Size() == *end {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(io.NewSectionReader(file, *end, info.Size()-*end))
+	for {
+		record, size, err := readSegmentRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if torn, tornErr := isTornRecord(file, *end, size, info.Size()); tornErr != nil {
+				return fmt.Errorf("failed to inspect %s: %w", name, tornErr)
+			} else if !torn {
+				return fmt.Errorf("%s at offset %d: %w", name, *end, err)
+			}
+			if handling == truncateTornRecords {
+				if err := os.Truncate(path, *end); err != nil {
+					return fmt.Errorf("failed to cut off torn record in %s: %w", name, err)
+				}
+			}
+			return nil
+		}
+		add(record)
+		*end += size
+	}
+}
+
+// appendSideLog writes records to the file at path, overwriting a torn
+// record after end.
+//
+// The caller must hold both locks and have refreshed the log.
+func (l *SegmentCommandLog) appendSideLog(path string, end *int64, records []*ExportedCommand) error {
+	name := filepath.Base(path)
+	data := []byte{}
+	for _, record := range records {
+		encoded, err := encodeSegmentRecord(record)
+		if err != nil {
+			return err
+		}
+		data = append(data, encoded...)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(data, *end); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := file.Truncate(*end + int64(len(data))); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", name, err)
+	}
+	if err := l.sync(file); err != nil {
+		return err
+	}
+	*end += int64(len(data))
+	return nil
+}
+
+// refresh picks up the commands and revisions appended by other processes.
+func (l *SegmentCommandLog) refresh() error {
+	after := 0
+	if len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		if err := l.scanSegment(last, waitForTornRecords); err != nil {
+			return err
+		}
+		after = last.first
+		// Only full segments are followed by another one.
+		if last.end < l.SegmentSize {
+			l.length = last.last
+			return l.scanSideLogs(waitForTornRecords)
+		}
+	}
+	known := len(l.segments)
+	if err := l.findSegments(after); err != nil {
+		return err
+	}
+	for _, seg := range l.segments[known:] {
+		if err := l.scanSegment(seg, waitForTornRecords); err != nil {
+			return err
+		}
+	}
+	if len(l.segments) > 0 {
+		l.length = l.segments[len(l.segments)-1].last
+	}
+	return l.scanSideLogs(waitForTornRecords)
+}
+
+// isTornRecord reports whether a record that failed to read at offset was
+// left behind by a crash: either it extends to the end of the file, or
+// the rest of the file is zero-filled, which happens when the size of a
+// file reached the disk before its data did.
+func isTornRecord(file io.ReaderAt, offset, size, fileSize int64) (bool, error) {
+	if offset+size >= fileSize {
+		return true, nil
+	}
+	buf := make([]byte, 32<<10)
+	for offset < fileSize {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), fileSize-offset)], offset)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if n == 0 {
+			break
+		}
+		offset += int64(n)
+	}
+	return true, nil
+}
+
+// readSegmentRecord reads the next record and returns it together with its size.
+//
+// It returns io.EOF if there are no more records, io.ErrUnexpectedEOF for
+// a record that is cut off, and ErrCorruptRecord if the checksum does not
+// match or the header holds an impossible length.
+func readSegmentRecord(r io.Reader) (*ExportedCommand, int64, error) {
+	header := make([]byte, segmentRecordHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, int64(n), io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length == 0 || length > maxSegmentRecordLength {
+		return nil, segmentRecordHeaderSize, fmt.Errorf("record length %d: %w", length, ErrCorruptRecord)
+	}
+	size := segmentRecordHeaderSize + int64(length)
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, size, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, segmentChecksum) != checksum {
+		return nil, size, ErrCorruptRecord
+	}
+	record := &ExportedCommand{}
+	if err := json.Unmarshal(payload, record); err != nil {
+		return nil, size, fmt.Errorf("failed to decode record: %w", err)
+	}
+	return record, size, nil
+}
+
+func readSegmentRecordAt(file *os.File, offset int64) (*ExportedCommand, int64, error) {
+	return readSegmentRecord(io.NewSectionReader(file, offset, 1<<62))
+}
+
+func encodeSegmentRecord(record *ExportedCommand) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command %d: %w", record.ID, err)
+	}
+	data := make([]byte, segmentRecordHeaderSize, segmentRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.Checksum(payload, segmentChecksum))
+	return append(data, payload...), nil
+}
+
+func (l *SegmentCommandLog) Append(command Command, metadata *CommandMetadata) error {
+	return l.AppendBatch([]*PersistedCommand{{Message: command, Metadata: metadata}})
+}
+
+// AppendBatch appends all entries with a single write.
+//
+// Unlike FileCommandLog, a crash can leave only some of the entries appended.
+func (l *SegmentCommandLog) AppendBatch(entries []*PersistedCommand) error {
+	return l.append(nil, entries)
+}
+
+// AppendIfVersion appends command while holding the lock on the log,
+// if the log ends at expected.
+func (l *SegmentCommandLog) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
+	return l.append(&expected, []*PersistedCommand{{Message: command, Metadata: metadata}})
+}
+
+func (l *SegmentCommandLog) append(expected *int, entries []*PersistedCommand) error {
+	records := make([]*ExportedCommand, len(entries))
+	for i, entry := range entries {
+		encoded, err := l.serializer.Encode(entry.Message)
+		if err != nil {
+			return fmt.Errorf("failed to encode command: %w", err)
+		}
+		records[i] = &ExportedCommand{Message: encoded, Metadata: entry.Metadata}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.lockExclusive(); err != nil {
+		return err
+	}
+	defer l.unlockExclusive()
+	if err := l.refresh(); err != nil {
+		return err
+	}
+	if expected != nil && *expected != l.length {
+		return fmt.Errorf("expected version %d, log is at %d: %w", *expected, l.length, ErrVersionConflict)
+	}
+	for i, record := range records {
+		record.ID = l.length + 1 + i
+	}
+	return l.write(records)
+}
+
+// appendExported appends records, keeping their IDs, which need to be increasing.
+func (l *SegmentCommandLog) appendExported(records []*ExportedCommand) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.lockExclusive(); err != nil {
+		return err
+	}
+	defer l.unlockExclusive()
+	if err := l.refresh(); err != nil {
+		return err
+	}
+	last := l.length
+	for _, record := range records {
+		if record.ID <= last {
+			return fmt.Errorf("command %d does not come after command %d", record.ID, last)
+		}
+		last = record.ID
+	}
+	return l.write(records)
+}
+
+// write appends records to the last segment, starting a new one if it is full.
+//
+// The caller must hold both locks and have refreshed the log.
+func (l *SegmentCommandLog) write(records []*ExportedCommand) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var seg *segment
+	if len(l.segments) > 0 {
+		seg = l.segments[len(l.segments)-1]
+	}
+	isNew := seg == nil || seg.end >= l.SegmentSize
+	if isNew {
+		seg = &segment{first: records[0].ID, last: records[0].ID - 1}
+	}
+
+	file, err := os.OpenFile(l.segmentPath(seg.first), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %d: %w", seg.first, err)
+	}
+	defer file.Close()
+
+	data := []byte{}
+	newIndexEntries := []segmentIndexEntry{}
+	before := *seg
+	for _, record := range records {
+		encoded, err := encodeSegmentRecord(record)
+		if err != nil {
+			*seg = before
+			return err
+		}
+		if entry := l.addRecord(seg, record.ID, before.end+int64(len(data)), int64(len(encoded))); entry != nil {
+			newIndexEntries = append(newIndexEntries, *entry)
+		}
+		data = append(data, encoded...)
+	}
+	// Writing at the end of the last complete record overwrites a torn one.
+	if _, err := file.WriteAt(data, before.end); err != nil {
+		*seg = before
+		return fmt.Errorf("failed to write to segment %d: %w", seg.first, err)
+	}
+	if err := file.Truncate(seg.end); err != nil {
+		return fmt.Errorf("failed to truncate segment %d: %w", seg.first, err)
+	}
+	if err := l.sync(file); err != nil {
+		return err
+	}
+	if isNew {
+		l.segments = append(l.segments, seg)
+		if err := l.syncDir(); err != nil {
+			return err
+		}
+	}
+	l.length = seg.last
+
+	index, err := os.OpenFile(l.indexPath(seg.first), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open index of segment %d: %w", seg.first, err)
+	}
+	defer index.Close()
+	if _, err := index.Write(encodeSegmentIndex(newIndexEntries)); err != nil {
+		return fmt.Errorf("failed to write index of segment %d: %w", seg.first, err)
+	}
+	return nil
+}
+
+// sync flushes file to disk, if the sync policy asks for it.
+func (l *SegmentCommandLog) sync(file *os.File) error {
+	switch l.Sync {
+	case SyncNever:
+		return nil
+	case SyncInterval:
+		if time.Since(l.lastSync) < l.SyncInterval {
+			return nil
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", file.Name(), err)
+	}
+	l.lastSync = time.Now()
+	return nil
+}
+
+// syncDir flushes the directory to disk, so that new segments survive a crash.
+func (l *SegmentCommandLog) syncDir() error {
+	if l.Sync == SyncNever {
+		return nil
+	}
+	dir, err := os.Open(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", l.dir, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", l.dir, err)
+	}
+	return nil
+}
+
+// ReviseCommands appends the revisions of all commands to revisions.log with a single write.
+func (l *SegmentCommandLog) ReviseCommands(ids []int, as func(id int) Command) error {
+	records := []*ExportedCommand{}
+	for _, id := range ids {
+		record := &ExportedCommand{ID: id}
+		if command := as(id); command != nil {
+			encoded, err := l.serializer.Encode(command)
+			if err != nil {
+				return fmt.Errorf("failed to encode command: %w", err)
+			}
+			record.ProcessAs = encoded
+		}
+		records = append(records, record)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.lockExclusive(); err != nil {
+		return err
+	}
+	defer l.unlockExclusive()
+	if err := l.refresh(); err != nil {
+		return err
+	}
+	if err := l.appendSideLog(l.revisionsPath(), &l.revisionsEnd, records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		l.revisions[record.ID] = record.ProcessAs
+	}
+	return nil
+}
+
+// RecordEvents appends the events of all commands to events.log with a single write.
+func (l *SegmentCommandLog) RecordEvents(events map[int][]Event) error {
+	records := []*ExportedCommand{}
+	for _, id := range slices.Sorted(maps.Keys(events)) {
+		encoded, err := EncodeEvents(events[id])
+		if err != nil {
+			return fmt.Errorf("command %d: %w", id, err)
+		}
+		records = append(records, &ExportedCommand{ID: id, Events: encoded})
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.lockExclusive(); err != nil {
+		return err
+	}
+	defer l.unlockExclusive()
+	if err := l.refresh(); err != nil {
+		return err
+	}
+	if err := l.appendSideLog(l.eventsPath(), &l.eventsEnd, records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		l.events[record.ID] = record.Events
+	}
+	return nil
+}
+
+func (l *SegmentCommandLog) Length() (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.refresh(); err != nil {
+		return 0, err
+	}
+	return l.length, nil
+}
+
+func (l *SegmentCommandLog) After(id int) (iter.Seq[*PersistedCommand], error) {
+	l.lock.Lock()
+	if err := l.refresh(); err != nil {
+		l.lock.Unlock()
+		return nil, err
+	}
+	// Only read what has been appended so far, so that records which are
+	// still being written by other processes are not visible.
+	segments := make([]segment, len(l.segments))
+	for i, seg := range l.segments {
+		segments[i] = *seg
+	}
+	revisions, events := maps.Clone(l.revisions), maps.Clone(l.events)
+	l.lock.Unlock()
+
+	return func(yield func(*PersistedCommand) bool) {
+		for _, seg := range segments {
+			if seg.last <= id {
+				continue
+			}
+			if !l.readSegment(&seg, id, revisions, events, yield) {
+				return
+			}
+		}
+	}, nil
+}
+
+// readSegment yields the commands in seg after the given ID and reports whether to continue.
+func (l *SegmentCommandLog) readSegment(seg *segment, after int, revisions, events map[int]json.RawMessage, yield func(*PersistedCommand) bool) bool {
+	file, err := os.Open(l.segmentPath(seg.first))
+	if err != nil {
+		panic(fmt.Errorf("failed to open segment %d: %w", seg.first, err))
+	}
+	defer file.Close()
+
+	start := int64(0)
+	for _, entry := range seg.index {
+		if entry.ID > after+1 {
+			break
+		}
+		start = entry.Offset
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, start, seg.end-start))
+	for {
+		record, _, err := readSegmentRecord(reader)
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			panic(fmt.Errorf("failed to read segment %d: %w", seg.first, err))
+		}
+		if record.ID <= after {
+			continue
+		}
+		if !yield(l.persisted(record, revisions, events)) {
+			return false
+		}
+	}
+}
+
+// persisted decodes record, applying the latest revision of it and the
+// events recorded for it.
+func (l *SegmentCommandLog) persisted(record *ExportedCommand, revisions, events map[int]json.RawMessage) *PersistedCommand {
+	processAs := record.ProcessAs
+	if revision, ok := revisions[record.ID]; ok {
+		processAs = revision
+	}
+	recorded := record.Events
+	if later, ok := events[record.ID]; ok {
+		recorded = later
+	}
+	command := &PersistedCommand{ID: record.ID, Message: l.decode(record.Message), Metadata: record.Metadata}
+	if len(processAs) > 0 {
+		command.Original, command.Message = command.Message, l.decode(processAs)
+	}
+	if command.Metadata == nil {
+		command.Metadata = &CommandMetadata{}
+	}
+	decoded, err := DecodeEvents(recorded)
+	if err != nil {
+		panic(fmt.Errorf("failed to decode events of command %d: %w", record.ID, err))
+	}
+	command.Events = decoded
+	return command
+}
+
+// decode decodes message, or returns an UndecodableCommand if that fails.
+func (l *SegmentCommandLog) decode(message []byte) Command {
+	var cmd Command
+	if err := l.serializer.Decode(message, &cmd); err != nil {
+		return &UndecodableCommand{Raw: message, Err: err}
+	}
+	return cmd
+}
+
+// ConvertCommandLog copies all commands in source to target, keeping their
+// IDs, metadata, revisions and events.
+func ConvertCommandLog(source CommandLog, target *SegmentCommandLog) (int, error) {
+	const batchSize = 1000
+	commands, err := source.After(0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read commands: %w", err)
+	}
+	converted := 0
+	batch := []*ExportedCommand{}
+	for command := range commands {
+		record, err := exportCommand(command, target.serializer)
+		if err != nil {
+			return converted, err
+		}
+		batch = append(batch, record)
+		if len(batch) < batchSize {
+			continue
+		}
+		if err := target.appendExported(batch); err != nil {
+			return converted, err
+		}
+		converted += len(batch)
+		batch = batch[:0]
+	}
+	if err := target.appendExported(batch); err != nil {
+		return converted, err
+	}
+	return converted + len(batch), nil
+}
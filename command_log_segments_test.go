@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openSegmentCommandLog(t *testing.T, dir string) *SegmentCommandLog {
+	t.Helper()
+	log := NewSegmentCommandLog(dir, DefaultSerializer)
+	log.SegmentSize = 512
+	log.IndexInterval = 2
+	if err := log.Setup(); err != nil {
+		t.Fatalf("failed to set up command log: %s", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func itemIDsAfter(t *testing.T, log CommandLog, id int) string {
+	t.Helper()
+	commands, err := log.After(id)
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+	result := []string{}
+	for command := range commands {
+		result = append(result, fmt.Sprintf("%d:%s", command.ID, command.Message.(*PostLink).ItemID))
+	}
+	return fmt.Sprint(result)
+}
+
+func Test_SegmentCommandLog_ReadsAcrossSegments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "commands")
+	log := openSegmentCommandLog(t, dir)
+	for i := 1; i <= 10; i++ {
+		if err := log.Append(benchmarkCommand(i), nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+	if len(log.segments) < 2 {
+		t.Fatalf("expected several segments, got %d", len(log.segments))
+	}
+
+	reopened := openSegmentCommandLog(t, dir)
+	if length, err := reopened.Length(); err != nil || length != 10 {
+		t.Fatalf("expected length 10, got %d (%v)", length, err)
+	}
+	if act, exp := itemIDsAfter(t, reopened, 7), "[8:item-8 9:item-9 10:item-10]"; act != exp {
+		t.Fatalf("expected %s, got %s", exp, act)
+	}
+}
+
+func Test_SegmentCommandLog_CutsOffTornRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tear   func(segment string) error
+		length int
+		after  string
+	}{
+		{
+			name: "cut off",
+			tear: func(segment string) error {
+				info, _ := os.Stat(segment)
+				return os.Truncate(segment, info.Size()-3)
+			},
+			length: 1,
+			after:  "[1:item-1 2:item-3]",
+		},
+		{
+			// A crash can persist the size of a file before its data.
+			name: "zero-filled",
+			tear: func(segment string) error {
+				file, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				_, err = file.Write(make([]byte, 100))
+				return err
+			},
+			length: 2,
+			after:  "[1:item-1 2:item-2 3:item-3]",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "commands")
+			log := openSegmentCommandLog(t, dir)
+			log.Append(benchmarkCommand(1), nil)
+			log.Append(benchmarkCommand(2), nil)
+			log.Close()
+
+			if err := tc.tear(log.segmentPath(1)); err != nil {
+				t.Fatalf("failed to tear record: %s", err)
+			}
+
+			reopened := openSegmentCommandLog(t, dir)
+			if length, err := reopened.Length(); err != nil || length != tc.length {
+				t.Fatalf("expected length %d, got %d (%v)", tc.length, length, err)
+			}
+			if err := reopened.Append(benchmarkCommand(3), nil); err != nil {
+				t.Fatalf("failed to append: %s", err)
+			}
+			if act, exp := itemIDsAfter(t, reopened, 0), tc.after; act != exp {
+				t.Fatalf("expected %s, got %s", exp, act)
+			}
+		})
+	}
+}
+
+func Test_SegmentCommandLog_RejectsImpossibleRecordLength(t *testing.T) {
+	header := make([]byte, segmentRecordHeaderSize)
+	binary.BigEndian.PutUint32(header, 1<<31)
+	if _, _, err := readSegmentRecord(bytes.NewReader(header)); !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected %s, got %v", ErrCorruptRecord, err)
+	}
+}
+
+func Test_SegmentCommandLog_RejectsCorruptRecord(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "commands")
+	log := openSegmentCommandLog(t, dir)
+	log.Append(benchmarkCommand(1), nil)
+	log.Append(benchmarkCommand(2), nil)
+	log.Close()
+
+	data, _ := os.ReadFile(log.segmentPath(1))
+	data[segmentRecordHeaderSize+2] ^= 0xff
+	os.WriteFile(log.segmentPath(1), data, 0644)
+	os.Remove(log.indexPath(1))
+
+	reopened := NewSegmentCommandLog(dir, DefaultSerializer)
+	defer reopened.Close()
+	if err := reopened.Setup(); !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected %s, got %v", ErrCorruptRecord, err)
+	}
+}
+
+func Test_SegmentCommandLog_SeesCommandsAppendedByAnotherLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "commands")
+	first, second := openSegmentCommandLog(t, dir), openSegmentCommandLog(t, dir)
+	for i := 1; i <= 6; i++ {
+		if err := first.Append(benchmarkCommand(i), nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+	}
+
+	if err := second.AppendIfVersion(5, benchmarkCommand(7), nil); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected %s, got %v", ErrVersionConflict, err)
+	}
+	if err := second.AppendIfVersion(6, benchmarkCommand(7), nil); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+	if act, exp := itemIDsAfter(t, first, 5), "[6:item-6 7:item-7]"; act != exp {
+		t.Fatalf("expected %s, got %s", exp, act)
+	}
+}
+
+func Test_ConvertCommandLog_KeepsIDsAndRevisions(t *testing.T) {
+	source := NewFileCommandLog(filepath.Join(t.TempDir(), "commands.db"), DefaultSerializer)
+	if err := source.Setup(); err != nil {
+		t.Fatalf("failed to set up command log: %s", err)
+	}
+	defer source.Close()
+	source.insertExported([]*ExportedCommand{mustExport(t, 2, benchmarkCommand(2)), mustExport(t, 5, benchmarkCommand(5))})
+	source.ReviseCommands([]int{5}, func(id int) Command { return &SkipCommand{} })
+
+	target := openSegmentCommandLog(t, filepath.Join(t.TempDir(), "commands"))
+	if n, err := ConvertCommandLog(source, target); err != nil || n != 2 {
+		t.Fatalf("expected 2 commands to be converted, got %d (%v)", n, err)
+	}
+	if length, err := target.Length(); err != nil || length != 5 {
+		t.Fatalf("expected length 5, got %d (%v)", length, err)
+	}
+	commands, _ := target.After(0)
+	ids := []int{}
+	for command := range commands {
+		ids = append(ids, command.ID)
+		if _, skipped := command.Message.(*SkipCommand); skipped != (command.ID == 5) {
+			t.Fatalf("expected only command 5 to be skipped, got %T for %d", command.Message, command.ID)
+		}
+	}
+	if act, exp := fmt.Sprint(ids), "[2 5]"; act != exp {
+		t.Fatalf("expected IDs %s, got %s", exp, act)
+	}
+}
+
+func mustExport(t *testing.T, id int, command Command) *ExportedCommand {
+	t.Helper()
+	entry, err := exportCommand(&PersistedCommand{ID: id, Message: command}, DefaultSerializer)
+	if err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	return entry
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newBenchmarkCommandLog(b *testing.B) *FileCommandLog {
+	b.Helper()
+	log := NewFileCommandLog(filepath.Join(b.TempDir(), "commands.db"), DefaultSerializer)
+	if err := log.Setup(); err != nil {
+		b.Fatalf("failed to set up command log: %s", err)
+	}
+	return log
+}
+
+// commandLogBackends creates an empty command log of every kind, for tests all of them need to pass.
+var commandLogBackends = map[string]func(t *testing.T) CommandLog{
+	"sqlite": func(t *testing.T) CommandLog {
+		log := NewFileCommandLog(filepath.Join(t.TempDir(), "commands.db"), DefaultSerializer)
+		if err := log.Setup(); err != nil {
+			t.Fatalf("failed to set up command log: %s", err)
+		}
+		t.Cleanup(func() { log.Close() })
+		return log
+	},
+	"memory": func(t *testing.T) CommandLog {
+		return NewInMemoryCommandLog()
+	},
+	"segments": func(t *testing.T) CommandLog {
+		log := NewSegmentCommandLog(filepath.Join(t.TempDir(), "commands"), DefaultSerializer)
+		log.IndexInterval = 2
+		if err := log.Setup(); err != nil {
+			t.Fatalf("failed to set up command log: %s", err)
+		}
+		t.Cleanup(func() { log.Close() })
+		return log
+	},
+}
+
+// forEachCommandLog runs test against every kind of command log.
+func forEachCommandLog(t *testing.T, test func(t *testing.T, log CommandLog)) {
+	for name, newLog := range commandLogBackends {
+		t.Run(name, func(t *testing.T) {
+			test(t, newLog(t))
+		})
+	}
+}
+
+func benchmarkCommand(i int) Command {
+	return &PostLink{ItemID: fmt.Sprintf("item-%d", i), Submitter: "bench", Url: "https://example.com", Title: "Benchmark"}
+}
+
+func Test_CommandLog_AppendBatch_AppendsAllCommandsInOrder(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		if length, err := log.Length(); err != nil || length != 0 {
+			t.Fatalf("expected empty log, got length %d (%v)", length, err)
+		}
+		if err := log.(CommandBatchAppender).AppendBatch([]*PersistedCommand{{Message: benchmarkCommand(1)}, {Message: benchmarkCommand(2)}}); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+		if err := log.Append(benchmarkCommand(3), nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+
+		commands, err := log.After(1)
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		itemIDs := []string{}
+		for command := range commands {
+			itemIDs = append(itemIDs, command.Message.(*PostLink).ItemID)
+		}
+		if act, exp := fmt.Sprint(itemIDs), "[item-2 item-3]"; act != exp {
+			t.Fatalf("expected %s, got %s", exp, act)
+		}
+	})
+}
+
+func Test_CommandLog_PersistsMetadata(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		metadata := &CommandMetadata{
+			RecordedAt:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Actor:         "alice",
+			Origin:        "web",
+			CorrelationID: "request-1",
+			CausationID:   7,
+		}
+		if err := log.Append(benchmarkCommand(1), metadata); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+
+		commands, err := log.After(0)
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		for command := range commands {
+			if act, exp := command.Metadata.String(), metadata.String(); act != exp {
+				t.Fatalf("expected metadata %s, got %s", exp, act)
+			}
+		}
+	})
+}
+
+func BenchmarkFileCommandLog_Append(b *testing.B) {
+	log := newBenchmarkCommandLog(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := log.Append(benchmarkCommand(i), nil); err != nil {
+			b.Fatalf("failed to append: %s", err)
+		}
+	}
+}
+
+// BenchmarkFileCommandLog_TailRead measures reading the tail of a log
+// which is already fully replayed, as done before serving every web request.
+func BenchmarkFileCommandLog_TailRead(b *testing.B) {
+	log := newBenchmarkCommandLog(b)
+	for i := 0; i < 1000; i++ {
+		if err := log.Append(benchmarkCommand(i), nil); err != nil {
+			b.Fatalf("failed to append: %s", err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		commands, err := log.After(1000)
+		if err != nil {
+			b.Fatalf("failed to read: %s", err)
+		}
+		for range commands {
+		}
+	}
+}
+
+func BenchmarkFileCommandLog_AppendBatch(b *testing.B) {
+	const batchSize = 100
+	log := newBenchmarkCommandLog(b)
+	batch := make([]*PersistedCommand, batchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		for j := range batch {
+			batch[j] = &PersistedCommand{Message: benchmarkCommand(i + j)}
+		}
+		if err := log.AppendBatch(batch); err != nil {
+			b.Fatalf("failed to append: %s", err)
+		}
+	}
+}
+
+func Test_CommandLog_AppendIfVersion_RejectsStaleVersion(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		if err := log.AppendIfVersion(0, benchmarkCommand(1), nil); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+		for _, expected := range []int{0, 2} {
+			if err := log.AppendIfVersion(expected, benchmarkCommand(2), nil); !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected %s for version %d, got %v", ErrVersionConflict, expected, err)
+			}
+		}
+		if length, err := log.Length(); err != nil || length != 1 {
+			t.Fatalf("expected length 1, got %d (%v)", length, err)
+		}
+	})
+}
+
+func Test_CommandLog_RecordsEvents(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		log.Append(&SignUpUser{Username: "alice"}, nil)
+		log.Append(benchmarkCommand(2), nil)
+
+		err := log.(EventRecorder).RecordEvents(map[int][]Event{
+			1: {&UserSignedUp{Username: "alice"}},
+			2: {},
+		})
+		if err != nil {
+			t.Fatalf("failed to record events: %s", err)
+		}
+
+		commands, err := log.After(0)
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		recorded := []string{}
+		for command := range commands {
+			if command.Events == nil {
+				t.Fatalf("expected events of command %d to be recorded", command.ID)
+			}
+			for _, event := range command.Events {
+				recorded = append(recorded, fmt.Sprintf("%d:%s:%s", command.ID, event.EventName(), event.(*UserSignedUp).Username))
+			}
+		}
+		if act, exp := fmt.Sprint(recorded), "[1:UserSignedUp:alice]"; act != exp {
+			t.Fatalf("expected %s, got %s", exp, act)
+		}
+	})
+}
+
+func Test_CommandLog_After_ReadsCommandsFollowingID(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		for i := 1; i <= 5; i++ {
+			if err := log.Append(benchmarkCommand(i), nil); err != nil {
+				t.Fatalf("failed to append: %s", err)
+			}
+		}
+		for id, exp := range map[int]string{
+			0: "[1:item-1 2:item-2 3:item-3 4:item-4 5:item-5]",
+			3: "[4:item-4 5:item-5]",
+			5: "[]",
+		} {
+			if act := itemIDsAfter(t, log, id); act != exp {
+				t.Fatalf("expected %s after %d, got %s", exp, id, act)
+			}
+		}
+	})
+}
+
+func Test_CommandLog_ReviseCommands_SkipsAndUnskips(t *testing.T) {
+	forEachCommandLog(t, func(t *testing.T, log CommandLog) {
+		for i := 1; i <= 3; i++ {
+			if err := log.Append(benchmarkCommand(i), nil); err != nil {
+				t.Fatalf("failed to append: %s", err)
+			}
+		}
+		reviser := log.(CommandReviser)
+		if err := reviser.ReviseCommands([]int{2}, func(id int) Command { return &SkipCommand{} }); err != nil {
+			t.Fatalf("failed to skip: %s", err)
+		}
+		commands, err := log.After(0)
+		if err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+		for command := range commands {
+			_, skipped := command.Message.(*SkipCommand)
+			if act, exp := skipped, command.ID == 2; act != exp {
+				t.Fatalf("expected command %d skipped to be %v, got %v", command.ID, exp, act)
+			}
+			if skipped && command.Original.(*PostLink).ItemID != "item-2" {
+				t.Fatalf("expected original of command 2 to be kept, got %v", command.Original)
+			}
+			if !skipped && command.Original != nil {
+				t.Fatalf("expected command %d not to be revised, got original %v", command.ID, command.Original)
+			}
+		}
+
+		if err := reviser.ReviseCommands([]int{2}, func(id int) Command { return nil }); err != nil {
+			t.Fatalf("failed to unskip: %s", err)
+		}
+		if act, exp := itemIDsAfter(t, log, 0), "[1:item-1 2:item-2 3:item-3]"; act != exp {
+			t.Fatalf("expected %s, got %s", exp, act)
+		}
+	})
+}
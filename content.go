@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"strconv"
 	"time"
 )
@@ -9,12 +11,21 @@ import (
 type ContentState interface {
 	PutSubmissionPreview(preview *SubmissionPreview) error
 	PutSubmission(submission *Submission) error
+	// GetSubmission and TopNSubmissions return submissions that queries
+	// can mark for their viewer without affecting other queries running
+	// concurrently.
 	GetSubmission(itemID string) (*Submission, error)
 	TopNSubmissions(n int, after int) ([]*Submission, error)
 	RecordVote(vote *Vote) error
-	HasVotedFor(user string, itemIDs []string) ([]bool, error)
+	GetVotesBy(user string, itemIDs []string) ([]VoteDirection, error)
+	GetKarma(username string) (int, error)
+	GetRankingPolicy() (*RankingPolicy, error)
+	PutRankingPolicy(policy *RankingPolicy) error
 	PutComment(comment *Comment) error
+	UpdateComment(comment *Comment) error
 	GetSubmissionForComment(commentID TreeID) (*Submission, error)
+	RecordEdit(edit *ContentEdit) error
+	GetEdits(itemID string) ([]*ContentEdit, error)
 
 	GetActiveSubscribers() ([]string, error)
 	GetSubscriptionSettings(username string) (*SubscriptionSettings, error)
@@ -26,14 +37,49 @@ type Submission struct {
 	Submitter      string
 	Url            string
 	Title          string
+	Body           string
 	SubmittedAt    time.Time
+	EditedAt       time.Time
 	Preview        *SubmissionPreview
 	Hidden         bool
 	VoteCount      int
 	Score          float32
 	ViewerHasVoted bool
-	CommentCount   int
-	Comments       []*Comment
+	ViewerVote     VoteDirection
+	ViewerCanEdit  bool
+	// ViewerCanDownvote is set if the viewer has enough karma to downvote.
+	ViewerCanDownvote bool
+	CommentCount      int
+	Comments          []*Comment
+}
+
+// clone returns a copy of s with copies of all its comments, which
+// callers can mark for a viewer without affecting other readers.
+func (s *Submission) clone() *Submission {
+	c := *s
+	c.Comments = cloneComments(s.Comments)
+	return &c
+}
+
+func cloneComments(comments []*Comment) []*Comment {
+	if comments == nil {
+		return nil
+	}
+	result := make([]*Comment, len(comments))
+	for i, comment := range comments {
+		c := *comment
+		c.Children = cloneComments(comment.Children)
+		result[i] = &c
+	}
+	return result
+}
+
+// BodyHTML returns the body of a text submission rendered as HTML.
+func (s *Submission) BodyHTML() string {
+	if s.Body == "" {
+		return ""
+	}
+	return ConvertContentToHTML(s.Body)
 }
 
 func (s *Submission) Comment(id TreeID) *Comment {
@@ -62,10 +108,25 @@ type SubmissionPreview struct {
 	ImageURL    *string
 }
 
+// VoteDirection is +1 for an upvote and -1 for a downvote.
+type VoteDirection int
+
+const (
+	NoVote   VoteDirection = 0
+	Upvote   VoteDirection = 1
+	Downvote VoteDirection = -1
+)
+
+// Vote is cast by a user on a submission or a comment, identified by
+// the string form of its TreeID.
+//
+// Every user has at most one vote per item: recording a vote replaces
+// an earlier one, and recording NoVote retracts it.
 type Vote struct {
-	By  string
-	For string
-	At  time.Time
+	By        string
+	For       string
+	Direction VoteDirection
+	At        time.Time
 }
 
 type Comment struct {
@@ -74,21 +135,37 @@ type Comment struct {
 	ContentHTML string
 	Author      string
 	PostedAt    time.Time
+	EditedAt    time.Time
 	Hidden      bool
-	Index       int
-	Children    []*Comment
+	Deleted     bool
+	// VoteCount is the number of upvotes minus the number of downvotes.
+	VoteCount int
+	Index     int
+	Children  []*Comment
+
+	ViewerCanEdit     bool
+	ViewerVote        VoteDirection
+	ViewerCanDownvote bool
 }
 
-func (c *Comment) IsHidden() bool          { return c.Hidden }
-func (c *Comment) CommentID() string       { return c.ID().String() }
-func (c *Comment) CommentParentID() string { return c.ParentID.String() }
-func (c *Comment) CommentableID() string   { return c.ID().String() }
-func (c *Comment) WrittenAt() time.Time    { return c.PostedAt }
-func (c *Comment) CommentAuthor() string   { return c.Author }
+func (c *Comment) IsHidden() bool           { return c.Hidden }
+func (c *Comment) CommentID() string        { return c.ID().String() }
+func (c *Comment) CommentParentID() string  { return c.ParentID.String() }
+func (c *Comment) CommentableID() string    { return c.ID().String() }
+func (c *Comment) WrittenAt() time.Time     { return c.PostedAt }
+func (c *Comment) CommentAuthor() string    { return c.Author }
+func (c *Comment) IsEdited() bool           { return !c.EditedAt.IsZero() }
+func (c *Comment) CommentEditable() bool    { return c.ViewerCanEdit }
+func (c *Comment) CommentVoteCount() int    { return c.VoteCount }
+func (c *Comment) CommentViewerVote() int   { return int(c.ViewerVote) }
+func (c *Comment) CommentDownvotable() bool { return c.ViewerCanDownvote && !c.Deleted }
 func (c *Comment) CommentContent() string {
 	if c.Hidden {
 		return "[hidden]"
 	}
+	if c.Deleted {
+		return "[deleted]"
+	}
 	if c.ContentHTML != "" {
 		return c.ContentHTML
 	}
@@ -133,26 +210,256 @@ func (parent *Comment) AddChild(child *Comment) {
 	parent.Children = append(parent.Children, child)
 }
 
+// ContentEdit records what a submission's title or a comment's content
+// was before its author changed it.
+type ContentEdit struct {
+	ItemID   string
+	Field    string
+	Previous string
+	EditedBy string
+	EditedAt time.Time
+}
+
+// DefaultEditWindow is how long after posting authors can edit or delete what they posted.
+var DefaultEditWindow = 2 * time.Hour
+
+// DownvotesDisabled as the karma needed for downvoting prevents everyone from downvoting.
+const DownvotesDisabled = -1
+
 type Content struct {
+	EventBuffer
 	state ContentState
+
+	// EditWindow is how long after posting authors can edit or delete what they posted.
+	EditWindow time.Duration
+	// DownvoteKarma is the karma users need to downvote, or DownvotesDisabled.
+	DownvoteKarma int
 }
 
 func NewContent(state ContentState) *Content {
-	return &Content{state: state}
+	return &Content{state: state, EditWindow: DefaultEditWindow, DownvoteKarma: DownvotesDisabled}
 }
 
 func NewDefaultContent() *Content {
 	return NewContent(NewInMemoryContentState())
 }
 
+func (self *Content) Ownership() *Ownership {
+	return &Ownership{
+		Module: "content",
+		Commands: []string{
+			"PostLink", "PostText", "SetSubmissionPreview", "UpvoteSubmission", "UpvoteComment", "DownvoteSubmission", "DownvoteComment", "RetractVote", "PostComment", "HideSubmission", "UnhideSubmission",
+			"HideComment", "UnhideComment", "EditComment", "EditSubmissionTitle", "DeleteOwnComment", "EnableSubscriptions", "DisableSubscriptions", "SetNotifierConfig", "SetRankingPolicy",
+		},
+		Queries: []string{
+			"GetFrontpageSubmissions", "FindSubmission", "MySubscriptionSettings",
+			"FindSubscribersForNewSubmission", "FindSubscribersForNewComment", "GetEditHistory", "GetKarma", "GetRankingPolicy", "PreviewRankingPolicy",
+		},
+		Events: []string{"SubmissionPosted", "CommentPosted", "SubmissionHidden", "CommentHidden"},
+	}
+}
+
+// ValidateCommand checks whether HandleCommand would accept cmd, without changing any state.
+func (self *Content) ValidateCommand(cmd Command) error {
+	switch cmd := cmd.(type) {
+	case *PostLink:
+		return self.validatePostLink(cmd)
+	case *PostText:
+		return self.validatePostText(cmd)
+	case *UpvoteSubmission:
+		return self.validateUpvoteSubmission(cmd)
+	case *UpvoteComment:
+		return self.validateUpvoteComment(cmd)
+	case *DownvoteSubmission:
+		return self.validateDownvoteSubmission(cmd)
+	case *DownvoteComment:
+		return self.validateDownvoteComment(cmd)
+	case *RetractVote:
+		return self.validateRetractVote(cmd)
+	case *PostComment:
+		return self.validatePostComment(cmd)
+	case *HideSubmission:
+		return self.validateHideSubmission(cmd)
+	case *UnhideSubmission:
+		return self.validateUnhideSubmission(cmd)
+	case *HideComment:
+		return self.validateHideComment(cmd)
+	case *UnhideComment:
+		return self.validateUnhideComment(cmd)
+	case *EditComment:
+		return self.validateEditComment(cmd)
+	case *EditSubmissionTitle:
+		return self.validateEditSubmissionTitle(cmd)
+	case *DeleteOwnComment:
+		return self.validateDeleteOwnComment(cmd)
+	case *EnableSubscriptions:
+		return self.validateEnableSubscriptions(cmd)
+	case *DisableSubscriptions:
+		return self.validateDisableSubscriptions(cmd)
+	case *SetRankingPolicy:
+		return self.validateSetRankingPolicy(cmd)
+	case *SetSubmissionPreview, *SetNotifierConfig:
+		return nil
+	}
+	return ErrCommandNotAccepted
+}
+
+// findComment returns the comment identified by id, or ErrItemNotFound.
+func (self *Content) findComment(id TreeID) (*Comment, error) {
+	submission, err := self.state.GetSubmissionForComment(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submission for comment %q: %w", id, err)
+	}
+	comment := submission.Comment(id)
+	if comment == nil {
+		return nil, ErrItemNotFound
+	}
+	return comment, nil
+}
+
+// validateNewItemID checks that no submission has itemID yet.
+func (self *Content) validateNewItemID(itemID string) error {
+	if itemID == "" {
+		return ErrMissingItemID
+	}
+	_, err := self.state.GetSubmission(itemID)
+	if err == nil {
+		return ErrItemExists
+	}
+	if !errors.Is(err, ErrItemNotFound) {
+		return fmt.Errorf("failed to get submission %q: %w", itemID, err)
+	}
+	return nil
+}
+
+// ownComment returns the comment identified by id if username wrote it, did not delete it and it is not hidden.
+func (self *Content) ownComment(id TreeID, username string) (*Comment, error) {
+	comment, err := self.findComment(id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Author != username {
+		return nil, ErrNotAuthor
+	}
+	if comment.Deleted {
+		return nil, ErrCommentDeleted
+	}
+	if comment.Hidden {
+		return nil, ErrItemHidden
+	}
+	return comment, nil
+}
+
+// withinEditWindow reports whether something posted at postedAt can still be changed at the time at.
+//
+// The edit window is only checked when validating commands, so that
+// changing it does not break replaying edits accepted earlier.
+func (self *Content) withinEditWindow(postedAt, at time.Time) bool {
+	return !at.After(postedAt.Add(self.EditWindow))
+}
+
+// currentVote returns the vote voter cast on itemID, or NoVote.
+func (self *Content) currentVote(voter string, itemID string) (VoteDirection, error) {
+	votes, err := self.state.GetVotesBy(voter, []string{itemID})
+	if err != nil {
+		return NoVote, fmt.Errorf("failed to get votes of %q: %w", voter, err)
+	}
+	if len(votes) == 0 {
+		return NoVote, nil
+	}
+	return votes[0], nil
+}
+
+// canVote checks that voter can cast a vote in direction on itemID.
+func (self *Content) canVote(voter string, itemID string, direction VoteDirection) error {
+	if itemID == "" {
+		return ErrMissingItemID
+	}
+	if voter == "" {
+		return ErrMissingVoter
+	}
+	current, err := self.currentVote(voter, itemID)
+	if err != nil {
+		return err
+	}
+	if current == direction {
+		return ErrAlreadyVoted
+	}
+	return nil
+}
+
+// canDownvote reports whether voter has the karma needed for downvoting.
+//
+// Karma is only checked when validating commands, so that downvotes
+// accepted earlier can be replayed after karma or the threshold changed.
+func (self *Content) canDownvote(voter string) (bool, error) {
+	if self.DownvoteKarma == DownvotesDisabled {
+		return false, nil
+	}
+	karma, err := self.state.GetKarma(voter)
+	if err != nil {
+		return false, fmt.Errorf("failed to get karma of %q: %w", voter, err)
+	}
+	return karma >= self.DownvoteKarma, nil
+}
+
+// validateDownvote checks that voter can downvote itemID.
+func (self *Content) validateDownvote(voter string, itemID string) error {
+	if err := self.canVote(voter, itemID, Downvote); err != nil {
+		return err
+	}
+	if self.DownvoteKarma == DownvotesDisabled {
+		return ErrDownvotesDisabled
+	}
+	allowed, err := self.canDownvote(voter)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrNotEnoughKarma
+	}
+	return nil
+}
+
+// rankComments returns copies of comments and their replies, ordered
+// by their vote count and then by the time they were posted.
+//
+// The comments in the state keep their order, because their position
+// is part of their ID.
+func rankComments(comments []*Comment) []*Comment {
+	ranked := make([]*Comment, len(comments))
+	for i, comment := range comments {
+		copied := *comment
+		copied.Children = rankComments(comment.Children)
+		ranked[i] = &copied
+	}
+	slices.SortStableFunc(ranked, func(a, b *Comment) int {
+		if a.VoteCount != b.VoteCount {
+			return b.VoteCount - a.VoteCount
+		}
+		return a.PostedAt.Compare(b.PostedAt)
+	})
+	return ranked
+}
+
 func (self *Content) HandleCommand(cmd Command) error {
 	switch cmd := cmd.(type) {
 	case *PostLink:
 		return self.handlePostLink(cmd)
+	case *PostText:
+		return self.handlePostText(cmd)
 	case *SetSubmissionPreview:
 		return self.handleSetSubmissionPreview(cmd)
 	case *UpvoteSubmission:
 		return self.handleUpvoteSubmission(cmd)
+	case *UpvoteComment:
+		return self.handleUpvoteComment(cmd)
+	case *DownvoteSubmission:
+		return self.handleDownvoteSubmission(cmd)
+	case *DownvoteComment:
+		return self.handleDownvoteComment(cmd)
+	case *RetractVote:
+		return self.handleRetractVote(cmd)
 	case *PostComment:
 		return self.handlePostComment(cmd)
 	case *HideSubmission:
@@ -163,12 +470,20 @@ func (self *Content) HandleCommand(cmd Command) error {
 		return self.handleHideComment(cmd)
 	case *UnhideComment:
 		return self.handleUnhideComment(cmd)
+	case *EditComment:
+		return self.handleEditComment(cmd)
+	case *EditSubmissionTitle:
+		return self.handleEditSubmissionTitle(cmd)
+	case *DeleteOwnComment:
+		return self.handleDeleteOwnComment(cmd)
 	case *EnableSubscriptions:
 		return self.handleEnableSubscriptions(cmd)
 	case *DisableSubscriptions:
 		return self.handleDisableSubscriptions(cmd)
 	case *SetNotifierConfig:
 		return self.handleSetNotifierConfig(cmd)
+	case *SetRankingPolicy:
+		return self.handleSetRankingPolicy(cmd)
 	}
 	return ErrCommandNotAccepted
 }
@@ -179,6 +494,16 @@ var (
 	ErrMalformedURL  = errors.New("url is malformed")
 	ErrMissingItemID = errors.New("item ID is missing")
 	ErrItemNotFound  = errors.New("item not found")
+	ErrItemExists    = errors.New("item already exists")
+
+	ErrNotAuthor        = errors.New("only the author can change this")
+	ErrEditWindowClosed = errors.New("too late to change this")
+	ErrCommentDeleted   = errors.New("comment was deleted")
+	ErrItemHidden       = errors.New("item was hidden")
+
+	ErrDownvotesDisabled = errors.New("downvotes are disabled")
+	ErrNotEnoughKarma    = errors.New("not enough karma to downvote")
+	ErrNotVoted          = errors.New("not voted")
 )
 
 func (self *Content) HandleQuery(query Query) error {
@@ -193,6 +518,14 @@ func (self *Content) HandleQuery(query Query) error {
 		return self.findSubscribersForNewSubmission(query)
 	case *FindSubscribersForNewComment:
 		return self.findSubscribersForNewComment(query)
+	case *GetEditHistory:
+		return self.getEditHistory(query)
+	case *GetKarma:
+		return self.getKarma(query)
+	case *GetRankingPolicy:
+		return self.getRankingPolicy(query)
+	case *PreviewRankingPolicy:
+		return self.previewRankingPolicy(query)
 	default:
 		return ErrQueryNotAccepted
 	}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+)
+
+// DeleteOwnComment removes the content of a comment, which only its
+// author can do within the edit window.
+//
+// Replies to the comment are kept.
+type DeleteOwnComment struct {
+	CommentID TreeID
+	DeletedBy string
+	DeletedAt time.Time
+}
+
+func (cmd *DeleteOwnComment) CommandName() string { return "DeleteOwnComment" }
+
+func init() {
+	DefaultCommandRegistry.Register("DeleteOwnComment", func() Command { return new(DeleteOwnComment) })
+}
+
+func (self *Content) validateDeleteOwnComment(cmd *DeleteOwnComment) error {
+	comment, err := self.ownComment(cmd.CommentID, cmd.DeletedBy)
+	if err != nil {
+		return err
+	}
+	if !self.withinEditWindow(comment.PostedAt, cmd.DeletedAt) {
+		return ErrEditWindowClosed
+	}
+	return nil
+}
+
+func (self *Content) handleDeleteOwnComment(cmd *DeleteOwnComment) error {
+	comment, err := self.ownComment(cmd.CommentID, cmd.DeletedBy)
+	if err != nil {
+		return err
+	}
+	edit := &ContentEdit{
+		ItemID:   cmd.CommentID.String(),
+		Field:    "content",
+		Previous: comment.Content,
+		EditedBy: cmd.DeletedBy,
+		EditedAt: cmd.DeletedAt,
+	}
+	comment.Content = ""
+	comment.ContentHTML = ""
+	comment.Deleted = true
+	comment.EditedAt = cmd.DeletedAt
+	if err := self.state.UpdateComment(comment); err != nil {
+		return err
+	}
+	return self.state.RecordEdit(edit)
+}
@@ -18,7 +18,9 @@ func init() {
 	DefaultCommandRegistry.Register("DisableSubscriptions", func() Command { return new(DisableSubscriptions) })
 }
 
-func (self *Content) handleDisableSubscriptions(cmd *DisableSubscriptions) error {
+// validateDisableSubscriptions checks the requested scopes and normalizes them, so that
+// only the deduplicated and validated list of scopes is persisted.
+func (self *Content) validateDisableSubscriptions(cmd *DisableSubscriptions) error {
 	scopes := []SubscriptionScope{}
 	for _, s := range cmd.Scopes {
 		if scope, err := ToSubscriptionScope(s); err != nil {
@@ -36,6 +38,13 @@ func (self *Content) handleDisableSubscriptions(cmd *DisableSubscriptions) error
 
 	// Persist the deduplicated and validated list of scopes only.
 	*cmd = *record
+	return nil
+}
+
+func (self *Content) handleDisableSubscriptions(cmd *DisableSubscriptions) error {
+	if err := self.validateDisableSubscriptions(cmd); err != nil {
+		return err
+	}
 
 	settings, err := self.state.GetSubscriptionSettings(cmd.Username)
 	if errors.Is(err, ErrSubscriptionSettingsNotFound) {
@@ -43,6 +52,6 @@ func (self *Content) handleDisableSubscriptions(cmd *DisableSubscriptions) error
 	} else if err != nil {
 		return err
 	}
-	settings.Disable(record)
+	settings.Disable(cmd)
 	return self.state.PutSubscriptionSettings(settings)
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// DownvoteComment lowers the score of a comment, which only users with
+// enough karma can do.
+type DownvoteComment struct {
+	CommentID TreeID
+	Voter     string
+	VotedAt   time.Time
+}
+
+func (cmd *DownvoteComment) CommandName() string { return "DownvoteComment" }
+
+func init() {
+	DefaultCommandRegistry.Register("DownvoteComment", func() Command { return &DownvoteComment{} })
+}
+
+func (self *Content) validateDownvoteComment(cmd *DownvoteComment) error {
+	if _, err := self.findComment(cmd.CommentID); err != nil {
+		return err
+	}
+	return self.validateDownvote(cmd.Voter, cmd.CommentID.String())
+}
+
+func (self *Content) handleDownvoteComment(cmd *DownvoteComment) error {
+	if err := self.canVote(cmd.Voter, cmd.CommentID.String(), Downvote); err != nil {
+		return err
+	}
+
+	return self.state.RecordVote(&Vote{
+		For:       cmd.CommentID.String(),
+		By:        cmd.Voter,
+		Direction: Downvote,
+		At:        cmd.VotedAt,
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// DownvoteSubmission lowers the score of a submission, which only users
+// with enough karma can do.
+type DownvoteSubmission struct {
+	ItemID  string
+	Voter   string
+	VotedAt time.Time
+}
+
+func (cmd *DownvoteSubmission) CommandName() string { return "DownvoteSubmission" }
+
+func init() {
+	DefaultCommandRegistry.Register("DownvoteSubmission", func() Command { return &DownvoteSubmission{} })
+}
+
+func (self *Content) validateDownvoteSubmission(cmd *DownvoteSubmission) error {
+	if _, err := self.state.GetSubmission(cmd.ItemID); err != nil {
+		return err
+	}
+	return self.validateDownvote(cmd.Voter, cmd.ItemID)
+}
+
+func (self *Content) handleDownvoteSubmission(cmd *DownvoteSubmission) error {
+	if err := self.canVote(cmd.Voter, cmd.ItemID, Downvote); err != nil {
+		return err
+	}
+
+	return self.state.RecordVote(&Vote{
+		For:       cmd.ItemID,
+		By:        cmd.Voter,
+		Direction: Downvote,
+		At:        cmd.VotedAt,
+	})
+}
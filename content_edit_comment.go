@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+)
+
+// EditComment replaces the content of a comment, which only its author
+// can do within the edit window.
+type EditComment struct {
+	CommentID TreeID
+	Content   string
+	EditedBy  string
+	EditedAt  time.Time
+}
+
+func (cmd *EditComment) CommandName() string { return "EditComment" }
+
+func init() {
+	DefaultCommandRegistry.Register("EditComment", func() Command { return new(EditComment) })
+}
+
+func (self *Content) validateEditComment(cmd *EditComment) error {
+	comment, err := self.commentToEdit(cmd)
+	if err != nil {
+		return err
+	}
+	if !self.withinEditWindow(comment.PostedAt, cmd.EditedAt) {
+		return ErrEditWindowClosed
+	}
+	return nil
+}
+
+// commentToEdit returns the comment cmd edits, if cmd is valid regardless of the edit window.
+func (self *Content) commentToEdit(cmd *EditComment) (*Comment, error) {
+	if len(cmd.Content) > MAX_COMMENT_LENGTH_IN_CHARACTERS {
+		return nil, ErrCommentTooLong
+	}
+	if len(cmd.Content) < MIN_COMMENT_LENGTH_IN_CHARACTERS {
+		return nil, ErrCommentTooShort
+	}
+	return self.ownComment(cmd.CommentID, cmd.EditedBy)
+}
+
+func (self *Content) handleEditComment(cmd *EditComment) error {
+	comment, err := self.commentToEdit(cmd)
+	if err != nil {
+		return err
+	}
+	edit := &ContentEdit{
+		ItemID:   cmd.CommentID.String(),
+		Field:    "content",
+		Previous: comment.Content,
+		EditedBy: cmd.EditedBy,
+		EditedAt: cmd.EditedAt,
+	}
+	comment.Content = cmd.Content
+	// The HTML is rendered again from the new content when it is displayed.
+	comment.ContentHTML = ""
+	comment.EditedAt = cmd.EditedAt
+	if err := self.state.UpdateComment(comment); err != nil {
+		return err
+	}
+	return self.state.RecordEdit(edit)
+}
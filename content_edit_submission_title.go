@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EditSubmissionTitle replaces the title of a submission, which only its
+// submitter can do within the edit window.
+type EditSubmissionTitle struct {
+	ItemID   string
+	Title    string
+	EditedBy string
+	EditedAt time.Time
+}
+
+func (cmd *EditSubmissionTitle) CommandName() string { return "EditSubmissionTitle" }
+
+func init() {
+	DefaultCommandRegistry.Register("EditSubmissionTitle", func() Command { return new(EditSubmissionTitle) })
+}
+
+func (self *Content) validateEditSubmissionTitle(cmd *EditSubmissionTitle) error {
+	submission, err := self.submissionToEdit(cmd)
+	if err != nil {
+		return err
+	}
+	if !self.withinEditWindow(submission.SubmittedAt, cmd.EditedAt) {
+		return ErrEditWindowClosed
+	}
+	return nil
+}
+
+// submissionToEdit returns the submission cmd edits, if cmd is valid regardless of the edit window.
+func (self *Content) submissionToEdit(cmd *EditSubmissionTitle) (*Submission, error) {
+	if cmd.Title == "" {
+		return nil, ErrEmptyTitle
+	}
+	submission, err := self.state.GetSubmission(cmd.ItemID)
+	if errors.Is(err, ErrItemNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submission %q: %w", cmd.ItemID, err)
+	}
+	if submission.Submitter != cmd.EditedBy {
+		return nil, ErrNotAuthor
+	}
+	if submission.Hidden {
+		return nil, ErrItemHidden
+	}
+	return submission, nil
+}
+
+func (self *Content) handleEditSubmissionTitle(cmd *EditSubmissionTitle) error {
+	submission, err := self.submissionToEdit(cmd)
+	if err != nil {
+		return err
+	}
+	edit := &ContentEdit{
+		ItemID:   cmd.ItemID,
+		Field:    "title",
+		Previous: submission.Title,
+		EditedBy: cmd.EditedBy,
+		EditedAt: cmd.EditedAt,
+	}
+	submission.Title = cmd.Title
+	submission.EditedAt = cmd.EditedAt
+	if err := self.state.PutSubmission(submission); err != nil {
+		return err
+	}
+	return self.state.RecordEdit(edit)
+}
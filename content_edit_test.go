@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (t *TestContext) editComment(id TreeID, content string) *EditComment {
+	return &EditComment{CommentID: id, Content: content, EditedBy: t.Viewer, EditedAt: time.Now()}
+}
+
+func (t *TestContext) deleteOwnComment(id TreeID) *DeleteOwnComment {
+	return &DeleteOwnComment{CommentID: id, DeletedBy: t.Viewer, DeletedAt: time.Now()}
+}
+
+func (t *TestContext) findSubmission(itemID string) *Submission {
+	t.t.Helper()
+	q := NewFindSubmission(itemID)
+	if err := t.App.HandleQuery(q); err != nil {
+		t.t.Fatalf("failed to find submission %q: %s", itemID, err)
+	}
+	return q.Submission
+}
+
+func Test_EditComment_ReplacesContentAndRecordsHistory(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "first *draft*"))
+		commentID := NewTreeID(scenario.PostIDs[0]).And(0)
+		if html := scenario.findSubmission(scenario.PostIDs[0]).Comment(commentID).CommentContent(); !strings.Contains(html, "<em>draft</em>") {
+			t.Fatalf("expected rendered comment, got %q", html)
+		}
+
+		scenario.must(scenario.editComment(commentID, "final **version**"))
+
+		comment := scenario.findSubmission(scenario.PostIDs[0]).Comment(commentID)
+		if !comment.IsEdited() {
+			t.Fatalf("expected comment to be marked as edited")
+		}
+		if html := comment.CommentContent(); !strings.Contains(html, "<strong>version</strong>") {
+			t.Fatalf("expected edited content to be rendered, got %q", html)
+		}
+
+		history := NewGetEditHistory(commentID.String())
+		if err := scenario.App.HandleQuery(history); err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Edits) != 1 || history.Edits[0].Previous != "first *draft*" {
+			t.Fatalf("expected previous content in history, got %#v", history.Edits)
+		}
+	})
+}
+
+func Test_EditComment_FailsForOtherUsers(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://err.ee", "link"))
+	scenario.must(scenario.commentOn(scenario.PostIDs[0], "mine"))
+	edit := scenario.editComment(NewTreeID(scenario.PostIDs[0]).And(0), "not yours")
+	edit.EditedBy = "intruder"
+	scenario.mustFailWith(edit, ErrNotAuthor)
+}
+
+func Test_EditComment_FailsAfterEditWindow(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://err.ee", "link"))
+	scenario.must(scenario.commentOn(scenario.PostIDs[0], "mine"))
+	edit := scenario.editComment(NewTreeID(scenario.PostIDs[0]).And(0), "too late")
+	edit.EditedAt = edit.EditedAt.Add(DefaultEditWindow + time.Minute)
+	scenario.mustFailWith(edit, ErrEditWindowClosed)
+}
+
+func Test_DeleteOwnComment_KeepsReplies(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "regrettable"))
+		commentID := NewTreeID(scenario.PostIDs[0]).And(0)
+		scenario.must(scenario.commentOn(commentID.String(), "a reply"))
+
+		scenario.must(scenario.deleteOwnComment(commentID))
+
+		comment := scenario.findSubmission(scenario.PostIDs[0]).Comment(commentID)
+		if comment.CommentContent() != "[deleted]" {
+			t.Fatalf("expected deleted comment, got %q", comment.CommentContent())
+		}
+		if len(comment.Children) != 1 {
+			t.Fatalf("expected reply to be kept, got %d replies", len(comment.Children))
+		}
+		scenario.mustFailWith(scenario.editComment(commentID, "undelete"), ErrCommentDeleted)
+	})
+}
+
+func Test_Edit_IsRefused_ForHiddenItems(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "hidden soon"))
+		commentID := NewTreeID(scenario.PostIDs[0]).And(0)
+		scenario.must(&HideComment{CommentID: commentID, HiddenAt: time.Now(), HiddenBy: "admin"})
+		scenario.must(&HideSubmission{ItemID: scenario.PostIDs[0], HiddenAt: time.Now(), HiddenBy: "admin"})
+
+		scenario.mustFailWith(scenario.editComment(commentID, "visible again"), ErrItemHidden)
+		scenario.mustFailWith(scenario.deleteOwnComment(commentID), ErrItemHidden)
+		scenario.mustFailWith(&EditSubmissionTitle{ItemID: scenario.PostIDs[0], Title: "Visible", EditedBy: scenario.Submitter, EditedAt: time.Now()}, ErrItemHidden)
+	})
+}
+
+func Test_EditSubmissionTitle_UpdatesFrontpage(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "Typo"))
+		scenario.must(&EditSubmissionTitle{ItemID: scenario.PostIDs[0], Title: "Fixed", EditedBy: scenario.Submitter, EditedAt: time.Now()})
+
+		submission := scenario.frontpage()[0]
+		if submission.Title != "Fixed" || submission.EditedAt.IsZero() {
+			t.Fatalf("expected edited title, got %#v", submission)
+		}
+		scenario.mustFailWith(&EditSubmissionTitle{ItemID: scenario.PostIDs[0], Title: "Mine", EditedBy: scenario.Viewer, EditedAt: time.Now()}, ErrNotAuthor)
+	})
+}
+
+func Test_FindSubmission_MarksEditableItemsForEachViewerSeparately(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "comment"))
+		itemID, comment := scenario.PostIDs[0], NewTreeID(scenario.PostIDs[0]).And(0)
+
+		var wg sync.WaitGroup
+		for _, viewer := range []string{scenario.Submitter, scenario.Viewer, scenario.Submitter, scenario.Viewer} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					q := &FindSubmission{ItemID: itemID, Viewer: &viewer, At: time.Now()}
+					if err := scenario.App.HandleQuery(q); err != nil {
+						t.Errorf("failed to find submission: %s", err)
+						return
+					}
+					canEditSubmission, canEditComment := q.Submission.ViewerCanEdit, q.Submission.Comment(comment).ViewerCanEdit
+					if canEditSubmission != (viewer == scenario.Submitter) || canEditComment != (viewer == scenario.Viewer) {
+						t.Errorf("expected %s to edit only their own items, got submission %t and comment %t", viewer, canEditSubmission, canEditComment)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -18,7 +18,9 @@ func init() {
 	DefaultCommandRegistry.Register("EnableSubscriptions", func() Command { return new(EnableSubscriptions) })
 }
 
-func (self *Content) handleEnableSubscriptions(cmd *EnableSubscriptions) error {
+// validateEnableSubscriptions checks the requested scopes and normalizes them, so that
+// only the deduplicated and validated list of scopes is persisted.
+func (self *Content) validateEnableSubscriptions(cmd *EnableSubscriptions) error {
 	scopes := []SubscriptionScope{}
 	for _, s := range cmd.Scopes {
 		if scope, err := ToSubscriptionScope(s); err != nil {
@@ -36,6 +38,13 @@ func (self *Content) handleEnableSubscriptions(cmd *EnableSubscriptions) error {
 
 	// Persist the deduplicated and validated list of scopes only.
 	*cmd = *record
+	return nil
+}
+
+func (self *Content) handleEnableSubscriptions(cmd *EnableSubscriptions) error {
+	if err := self.validateEnableSubscriptions(cmd); err != nil {
+		return err
+	}
 
 	settings, err := self.state.GetSubscriptionSettings(cmd.Username)
 	if errors.Is(err, ErrSubscriptionSettingsNotFound) {
@@ -43,6 +52,6 @@ func (self *Content) handleEnableSubscriptions(cmd *EnableSubscriptions) error {
 	} else if err != nil {
 		return err
 	}
-	settings.Enable(record)
+	settings.Enable(cmd)
 	return self.state.PutSubscriptionSettings(settings)
 }
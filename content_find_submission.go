@@ -1,7 +1,17 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 type FindSubmission struct {
-	ItemID     string
+	ItemID string
+	// Viewer, if set, is marked as able to edit what they posted within
+	// the edit window at the time At, and their votes are looked up.
+	Viewer *string
+	At     time.Time
+
 	Submission *Submission
 }
 
@@ -17,6 +27,62 @@ func (self *Content) findSubmission(q *FindSubmission) error {
 	if err != nil {
 		return err
 	}
+	if q.Viewer != nil {
+		self.markEditable(submission, *q.Viewer, q.At)
+		if err := self.markVotes(submission, *q.Viewer); err != nil {
+			return err
+		}
+	}
 	q.Submission = submission
 	return nil
 }
+
+// markEditable marks the submission and comments viewer can still edit at the time at.
+//
+// The submission must be one returned by the state for this query only,
+// as it is read by other queries otherwise.
+func (self *Content) markEditable(submission *Submission, viewer string, at time.Time) {
+	submission.ViewerCanEdit = submission.Submitter == viewer && !submission.Hidden &&
+		self.withinEditWindow(submission.SubmittedAt, at)
+	var mark func(comments []*Comment)
+	mark = func(comments []*Comment) {
+		for _, comment := range comments {
+			comment.ViewerCanEdit = comment.Author == viewer && !comment.Deleted && !comment.Hidden &&
+				self.withinEditWindow(comment.PostedAt, at)
+			mark(comment.Children)
+		}
+	}
+	mark(submission.Comments)
+}
+
+// markVotes marks the submission and comments with the votes viewer cast on them.
+func (self *Content) markVotes(submission *Submission, viewer string) error {
+	canDownvote, err := self.canDownvote(viewer)
+	if err != nil {
+		return err
+	}
+	comments := []*Comment{}
+	itemIDs := []string{submission.ItemID}
+	var collect func(children []*Comment)
+	collect = func(children []*Comment) {
+		for _, comment := range children {
+			comments = append(comments, comment)
+			itemIDs = append(itemIDs, comment.ID().String())
+			collect(comment.Children)
+		}
+	}
+	collect(submission.Comments)
+
+	votes, err := self.state.GetVotesBy(viewer, itemIDs)
+	if err != nil {
+		return fmt.Errorf("failed to get votes of %q: %w", viewer, err)
+	}
+	submission.ViewerVote = votes[0]
+	submission.ViewerHasVoted = votes[0] != NoVote
+	submission.ViewerCanDownvote = canDownvote
+	for i, comment := range comments {
+		comment.ViewerVote = votes[i+1]
+		comment.ViewerCanDownvote = canDownvote
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+// GetEditHistory finds the earlier versions of a submission or comment,
+// oldest first.
+type GetEditHistory struct {
+	ItemID string
+
+	Edits []*ContentEdit
+}
+
+func (q *GetEditHistory) QueryName() string { return "GetEditHistory" }
+func (q *GetEditHistory) Result() any       { return q.Edits }
+
+func NewGetEditHistory(itemID string) *GetEditHistory {
+	return &GetEditHistory{ItemID: itemID}
+}
+
+func (self *Content) getEditHistory(q *GetEditHistory) error {
+	edits, err := self.state.GetEdits(q.ItemID)
+	if err != nil {
+		return err
+	}
+	q.Edits = edits
+	return nil
+}
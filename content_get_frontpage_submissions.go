@@ -50,9 +50,12 @@ func (self *Content) getFrontpageSubmissions(query *GetFrontpageSubmissions) err
 		for i, s := range result {
 			itemIDs[i] = s.ItemID
 		}
-		voted, _ := self.state.HasVotedFor(viewer, itemIDs)
+		votes, _ := self.state.GetVotesBy(viewer, itemIDs)
+		canDownvote, _ := self.canDownvote(viewer)
 		for i, s := range result {
-			s.ViewerHasVoted = voted[i]
+			s.ViewerVote = votes[i]
+			s.ViewerHasVoted = votes[i] != NoVote
+			s.ViewerCanDownvote = canDownvote
 		}
 	}
 	query.Submissions = result
@@ -0,0 +1,27 @@
+package main
+
+// GetKarma finds the karma of a user: the votes others cast on their
+// submissions and comments, upvotes minus downvotes.
+type GetKarma struct {
+	Username string
+
+	Karma       int
+	CanDownvote bool
+}
+
+func (q *GetKarma) QueryName() string { return "GetKarma" }
+func (q *GetKarma) Result() any       { return q.Karma }
+
+func NewGetKarma(username string) *GetKarma {
+	return &GetKarma{Username: username}
+}
+
+func (self *Content) getKarma(q *GetKarma) error {
+	karma, err := self.state.GetKarma(q.Username)
+	if err != nil {
+		return err
+	}
+	q.Karma = karma
+	q.CanDownvote, err = self.canDownvote(q.Username)
+	return err
+}
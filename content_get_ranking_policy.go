@@ -0,0 +1,18 @@
+package main
+
+// GetRankingPolicy finds the policy by which the front page is ordered.
+type GetRankingPolicy struct {
+	Policy *RankingPolicy
+}
+
+func (q *GetRankingPolicy) QueryName() string { return "GetRankingPolicy" }
+func (q *GetRankingPolicy) Result() any       { return q.Policy }
+
+func (self *Content) getRankingPolicy(q *GetRankingPolicy) error {
+	policy, err := self.state.GetRankingPolicy()
+	if err != nil {
+		return err
+	}
+	q.Policy = policy
+	return nil
+}
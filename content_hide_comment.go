@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"time"
 )
 
@@ -13,19 +12,40 @@ type HideComment struct {
 
 func (cmd *HideComment) CommandName() string { return "HideComment" }
 
+// CommentHidden is emitted when a comment has been hidden.
+type CommentHidden struct {
+	CommentID TreeID
+	Author    string
+	HiddenAt  time.Time
+	HiddenBy  string
+}
+
+func (e *CommentHidden) EventName() string { return "CommentHidden" }
+
 func init() {
 	DefaultCommandRegistry.Register("HideComment", func() Command { return new(HideComment) })
+	DefaultEventRegistry.Register("CommentHidden", func() Event { return new(CommentHidden) })
+}
+
+func (self *Content) validateHideComment(cmd *HideComment) error {
+	_, err := self.findComment(cmd.CommentID)
+	return err
 }
 
 func (self *Content) handleHideComment(cmd *HideComment) error {
-	submission, err := self.state.GetSubmissionForComment(cmd.CommentID)
+	comment, err := self.findComment(cmd.CommentID)
 	if err != nil {
-		return fmt.Errorf("failed to get submission for comment %q: %w", cmd.CommentID, err)
-	}
-	comment := submission.Comment(cmd.CommentID)
-	if comment == nil {
-		return ErrItemNotFound
+		return err
 	}
 	comment.Hidden = true
+	if err := self.state.UpdateComment(comment); err != nil {
+		return err
+	}
+	self.Emit(&CommentHidden{
+		CommentID: cmd.CommentID,
+		Author:    comment.Author,
+		HiddenAt:  cmd.HiddenAt,
+		HiddenBy:  cmd.HiddenBy,
+	})
 	return nil
 }
@@ -14,8 +14,30 @@ type HideSubmission struct {
 
 func (cmd *HideSubmission) CommandName() string { return "HideSubmission" }
 
+// SubmissionHidden is emitted when a submission has been hidden.
+type SubmissionHidden struct {
+	ItemID    string
+	Submitter string
+	HiddenAt  time.Time
+	HiddenBy  string
+}
+
+func (e *SubmissionHidden) EventName() string { return "SubmissionHidden" }
+
 func init() {
 	DefaultCommandRegistry.Register("HideSubmission", func() Command { return new(HideSubmission) })
+	DefaultEventRegistry.Register("SubmissionHidden", func() Event { return new(SubmissionHidden) })
+}
+
+func (self *Content) validateHideSubmission(cmd *HideSubmission) error {
+	_, err := self.state.GetSubmission(cmd.ItemID)
+	if errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get submission %q: %w", cmd.ItemID, err)
+	}
+	return nil
 }
 
 func (self *Content) handleHideSubmission(cmd *HideSubmission) error {
@@ -27,5 +49,14 @@ func (self *Content) handleHideSubmission(cmd *HideSubmission) error {
 		return fmt.Errorf("failed to get submission %q: %w", cmd.ItemID, err)
 	}
 	submission.Hidden = true
-	return self.state.PutSubmission(submission)
+	if err := self.state.PutSubmission(submission); err != nil {
+		return err
+	}
+	self.Emit(&SubmissionHidden{
+		ItemID:    cmd.ItemID,
+		Submitter: submission.Submitter,
+		HiddenAt:  cmd.HiddenAt,
+		HiddenBy:  cmd.HiddenBy,
+	})
+	return nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"slices"
 	"time"
 )
 
@@ -23,11 +24,29 @@ type PostComment struct {
 
 func (cmd *PostComment) CommandName() string { return "PostComment" }
 
+// CommentPosted is emitted when a comment has been posted.
+//
+// ParentAuthor is the author of the comment or submission that was
+// replied to.  Subscribers are the users taking part in the thread who
+// were subscribed to replies at that time, except for the author.
+type CommentPosted struct {
+	CommentID    TreeID
+	SubmissionID string
+	ParentID     TreeID
+	ParentAuthor string
+	Author       string
+	PostedAt     time.Time
+	Subscribers  []string
+}
+
+func (e *CommentPosted) EventName() string { return "CommentPosted" }
+
 func init() {
 	DefaultCommandRegistry.Register("PostComment", func() Command { return new(PostComment) })
+	DefaultEventRegistry.Register("CommentPosted", func() Event { return new(CommentPosted) })
 }
 
-func (self *Content) handlePostComment(cmd *PostComment) error {
+func (self *Content) validatePostComment(cmd *PostComment) error {
 	if len(cmd.Content) > MAX_COMMENT_LENGTH_IN_CHARACTERS {
 		return ErrCommentTooLong
 	}
@@ -36,6 +55,38 @@ func (self *Content) handlePostComment(cmd *PostComment) error {
 		return ErrCommentTooShort
 	}
 
+	submission, err := self.state.GetSubmissionForComment(cmd.ParentID)
+	if errors.Is(err, ErrItemNotFound) {
+		return ErrUncommentableItem
+	}
+	if err != nil {
+		return err
+	}
+	if len(cmd.ParentID) > 1 && submission.Comment(cmd.ParentID) == nil {
+		return ErrUncommentableItem
+	}
+
+	return nil
+}
+
+func (self *Content) handlePostComment(cmd *PostComment) error {
+	if err := self.validatePostComment(cmd); err != nil {
+		return err
+	}
+
+	submission, err := self.state.GetSubmissionForComment(cmd.ParentID)
+	if err != nil {
+		return err
+	}
+	parentAuthor := submission.Submitter
+	if len(cmd.ParentID) > 1 {
+		parentAuthor = submission.Comment(cmd.ParentID).Author
+	}
+	subscribers := NewFindSubscribersForNewComment(cmd.ParentID.String())
+	if err := self.findSubscribersForNewComment(subscribers); err != nil {
+		return err
+	}
+
 	comment := &Comment{
 		ParentID: cmd.ParentID,
 		Author:   cmd.Author,
@@ -49,5 +100,17 @@ func (self *Content) handlePostComment(cmd *PostComment) error {
 		}
 		return err
 	}
+
+	self.Emit(&CommentPosted{
+		CommentID:    comment.ID(),
+		SubmissionID: submission.ItemID,
+		ParentID:     cmd.ParentID,
+		ParentAuthor: parentAuthor,
+		Author:       cmd.Author,
+		PostedAt:     cmd.PostedAt,
+		Subscribers: slices.DeleteFunc(subscribers.Subscribers, func(subscriber string) bool {
+			return subscriber == cmd.Author
+		}),
+	})
 	return nil
 }
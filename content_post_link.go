@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/url"
+	"slices"
 	"time"
 )
 
@@ -17,11 +18,26 @@ func (cmd *PostLink) CommandName() string {
 	return "PostLink"
 }
 
+// SubmissionPosted is emitted when a new submission has been posted.
+//
+// Subscribers are the users subscribed to new submissions at that time,
+// except for the submitter.
+type SubmissionPosted struct {
+	ItemID      string
+	Submitter   string
+	Title       string
+	SubmittedAt time.Time
+	Subscribers []string
+}
+
+func (e *SubmissionPosted) EventName() string { return "SubmissionPosted" }
+
 func init() {
 	DefaultCommandRegistry.Register("PostLink", func() Command { return &PostLink{} })
+	DefaultEventRegistry.Register("SubmissionPosted", func() Event { return new(SubmissionPosted) })
 }
 
-func (self *Content) handlePostLink(cmd *PostLink) error {
+func (self *Content) validatePostLink(cmd *PostLink) error {
 	if cmd.Title == "" {
 		return ErrEmptyTitle
 	}
@@ -38,15 +54,38 @@ func (self *Content) handlePostLink(cmd *PostLink) error {
 		return ErrMalformedURL
 	}
 
-	if cmd.ItemID == "" {
-		return ErrMissingItemID
+	return self.validateNewItemID(cmd.ItemID)
+}
+
+func (self *Content) handlePostLink(cmd *PostLink) error {
+	if err := self.validatePostLink(cmd); err != nil {
+		return err
 	}
 
-	return self.state.PutSubmission(&Submission{
+	subscribers := NewFindSubscribersForNewSubmission()
+	if err := self.findSubscribersForNewSubmission(subscribers); err != nil {
+		return err
+	}
+
+	err := self.state.PutSubmission(&Submission{
 		ItemID:      cmd.ItemID,
 		Submitter:   cmd.Submitter,
 		Url:         cmd.Url,
 		Title:       cmd.Title,
 		SubmittedAt: cmd.SubmittedAt,
 	})
+	if err != nil {
+		return err
+	}
+
+	self.Emit(&SubmissionPosted{
+		ItemID:      cmd.ItemID,
+		Submitter:   cmd.Submitter,
+		Title:       cmd.Title,
+		SubmittedAt: cmd.SubmittedAt,
+		Subscribers: slices.DeleteFunc(subscribers.Subscribers, func(subscriber string) bool {
+			return subscriber == cmd.Submitter
+		}),
+	})
+	return nil
 }
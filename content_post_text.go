@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+const MAX_TEXT_LENGTH_IN_CHARACTERS = 10000
+
+var (
+	ErrEmptyText   = errors.New("text cannot be empty")
+	ErrTextTooLong = errors.New("text too long")
+)
+
+// PostText submits a discussion thread whose Body is Markdown, instead of a link.
+type PostText struct {
+	ItemID      string
+	Submitter   string
+	Title       string
+	Body        string
+	SubmittedAt time.Time
+}
+
+func (cmd *PostText) CommandName() string {
+	return "PostText"
+}
+
+func init() {
+	DefaultCommandRegistry.Register("PostText", func() Command { return &PostText{} })
+}
+
+func (self *Content) validatePostText(cmd *PostText) error {
+	if cmd.Title == "" {
+		return ErrEmptyTitle
+	}
+
+	if cmd.Body == "" {
+		return ErrEmptyText
+	}
+
+	if len(cmd.Body) > MAX_TEXT_LENGTH_IN_CHARACTERS {
+		return ErrTextTooLong
+	}
+
+	return self.validateNewItemID(cmd.ItemID)
+}
+
+func (self *Content) handlePostText(cmd *PostText) error {
+	if err := self.validatePostText(cmd); err != nil {
+		return err
+	}
+
+	subscribers := NewFindSubscribersForNewSubmission()
+	if err := self.findSubscribersForNewSubmission(subscribers); err != nil {
+		return err
+	}
+
+	err := self.state.PutSubmission(&Submission{
+		ItemID:      cmd.ItemID,
+		Submitter:   cmd.Submitter,
+		Title:       cmd.Title,
+		Body:        cmd.Body,
+		SubmittedAt: cmd.SubmittedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	self.Emit(&SubmissionPosted{
+		ItemID:      cmd.ItemID,
+		Submitter:   cmd.Submitter,
+		Title:       cmd.Title,
+		SubmittedAt: cmd.SubmittedAt,
+		Subscribers: slices.DeleteFunc(subscribers.Subscribers, func(subscriber string) bool {
+			return subscriber == cmd.Submitter
+		}),
+	})
+	return nil
+}
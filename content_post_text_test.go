@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_PostText_RequiresTitleAndBody(t *testing.T) {
+	scenario := setup(t)
+	scenario.mustFailWith(scenario.postText("", "What do you think?"), ErrEmptyTitle)
+	scenario.mustFailWith(scenario.postText("Ask: editors", ""), ErrEmptyText)
+	scenario.mustFailWith(scenario.postText("Ask: editors", strings.Repeat("a", MAX_TEXT_LENGTH_IN_CHARACTERS+1)), ErrTextTooLong)
+}
+
+func Test_OnFrontpage_TextSubmissionsAreRankedWithLinks(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://news.ycombinator.com", "link"))
+		scenario.must(scenario.postText("Ask: which editor do you use?", "Asking for **a friend**."))
+		scenario.upvoteN(scenario.PostIDs[0], 2)
+
+		submissions := scenario.frontpage()
+		if len(submissions) != 2 || submissions[0].Title != "link" {
+			t.Fatalf("expected upvoted link to rank first, got %#v", submissions)
+		}
+		text := submissions[1]
+		if text.Url != "" || text.Body != "Asking for **a friend**." {
+			t.Fatalf("expected text submission without url, got %#v", text)
+		}
+		if html := text.BodyHTML(); !strings.Contains(html, "<strong>a friend</strong>") {
+			t.Fatalf("expected body to be rendered as Markdown, got %q", html)
+		}
+	})
+}
+
+func Test_PreviewGenerator_SkipsTextSubmissions(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postText("Ask: editors", "Which one?"))
+
+	generator := NewPreviewGenerator(scenario.App, scenario.App.Commands, discardLogger())
+	generator.fetchPreviews()
+	if scenario.LogContains(func(command *PersistedCommand) bool {
+		_, ok := command.Message.(*SetSubmissionPreview)
+		return ok
+	}) {
+		t.Fatalf("expected no preview to be generated for a text submission")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// PreviewRankingPolicy shows how the top submissions on the front page
+// would be reordered if Candidate became the ranking policy.
+//
+// Submissions are scored at the time At under both policies.
+type PreviewRankingPolicy struct {
+	Candidate *RankingPolicy
+	At        time.Time
+	N         int
+
+	Current *RankingPolicy
+	Entries []*RankingPreviewEntry
+}
+
+// RankingPreviewEntry is a submission with its position and score on the
+// front page now and under the candidate policy.
+type RankingPreviewEntry struct {
+	Submission        *Submission
+	Position          int
+	Score             float64
+	CandidatePosition int
+	CandidateScore    float64
+}
+
+func (q *PreviewRankingPolicy) QueryName() string { return "PreviewRankingPolicy" }
+func (q *PreviewRankingPolicy) Result() any       { return q.Entries }
+
+func NewPreviewRankingPolicy(candidate *RankingPolicy, at time.Time) *PreviewRankingPolicy {
+	return &PreviewRankingPolicy{Candidate: candidate, At: at, N: 30}
+}
+
+// previewRankingPolicy orders the entries by their candidate position.
+func (self *Content) previewRankingPolicy(q *PreviewRankingPolicy) error {
+	if err := q.Candidate.Validate(); err != nil {
+		return err
+	}
+	current, err := self.state.GetRankingPolicy()
+	if err != nil {
+		return err
+	}
+	q.Current = current
+
+	top := []*Submission{}
+	for after := 0; len(top) < q.N; after += q.N {
+		submissions, err := self.state.TopNSubmissions(q.N, after)
+		if err != nil {
+			return err
+		}
+		if len(submissions) == 0 {
+			break
+		}
+		for _, s := range submissions {
+			if !s.Hidden && len(top) < q.N {
+				top = append(top, s)
+			}
+		}
+	}
+
+	currentRanking, candidateRanking := current.Ranking(), q.Candidate.Ranking()
+	entries := make([]*RankingPreviewEntry, len(top))
+	for i, s := range top {
+		entries[i] = &RankingPreviewEntry{
+			Submission:     s,
+			Position:       i + 1,
+			Score:          currentRanking.Score(s.VoteCount, s.CommentCount, s.SubmittedAt, q.At),
+			CandidateScore: candidateRanking.Score(s.VoteCount, s.CommentCount, s.SubmittedAt, q.At),
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b *RankingPreviewEntry) int {
+		if a.CandidateScore == b.CandidateScore {
+			return b.Submission.SubmittedAt.Compare(a.Submission.SubmittedAt)
+		}
+		return cmp.Compare(b.CandidateScore, a.CandidateScore)
+	})
+	for i, entry := range entries {
+		entry.CandidatePosition = i + 1
+	}
+	q.Entries = entries
+	return nil
+}
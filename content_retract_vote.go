@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// RetractVote takes back the vote a user cast on a submission or comment.
+type RetractVote struct {
+	ItemID      string
+	Voter       string
+	RetractedAt time.Time
+}
+
+func (cmd *RetractVote) CommandName() string { return "RetractVote" }
+
+func init() {
+	DefaultCommandRegistry.Register("RetractVote", func() Command { return &RetractVote{} })
+}
+
+func (self *Content) validateRetractVote(cmd *RetractVote) error {
+	err := self.canVote(cmd.Voter, cmd.ItemID, NoVote)
+	if errors.Is(err, ErrAlreadyVoted) {
+		return ErrNotVoted
+	}
+	return err
+}
+
+func (self *Content) handleRetractVote(cmd *RetractVote) error {
+	if err := self.validateRetractVote(cmd); err != nil {
+		return err
+	}
+
+	return self.state.RecordVote(&Vote{
+		For:       cmd.ItemID,
+		By:        cmd.Voter,
+		Direction: NoVote,
+		At:        cmd.RetractedAt,
+	})
+}
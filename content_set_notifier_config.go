@@ -18,3 +18,31 @@ func init() {
 func (self *Content) handleSetNotifierConfig(cmd *SetNotifierConfig) error {
 	return nil
 }
+
+func init() {
+	DefaultCompactionRules.Register("SetNotifierConfig", func(now time.Time) CompactionRule {
+		return &supersededNotifierConfigRule{needed: map[int]bool{}}
+	})
+}
+
+// supersededNotifierConfigRule drops notifier configurations that were
+// replaced before the notifier saw any new content under them.
+type supersededNotifierConfigRule struct {
+	latest int
+	needed map[int]bool
+}
+
+func (r *supersededNotifierConfigRule) Observe(command *PersistedCommand) {
+	switch command.Message.(type) {
+	case *SetNotifierConfig:
+		r.latest = command.ID
+	case *PostLink, *PostText, *PostComment:
+		if r.latest != 0 {
+			r.needed[r.latest] = true
+		}
+	}
+}
+
+func (r *supersededNotifierConfigRule) Keep(command *PersistedCommand) bool {
+	return command.ID == r.latest || r.needed[command.ID]
+}
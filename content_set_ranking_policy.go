@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+)
+
+// SetRankingPolicy changes how submissions are ordered on the front page.
+type SetRankingPolicy struct {
+	Algorithm     string
+	Gravity       float64
+	Decay         float64
+	CommentWeight float64
+	SetBy         string
+	SetAt         time.Time
+}
+
+func (cmd *SetRankingPolicy) CommandName() string { return "SetRankingPolicy" }
+
+func init() {
+	DefaultCommandRegistry.Register("SetRankingPolicy", func() Command { return new(SetRankingPolicy) })
+}
+
+func (cmd *SetRankingPolicy) Policy() *RankingPolicy {
+	return &RankingPolicy{
+		Algorithm:     cmd.Algorithm,
+		Gravity:       cmd.Gravity,
+		Decay:         cmd.Decay,
+		CommentWeight: cmd.CommentWeight,
+	}
+}
+
+func (self *Content) validateSetRankingPolicy(cmd *SetRankingPolicy) error {
+	return cmd.Policy().Validate()
+}
+
+func (self *Content) handleSetRankingPolicy(cmd *SetRankingPolicy) error {
+	if err := self.validateSetRankingPolicy(cmd); err != nil {
+		return err
+	}
+	return self.state.PutRankingPolicy(cmd.Policy())
+}
@@ -2,103 +2,156 @@ package main
 
 import (
 	"cmp"
+	"encoding/json"
 	"fmt"
-	"math"
 	"slices"
 	"sync"
 	"time"
 )
 
-var _ ContentState = (*InMemoryContentState)(nil)
+var (
+	_ ContentState = (*InMemoryContentState)(nil)
+	_ Snapshotter  = (*InMemoryContentState)(nil)
+)
 
 type InMemoryContentState struct {
 	Lock                sync.Mutex
-	FrontpageDirty      bool
 	LastSubmissionAt    time.Time
 	Submissions         []*Submission
-	VotesByItemID       map[string][]string
+	VotesByItemID       map[string]map[string]VoteDirection
 	SubscriptionsByUser map[string]*SubscriptionSettings
+	EditsByItemID       map[string][]*ContentEdit
+	RankingPolicy       *RankingPolicy
+
+	// The fields below are derived from the ones above by reindex.
+	positionByID map[string]int
+	voteTotals   map[string]int
+	ranking      Ranking
+	// frontpage orders submissions if ranking is a StableRanking, and is nil otherwise.
+	frontpage *rankingIndex
 }
 
-func (self *InMemoryContentState) scoreSubmissions() {
-	for _, submission := range self.Submissions {
-		self.score(submission)
+// reindex derives the lookup tables and the front page from the submissions and votes.
+func (self *InMemoryContentState) reindex() {
+	self.positionByID = make(map[string]int, len(self.Submissions))
+	for i, submission := range self.Submissions {
+		self.positionByID[submission.ItemID] = i
 	}
+	self.voteTotals = make(map[string]int, len(self.VotesByItemID))
+	for itemID, voters := range self.VotesByItemID {
+		for _, direction := range voters {
+			self.voteTotals[itemID] += int(direction)
+		}
+	}
+	self.rerankAll()
 }
 
-func (self *InMemoryContentState) score(s *Submission) {
-	voteCount := len(self.VotesByItemID[s.ItemID])
-	commentCount := s.CommentCount
-	age := float64(self.LastSubmissionAt.Sub(s.SubmittedAt)) / float64(24*time.Hour)
-	decay := float32(math.Pow(0.9, age))
-	s.Score = (float32(voteCount) + float32(commentCount)*0.5) * decay
+// rerankAll rebuilds the front page for the current ranking policy.
+func (self *InMemoryContentState) rerankAll() {
+	self.ranking = self.RankingPolicy.Ranking()
+	self.frontpage = nil
+	if _, ok := self.ranking.(StableRanking); !ok {
+		return
+	}
+	self.frontpage = newRankingIndex()
+	for _, submission := range self.Submissions {
+		self.rerank(submission)
+	}
 }
 
-func (self *InMemoryContentState) refreshFrontpage() {
-	self.Lock.Lock()
-	defer self.Lock.Unlock()
-	if !self.FrontpageDirty {
+// rerank moves s to its place on the front page.
+func (self *InMemoryContentState) rerank(s *Submission) {
+	ranking, ok := self.ranking.(StableRanking)
+	if !ok {
 		return
 	}
-	self.scoreSubmissions()
-	slices.SortFunc(self.Submissions, func(i, j *Submission) int {
-		a, b := i.Score, j.Score
-		if a == b {
-			return j.SubmittedAt.Compare(i.SubmittedAt)
-		}
-		return cmp.Compare(b, a)
-	})
+	group, rank := ranking.Rank(self.netVotes(s.ItemID), s.CommentCount, s.SubmittedAt)
+	self.frontpage.Put(rankingKey{itemID: s.ItemID, group: group, rank: rank, submittedAt: s.SubmittedAt})
+}
 
-	self.FrontpageDirty = false
+// scored returns a copy of s with its score at the time of the most
+// recent submission and its vote count.  The copy shares its comments with s.
+func (self *InMemoryContentState) scored(s *Submission) *Submission {
+	c := *s
+	c.VoteCount = self.netVotes(s.ItemID)
+	c.Score = float32(self.ranking.Score(c.VoteCount, c.CommentCount, c.SubmittedAt, self.LastSubmissionAt))
+	return &c
+}
+
+func (self *InMemoryContentState) submission(itemID string) (*Submission, bool) {
+	i, ok := self.positionByID[itemID]
+	if !ok {
+		return nil, false
+	}
+	return self.Submissions[i], true
 }
 
 func (self *InMemoryContentState) PutSubmissionPreview(preview *SubmissionPreview) error {
-	for _, submission := range self.Submissions {
-		if submission.ItemID == preview.ItemID {
-			submission.Preview = preview
-			return nil
-		}
+	if submission, ok := self.submission(preview.ItemID); ok {
+		submission.Preview = preview
 	}
 	return nil
 }
 
 func NewInMemoryContentState() *InMemoryContentState {
-	return &InMemoryContentState{
+	state := &InMemoryContentState{
 		Submissions:         make([]*Submission, 0),
-		VotesByItemID:       map[string][]string{},
+		VotesByItemID:       map[string]map[string]VoteDirection{},
 		SubscriptionsByUser: map[string]*SubscriptionSettings{},
+		EditsByItemID:       map[string][]*ContentEdit{},
+		RankingPolicy:       NewDefaultRankingPolicy(),
 	}
+	state.reindex()
+	return state
 }
 
+// GetSubmission returns a copy of the submission identified by itemID,
+// so that queries running concurrently do not share it.
 func (self *InMemoryContentState) GetSubmission(itemID string) (*Submission, error) {
-	for _, submission := range self.Submissions {
-		if submission.ItemID == itemID {
-			return submission, nil
+	submission, ok := self.submission(itemID)
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	submission = submission.clone()
+	self.countVotes(submission)
+	return submission, nil
+}
+
+// netVotes returns the number of upvotes minus the number of downvotes for itemID.
+func (self *InMemoryContentState) netVotes(itemID string) int {
+	return self.voteTotals[itemID]
+}
+
+// countVotes updates the vote counts of submission and its comments.
+func (self *InMemoryContentState) countVotes(submission *Submission) {
+	submission.VoteCount = self.netVotes(submission.ItemID)
+	var count func(comments []*Comment)
+	count = func(comments []*Comment) {
+		for _, comment := range comments {
+			comment.VoteCount = self.netVotes(comment.ID().String())
+			count(comment.Children)
 		}
 	}
-	return nil, ErrItemNotFound
+	count(submission.Comments)
 }
 
 func (self *InMemoryContentState) PutSubmission(submission *Submission) error {
-	self.Submissions = append(self.Submissions, submission)
+	if i, ok := self.positionByID[submission.ItemID]; ok {
+		self.Submissions[i] = submission
+	} else {
+		self.positionByID[submission.ItemID] = len(self.Submissions)
+		self.Submissions = append(self.Submissions, submission)
+	}
 	if submission.SubmittedAt.After(self.LastSubmissionAt) {
 		self.LastSubmissionAt = submission.SubmittedAt
 	}
-	self.FrontpageDirty = true
+	self.rerank(submission)
 	return nil
 }
 
 func (self *InMemoryContentState) PutComment(comment *Comment) error {
-	submissionID := comment.ParentID[0]
-	submission := (*Submission)(nil)
-	for _, s := range self.Submissions {
-		if s.ItemID == submissionID {
-			submission = s
-			break
-		}
-	}
-
-	if submission == nil {
+	submission, ok := self.submission(comment.ParentID[0])
+	if !ok {
 		return ErrItemNotFound
 	}
 
@@ -106,6 +159,7 @@ func (self *InMemoryContentState) PutComment(comment *Comment) error {
 		comment.Index = len(submission.Comments)
 		submission.Comments = append(submission.Comments, comment)
 		submission.CommentCount++
+		self.rerank(submission)
 		return nil
 	}
 
@@ -126,57 +180,125 @@ func (self *InMemoryContentState) PutComment(comment *Comment) error {
 
 	parentComment.AddChild(comment)
 	submission.CommentCount++
+	self.rerank(submission)
+	return nil
+}
+
+// UpdateComment replaces the contents of an existing comment, keeping its replies.
+func (self *InMemoryContentState) UpdateComment(comment *Comment) error {
+	submission, ok := self.submission(comment.ParentID.Root())
+	if !ok {
+		return ErrItemNotFound
+	}
+	existing := submission.Comment(comment.ID())
+	if existing == nil {
+		return ErrItemNotFound
+	}
+	if existing != comment {
+		children := existing.Children
+		*existing = *comment
+		existing.Children = children
+	}
 	return nil
 }
 
+// TopNSubmissions returns n submissions in front page order, skipping the first after ones.
+//
+// Under a StableRanking only the returned submissions are scored.  Other
+// rankings, like gravity, have no index: every call scores and sorts all
+// submissions, which is linear in their number.
 func (self *InMemoryContentState) TopNSubmissions(n int, after int) ([]*Submission, error) {
+	self.Lock.Lock()
+	defer self.Lock.Unlock()
 	if after < 0 {
 		after = 0
 	}
-	if after >= len(self.Submissions) {
-		after = len(self.Submissions)
+
+	if self.frontpage == nil {
+		return self.topNScored(n, after), nil
 	}
-	end := after + n
-	if end > len(self.Submissions) {
-		end = len(self.Submissions)
+	itemIDs := self.frontpage.Range(after, n)
+	topN := make([]*Submission, len(itemIDs))
+	for i, itemID := range itemIDs {
+		submission, _ := self.submission(itemID)
+		topN[i] = self.scored(submission)
 	}
+	return topN, nil
+}
 
-	self.refreshFrontpage()
-	topN := self.Submissions[after:end]
-	for _, s := range topN {
-		s.VoteCount = len(self.VotesByItemID[s.ItemID])
+// topNScored returns n submissions after the first after ones, scoring
+// all submissions at the time of the most recent one.
+func (self *InMemoryContentState) topNScored(n int, after int) []*Submission {
+	sorted := make([]*Submission, len(self.Submissions))
+	for i, s := range self.Submissions {
+		sorted[i] = self.scored(s)
 	}
-	return topN, nil
+	slices.SortFunc(sorted, func(i, j *Submission) int {
+		a, b := i.Score, j.Score
+		if a == b {
+			return j.SubmittedAt.Compare(i.SubmittedAt)
+		}
+		return cmp.Compare(b, a)
+	})
+	after = min(after, len(sorted))
+	return sorted[after:min(after+n, len(sorted))]
 }
 
 func (self *InMemoryContentState) RecordVote(vote *Vote) error {
 	voters, ok := self.VotesByItemID[vote.For]
 	if !ok {
-		voters = []string{}
+		voters = map[string]VoteDirection{}
+		self.VotesByItemID[vote.For] = voters
 	}
 
-	if slices.Contains(voters, vote.By) {
-		return nil
+	self.voteTotals[vote.For] += int(vote.Direction - voters[vote.By])
+	if vote.Direction == NoVote {
+		delete(voters, vote.By)
+	} else {
+		voters[vote.By] = vote.Direction
+	}
+	if submission, ok := self.submission(vote.For); ok {
+		self.rerank(submission)
 	}
-
-	voters = append(voters, vote.By)
-	self.VotesByItemID[vote.For] = voters
-	self.FrontpageDirty = true
 	return nil
 }
 
-func (self *InMemoryContentState) HasVotedFor(user string, itemIDs []string) ([]bool, error) {
-	result := make([]bool, len(itemIDs))
+func (self *InMemoryContentState) GetVotesBy(user string, itemIDs []string) ([]VoteDirection, error) {
+	result := make([]VoteDirection, len(itemIDs))
 	for i, itemID := range itemIDs {
-		voters, ok := self.VotesByItemID[itemID]
-		if !ok {
-			continue
-		}
-		result[i] = slices.Contains(voters, user)
+		result[i] = self.VotesByItemID[itemID][user]
 	}
 	return result, nil
 }
 
+// GetKarma returns the votes others cast on the submissions and comments of username.
+func (self *InMemoryContentState) GetKarma(username string) (int, error) {
+	karma := 0
+	votesOfOthers := func(itemID string) {
+		for voter, direction := range self.VotesByItemID[itemID] {
+			if voter != username {
+				karma += int(direction)
+			}
+		}
+	}
+	var count func(comments []*Comment)
+	count = func(comments []*Comment) {
+		for _, comment := range comments {
+			if comment.Author == username {
+				votesOfOthers(comment.ID().String())
+			}
+			count(comment.Children)
+		}
+	}
+	for _, submission := range self.Submissions {
+		if submission.Submitter == username {
+			votesOfOthers(submission.ItemID)
+		}
+		count(submission.Comments)
+	}
+	return karma, nil
+}
+
 func (self *InMemoryContentState) GetSubmissionForComment(commentID TreeID) (*Submission, error) {
 	submissionID := commentID[0]
 	return self.GetSubmission(submissionID)
@@ -213,3 +335,79 @@ func (self *InMemoryContentState) PutSubscriptionSettings(settings *Subscription
 	self.SubscriptionsByUser[settings.Subscriber] = settings
 	return nil
 }
+
+// RecordEdit adds edit to the history of the item it changed.
+func (self *InMemoryContentState) RecordEdit(edit *ContentEdit) error {
+	self.EditsByItemID[edit.ItemID] = append(self.EditsByItemID[edit.ItemID], edit)
+	return nil
+}
+
+// GetEdits returns the history of the item identified by itemID, oldest edit first.
+func (self *InMemoryContentState) GetEdits(itemID string) ([]*ContentEdit, error) {
+	return slices.Clone(self.EditsByItemID[itemID]), nil
+}
+
+// GetRankingPolicy returns the policy set last, or DefaultRankingPolicy.
+func (self *InMemoryContentState) GetRankingPolicy() (*RankingPolicy, error) {
+	if self.RankingPolicy == nil {
+		return NewDefaultRankingPolicy(), nil
+	}
+	return self.RankingPolicy, nil
+}
+
+// PutRankingPolicy replaces the ranking policy, ranking all submissions again.
+func (self *InMemoryContentState) PutRankingPolicy(policy *RankingPolicy) error {
+	self.RankingPolicy = policy
+	self.rerankAll()
+	return nil
+}
+
+// inMemoryContentSnapshot contains all fields of InMemoryContentState
+// that cannot be derived from other fields.
+type inMemoryContentSnapshot struct {
+	LastSubmissionAt    time.Time
+	Submissions         []*Submission
+	VotesByItemID       map[string]map[string]VoteDirection
+	SubscriptionsByUser map[string]*SubscriptionSettings
+	EditsByItemID       map[string][]*ContentEdit
+	RankingPolicy       *RankingPolicy
+}
+
+func (self *InMemoryContentState) Snapshot() ([]byte, error) {
+	self.Lock.Lock()
+	defer self.Lock.Unlock()
+	return json.Marshal(&inMemoryContentSnapshot{
+		LastSubmissionAt:    self.LastSubmissionAt,
+		Submissions:         self.Submissions,
+		VotesByItemID:       self.VotesByItemID,
+		SubscriptionsByUser: self.SubscriptionsByUser,
+		EditsByItemID:       self.EditsByItemID,
+		RankingPolicy:       self.RankingPolicy,
+	})
+}
+
+// Restore replaces the entire state with the one found in data.
+//
+// The state is left untouched if data cannot be decoded.
+func (self *InMemoryContentState) Restore(data []byte) error {
+	restored := &inMemoryContentSnapshot{
+		Submissions:         []*Submission{},
+		VotesByItemID:       map[string]map[string]VoteDirection{},
+		SubscriptionsByUser: map[string]*SubscriptionSettings{},
+		EditsByItemID:       map[string][]*ContentEdit{},
+	}
+	if err := json.Unmarshal(data, restored); err != nil {
+		return fmt.Errorf("failed to decode content state: %w", err)
+	}
+
+	self.Lock.Lock()
+	defer self.Lock.Unlock()
+	self.LastSubmissionAt = restored.LastSubmissionAt
+	self.Submissions = restored.Submissions
+	self.VotesByItemID = restored.VotesByItemID
+	self.SubscriptionsByUser = restored.SubscriptionsByUser
+	self.EditsByItemID = restored.EditsByItemID
+	self.RankingPolicy = restored.RankingPolicy
+	self.reindex()
+	return nil
+}
@@ -1,32 +1,45 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 )
 
-var _ ContentState = (*PersistentContentState)(nil)
+var (
+	_ ContentState   = (*PersistentContentState)(nil)
+	_ VersionedState = (*PersistentContentState)(nil)
+)
 
-// PersistentContentState is a **sketch** of a persistent version of storing all content.
+// PersistentContentState stores all content in a sqlite3 database.
 //
 // Using a persistent version means we do not need to replay the
 // entire log on application startup, but instead can *remember* our
 // position and resume from there.
 //
-// This particular implementation uses sqlite3 for this purpose.
+// Comments are stored with their full TreeID as a path, so that a
+// submission's comment tree can be rebuilt with a single query.
 //
-// Since storing graph-based data in a SQL database is not straightforward,
-// I eventually gave up, because at this stage it's not necessary.
+// Votes on submissions and comments are kept in the same table, keyed
+// by the item ID of a submission or the path of a comment.
 //
-// One day I'll return and implement the rest, just to demonstrate
-// that you don't have to keep the entire application state in memory.
+// Submissions are ranked by storing the sign and the logarithm of their
+// score at the time they were submitted: time decay affects all
+// submissions in the same way, so the order of submissions only changes
+// when one of them receives a vote or a comment.  Rankings under which the order
+// changes over time instead score all submissions when they are queried.
 type PersistentContentState struct {
+	sqliteState
 	filename string
+	policy   *RankingPolicy
 }
 
 func NewPersistentContentState(filename string) *PersistentContentState {
-	return &PersistentContentState{filename: filename}
+	return &PersistentContentState{sqliteState: sqliteState{versionTable: "content_version"}, filename: filename}
 }
 
 func (self *PersistentContentState) Setup() error {
@@ -34,175 +47,723 @@ func (self *PersistentContentState) Setup() error {
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
-	defer db.Close()
 	schema := []string{
-		"CREATE TABLE IF NOT EXISTS submissions (item_id TEXT PRIMARY KEY, submitter TEXT, url TEXT, title TEXT, submitted_at TIMESTAMP);",
+		"CREATE TABLE IF NOT EXISTS content_version (id INTEGER PRIMARY KEY CHECK (id = 1), version INTEGER NOT NULL);",
+		`CREATE TABLE IF NOT EXISTS submissions (
+       item_id TEXT PRIMARY KEY,
+       submitter TEXT,
+       url TEXT,
+       title TEXT,
+       body TEXT,
+       submitted_at TIMESTAMP,
+       edited_at TIMESTAMP,
+       hidden BOOLEAN NOT NULL DEFAULT FALSE,
+       vote_count INTEGER NOT NULL DEFAULT 0,
+       comment_count INTEGER NOT NULL DEFAULT 0,
+       rank_group INTEGER,
+       rank REAL
+     );`,
 		"CREATE TABLE IF NOT EXISTS submission_previews (item_id TEXT PRIMARY KEY, title TEXT, image_url TEXT, description TEXT, generated_at TIMESTAMP);",
-		"CREATE TABLE IF NOT EXISTS submission_votes (item_id TEXT, voter TEXT, voted_at TIMESTAMP, PRIMARY KEY (item_id, voter));",
+		"CREATE TABLE IF NOT EXISTS submission_votes (item_id TEXT, voter TEXT, voted_at TIMESTAMP, direction INTEGER NOT NULL DEFAULT 1, PRIMARY KEY (item_id, voter));",
+		"CREATE INDEX IF NOT EXISTS submission_votes_by_voter ON submission_votes (voter);",
 		"CREATE INDEX IF NOT EXISTS submission_voters ON submission_votes (item_id, voter);",
+		`CREATE TABLE IF NOT EXISTS comments (
+       path TEXT PRIMARY KEY,
+       submission_id TEXT NOT NULL,
+       parent_path TEXT NOT NULL,
+       depth INTEGER NOT NULL,
+       idx INTEGER NOT NULL,
+       author TEXT,
+       content TEXT,
+       content_html TEXT,
+       posted_at TIMESTAMP,
+       edited_at TIMESTAMP,
+       hidden BOOLEAN NOT NULL DEFAULT FALSE,
+       deleted BOOLEAN NOT NULL DEFAULT FALSE,
+       vote_count INTEGER NOT NULL DEFAULT 0
+     );`,
+		"CREATE INDEX IF NOT EXISTS comments_by_author ON comments (author);",
+		"CREATE INDEX IF NOT EXISTS submissions_by_submitter ON submissions (submitter);",
+		"CREATE INDEX IF NOT EXISTS comments_by_submission ON comments (submission_id, depth, idx);",
+		"CREATE INDEX IF NOT EXISTS comments_by_parent ON comments (parent_path);",
+		"CREATE TABLE IF NOT EXISTS content_edits (item_id TEXT NOT NULL, field TEXT NOT NULL, previous TEXT, edited_by TEXT, edited_at TIMESTAMP);",
+		"CREATE INDEX IF NOT EXISTS content_edits_by_item ON content_edits (item_id);",
+		"CREATE TABLE IF NOT EXISTS ranking_policy (id INTEGER PRIMARY KEY CHECK (id = 1), algorithm TEXT NOT NULL, gravity REAL NOT NULL, decay REAL NOT NULL, comment_weight REAL NOT NULL);",
+		"CREATE TABLE IF NOT EXISTS subscription_settings (username TEXT PRIMARY KEY, last_change_at TIMESTAMP);",
+		"CREATE TABLE IF NOT EXISTS subscription_scopes (username TEXT, scope TEXT, enabled BOOLEAN, position INTEGER, PRIMARY KEY (username, scope));",
 	}
 	tx, err := db.Begin()
 	if err != nil {
+		db.Close()
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer tx.Commit()
+	defer tx.Rollback()
+	if err := dropUnversionedContentTables(tx); err != nil {
+		db.Close()
+		return err
+	}
 	for _, stmt := range schema {
 		if _, err := tx.Exec(stmt); err != nil {
+			db.Close()
+			return fmt.Errorf("failed to create database schema: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to commit schema: %w", err)
+	}
+	if err := addMissingColumns(db, "submissions", map[string]string{"body": "TEXT", "edited_at": "TIMESTAMP", "rank_group": "INTEGER"}); err != nil {
+		db.Close()
+		return err
+	}
+	for _, stmt := range []string{
+		// Submissions used to be ordered by rank alone, leaving those without points unranked.
+		"DROP INDEX IF EXISTS submissions_by_rank;",
+		"CREATE INDEX IF NOT EXISTS submissions_by_rank_group ON submissions (rank_group DESC, rank DESC, submitted_at DESC);",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
 			return fmt.Errorf("failed to create database schema: %w", err)
 		}
 	}
+	if err := addMissingColumns(db, "submission_votes", map[string]string{"direction": "INTEGER NOT NULL DEFAULT 1"}); err != nil {
+		db.Close()
+		return err
+	}
+	if err := addMissingColumns(db, "comments", map[string]string{"edited_at": "TIMESTAMP", "deleted": "BOOLEAN NOT NULL DEFAULT FALSE", "vote_count": "INTEGER NOT NULL DEFAULT 0"}); err != nil {
+		db.Close()
+		return err
+	}
+	self.db = db
+	policy, err := self.GetRankingPolicy()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	self.policy = policy
+	if err := self.update(func(tx sqlHandle) error { return rescoreWhere(tx, self.ranking(), "rank_group IS NULL") }); err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+// dropUnversionedContentTables removes tables created by earlier
+// versions of this state, which did not record the version they were
+// derived at and thus need to be derived again from the start.
+func dropUnversionedContentTables(tx *sql.Tx) error {
+	versioned := 0
+	if err := tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'content_version'").Scan(&versioned); err != nil {
+		return fmt.Errorf("failed to inspect database schema: %w", err)
+	}
+	if versioned > 0 {
+		return nil
+	}
+	for _, table := range []string{"submissions", "submission_previews", "submission_votes"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return fmt.Errorf("failed to drop outdated table %s: %w", table, err)
+		}
+	}
 	return nil
 }
 
 func (self *PersistentContentState) conninfo() string {
-	return fmt.Sprintf("file:%s?_journal=wal", self.filename)
+	return fmt.Sprintf("file:%s?_journal=wal&_busy_timeout=5000", self.filename)
 }
 
-func (self *PersistentContentState) PutSubmission(submission *Submission) error {
-	db, err := sql.Open("sqlite3", self.conninfo())
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+// ranking returns the ranking set by the current ranking policy.
+func (self *PersistentContentState) ranking() Ranking {
+	return self.policy.Ranking()
+}
+
+// rescore updates the stored rank of the submission identified by itemID.
+//
+// Rankings that are not stable do not use stored ranks.
+func rescore(tx sqlHandle, ranking Ranking, itemID string) error {
+	stable, ok := ranking.(StableRanking)
+	if !ok {
+		return nil
+	}
+	var (
+		voteCount    int
+		commentCount int
+		submittedAt  time.Time
+	)
+	if err := tx.QueryRow(`SELECT vote_count, comment_count, submitted_at FROM submissions WHERE item_id = ?`, itemID).Scan(
+		&voteCount, &commentCount, &submittedAt); err != nil {
+		return fmt.Errorf("failed to load submission %q for scoring: %w", itemID, err)
 	}
-	defer db.Close()
-	if _, err := db.Exec(`INSERT OR REPLACE INTO submissions (item_id, submitter, url, title, submitted_at) VALUES (?, ?, ?, ?, ?)`,
-		submission.ItemID, submission.Submitter, submission.Url, submission.Title, submission.SubmittedAt); err != nil {
-		return fmt.Errorf("failed to insert submission: %w", err)
+	group, rank := stable.Rank(voteCount, commentCount, submittedAt)
+	if _, err := tx.Exec(`UPDATE submissions SET rank_group = ?, rank = ? WHERE item_id = ?`, group, rank, itemID); err != nil {
+		return fmt.Errorf("failed to update rank of %q: %w", itemID, err)
 	}
 	return nil
 }
 
-func (self *PersistentContentState) GetSubmission(itemID string) (*Submission, error) {
-	db, err := sql.Open("sqlite3", self.conninfo())
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+func (self *PersistentContentState) PutSubmission(submission *Submission) error {
+	return self.update(func(tx sqlHandle) error {
+		if _, err := tx.Exec(`INSERT INTO submissions (item_id, submitter, url, title, body, submitted_at, edited_at, hidden) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (item_id) DO UPDATE SET
+      submitter = excluded.submitter,
+      url = excluded.url,
+      title = excluded.title,
+      body = excluded.body,
+      submitted_at = excluded.submitted_at,
+      edited_at = excluded.edited_at,
+      hidden = excluded.hidden`,
+			submission.ItemID, submission.Submitter, submission.Url, submission.Title, submission.Body, submission.SubmittedAt.UTC(), nullTime(submission.EditedAt), submission.Hidden); err != nil {
+			return fmt.Errorf("failed to insert submission: %w", err)
+		}
+		return rescore(tx, self.ranking(), submission.ItemID)
+	})
+}
+
+const submissionColumns = `s.item_id, s.submitter, s.url, s.title, COALESCE(s.body, ''), s.submitted_at, s.edited_at, s.hidden, s.vote_count, s.comment_count,
+  p.item_id, p.title, p.image_url, p.description, p.generated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSubmission reads a row selected with submissionColumns.
+func scanSubmission(row rowScanner) (*Submission, error) {
+	submission := &Submission{}
+	var (
+		editedAt    sql.NullTime
+		previewID   sql.NullString
+		title       sql.NullString
+		imageURL    sql.NullString
+		description sql.NullString
+		generatedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&submission.ItemID, &submission.Submitter, &submission.Url, &submission.Title, &submission.Body, &submission.SubmittedAt,
+		&editedAt, &submission.Hidden, &submission.VoteCount, &submission.CommentCount,
+		&previewID, &title, &imageURL, &description, &generatedAt,
+	); err != nil {
+		return nil, err
+	}
+	submission.EditedAt = editedAt.Time
+	if previewID.Valid {
+		submission.Preview = &SubmissionPreview{
+			ItemID:      previewID.String,
+			GeneratedAt: generatedAt.Time,
+		}
+		if title.Valid {
+			submission.Preview.Title = &title.String
+		}
+		if imageURL.Valid {
+			submission.Preview.ImageURL = &imageURL.String
+		}
+		if description.Valid {
+			submission.Preview.Description = &description.String
+		}
 	}
-	defer db.Close()
-	submission := &Submission{
-		ItemID: itemID,
+	return submission, nil
+}
+
+func (self *PersistentContentState) GetSubmission(itemID string) (*Submission, error) {
+	row := self.handle().QueryRow(`SELECT `+submissionColumns+`
+    FROM submissions s LEFT JOIN submission_previews p ON p.item_id = s.item_id
+    WHERE s.item_id = ?`, itemID)
+	submission, err := scanSubmission(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
 	}
-	if err := db.QueryRow(`SELECT submitter, url, title, submitted_at FROM submissions WHERE item_id = ?`, itemID).Scan(
-		&submission.Submitter, &submission.Url, &submission.Title, &submission.SubmittedAt); err != nil {
+	if err != nil {
 		return nil, fmt.Errorf("failed to get submission: %w", err)
 	}
+	if err := self.loadComments(submission); err != nil {
+		return nil, err
+	}
 	return submission, nil
 }
 
+// loadComments rebuilds the comment tree of submission.
+func (self *PersistentContentState) loadComments(submission *Submission) error {
+	rows, err := self.handle().Query(`SELECT parent_path, idx, author, content, content_html, posted_at, edited_at, hidden, deleted, vote_count
+    FROM comments WHERE submission_id = ? ORDER BY depth, idx`, submission.ItemID)
+	if err != nil {
+		return fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	byPath := map[string]*Comment{}
+	submission.Comments = []*Comment{}
+	for rows.Next() {
+		comment := &Comment{}
+		var (
+			parentPath  string
+			contentHTML sql.NullString
+			editedAt    sql.NullTime
+		)
+		if err := rows.Scan(&parentPath, &comment.Index, &comment.Author, &comment.Content, &contentHTML, &comment.PostedAt, &editedAt, &comment.Hidden, &comment.Deleted, &comment.VoteCount); err != nil {
+			return fmt.Errorf("failed to scan comment: %w", err)
+		}
+		comment.ParentID = NewTreeID(parentPath)
+		comment.ContentHTML = contentHTML.String
+		comment.EditedAt = editedAt.Time
+		byPath[comment.ID().String()] = comment
+		if len(comment.ParentID) == 1 {
+			submission.Comments = append(submission.Comments, comment)
+			continue
+		}
+		parent, found := byPath[parentPath]
+		if !found {
+			return fmt.Errorf("parent %q of comment %q is missing: %w", parentPath, comment.ID(), ErrItemNotFound)
+		}
+		parent.Children = append(parent.Children, comment)
+	}
+	return rows.Err()
+}
+
 func (self *PersistentContentState) PutComment(comment *Comment) error {
-	return ErrItemNotFound
+	return self.update(func(tx sqlHandle) error {
+		submissionID := comment.ParentID.Root()
+		parentPath := comment.ParentID.String()
+		parents := 0
+		var err error
+		if len(comment.ParentID) == 1 {
+			err = tx.QueryRow(`SELECT count(*) FROM submissions WHERE item_id = ?`, submissionID).Scan(&parents)
+		} else {
+			err = tx.QueryRow(`SELECT count(*) FROM comments WHERE path = ?`, parentPath).Scan(&parents)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find parent of comment: %w", err)
+		}
+		if parents == 0 {
+			return ErrItemNotFound
+		}
+
+		if err := tx.QueryRow(`SELECT count(*) FROM comments WHERE parent_path = ?`, parentPath).Scan(&comment.Index); err != nil {
+			return fmt.Errorf("failed to count siblings of comment: %w", err)
+		}
+		if _, err := tx.Exec(`INSERT INTO comments (path, submission_id, parent_path, depth, idx, author, content, content_html, posted_at, hidden)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			comment.ID().String(), submissionID, parentPath, len(comment.ParentID), comment.Index,
+			comment.Author, comment.Content, comment.ContentHTML, comment.PostedAt, comment.Hidden); err != nil {
+			return fmt.Errorf("failed to insert comment: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE submissions SET comment_count = comment_count + 1 WHERE item_id = ?`, submissionID); err != nil {
+			return fmt.Errorf("failed to update comment count: %w", err)
+		}
+		return rescore(tx, self.ranking(), submissionID)
+	})
 }
 
-func (self *PersistentContentState) PutSubmissionPreview(preview *SubmissionPreview) error {
-	db, err := sql.Open("sqlite3", self.conninfo())
+func (self *PersistentContentState) UpdateComment(comment *Comment) error {
+	result, err := self.handle().Exec(`UPDATE comments SET content = ?, content_html = ?, edited_at = ?, hidden = ?, deleted = ? WHERE path = ?`,
+		comment.Content, comment.ContentHTML, nullTime(comment.EditedAt), comment.Hidden, comment.Deleted, comment.ID().String())
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return fmt.Errorf("failed to update comment: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+func (self *PersistentContentState) RecordEdit(edit *ContentEdit) error {
+	if _, err := self.handle().Exec(`INSERT INTO content_edits (item_id, field, previous, edited_by, edited_at) VALUES (?, ?, ?, ?, ?)`,
+		edit.ItemID, edit.Field, edit.Previous, edit.EditedBy, edit.EditedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to record edit of %q: %w", edit.ItemID, err)
+	}
+	return nil
+}
+
+func (self *PersistentContentState) GetEdits(itemID string) ([]*ContentEdit, error) {
+	rows, err := self.handle().Query(`SELECT item_id, field, previous, edited_by, edited_at FROM content_edits WHERE item_id = ? ORDER BY rowid`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query edits of %q: %w", itemID, err)
+	}
+	defer rows.Close()
+	edits := []*ContentEdit{}
+	for rows.Next() {
+		edit := &ContentEdit{}
+		if err := rows.Scan(&edit.ItemID, &edit.Field, &edit.Previous, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan edit: %w", err)
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
 	}
-	defer db.Close()
-	if _, err := db.Exec(`INSERT OR REPLACE INTO submission_previews (item_id, title, image_url, description, generated_at) VALUES (?, ?, ?, ?, ?)`,
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (self *PersistentContentState) PutSubmissionPreview(preview *SubmissionPreview) error {
+	if _, err := self.handle().Exec(`INSERT OR REPLACE INTO submission_previews (item_id, title, image_url, description, generated_at) VALUES (?, ?, ?, ?, ?)`,
 		preview.ItemID, preview.Title, preview.ImageURL, preview.Description, preview.GeneratedAt); err != nil {
 		return fmt.Errorf("failed to insert submission preview: %w", err)
 	}
 	return nil
 }
 
+// TopNSubmissions returns n submissions in front page order, skipping the first after ones.
+//
+// Under a StableRanking the stored ranks order the submissions.  Other
+// rankings, like gravity, read and score all submissions on every call.
 func (self *PersistentContentState) TopNSubmissions(n int, after int) ([]*Submission, error) {
-	db, err := sql.Open("sqlite3", self.conninfo())
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+	if after < 0 {
+		after = 0
+	}
+	lastSubmissionAt := time.Time{}
+	err := self.handle().QueryRow(`SELECT submitted_at FROM submissions ORDER BY submitted_at DESC LIMIT 1`).Scan(&lastSubmissionAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to query most recent submission: %w", err)
 	}
-	defer db.Close()
-	rows, err := db.Query(`SELECT item_id, submitter, url, title, submitted_at FROM submissions ORDER BY submitted_at DESC LIMIT ? OFFSET ?`, n, after)
+
+	ranking := self.ranking()
+	if _, ok := ranking.(StableRanking); !ok {
+		return self.topNScored(ranking, n, after, lastSubmissionAt)
+	}
+
+	rows, err := self.handle().Query(`SELECT `+submissionColumns+`
+    FROM submissions s LEFT JOIN submission_previews p ON p.item_id = s.item_id
+    ORDER BY s.rank_group DESC, s.rank DESC, s.submitted_at DESC
+    LIMIT ? OFFSET ?`, n, after)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query submissions: %w", err)
 	}
 	defer rows.Close()
-	submissions := make([]*Submission, 0, n)
 
+	submissions := make([]*Submission, 0, n)
 	for rows.Next() {
-		submission := &Submission{}
-		if err := rows.Scan(&submission.ItemID, &submission.Submitter, &submission.Url, &submission.Title, &submission.SubmittedAt); err != nil {
+		submission, err := scanSubmission(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan submission: %w", err)
 		}
-		preview := &SubmissionPreview{
-			ItemID: submission.ItemID,
+		submissions = append(submissions, submission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read submissions: %w", err)
+	}
+
+	for _, s := range submissions {
+		s.Score = float32(ranking.Score(s.VoteCount, s.CommentCount, s.SubmittedAt, lastSubmissionAt))
+	}
+
+	return submissions, nil
+}
+
+// topNScored returns n submissions after the first after ones, scoring
+// all submissions at the time of the most recent one.
+func (self *PersistentContentState) topNScored(ranking Ranking, n int, after int, lastSubmissionAt time.Time) ([]*Submission, error) {
+	type scored struct {
+		itemID      string
+		submittedAt time.Time
+		score       float64
+	}
+	rows, err := self.handle().Query(`SELECT item_id, vote_count, comment_count, submitted_at FROM submissions`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query submissions for scoring: %w", err)
+	}
+	all := []scored{}
+	for rows.Next() {
+		var (
+			s            scored
+			voteCount    int
+			commentCount int
+		)
+		if err := rows.Scan(&s.itemID, &voteCount, &commentCount, &s.submittedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan submission for scoring: %w", err)
 		}
+		s.score = ranking.Score(voteCount, commentCount, s.submittedAt, lastSubmissionAt)
+		all = append(all, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read submissions for scoring: %w", err)
+	}
 
-		if err := db.QueryRow(
-			"select title, image_url, description, generated_at from submission_previews where item_id = ?",
-			submission.ItemID,
-		).Scan(&preview.Title, &preview.ImageURL, &preview.Description, &preview.GeneratedAt); err == nil {
-			submission.Preview = preview
+	slices.SortFunc(all, func(a, b scored) int {
+		if a.score == b.score {
+			return b.submittedAt.Compare(a.submittedAt)
 		}
+		return cmp.Compare(b.score, a.score)
+	})
+	if after >= len(all) {
+		return []*Submission{}, nil
+	}
+	page := all[after:min(after+n, len(all))]
 
-		submissions = append(submissions, submission)
+	args := make([]any, len(page))
+	for i, s := range page {
+		args[i] = s.itemID
+	}
+	rows, err = self.handle().Query(fmt.Sprintf(`SELECT `+submissionColumns+`
+    FROM submissions s LEFT JOIN submission_previews p ON p.item_id = s.item_id
+    WHERE s.item_id IN (%s)`, placeholders(len(page))), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query submissions: %w", err)
+	}
+	defer rows.Close()
+	byID := map[string]*Submission{}
+	for rows.Next() {
+		submission, err := scanSubmission(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan submission: %w", err)
+		}
+		byID[submission.ItemID] = submission
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read submissions: %w", err)
 	}
 
+	submissions := make([]*Submission, 0, len(page))
+	for _, s := range page {
+		if submission, ok := byID[s.itemID]; ok {
+			submission.Score = float32(s.score)
+			submissions = append(submissions, submission)
+		}
+	}
 	return submissions, nil
 }
 
-func (self *PersistentContentState) RecordVote(vote *Vote) error {
-	db, err := sql.Open("sqlite3", self.conninfo())
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+// GetRankingPolicy returns the policy set last, or DefaultRankingPolicy.
+func (self *PersistentContentState) GetRankingPolicy() (*RankingPolicy, error) {
+	policy := &RankingPolicy{}
+	err := self.handle().QueryRow(`SELECT algorithm, gravity, decay, comment_weight FROM ranking_policy WHERE id = 1`).Scan(
+		&policy.Algorithm, &policy.Gravity, &policy.Decay, &policy.CommentWeight)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NewDefaultRankingPolicy(), nil
 	}
-	defer db.Close()
-	_, err = db.Query(`
-    INSERT OR REPLACE INTO submission_votes (item_id, voter, voted_at) 
-          values (?, ?, ?) 
-    ON CONFLICT (item_id, voter)
-    DO NOTHING
-  `, vote.For, vote.By, vote.At)
 	if err != nil {
-		return fmt.Errorf("failed to insert vote: %w", err)
+		return nil, fmt.Errorf("failed to get ranking policy: %w", err)
 	}
+	return policy, nil
+}
 
-	return nil
+// PutRankingPolicy replaces the ranking policy, ranking all submissions again.
+func (self *PersistentContentState) PutRankingPolicy(policy *RankingPolicy) error {
+	return self.update(func(tx sqlHandle) error {
+		if _, err := tx.Exec(`INSERT INTO ranking_policy (id, algorithm, gravity, decay, comment_weight) VALUES (1, ?, ?, ?, ?)
+    ON CONFLICT (id) DO UPDATE SET
+      algorithm = excluded.algorithm,
+      gravity = excluded.gravity,
+      decay = excluded.decay,
+      comment_weight = excluded.comment_weight`,
+			policy.Algorithm, policy.Gravity, policy.Decay, policy.CommentWeight); err != nil {
+			return fmt.Errorf("failed to store ranking policy: %w", err)
+		}
+
+		if err := rescoreWhere(tx, policy.Ranking(), "TRUE"); err != nil {
+			return err
+		}
+		self.policy = policy
+		return nil
+	})
 }
 
-func (self *PersistentContentState) HasVotedFor(user string, itemIDs []string) ([]bool, error) {
-	db, err := sql.Open("sqlite3", self.conninfo())
+// rescoreWhere updates the stored ranks of all submissions matching condition.
+func rescoreWhere(tx sqlHandle, ranking Ranking, condition string) error {
+	if _, ok := ranking.(StableRanking); !ok {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT item_id FROM submissions WHERE ` + condition)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return fmt.Errorf("failed to query submissions for ranking: %w", err)
+	}
+	itemIDs := []string{}
+	for rows.Next() {
+		itemID := ""
+		if err := rows.Scan(&itemID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan submission for ranking: %w", err)
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read submissions for ranking: %w", err)
+	}
+	for _, itemID := range itemIDs {
+		if err := rescore(tx, ranking, itemID); err != nil {
+			return err
+		}
 	}
-	defer db.Close()
+	return nil
+}
 
-	idList := []string{}
-	for _, id := range itemIDs {
-		idList = append(idList, fmt.Sprintf("%q", id))
+func (self *PersistentContentState) RecordVote(vote *Vote) error {
+	return self.update(func(tx sqlHandle) error {
+		previous := NoVote
+		err := tx.QueryRow(`SELECT direction FROM submission_votes WHERE item_id = ? AND voter = ?`, vote.For, vote.By).Scan(&previous)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get previous vote: %w", err)
+		}
+		if previous == vote.Direction {
+			return nil
+		}
+
+		if vote.Direction == NoVote {
+			_, err = tx.Exec(`DELETE FROM submission_votes WHERE item_id = ? AND voter = ?`, vote.For, vote.By)
+		} else {
+			_, err = tx.Exec(`INSERT INTO submission_votes (item_id, voter, voted_at, direction) VALUES (?, ?, ?, ?)
+    ON CONFLICT (item_id, voter) DO UPDATE SET voted_at = excluded.voted_at, direction = excluded.direction`,
+				vote.For, vote.By, vote.At, vote.Direction)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record vote: %w", err)
+		}
+
+		delta := vote.Direction - previous
+		if id := NewTreeID(vote.For); len(id) > 1 {
+			if _, err := tx.Exec(`UPDATE comments SET vote_count = vote_count + ? WHERE path = ?`, delta, vote.For); err != nil {
+				return fmt.Errorf("failed to update vote count: %w", err)
+			}
+			return nil
+		}
+
+		updated, err := tx.Exec(`UPDATE submissions SET vote_count = vote_count + ? WHERE item_id = ?`, delta, vote.For)
+		if err != nil {
+			return fmt.Errorf("failed to update vote count: %w", err)
+		}
+		if n, err := updated.RowsAffected(); err == nil && n > 0 {
+			if err := rescore(tx, self.ranking(), vote.For); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// placeholders returns a comma separated list of n placeholders for use in SQL queries.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func (self *PersistentContentState) GetVotesBy(user string, itemIDs []string) ([]VoteDirection, error) {
+	votes := make([]VoteDirection, len(itemIDs))
+	if len(itemIDs) == 0 {
+		return votes, nil
 	}
-	ids := strings.Join(idList, ",")
 
-	rows, err := db.Query(fmt.Sprintf(`
-  SELECT item_id FROM submission_votes WHERE voter = ? AND item_id IN (%s)
-  `, ids), user)
+	args := []any{user}
+	for _, id := range itemIDs {
+		args = append(args, id)
+	}
+	rows, err := self.handle().Query(fmt.Sprintf(`SELECT item_id, direction FROM submission_votes WHERE voter = ? AND item_id IN (%s)`, placeholders(len(itemIDs))), args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get voting state: %w", err)
 	}
+	defer rows.Close()
 
-	voted := make([]bool, len(itemIDs))
-	votedFor := map[string]bool{}
+	votedFor := map[string]VoteDirection{}
 	for rows.Next() {
 		itemID := ""
-		rows.Scan(&itemID)
-		votedFor[itemID] = true
+		direction := NoVote
+		if err := rows.Scan(&itemID, &direction); err != nil {
+			return nil, fmt.Errorf("failed to scan vote: %w", err)
+		}
+		votedFor[itemID] = direction
 	}
 
 	for i, itemID := range itemIDs {
-		voted[i] = votedFor[itemID]
+		votes[i] = votedFor[itemID]
 	}
 
-	return voted, nil
+	return votes, rows.Err()
 }
 
-func (self *PersistentContentState) GetActiveSubscribers() ([]string, error) { return []string{}, nil }
+// GetKarma returns the votes others cast on the submissions and comments of username.
+func (self *PersistentContentState) GetKarma(username string) (int, error) {
+	karma := 0
+	if err := self.handle().QueryRow(`SELECT COALESCE(SUM(v.direction), 0) FROM submission_votes v
+    WHERE v.voter != ? AND (
+      v.item_id IN (SELECT item_id FROM submissions WHERE submitter = ?) OR
+      v.item_id IN (SELECT path FROM comments WHERE author = ?))`, username, username, username).Scan(&karma); err != nil {
+		return 0, fmt.Errorf("failed to get karma of %q: %w", username, err)
+	}
+	return karma, nil
+}
 
 func (self *PersistentContentState) GetSubmissionForComment(commentID TreeID) (*Submission, error) {
-	panic("unimplemented")
+	return self.GetSubmission(commentID.Root())
+}
+
+// GetActiveSubscribers returns the names of all users that have at least one enabled subscription scope.
+func (self *PersistentContentState) GetActiveSubscribers() ([]string, error) {
+	rows, err := self.handle().Query(`SELECT DISTINCT username FROM subscription_scopes WHERE enabled ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active subscribers: %w", err)
+	}
+	defer rows.Close()
+	result := []string{}
+	for rows.Next() {
+		username := ""
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan subscriber: %w", err)
+		}
+		result = append(result, username)
+	}
+	return result, rows.Err()
 }
 
 func (self *PersistentContentState) GetSubscriptionSettings(username string) (*SubscriptionSettings, error) {
-	panic("unimplemented")
+	settings := &SubscriptionSettings{
+		Subscriber:  username,
+		EnabledFor:  []SubscriptionScope{},
+		DisabledFor: []SubscriptionScope{},
+	}
+	err := self.handle().QueryRow(`SELECT last_change_at FROM subscription_settings WHERE username = ?`, username).Scan(&settings.LastChangeAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSubscriptionSettingsNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription settings: %w", err)
+	}
+
+	rows, err := self.handle().Query(`SELECT scope, enabled FROM subscription_scopes WHERE username = ? ORDER BY position`, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscription scopes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			scope   SubscriptionScope
+			enabled bool
+		)
+		if err := rows.Scan(&scope, &enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription scope: %w", err)
+		}
+		if enabled {
+			settings.EnabledFor = append(settings.EnabledFor, scope)
+		} else {
+			settings.DisabledFor = append(settings.DisabledFor, scope)
+		}
+	}
+	return settings, rows.Err()
 }
 
 func (self *PersistentContentState) PutSubscriptionSettings(settings *SubscriptionSettings) error {
-	panic("unimplemented")
+	return self.update(func(tx sqlHandle) error {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO subscription_settings (username, last_change_at) VALUES (?, ?)`,
+			settings.Subscriber, settings.LastChangeAt); err != nil {
+			return fmt.Errorf("failed to store subscription settings: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM subscription_scopes WHERE username = ?`, settings.Subscriber); err != nil {
+			return fmt.Errorf("failed to clear subscription scopes: %w", err)
+		}
+		position := 0
+		for _, scopes := range []struct {
+			enabled bool
+			scopes  []SubscriptionScope
+		}{{true, settings.EnabledFor}, {false, settings.DisabledFor}} {
+			for _, scope := range scopes.scopes {
+				if _, err := tx.Exec(`INSERT OR REPLACE INTO subscription_scopes (username, scope, enabled, position) VALUES (?, ?, ?, ?)`,
+					settings.Subscriber, scope, scopes.enabled, position); err != nil {
+					return fmt.Errorf("failed to store subscription scope: %w", err)
+				}
+				position++
+			}
+		}
+		return nil
+	})
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withEachContentState runs test once for every ContentState implementation.
+func withEachContentState(t *testing.T, test func(t *testing.T, state ContentState)) {
+	states := map[string]func(t *testing.T) ContentState{
+		"memory": func(t *testing.T) ContentState { return NewInMemoryContentState() },
+		"sqlite": func(t *testing.T) ContentState {
+			state := NewPersistentContentState(filepath.Join(t.TempDir(), "content.db"))
+			if err := state.Setup(); err != nil {
+				t.Fatalf("failed to set up content state: %s", err)
+			}
+			t.Cleanup(func() { state.Close() })
+			return state
+		},
+	}
+	for name, newState := range states {
+		t.Run(name, func(t *testing.T) { test(t, newState(t)) })
+	}
+}
+
+func Test_PutComment_InsertsCommentAndBumpsCount(t *testing.T) {
+	withEachContentState(t, func(t *testing.T, state ContentState) {
+		submission := &Submission{
+			ItemID:      "item",
+			Submitter:   "alice",
+			SubmittedAt: time.Now(),
+		}
+		comment := &Comment{
+			ParentID: NewTreeID("item"),
+			Author:   "alice",
+			Content:  "content",
+			PostedAt: time.Now(),
+		}
+		if err := state.PutSubmission(submission); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		if err := state.PutComment(comment); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		nestedComment := &Comment{
+			ParentID: comment.ID(),
+		}
+
+		if err := state.PutComment(nestedComment); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		submission, err := state.GetSubmission("item")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(submission.Comments) != 1 {
+			t.Fatalf("expected 1 child, got %d", len(submission.Comments))
+		}
+
+		if len(submission.Comments[0].Children) != 1 {
+			t.Errorf("expected 1 nested child, got %d", len(submission.Comments[0].Children))
+		}
+
+		if submission.CommentCount != 2 {
+			t.Errorf("expected 2 comments, got %d", submission.CommentCount)
+		}
+	})
+}
+
+func Test_PutComment_FailsForMissingParent(t *testing.T) {
+	withEachContentState(t, func(t *testing.T, state ContentState) {
+		err := state.PutComment(&Comment{ParentID: NewTreeID("missing")})
+		if !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("expected %s, got %v", ErrItemNotFound, err)
+		}
+	})
+}
+
+func Test_UpdateComment_KeepsReplies(t *testing.T) {
+	withEachContentState(t, func(t *testing.T, state ContentState) {
+		if err := state.PutSubmission(&Submission{ItemID: "item", SubmittedAt: time.Now()}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		comment := &Comment{ParentID: NewTreeID("item"), Content: "content"}
+		if err := state.PutComment(comment); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := state.PutComment(&Comment{ParentID: comment.ID(), Content: "reply"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := state.UpdateComment(&Comment{ParentID: NewTreeID("item"), Content: "content", Hidden: true}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		submission, err := state.GetSubmission("item")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		updated := submission.Comment(comment.ID())
+		if !updated.Hidden {
+			t.Errorf("expected comment to be hidden")
+		}
+		if len(updated.Children) != 1 {
+			t.Errorf("expected 1 reply, got %d", len(updated.Children))
+		}
+	})
+}
+
+func Test_GetActiveSubscribers_ReturnsUsersWithEnabledScopes(t *testing.T) {
+	withEachContentState(t, func(t *testing.T, state ContentState) {
+		active := NewDefaultSubscriptionSettings("active", time.Now())
+		active.EnableScope(SUBSCRIPTION_SCOPE_REPLIES)
+		inactive := NewDefaultSubscriptionSettings("inactive", time.Now())
+		for _, settings := range []*SubscriptionSettings{active, inactive} {
+			if err := state.PutSubscriptionSettings(settings); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		subscribers, err := state.GetActiveSubscribers()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(subscribers) != 1 || subscribers[0] != "active" {
+			t.Fatalf("expected only %q to be active, got %v", "active", subscribers)
+		}
+
+		settings, err := state.GetSubscriptionSettings("active")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(settings.EnabledFor) != 1 || settings.EnabledFor[0] != SUBSCRIPTION_SCOPE_REPLIES {
+			t.Fatalf("expected replies to be enabled, got %v", settings.EnabledFor)
+		}
+	})
+}
+
+func Test_GetSubmissionForComment_ReturnsSubmissionForComment(t *testing.T) {
+	submission := &Submission{
+		ItemID:      "item",
+		Submitter:   "alice",
+		SubmittedAt: time.Now(),
+	}
+	comment := &Comment{
+		ParentID: NewTreeID("item"),
+		Index:    0,
+		Author:   "alice",
+		Content:  "content",
+		PostedAt: time.Now(),
+	}
+	submission.Comments = append(submission.Comments, comment)
+
+	c := submission.Comment(NewTreeID("item", "0"))
+	if c == nil {
+		t.Fatalf("expected comment, got nil")
+	}
+}
@@ -3,10 +3,30 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
+var contentBackends = map[string]func(t *testing.T) *url.URL{
+	"memory": func(t *testing.T) *url.URL { return parseURL("memory://", "ContentStore") },
+	"sqlite": func(t *testing.T) *url.URL {
+		return parseURL("file:///"+filepath.Join(t.TempDir(), "content.db"), "ContentStore")
+	},
+}
+
+// withEachContentBackend runs test once for every supported ContentState implementation.
+func withEachContentBackend(t *testing.T, test func(t *testing.T, scenario *TestContext)) {
+	for name, contentStore := range contentBackends {
+		t.Run(name, func(t *testing.T) {
+			config := NewPlatformConfigForTest()
+			config.ContentStore = contentStore(t)
+			test(t, setupWithConfig(t, config))
+		})
+	}
+}
+
 func TestUpvoteFailsIfVoterIsMissing(t *testing.T) {
 	scenario := setup(t)
 	err := scenario.do(scenario.upvote("item-id", ""))
@@ -26,181 +46,233 @@ func TestUpvoteFailsIfVoterAlreadyVoted(t *testing.T) {
 }
 
 func TestFrontpageReturnsRecentSubmissionsFirst(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.postLink("https://news.ycombinator.com", "1"))
-	scenario.must(scenario.postLink("https://err.ee", "2"))
-	scenario.must(scenario.postLink("https://www.kathimerini.gr", "3"))
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://news.ycombinator.com", "1"))
+		scenario.must(scenario.postLink("https://err.ee", "2"))
+		scenario.must(scenario.postLink("https://www.kathimerini.gr", "3"))
 
-	submissions := scenario.frontpage()
+		submissions := scenario.frontpage()
 
-	if submissions[0].Title != "3" {
-		t.Fatalf("expected submission %q, got %#v", "3", submissions[0])
-	}
+		if submissions[0].Title != "3" {
+			t.Fatalf("expected submission %q, got %#v", "3", submissions[0])
+		}
+	})
 }
 
 func TestFrontPageReturns10Submissions(t *testing.T) {
-	scenario := setup(t)
-	for i := range 20 {
-		scenario.must(scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i)))
-	}
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		for i := range 20 {
+			scenario.must(scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i)))
+		}
 
-	submissions := scenario.frontpage()
+		submissions := scenario.frontpage()
 
-	if len(submissions) != 10 {
-		t.Fatalf("expected %d entries for the frontpage, got %d", 10, len(submissions))
-	}
+		if len(submissions) != 10 {
+			t.Fatalf("expected %d entries for the frontpage, got %d", 10, len(submissions))
+		}
+	})
 }
 
 func Test_OnFrontpage_CanVoteIsTrue_IfUserVotedAlready(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.postLink("https://err.ee", "Upvoted"))
-	upvotedId := scenario.PostIDs[0]
-	scenario.must(scenario.upvote(upvotedId, scenario.Viewer))
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "Upvoted"))
+		upvotedId := scenario.PostIDs[0]
+		scenario.must(scenario.upvote(upvotedId, scenario.Viewer))
 
-	scenario.must(scenario.postLink("https://err.ee", "Other"))
+		scenario.must(scenario.postLink("https://err.ee", "Other"))
 
-	submissions := scenario.frontpage()
+		submissions := scenario.frontpage()
 
-	upvoted := mustFind(submissions, "Title", "Upvoted")
-	other := mustFind(submissions, "Title", "Other")
-	if upvoted.ViewerHasVoted == false {
-		t.Fatalf("ViewerHasVoted is false for Upvoted")
-	}
+		upvoted := mustFind(submissions, "Title", "Upvoted")
+		other := mustFind(submissions, "Title", "Other")
+		if upvoted.ViewerHasVoted == false {
+			t.Fatalf("ViewerHasVoted is false for Upvoted")
+		}
 
-	if other.ViewerHasVoted == true {
-		t.Fatalf("ViewerHasVoted is true for Other")
-	}
+		if other.ViewerHasVoted == true {
+			t.Fatalf("ViewerHasVoted is true for Other")
+		}
+	})
 }
 
 func Test_OnFrontpage_VotingMultipleTimes(t *testing.T) {
-	scenario := setup(t)
-	scenario.must(scenario.postLink("https://err.ee", "Upvoted"))
-	upvotedId := scenario.PostIDs[0]
-	scenario.must(scenario.upvote(upvotedId, scenario.Viewer))
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "Upvoted"))
+		upvotedId := scenario.PostIDs[0]
+		scenario.must(scenario.upvote(upvotedId, scenario.Viewer))
 
-	submissions := scenario.frontpage()
+		submissions := scenario.frontpage()
 
-	upvoted := mustFind(submissions, "Title", "Upvoted")
-	if upvoted.VoteCount != 1 {
-		t.Fatalf("VoteCount is %d, expected %d", upvoted.VoteCount, 1)
-	}
+		upvoted := mustFind(submissions, "Title", "Upvoted")
+		if upvoted.VoteCount != 1 {
+			t.Fatalf("VoteCount is %d, expected %d", upvoted.VoteCount, 1)
+		}
+	})
 }
 
 func Test_OnFrontpage_SubmissionsAreSortedByVotes(t *testing.T) {
-	scenario := setup(t)
-	for i := range 10 {
-		scenario.must(scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i)))
-		scenario.upvoteN(scenario.PostIDs[i], 10-i)
-	}
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		for i := range 10 {
+			scenario.must(scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i)))
+			scenario.upvoteN(scenario.PostIDs[i], 10-i)
+		}
 
-	submissions := scenario.frontpage()
-	for i, s := range submissions {
-		t.Logf("%d: %s", i, s.Title)
-	}
-	first := mustFind(submissions, "Title", "0")
-	if first.ItemID != submissions[0].ItemID {
-		t.Fatalf("expected first submission to be %q, got %q", "0", submissions[0].Title)
-	}
+		submissions := scenario.frontpage()
+		for i, s := range submissions {
+			t.Logf("%d: %s", i, s.Title)
+		}
+		first := mustFind(submissions, "Title", "0")
+		if first.ItemID != submissions[0].ItemID {
+			t.Fatalf("expected first submission to be %q, got %q", "0", submissions[0].Title)
+		}
+	})
 }
 
 func Test_OnFrontpage_SubmissionsAreSortedByScores_ThenSubmissionTime(t *testing.T) {
-	scenario := setup(t)
-	for i := range 10 {
-		postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
-		postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
-		scenario.must(postLink)
-	}
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		for i := range 10 {
+			postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
+			postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
+			scenario.must(postLink)
+		}
 
-	// The oldest submission got 5 upvotes, but votes are multiplied by 0.9 every day.
-	// Since ten days have passed, it will have a score of 5 * 0.9**10 = 1.74.
-	//
-	// The submission three days ago gets 3 upvotes, and will have a higher score.
-	//
-	// The submission two days ago got two comments, counting as half an upvote each.
-	scenario.upvoteN(scenario.PostIDs[0], 5)
-	scenario.upvoteN(scenario.PostIDs[10-3], 3)
-	scenario.must(scenario.commentOn(scenario.PostIDs[10-2], "second"))
-	scenario.must(scenario.commentOn(scenario.PostIDs[10-2], "second"))
-
-	submissions := scenario.frontpage()
-	for _, s := range submissions {
-		t.Logf("votes: %d, score: %.2f: %s %s",
-			s.VoteCount,
-			s.Score,
-			s.Title,
-			s.SubmittedAt.Format(time.DateOnly),
-		)
-	}
-	first := submissions[0]
-	second := submissions[1]
-	third := submissions[2]
+		// The oldest submission got 5 upvotes, but votes are multiplied by 0.9 every day.
+		// Since ten days have passed, it will have a score of 5 * 0.9**10 = 1.74.
+		//
+		// The submission three days ago gets 3 upvotes, and will have a higher score.
+		//
+		// The submission two days ago got two comments, counting as half an upvote each.
+		scenario.upvoteN(scenario.PostIDs[0], 5)
+		scenario.upvoteN(scenario.PostIDs[10-3], 3)
+		scenario.must(scenario.commentOn(scenario.PostIDs[10-2], "second"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[10-2], "second"))
+
+		submissions := scenario.frontpage()
+		for _, s := range submissions {
+			t.Logf("votes: %d, score: %.2f: %s %s",
+				s.VoteCount,
+				s.Score,
+				s.Title,
+				s.SubmittedAt.Format(time.DateOnly),
+			)
+		}
+		first := submissions[0]
+		second := submissions[1]
+		third := submissions[2]
 
-	if f, s := first.Score, second.Score; f < s {
-		t.Fatalf("Expected first score to be higher than second, got %.2f < %.2f", f, s)
-	}
+		if f, s := first.Score, second.Score; f < s {
+			t.Fatalf("Expected first score to be higher than second, got %.2f < %.2f", f, s)
+		}
 
-	if s, th := second.Score, third.Score; s < th {
-		t.Fatalf("Expected second score to be higher than third, got %.2f < %.2f", s, th)
-	}
+		if s, th := second.Score, third.Score; s < th {
+			t.Fatalf("Expected second score to be higher than third, got %.2f < %.2f", s, th)
+		}
 
-	if act, exp := third.Score, float32(2*0.5)*0.9; exp-act > 0.02 {
-		t.Fatalf("Expected third score to be within %.2f of %.2f, got %.2f", 0.02, exp, act)
-	}
+		if act, exp := third.Score, float32(2*0.5)*0.9; exp-act > 0.02 {
+			t.Fatalf("Expected third score to be within %.2f of %.2f, got %.2f", 0.02, exp, act)
+		}
 
-	for i := 3; i < 10-1; i += 2 {
-		if a, b := submissions[i].SubmittedAt, submissions[i+1].SubmittedAt; b.After(a) {
-			t.Fatalf("submission %d: Expected %s to be before %s",
-				i,
-				b.Format(time.DateOnly),
-				a.Format(time.DateOnly))
+		for i := 3; i < 10-1; i += 2 {
+			if a, b := submissions[i].SubmittedAt, submissions[i+1].SubmittedAt; b.After(a) {
+				t.Fatalf("submission %d: Expected %s to be before %s",
+					i,
+					b.Format(time.DateOnly),
+					a.Format(time.DateOnly))
+			}
 		}
-	}
+	})
 }
 
 func Test_OnFrontpage_SubmissionsCanBePaged_WithAfter(t *testing.T) {
-	scenario := setup(t)
-	for i := range 10 {
-		postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
-		postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
-		scenario.must(postLink)
-	}
-	submissions := scenario.frontpageAfter(0)
-	if act, exp := len(submissions), 10; act != exp {
-		t.Fatalf("expected %d submissions, got %d", exp, act)
-	}
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		for i := range 10 {
+			postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
+			postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
+			scenario.must(postLink)
+		}
+		submissions := scenario.frontpageAfter(0)
+		if act, exp := len(submissions), 10; act != exp {
+			t.Fatalf("expected %d submissions, got %d", exp, act)
+		}
 
-	submissions = scenario.frontpageAfter(5)
-	if act, exp := len(submissions), 5; act != exp {
-		t.Fatalf("expected %d submissions, got %d", exp, act)
-	}
+		submissions = scenario.frontpageAfter(5)
+		if act, exp := len(submissions), 5; act != exp {
+			t.Fatalf("expected %d submissions, got %d", exp, act)
+		}
 
-	submissions = scenario.frontpageAfter(11)
-	if act, exp := len(submissions), 0; act != exp {
-		t.Fatalf("expected %d submissions, got %d", exp, act)
-	}
+		submissions = scenario.frontpageAfter(11)
+		if act, exp := len(submissions), 0; act != exp {
+			t.Fatalf("expected %d submissions, got %d", exp, act)
+		}
+	})
 }
 
 func Test_OnFrontpage_SubmissionsCanBeHidden(t *testing.T) {
-	scenario := setup(t)
-	for i := range 10 {
-		postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
-		postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
-		scenario.must(postLink)
-	}
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		for i := range 10 {
+			postLink := scenario.postLink("https://news.ycombinator.com", fmt.Sprintf("%d", i))
+			postLink.SubmittedAt = postLink.SubmittedAt.Add(-time.Duration(10-i) * 24 * time.Hour)
+			scenario.must(postLink)
+		}
 
-	scenario.must(scenario.hideSubmission(scenario.PostIDs[0]))
-	submissions := scenario.frontpageAfter(0)
-	hiddenSubmission := mustFind(submissions, "ItemID", scenario.PostIDs[0])
+		scenario.must(scenario.hideSubmission(scenario.PostIDs[0]))
+		submissions := scenario.frontpageAfter(0)
+		hiddenSubmission := mustFind(submissions, "ItemID", scenario.PostIDs[0])
 
-	if act, exp := hiddenSubmission.Hidden, true; act != exp {
-		t.Fatalf("expected submission to be hidden, got %v", act)
-	}
+		if act, exp := hiddenSubmission.Hidden, true; act != exp {
+			t.Fatalf("expected submission to be hidden, got %v", act)
+		}
 
-	scenario.must(scenario.unhideSubmission(scenario.PostIDs[0]))
-	submissions = scenario.frontpageAfter(0)
-	unhiddenSubmission := mustFind(submissions, "ItemID", scenario.PostIDs[0])
+		scenario.must(scenario.unhideSubmission(scenario.PostIDs[0]))
+		submissions = scenario.frontpageAfter(0)
+		unhiddenSubmission := mustFind(submissions, "ItemID", scenario.PostIDs[0])
 
-	if act, exp := unhiddenSubmission.Hidden, false; act != exp {
-		t.Fatalf("expected submission to be hidden, got %v", act)
+		if act, exp := unhiddenSubmission.Hidden, false; act != exp {
+			t.Fatalf("expected submission to be hidden, got %v", act)
+		}
+	})
+}
+
+func Test_PersistentContentState_ResumesReplay_AfterLastAppliedCommand(t *testing.T) {
+	config := NewPlatformConfigForTest()
+	config.ContentStore = contentBackends["sqlite"](t)
+	scenario := setupWithConfig(t, config)
+	scenario.must(scenario.postLink("https://example.com", "Persisted"))
+	scenario.must(scenario.commentOn(scenario.PostIDs[0], "comment"))
+	scenario.must(scenario.upvote(scenario.PostIDs[0], scenario.Viewer))
+
+	restarted := setupWithConfig(t, config)
+	restarted.App.Commands = scenario.App.Commands
+	if err := restarted.App.Replay(false); err != nil {
+		t.Fatalf("expected replay to skip applied commands, got %s", err)
+	}
+	if act, exp := restarted.App.version, 3; act != exp {
+		t.Fatalf("expected version %d, got %d", exp, act)
+	}
+	persisted := mustFind(restarted.frontpage(), "Title", "Persisted")
+	if act, exp := persisted.VoteCount, 1; act != exp {
+		t.Fatalf("expected %d votes, got %d", exp, act)
+	}
+	if act, exp := persisted.CommentCount, 1; act != exp {
+		t.Fatalf("expected %d comments, got %d", exp, act)
 	}
 }
+
+func Test_PostLink_RejectsExistingItemID(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "original"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "first comment"))
+
+		repost := scenario.postLink("https://example.com", "repost")
+		repost.ItemID = scenario.PostIDs[0]
+		scenario.mustFailWith(repost, ErrItemExists)
+		text := scenario.postText("repost", "as text")
+		text.ItemID = scenario.PostIDs[0]
+		scenario.mustFailWith(text, ErrItemExists)
+
+		submission := scenario.findSubmission(scenario.PostIDs[0])
+		if submission.Title != "original" || len(submission.Comments) != 1 {
+			t.Fatalf("expected original submission with its comment, got %q with %d comments", submission.Title, len(submission.Comments))
+		}
+	})
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"time"
 )
 
@@ -17,15 +16,16 @@ func init() {
 	DefaultCommandRegistry.Register("UnhideComment", func() Command { return new(UnhideComment) })
 }
 
+func (self *Content) validateUnhideComment(cmd *UnhideComment) error {
+	_, err := self.findComment(cmd.CommentID)
+	return err
+}
+
 func (self *Content) handleUnhideComment(cmd *UnhideComment) error {
-	submission, err := self.state.GetSubmissionForComment(cmd.CommentID)
+	comment, err := self.findComment(cmd.CommentID)
 	if err != nil {
-		return fmt.Errorf("failed to get submission for comment %q: %w", cmd.CommentID, err)
-	}
-	comment := submission.Comment(cmd.CommentID)
-	if comment == nil {
-		return ErrItemNotFound
+		return err
 	}
 	comment.Hidden = false
-	return nil
+	return self.state.UpdateComment(comment)
 }
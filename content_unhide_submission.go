@@ -18,6 +18,17 @@ func init() {
 	DefaultCommandRegistry.Register("UnhideSubmission", func() Command { return new(UnhideSubmission) })
 }
 
+func (self *Content) validateUnhideSubmission(cmd *UnhideSubmission) error {
+	_, err := self.state.GetSubmission(cmd.ItemID)
+	if errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get submission %q: %w", cmd.ItemID, err)
+	}
+	return nil
+}
+
 func (self *Content) handleUnhideSubmission(cmd *UnhideSubmission) error {
 	submission, err := self.state.GetSubmission(cmd.ItemID)
 	if errors.Is(err, ErrItemNotFound) {
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+)
+
+type UpvoteComment struct {
+	CommentID TreeID
+	Voter     string
+	VotedAt   time.Time
+}
+
+func (cmd *UpvoteComment) CommandName() string { return "UpvoteComment" }
+
+func init() {
+	DefaultCommandRegistry.Register("UpvoteComment", func() Command { return &UpvoteComment{} })
+}
+
+func (self *Content) validateUpvoteComment(cmd *UpvoteComment) error {
+	if _, err := self.findComment(cmd.CommentID); err != nil {
+		return err
+	}
+	return self.canVote(cmd.Voter, cmd.CommentID.String(), Upvote)
+}
+
+func (self *Content) handleUpvoteComment(cmd *UpvoteComment) error {
+	if err := self.validateUpvoteComment(cmd); err != nil {
+		return err
+	}
+
+	return self.state.RecordVote(&Vote{
+		For:       cmd.CommentID.String(),
+		By:        cmd.Voter,
+		Direction: Upvote,
+		At:        cmd.VotedAt,
+	})
+}
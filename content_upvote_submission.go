@@ -23,25 +23,19 @@ func init() {
 	DefaultCommandRegistry.Register("UpvoteSubmission", func() Command { return &UpvoteSubmission{} })
 }
 
-func (self *Content) handleUpvoteSubmission(cmd *UpvoteSubmission) error {
-	if cmd.ItemID == "" {
-		return ErrMissingItemID
-	}
-	if cmd.Voter == "" {
-		return ErrMissingVoter
-	}
+func (self *Content) validateUpvoteSubmission(cmd *UpvoteSubmission) error {
+	return self.canVote(cmd.Voter, cmd.ItemID, Upvote)
+}
 
-	votes, err := self.state.HasVotedFor(cmd.Voter, []string{cmd.ItemID})
-	if err != nil {
+func (self *Content) handleUpvoteSubmission(cmd *UpvoteSubmission) error {
+	if err := self.validateUpvoteSubmission(cmd); err != nil {
 		return err
 	}
-	if len(votes) > 0 && votes[0] {
-		return ErrAlreadyVoted
-	}
 
 	return self.state.RecordVote(&Vote{
-		For: cmd.ItemID,
-		By:  cmd.Voter,
-		At:  cmd.VotedAt,
+		For:       cmd.ItemID,
+		By:        cmd.Voter,
+		Direction: Upvote,
+		At:        cmd.VotedAt,
 	})
 }
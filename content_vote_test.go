@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func (t *TestContext) upvoteComment(id TreeID, as string) Command {
+	return &UpvoteComment{CommentID: id, Voter: as, VotedAt: time.Now()}
+}
+
+func (t *TestContext) downvoteComment(id TreeID, as string) Command {
+	return &DownvoteComment{CommentID: id, Voter: as, VotedAt: time.Now()}
+}
+
+func (t *TestContext) retractVote(itemID, as string) Command {
+	return &RetractVote{ItemID: itemID, Voter: as, RetractedAt: time.Now()}
+}
+
+func Test_RetractVote_RemovesVoteFromSubmission(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		itemID := scenario.PostIDs[0]
+		scenario.mustFailWith(scenario.retractVote(itemID, scenario.Viewer), ErrNotVoted)
+		scenario.must(scenario.upvote(itemID, scenario.Viewer))
+		scenario.must(scenario.retractVote(itemID, scenario.Viewer))
+
+		submission := scenario.frontpage()[0]
+		if submission.VoteCount != 0 || submission.ViewerHasVoted {
+			t.Fatalf("expected vote to be retracted, got %#v", submission)
+		}
+		scenario.must(scenario.upvote(itemID, scenario.Viewer))
+	})
+}
+
+func Test_UpvoteComment_CountsVotesPerComment(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "first"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "second"))
+		second := NewTreeID(scenario.PostIDs[0]).And(1)
+		scenario.must(scenario.upvoteComment(second, "alice"))
+		scenario.must(scenario.upvoteComment(second, "bob"))
+		scenario.mustFailWith(scenario.upvoteComment(second, "bob"), ErrAlreadyVoted)
+		scenario.mustFailWith(scenario.upvoteComment(NewTreeID(scenario.PostIDs[0]).And(7), "bob"), ErrItemNotFound)
+
+		submission := scenario.findSubmission(scenario.PostIDs[0])
+		if votes := submission.Comment(second).VoteCount; votes != 2 {
+			t.Fatalf("expected 2 votes on comment, got %d", votes)
+		}
+		if votes := submission.VoteCount; votes != 0 {
+			t.Fatalf("expected comment votes not to count for submission, got %d", votes)
+		}
+		ranked := rankComments(submission.Comments)
+		if ranked[0].Content != "second" || submission.Comments[0].Content != "first" {
+			t.Fatalf("expected upvoted comment to be ranked first without reordering the submission")
+		}
+	})
+}
+
+func Test_Downvote_IsDisabledByDefault(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://err.ee", "link"))
+	scenario.mustFailWith(&DownvoteSubmission{ItemID: scenario.PostIDs[0], Voter: scenario.Viewer, VotedAt: time.Now()}, ErrDownvotesDisabled)
+}
+
+func Test_Downvote_RequiresKarma(t *testing.T) {
+	for name, contentStore := range contentBackends {
+		t.Run(name, func(t *testing.T) {
+			config := NewPlatformConfigForTest()
+			config.ContentStore = contentStore(t)
+			config.DownvoteKarma = 1
+			scenario := setupWithConfig(t, config)
+
+			scenario.must(scenario.postLink("https://err.ee", "link"))
+			scenario.must(scenario.commentOn(scenario.PostIDs[0], "viewer's comment"))
+			comment := NewTreeID(scenario.PostIDs[0]).And(0)
+			downvote := &DownvoteSubmission{ItemID: scenario.PostIDs[0], Voter: scenario.Viewer, VotedAt: time.Now()}
+
+			scenario.must(scenario.upvoteComment(comment, scenario.Viewer))
+			scenario.mustFailWith(downvote, ErrNotEnoughKarma)
+
+			scenario.must(scenario.upvoteComment(comment, "alice"))
+			scenario.must(downvote)
+			if votes := scenario.frontpage()[0].VoteCount; votes != -1 {
+				t.Fatalf("expected downvote to count, got %d votes", votes)
+			}
+
+			scenario.must(scenario.upvote(scenario.PostIDs[0], scenario.Viewer))
+			if votes := scenario.frontpage()[0].VoteCount; votes != 1 {
+				t.Fatalf("expected upvote to replace downvote, got %d votes", votes)
+			}
+
+			scenario.must(scenario.downvoteComment(comment, scenario.Submitter))
+			karma := NewGetKarma(scenario.Viewer)
+			if err := scenario.App.HandleQuery(karma); err != nil {
+				t.Fatal(err)
+			}
+			if karma.Karma != 0 || karma.CanDownvote {
+				t.Fatalf("expected downvote to lower karma, got %#v", karma)
+			}
+		})
+	}
+}
+
+func Test_FindSubmission_MarksVotesOfEachViewerSeparately(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		scenario.must(scenario.postLink("https://err.ee", "link"))
+		scenario.must(scenario.commentOn(scenario.PostIDs[0], "comment"))
+		itemID, comment := scenario.PostIDs[0], NewTreeID(scenario.PostIDs[0]).And(0)
+		scenario.must(scenario.upvote(itemID, "alice"))
+		scenario.must(scenario.upvoteComment(comment, "alice"))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			viewer, expected := "bob", NoVote
+			if i%2 == 0 {
+				viewer, expected = "alice", Upvote
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					q := &FindSubmission{ItemID: itemID, Viewer: &viewer, At: time.Now()}
+					if err := scenario.App.HandleQuery(q); err != nil {
+						t.Errorf("failed to find submission: %s", err)
+						return
+					}
+					if q.Submission.ViewerVote != expected || q.Submission.Comment(comment).ViewerVote != expected {
+						t.Errorf("expected %s to see vote %d, got %d and %d", viewer, expected,
+							q.Submission.ViewerVote, q.Submission.Comment(comment).ViewerVote)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,9 @@ type Email struct {
 	Retries int
 	Status  string
 	Message string
+
+	// QueuedBy is the QueueEmail command that put the email into the outbox.
+	QueuedBy *PersistedCommand
 }
 
 type EmailSender interface {
@@ -28,7 +33,7 @@ type EmailReceipt interface {
 }
 
 type EmailLogger struct {
-	Logger *log.Logger
+	Logger *slog.Logger
 }
 
 func (logger *EmailLogger) SendEmail(email *Email) (EmailReceipt, error) {
@@ -37,7 +42,7 @@ func (logger *EmailLogger) SendEmail(email *Email) (EmailReceipt, error) {
 		label = email.TemplateName
 	}
 	templateData, _ := json.Marshal(email.TemplateData)
-	logger.Logger.Printf("sending email to %s: %s %s", email.Recipient, label, string(templateData))
+	logger.Logger.Info("sending email", "email_id", email.InternalID, "recipient", email.Recipient, "subject", label, "template_data", string(templateData))
 	return nil, nil
 }
 
@@ -75,15 +80,76 @@ const StatusDelivered = "delivered"
 const StatusFailed = "failed"
 const StatusQueued = "queued"
 
+func init() {
+	newRule := func(now time.Time) CompactionRule {
+		return &deliveredEmailRule{
+			before:    now.Add(-EmailRequestLifetime),
+			queued:    map[string]*PersistedCommand{},
+			delivered: map[string]bool{},
+		}
+	}
+	DefaultCompactionRules.Register("QueueEmail", newRule)
+	DefaultCompactionRules.Register("SetEmailDeliveryStatus", newRule)
+}
+
+// EmailRequestLifetime is how long magic links and password resets can
+// be requested before they expire.
+//
+// Emails sent in response to these requests are needed to prevent sending
+// them again while the request is still pending.
+const EmailRequestLifetime = time.Hour
+
+// deliveredEmailRule drops delivered emails, and their delivery status,
+// once nothing depends on their existence anymore.
+//
+// Content notifications are always kept, because the notifier uses them
+// to remember which notifications it has already sent.
+type deliveredEmailRule struct {
+	before    time.Time
+	queued    map[string]*PersistedCommand
+	delivered map[string]bool
+}
+
+func (r *deliveredEmailRule) Observe(command *PersistedCommand) {
+	switch cmd := command.Message.(type) {
+	case *QueueEmail:
+		r.queued[cmd.InternalID] = command
+	case *SetEmailDeliveryStatus:
+		r.delivered[cmd.InternalID] = cmd.Status == StatusDelivered
+	}
+}
+
+func (r *deliveredEmailRule) Keep(command *PersistedCommand) bool {
+	internalID := ""
+	switch cmd := command.Message.(type) {
+	case *QueueEmail:
+		internalID = cmd.InternalID
+	case *SetEmailDeliveryStatus:
+		internalID = cmd.InternalID
+	}
+	queued, found := r.queued[internalID]
+	if !found || !r.delivered[internalID] {
+		return true
+	}
+	if queued.Message.(*QueueEmail).TemplateName == "content-notification" {
+		return true
+	}
+	return queued.Metadata != nil && queued.Metadata.RecordedAt.After(r.before)
+}
+
 type Mailer struct {
 	Sender  EmailSender
 	Outbox  map[string]map[string]*Email
-	Logger  *log.Logger
+	Logger  *slog.Logger
 	App     *App
 	Version int
+
+	// handling is the command currently being handled by catchUp.
+	handling *PersistedCommand
+	progress Progress
 }
 
-func NewMailer(sender EmailSender, logger *log.Logger, app *App) *Mailer {
+func NewMailer(sender EmailSender, logger *slog.Logger, app *App) *Mailer {
 	return &Mailer{
 		Sender: sender,
 		Outbox: map[string]map[string]*Email{
@@ -96,6 +162,39 @@ func NewMailer(sender EmailSender, logger *log.Logger, app *App) *Mailer {
 	}
 }
 
+// Ownership declares that emails are queued for and delivered by the mailer alone.
+func (self *Mailer) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "mailer",
+		Commands: []string{"QueueEmail", "SetEmailDeliveryStatus"},
+	}
+}
+
+func (self *Mailer) Progress() *Progress { return &self.progress }
+
+// Snapshot serializes the work left to the mailer: the emails it still
+// has to send and those it gave up on, ordered by their internal ID.
+//
+// Delivered emails need no more work and are left out, like the commands
+// which queued the emails.
+func (self *Mailer) Snapshot() ([]byte, error) {
+	pending := map[string][]*Email{}
+	for _, status := range []string{StatusQueued, StatusFailed} {
+		pending[status] = []*Email{}
+		for _, email := range self.Outbox[status] {
+			withoutCommand := *email
+			withoutCommand.QueuedBy = nil
+			pending[status] = append(pending[status], &withoutCommand)
+		}
+		slices.SortFunc(pending[status], func(a, b *Email) int { return strings.Compare(a.InternalID, b.InternalID) })
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize outbox: %w", err)
+	}
+	return data, nil
+}
+
 func (self *Mailer) HandleCommand(cmd Command) error {
 	switch cmd := cmd.(type) {
 	case *QueueEmail:
@@ -114,6 +213,7 @@ func (self *Mailer) addToOutbox(cmd *QueueEmail) error {
 		Subject:      cmd.Subject,
 		TemplateName: cmd.TemplateName,
 		TemplateData: cmd.TemplateData,
+		QueuedBy:     self.handling,
 	}
 	byStatus := self.Outbox[StatusQueued]
 	byStatus[email.InternalID] = email
@@ -143,26 +243,27 @@ func (self *Mailer) removeFromOutbox(cmd *SetEmailDeliveryStatus) error {
 	return nil
 }
 func (self *Mailer) Start() func() {
-	stop := make(chan struct{})
+	wake, stopWaiting := self.App.WaitForCommands()
 	self.catchUp()
 	self.logOutbox()
-	go self.loop(stop)
-	return func() { stop <- struct{}{} }
+	stopLoop := startLoop(func(stop <-chan struct{}) { self.loop(stop, wake) })
+	return func() { stopWaiting(); stopLoop() }
 }
 
 func (self *Mailer) logOutbox() {
 	for status, messages := range self.Outbox {
-		self.Logger.Printf("%s: %d", status, len(messages))
+		self.Logger.Info("outbox", "status", status, "count", len(messages))
 	}
 }
-func (self *Mailer) loop(stop <-chan struct{}) {
-	everySecond := time.NewTicker(time.Second)
-	defer everySecond.Stop()
+func (self *Mailer) loop(stop <-chan struct{}, wake <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
+			// Emails queued right before shutting down are sent before exiting.
+			self.catchUp()
+			self.sendEmails()
 			return
-		case <-everySecond.C:
+		case <-wake:
 			self.catchUp()
 			self.sendEmails()
 		}
@@ -172,59 +273,63 @@ func (self *Mailer) loop(stop <-chan struct{}) {
 func (self *Mailer) catchUp() {
 	commands, err := self.App.Commands.After(self.Version)
 	if err != nil {
-		self.Logger.Printf("failed to fetch commands: %v", err)
+		self.Logger.Error("failed to fetch commands", "version", self.Version, "error", err)
 		return
 	}
 	for command := range commands {
+		self.handling = command
 		self.HandleCommand(command.Message)
 		self.Version = command.ID
 	}
+	self.handling = nil
+	self.progress.Record(self.Version, time.Now())
 	if len(self.Outbox[StatusQueued]) > 0 {
-		self.Logger.Printf("new messages in outbox")
+		self.Logger.Info("new messages in outbox")
 		self.logOutbox()
 	}
 }
 
-func (self *Mailer) deliver(id string, receipt EmailReceipt) {
+func (self *Mailer) deliver(email *Email, receipt EmailReceipt) {
 	cmd := &SetEmailDeliveryStatus{
-		InternalID: id,
+		InternalID: email.InternalID,
 		Status:     StatusDelivered,
 	}
 	if receipt != nil {
 		cmd.Message = receipt.ExternalMessageID()
 	}
-	if err := self.App.HandleCommand(cmd); err != nil {
-		self.Logger.Printf("failed to set email status: %v", err)
+	self.App.Metrics.Count("orange_emails_sent_total", "Emails sent.")
+	if err := self.App.HandleCommandWithMetadata(cmd, CausedBy(email.QueuedBy, "mailer")); err != nil {
+		self.Logger.Error("failed to set email status", "email_id", email.InternalID, "status", StatusDelivered, "error", err)
 		return
 	}
 }
 
-func (self *Mailer) fail(id string, err error) {
-	if err := self.App.HandleCommand(&SetEmailDeliveryStatus{
-		InternalID: id,
+func (self *Mailer) fail(email *Email, err error) {
+	self.App.Metrics.Count("orange_emails_failed_total", "Emails that could not be sent after retrying.")
+	if err := self.App.HandleCommandWithMetadata(&SetEmailDeliveryStatus{
+		InternalID: email.InternalID,
 		Status:     StatusFailed,
 		Message:    err.Error(),
-	}); err != nil {
-		self.Logger.Printf("failed to set email status: %v", err)
+	}, CausedBy(email.QueuedBy, "mailer")); err != nil {
+		self.Logger.Error("failed to set email status", "email_id", email.InternalID, "status", StatusFailed, "error", err)
 		return
 	}
-	self.Logger.Printf("email %s failed: %v", id, err)
+	self.Logger.Error("email failed", "email_id", email.InternalID, "error", err)
 }
 
 func (self *Mailer) sendEmails() {
 	for _, email := range self.Outbox[StatusQueued] {
 		if receipt, err := self.Sender.SendEmail(email); err != nil {
-			self.Logger.Printf("failed to send email: %v", err)
+			self.Logger.Warn("failed to send email", "email_id", email.InternalID, "retries_left", 3-email.Retries, "error", err)
 			if email.Retries < 3 {
-				self.Logger.Printf("email %s has %d retries left", email.InternalID, 3-email.Retries)
 				email.Retries++
 				continue
 			} else {
-				self.fail(email.InternalID, fmt.Errorf("retries exhausted: %w", err))
+				self.fail(email, fmt.Errorf("retries exhausted: %w", err))
 				continue
 			}
 		} else {
-			self.deliver(email.InternalID, receipt)
+			self.deliver(email, receipt)
 		}
 	}
 }
@@ -5,19 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
 type PostmarkEmailSender struct {
 	From   string
-	Logger *log.Logger
+	Logger *slog.Logger
 	APIKey string
 	Client *http.Client
 }
 
-func NewPostmarkEmailSender(logger *log.Logger, params Parameters) *PostmarkEmailSender {
+func NewPostmarkEmailSender(logger *slog.Logger, params Parameters) *PostmarkEmailSender {
 	return &PostmarkEmailSender{
 		From:   params.Get("from"),
 		Logger: logger,
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// StuckAfter is how long a background process may go without checking
+// the command log while it has commands left to process, before it is
+// reported as stuck.
+var StuckAfter = time.Minute
+
+// Progress tracks how far a background process has consumed the command log.
+//
+// It is safe for concurrent use, so that it can be reported while the process is running.
+type Progress struct {
+	version   atomic.Int64
+	checkedAt atomic.Int64
+}
+
+// Record notes that all commands up to version were processed at the given time.
+func (p *Progress) Record(version int, at time.Time) {
+	p.version.Store(int64(version))
+	p.checkedAt.Store(at.UnixNano())
+}
+
+// Version returns the ID of the last command processed.
+func (p *Progress) Version() int { return int(p.version.Load()) }
+
+// CheckedAt returns when the process last checked for new commands, or the zero time.
+func (p *Progress) CheckedAt() time.Time {
+	if at := p.checkedAt.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
+// ProgressReporter is implemented by background processes that report their Progress.
+type ProgressReporter interface {
+	Progress() *Progress
+}
+
+// HealthReport describes whether the app has replayed the log and how far
+// behind the background processes are.
+type HealthReport struct {
+	Replayed    bool             `json:"replayed"`
+	ReplayError string           `json:"replay_error,omitempty"`
+	Version     int              `json:"version"`
+	Length      int              `json:"length"`
+	Lag         int              `json:"lag"`
+	Starters    []*StarterHealth `json:"starters"`
+}
+
+// StarterHealth describes the progress of a background process.
+type StarterHealth struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Lag       int       `json:"lag"`
+	CheckedAt time.Time `json:"checked_at"`
+	Stuck     bool      `json:"stuck"`
+}
+
+// Ready reports whether the app has replayed the log, so that it can serve requests.
+func (r *HealthReport) Ready() bool {
+	return r.Replayed && r.ReplayError == ""
+}
+
+// Healthy reports whether no background process is stuck.
+func (r *HealthReport) Healthy() bool {
+	for _, starter := range r.Starters {
+		if starter.Stuck {
+			return false
+		}
+	}
+	return true
+}
+
+// Health reports the replay status of the app and the progress of starters at the time now.
+func (app *App) Health(starters []Starter, now time.Time) (*HealthReport, error) {
+	length, err := app.Commands.Length()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine length of command log: %w", err)
+	}
+
+	app.lock.RLock()
+	report := &HealthReport{
+		Replayed: app.replayed,
+		Version:  app.version,
+		Length:   length,
+		Lag:      max(length-app.version, 0),
+		Starters: []*StarterHealth{},
+	}
+	if app.replayError != nil {
+		report.ReplayError = app.replayError.Error()
+	}
+	app.lock.RUnlock()
+
+	for _, starter := range starters {
+		reporter, ok := starter.(ProgressReporter)
+		if !ok {
+			continue
+		}
+		progress := reporter.Progress()
+		health := &StarterHealth{
+			Name:      starterName(starter),
+			Version:   progress.Version(),
+			Lag:       max(length-progress.Version(), 0),
+			CheckedAt: progress.CheckedAt(),
+		}
+		health.Stuck = health.Lag > 0 && now.Sub(health.CheckedAt) > StuckAfter
+		report.Starters = append(report.Starters, health)
+	}
+	return report, nil
+}
+
+// starterName returns the name of the module starter declares, or its type.
+func starterName(starter Starter) string {
+	if owner, ok := starter.(Owner); ok {
+		return owner.Ownership().Module
+	}
+	return fmt.Sprintf("%T", starter)
+}
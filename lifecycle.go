@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// ErrStopTimeout is returned for parts of a Lifecycle that did not stop in time.
+var ErrStopTimeout = errors.New("did not stop in time")
+
+// Lifecycle starts the long-running parts of a process, like servers and
+// starters, and stops them again in reverse order.
+//
+// Run blocks until the process receives SIGINT or SIGTERM, or one of the
+// servers fails.  Every part is given StopTimeout to stop: HTTP servers
+// finish the requests they are serving, starters finish what they are
+// doing, e.g. the mailer sends the emails that were queued.
+type Lifecycle struct {
+	Logger      *slog.Logger
+	StopTimeout time.Duration
+
+	parts  []*lifecyclePart
+	failed chan error
+}
+
+// lifecyclePart is a part of a Lifecycle that is running.
+type lifecyclePart struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+func NewLifecycle(logger *slog.Logger) *Lifecycle {
+	return &Lifecycle{
+		Logger:      logger,
+		StopTimeout: 10 * time.Second,
+		failed:      make(chan error, 1),
+	}
+}
+
+// Start starts starter and stops it on shutdown.
+func (l *Lifecycle) Start(name string, starter Starter) {
+	stop := starter.Start()
+	l.add(name, func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			stop()
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ErrStopTimeout
+		}
+	})
+}
+
+// Serve serves HTTP requests accepted by listener until shutdown, which
+// waits for the requests in flight.
+func (l *Lifecycle) Serve(name string, server *http.Server, listener net.Listener) {
+	l.Go(name, func() error {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		err := server.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			server.Close()
+			return ErrStopTimeout
+		}
+		return err
+	})
+}
+
+// Go calls run in a new goroutine and stop on shutdown.  If run returns
+// an error before that, the whole lifecycle shuts down.
+func (l *Lifecycle) Go(name string, run func() error, stop func(ctx context.Context) error) {
+	go func() {
+		if err := run(); err != nil {
+			select {
+			case l.failed <- fmt.Errorf("%s: %w", name, err):
+			default:
+			}
+		}
+	}()
+	l.add(name, stop)
+}
+
+func (l *Lifecycle) add(name string, stop func(ctx context.Context) error) {
+	l.parts = append(l.parts, &lifecyclePart{name: name, stop: stop})
+	l.Logger.Info("started", "part", name)
+}
+
+// Run blocks until ctx is done, the process is asked to terminate, or a
+// part fails, and then stops all parts.
+//
+// It returns the error of the failed part, if any, joined with the errors of stopping.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	ctx, stopNotifying := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopNotifying()
+
+	var failure error
+	select {
+	case <-ctx.Done():
+		l.Logger.Info("shutting down")
+	case failure = <-l.failed:
+		l.Logger.Error("shutting down", "error", failure)
+	}
+	return errors.Join(failure, l.Stop())
+}
+
+// Stop stops all parts in the reverse order they were started in.
+func (l *Lifecycle) Stop() error {
+	errs := []error{}
+	for _, part := range slices.Backward(l.parts) {
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), l.StopTimeout)
+		err := part.stop(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", part.name, err))
+			continue
+		}
+		l.Logger.Info("stopped", "part", part.name, "duration", time.Since(started))
+	}
+	l.parts = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestLifecycle(t *testing.T) *Lifecycle {
+	t.Helper()
+	lifecycle := NewLifecycle(discardLogger())
+	lifecycle.StopTimeout = time.Second
+	return lifecycle
+}
+
+func listenOnLoopback(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	return listener
+}
+
+func Test_Lifecycle_FinishesRequestsInFlight(t *testing.T) {
+	lifecycle := newTestLifecycle(t)
+	handling, release := make(chan struct{}), make(chan struct{})
+	listener := listenOnLoopback(t)
+	lifecycle.Serve("web", &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(handling)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})}, listener)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Errorf("request failed: %s", err)
+		}
+		responses <- res
+	}()
+	<-handling
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- lifecycle.Stop() }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("expected shutdown to wait for the request, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-stopped; err != nil {
+		t.Fatalf("failed to stop: %s", err)
+	}
+	if res := <-responses; res == nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected request to be finished, got %v", res)
+	}
+}
+
+type stuckStarter struct{ release chan struct{} }
+
+func (s *stuckStarter) Start() func() { return func() { <-s.release } }
+
+func Test_Lifecycle_ReportsStartersThatDoNotStopInTime(t *testing.T) {
+	lifecycle := newTestLifecycle(t)
+	lifecycle.StopTimeout = 10 * time.Millisecond
+	starter := &stuckStarter{release: make(chan struct{})}
+	defer close(starter.release)
+	lifecycle.Start("stuck", starter)
+
+	if err := lifecycle.Stop(); !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("expected %s, got %v", ErrStopTimeout, err)
+	}
+}
+
+func Test_Lifecycle_SendsQueuedEmailsBeforeExiting(t *testing.T) {
+	defer func(address string) { ReplAddress = address }(ReplAddress)
+	ReplAddress = "127.0.0.1:0"
+
+	config := NewPlatformConfigForTest()
+	app, starters := HackerNews(config)
+	shell := NewDefaultShell(app)
+	lifecycle := newTestLifecycle(t)
+	listener := listenOnLoopback(t)
+	if err := startServing(lifecycle, app, shell, config, starters, NewWebApp(app, shell), listener); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected web server to be running, got %v (%v)", res, err)
+	}
+	if err := app.HandleCommand(&QueueEmail{InternalID: "shutdown", Recipients: "user@example.com", TemplateName: "test"}); err != nil {
+		t.Fatalf("failed to queue email: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lifecycle.Run(ctx); err != nil {
+		t.Fatalf("failed to shut down: %s", err)
+	}
+
+	commands, _ := app.Commands.After(0)
+	for command := range commands {
+		if status, ok := command.Message.(*SetEmailDeliveryStatus); ok && status.InternalID == "shutdown" {
+			return
+		}
+	}
+	t.Fatalf("expected queued email to be sent before exiting")
+}
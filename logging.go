@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// NewLogger returns a logger for component that writes to out in format,
+// dropping records below level.
+//
+// Every record carries the component as an attribute, so that the logs of
+// a single subsystem can be filtered.
+func NewLogger(out io.Writer, format string, level slog.Leveler, component string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(out, options)
+	} else {
+		handler = slog.NewTextHandler(out, options)
+	}
+	logger := slog.New(handler)
+	if component != "" {
+		logger = logger.With("component", component)
+	}
+	return logger
+}
+
+// NewLogger returns a logger for component as configured by LogFormat and LogLevel.
+func (c *PlatformConfig) NewLogger(component string) *slog.Logger {
+	out := c.LogOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	return NewLogger(out, c.LogFormat, c.LogLevel, component)
+}
+
+func parseLogLevel(level string) slog.Level {
+	var result slog.Level
+	if err := result.UnmarshalText([]byte(level)); err != nil {
+		panic(fmt.Errorf("Error parsing log level %q: %w", level, err))
+	}
+	return result
+}
+
+func parseLogFormat(format string) string {
+	if format != LogFormatText && format != LogFormatJSON {
+		panic(fmt.Errorf("Unsupported log format %q", format))
+	}
+	return format
+}
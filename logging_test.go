@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// discardLogger returns a logger that drops all records.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func Test_PlatformConfig_ConfiguresLoggingFromEnv(t *testing.T) {
+	env := map[string]string{"ORANGE_LOG_LEVEL": "warn", "ORANGE_LOG_FORMAT": "json"}
+	config := NewPlatformConfigFromEnv(func(key string) string { return env[key] })
+	out := &bytes.Buffer{}
+	config.LogOutput = out
+
+	logger := config.NewLogger("mailer")
+	logger.Info("dropped")
+	logger.Warn("failed to send email", "email_id", "1")
+
+	record := map[string]any{}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %s", out, err)
+	}
+	if record["msg"] != "failed to send email" || record["component"] != "mailer" || record["email_id"] != "1" {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
+
+func Test_WebApp_LogsRequestsWithStatusAndLatency(t *testing.T) {
+	web := NewWebTest(t)
+	req, _ := http.NewRequest("GET", "/s/does-not-exist.css", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	web.web.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := web.logs.String()
+	for _, attr := range []string{"msg=request", "status=404", "duration=", "request_id=req-1", "path=/s/does-not-exist.css"} {
+		if !strings.Contains(line, attr) {
+			t.Errorf("expected request log to contain %q, got %q", attr, line)
+		}
+	}
+}
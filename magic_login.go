@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -11,14 +11,20 @@ import (
 
 type MagicLoginController struct {
 	BaseUrl         string
-	Logger          *log.Logger
+	Logger          *slog.Logger
 	App             *App
 	Commands        CommandLog
 	PendingRequests map[string]string
 	Version         int
+
+	// pendingCauses contains the command that caused each pending request, by email.
+	pendingCauses map[string]*PersistedCommand
+	// handling is the command currently being handled by catchUp.
+	handling *PersistedCommand
+	progress Progress
 }
 
-func NewMagicLoginController(app *App, commands CommandLog, logger *log.Logger, baseUrl string) *MagicLoginController {
+func NewMagicLoginController(app *App, commands CommandLog, logger *slog.Logger, baseUrl string) *MagicLoginController {
 	return &MagicLoginController{
 		BaseUrl:         baseUrl,
 		Logger:          logger,
@@ -26,9 +32,19 @@ func NewMagicLoginController(app *App, commands CommandLog, logger *log.Logger,
 		Commands:        commands,
 		PendingRequests: make(map[string]string),
 		Version:         0,
+		pendingCauses:   make(map[string]*PersistedCommand),
 	}
 }
 
+func (m *MagicLoginController) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "magic-login",
+		Consumes: []string{"RequestMagicLinkLogin", "LogInWithMagic", "QueueEmail"},
+	}
+}
+
+func (m *MagicLoginController) Progress() *Progress { return &m.progress }
+
 func (m *MagicLoginController) HandleCommand(command Command, from time.Time) error {
 	switch c := command.(type) {
 	case *RequestMagicLinkLogin:
@@ -43,35 +59,35 @@ func (m *MagicLoginController) HandleCommand(command Command, from time.Time) er
 }
 
 func (m *MagicLoginController) Start() func() {
-	stop := make(chan struct{})
+	wake, stopWaiting := m.App.WaitForCommands()
 	m.catchUp()
-	go m.loop(stop)
-	return func() { close(stop) }
+	stopLoop := startLoop(func(stop <-chan struct{}) { m.loop(stop, wake) })
+	return func() { stopWaiting(); stopLoop() }
 }
 
 func (m *MagicLoginController) catchUp() {
 	commands, err := m.Commands.After(m.Version)
 	if err != nil {
-		m.Logger.Printf("failed to fetch commands: %v", err)
+		m.Logger.Error("failed to fetch commands", "version", m.Version, "error", err)
 		return
 	}
 
-	from := time.Now().Add(time.Duration(-1) * time.Hour)
+	from := time.Now().Add(-EmailRequestLifetime)
 	for c := range commands {
+		m.handling = c
 		m.HandleCommand(c.Message, from)
 		m.Version = c.ID
 	}
+	m.handling = nil
+	m.progress.Record(m.Version, time.Now())
 }
 
-func (m *MagicLoginController) loop(stop <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	tick := ticker.C
+func (m *MagicLoginController) loop(stop <-chan struct{}, wake <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
 			return
-		case <-tick:
+		case <-wake:
 			m.catchUp()
 			m.handleMagicLogin()
 		}
@@ -81,6 +97,7 @@ func (m *MagicLoginController) loop(stop <-chan struct{}) {
 func (m *MagicLoginController) addPendingRequest(c *RequestMagicLinkLogin, from time.Time) error {
 	if c.RequestedAt.After(from) {
 		m.PendingRequests[c.Email] = c.Magic
+		m.pendingCauses[c.Email] = m.handling
 	}
 	return nil
 }
@@ -89,6 +106,7 @@ func (m *MagicLoginController) removePendingRequest(magic string) error {
 	for email, pendingMagic := range m.PendingRequests {
 		if pendingMagic == magic {
 			delete(m.PendingRequests, email)
+			delete(m.pendingCauses, email)
 			return nil
 		}
 	}
@@ -125,13 +143,14 @@ func (m *MagicLoginController) handleMagicLogin() error {
 					"action_url": fmt.Sprintf("%s/login/%s", m.BaseUrl, magic),
 				},
 			}
-			m.App.HandleCommand(&queueEmail)
+			m.App.HandleCommandWithMetadata(&queueEmail, CausedBy(m.pendingCauses[email], "magic-login"))
 		}
 		toBeRemoved = append(toBeRemoved, email)
 	}
 
 	for _, email := range toBeRemoved {
 		delete(m.PendingRequests, email)
+		delete(m.pendingCauses, email)
 	}
 
 	return nil
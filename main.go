@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/kr/pretty"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -54,18 +57,117 @@ func run(err error, usage ...string) {
 
 func main() {
 	config := NewPlatformConfigFromEnv(os.Getenv)
+	slog.SetDefault(config.NewLogger(""))
 	app, starters := HackerNews(config)
-	before := time.Now()
-	if err := app.Replay(config.SkipErrorsDuringReplay); err != nil {
-		fmt.Printf("failed to replay commands: %s\n", err)
-		os.Exit(1)
-	}
-	after := time.Now()
 	subcommand := "serve"
 	if len(os.Args) >= 2 {
 		subcommand = os.Args[1]
 	}
+	if subcommand == "verify-log" {
+		// Replay refuses to start if the log cannot be decoded, so verify it before replaying.
+		err := NewDefaultShell(app).VerifyLog(os.Stdout)
+		app.Close()
+		run(err)
+		return
+	}
+	if subcommand == "modules" {
+		// The ownership map only depends on which modules are mounted, not on the log.
+		err := NewDefaultShell(app).Modules(os.Stdout)
+		app.Close()
+		run(err)
+		return
+	}
+	if subcommand == "export" {
+		// orange export [after <id>] [until <id>] [type <name>,...] > commands.jsonl
+		defer app.Close()
+		if len(os.Args[2:])%2 != 0 {
+			run(fmt.Errorf("expected pairs of keys and values"), "export [after <id>] [until <id>] [type <name>,...]")
+		}
+		run(NewDefaultShell(app).Export(DictFromList(os.Args[2:]), os.Stdout), "export [after <id>] [until <id>] [type <name>,...]")
+		return
+	}
+	if subcommand == "import" {
+		// orange import [commands.jsonl]
+		defer app.Close()
+		target, ok := app.Commands.(*FileCommandLog)
+		if !ok {
+			run(fmt.Errorf("cannot import into command log %s", config.CommandLog))
+		}
+		in := os.Stdin
+		if filename := p(2); filename != "" {
+			file, err := os.Open(filename)
+			run(err, "import [<file>]")
+			defer file.Close()
+			in = file
+		}
+		n, err := ImportCommands(target, config, in)
+		run(err, "import [<file>]")
+		fmt.Printf("imported %d commands\n", n)
+		return
+	}
+	if subcommand == "anonymize" {
+		// orange anonymize <output> [<password>]
+		defer app.Close()
+		if p(2) == "" {
+			run(fmt.Errorf("missing output file"), "anonymize <output> [<password>]")
+		}
+		key := []byte(os.Getenv("ORANGE_ANONYMIZE_KEY"))
+		if len(key) == 0 {
+			fmt.Printf("ORANGE_ANONYMIZE_KEY is not set, using a random key\n")
+			key = []byte(uuid.NewString())
+		}
+		password := p(3)
+		if password == "" {
+			password = "password"
+		}
+		n, err := AnonymizeCommandLog(app.Commands, NewAnonymizer(key, password), p(2))
+		run(err)
+		fmt.Printf("anonymized %d commands, all users have the password %q\n", n, password)
+		return
+	}
+	if subcommand == "convert-log" {
+		// orange convert-log <directory>
+		defer app.Close()
+		if p(2) == "" {
+			run(fmt.Errorf("missing target directory"), "convert-log <directory>")
+		}
+		target := NewSegmentCommandLog(p(2), DefaultSerializer)
+		run(target.Setup())
+		defer target.Close()
+		n, err := ConvertCommandLog(app.Commands, target)
+		run(err)
+		fmt.Printf("converted %d commands, use ORANGE_COMMAND_LOG=segments:///%s\n", n, p(2))
+		return
+	}
+	if subcommand == "replay-report" {
+		// Replay into fresh modules, so that all failures are reported, not just the first one.
+		app.Close()
+		if config.CommandLog.Scheme != "file" {
+			run(fmt.Errorf("cannot replay command log %s", config.CommandLog))
+		}
+		dryRun, err := replayInMemory(toFilePath(config.CommandLog), true)
+		reportErr := NewDefaultShell(dryRun).ReplayReport(os.Stdout)
+		dryRun.Close()
+		run(errors.Join(err, reportErr))
+		return
+	}
+	if subcommand == "compact" {
+		// Compaction works on the log file directly, while no one is using it.
+		app.Close()
+		if config.CommandLog.Scheme != "file" {
+			run(fmt.Errorf("cannot compact command log %s", config.CommandLog))
+		}
+		run(CompactCommandLogFile(toFilePath(config.CommandLog), DefaultCompactionRules, time.Now(), os.Stdout))
+		return
+	}
+
+	if err := app.Replay(config.SkipErrorsDuringReplay); err != nil {
+		fmt.Printf("failed to replay commands: %s\n", err)
+		os.Exit(1)
+	}
+	defer app.Close()
 	shell := NewDefaultShell(app)
+	shell.Origin = "cli"
 
 	values := url.Values{}
 
@@ -73,6 +175,8 @@ func main() {
 	case "log":
 		pv(2, "after", &values)
 		run(shell.List(values, os.Stdout))
+	case "snapshot":
+		run(shell.TakeSnapshot(os.Stdout))
 	case "unskip-commands":
 		for i, arg := range os.Args[2:] {
 			values.Set(fmt.Sprintf("id[%d]", i), arg)
@@ -85,16 +189,22 @@ func main() {
 		run(shell.SkipCommands(values), "skip-commands <id>...")
 	case "serve":
 		web := NewWebApp(app, shell)
+		web.logger = config.NewLogger("web")
+		web.Starters = starters
 		conninfo := ":8080"
 		if len(os.Args) > 2 {
 			conninfo = os.Args[2]
 		}
-		web.logger.Printf("Replayed events in %s\n", after.Sub(before))
-		for _, starter := range starters {
-			starter.Start()
+		listener, err := net.Listen("tcp", conninfo)
+		run(err)
+		lifecycle := NewLifecycle(config.NewLogger("lifecycle"))
+		if err := startServing(lifecycle, app, shell, config, starters, web, listener); err != nil {
+			lifecycle.Stop()
+			run(err)
 		}
-		go replServer(shell, log.New(os.Stdout, "[repl] ", log.LstdFlags))
-		fmt.Printf("%s\n", http.ListenAndServe(conninfo, web))
+		err = lifecycle.Run(context.Background())
+		app.Close()
+		run(err)
 	case "do", "get":
 		if len(os.Args) < 3 {
 			fmt.Printf("usage: %s <command> <args...>\n", subcommand)
@@ -120,7 +230,8 @@ func main() {
 		}
 		fmt.Printf("> headers: %#v\n> parameters: %#v\n", headers, parameters)
 		req := &Request{Headers: headers, Parameters: parameters}
-		result, err := shell.Do(context.Background(), req)
+		ctx := WithCommandOrigin(context.Background(), "cli", uuid.NewString())
+		result, err := shell.Do(ctx, req)
 		fmt.Printf("< result: %# v\n", pretty.Formatter(result))
 		fmt.Printf("< err: %s\n", err)
 	default:
@@ -129,6 +240,40 @@ func main() {
 	}
 }
 
+// ReplAddress is the address the REPL server listens on.
+var ReplAddress = "127.0.0.1:8088"
+
+// startServing starts all starters, the REPL server and the receiver of
+// commands from followers on the primary, and finally serves web on listener.
+//
+// Stopping lifecycle stops them in reverse order, so that requests in
+// flight are finished before the background processes stop.
+func startServing(lifecycle *Lifecycle, app *App, shell *Shell, config *PlatformConfig, starters []Starter, web http.Handler, listener net.Listener) error {
+	for _, starter := range starters {
+		lifecycle.Start(starterName(starter), starter)
+	}
+	if !config.Follower {
+		logger := config.NewLogger("repl")
+		if repl, err := net.Listen("tcp", ReplAddress); err != nil {
+			logger.Error("failed to listen", "address", ReplAddress, "error", err)
+		} else {
+			lifecycle.Go("repl", func() error { return replServer(shell, repl, logger) },
+				func(ctx context.Context) error { return repl.Close() })
+		}
+	}
+	if !config.Follower && config.Primary.Scheme != "none" {
+		followers, err := ListenForFollowers(config.Primary)
+		if err != nil {
+			return err
+		}
+		logger := config.NewLogger("primary")
+		logger.Info("accepting commands from followers", "address", config.Primary.String())
+		lifecycle.Serve("primary", &http.Server{Handler: NewCommandReceiver(app, DefaultSerializer, logger)}, followers)
+	}
+	lifecycle.Serve("web", &http.Server{Handler: web}, listener)
+	return nil
+}
+
 var titler = cases.Title(language.AmericanEnglish)
 
 func toCamelCase(s string) string {
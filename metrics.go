@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets latencies are counted in.
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Metrics collects counters, gauges and histograms, and writes them in
+// the Prometheus text format.
+//
+// Labels are passed as pairs of names and values.  Metrics is safe for
+// concurrent use.
+type Metrics struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+// metricFamily holds all series of a metric, by their formatted labels.
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	value float64
+	// buckets counts the observations per bucket of a histogram; they are
+	// only made cumulative when writing.
+	buckets []uint64
+	count   uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{families: map[string]*metricFamily{}}
+}
+
+// Count adds one to the counter called name.
+func (m *Metrics) Count(name, help string, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.series(name, help, "counter", labels).value++
+}
+
+// Set sets the gauge called name to value.
+func (m *Metrics) Set(name, help string, value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.series(name, help, "gauge", labels).value = value
+}
+
+// Observe records value in the histogram called name, using DefaultLatencyBuckets.
+func (m *Metrics) Observe(name, help string, value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	series := m.series(name, help, "histogram", labels)
+	if series.buckets == nil {
+		series.buckets = make([]uint64, len(DefaultLatencyBuckets))
+	}
+	if i, _ := slices.BinarySearch(DefaultLatencyBuckets, value); i < len(series.buckets) {
+		series.buckets[i]++
+	}
+	series.value += value
+	series.count++
+}
+
+// Value returns the value of a counter or gauge, or the number of observations of a histogram.
+func (m *Metrics) Value(name string, labels ...string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	family, ok := m.families[name]
+	if !ok {
+		return 0
+	}
+	series, ok := family.series[formatLabels(labels)]
+	if !ok {
+		return 0
+	}
+	if family.kind == "histogram" {
+		return float64(series.count)
+	}
+	return series.value
+}
+
+func (m *Metrics) series(name, help, kind string, labels []string) *metricSeries {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{name: name, help: help, kind: kind, series: map[string]*metricSeries{}}
+		m.families[name] = family
+	}
+	key := formatLabels(labels)
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{}
+		family.series[key] = series
+	}
+	return series
+}
+
+// WriteTo writes all metrics in the Prometheus text format, sorted by name and labels.
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	b := &strings.Builder{}
+	for _, name := range slices.Sorted(maps.Keys(m.families)) {
+		family := m.families[name]
+		fmt.Fprintf(b, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, family.kind)
+		for _, labels := range slices.Sorted(maps.Keys(family.series)) {
+			series := family.series[labels]
+			if family.kind != "histogram" {
+				fmt.Fprintf(b, "%s%s %s\n", name, labels, formatFloat(series.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for i, bound := range DefaultLatencyBuckets {
+				cumulative += series.buckets[i]
+				fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), series.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", name, labels, formatFloat(series.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", name, labels, series.count)
+		}
+	}
+	n, err := io.WriteString(out, b.String())
+	return int64(n), err
+}
+
+// formatLabels formats pairs of label names and values as {name="value",...}.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, formatLabel(labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to labels formatted by formatLabels.
+func withLabel(labels, name, value string) string {
+	label := formatLabel(name, value)
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + label + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(name, value string) string {
+	return name + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
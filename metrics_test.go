@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Metrics_WritesPrometheusTextFormat(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Count("orange_commands_handled_total", "Commands handled.", "command", "SignUpUser", "result", "accepted")
+	metrics.Count("orange_commands_handled_total", "Commands handled.", "command", "SignUpUser", "result", "accepted")
+	metrics.Set("orange_starter_lag", "Lag.", 3, "starter", `say "hi"`)
+	metrics.Observe("orange_query_duration_seconds", "Query latency.", 0.003, "query", "FindSession")
+
+	out := &strings.Builder{}
+	if _, err := metrics.WriteTo(out); err != nil {
+		t.Fatalf("failed to write metrics: %s", err)
+	}
+	for _, line := range []string{
+		"# TYPE orange_commands_handled_total counter\n",
+		`orange_commands_handled_total{command="SignUpUser",result="accepted"} 2` + "\n",
+		`orange_starter_lag{starter="say \"hi\""} 3` + "\n",
+		"# TYPE orange_query_duration_seconds histogram\n",
+		`orange_query_duration_seconds_bucket{query="FindSession",le="0.0025"} 0` + "\n",
+		`orange_query_duration_seconds_bucket{query="FindSession",le="0.005"} 1` + "\n",
+		`orange_query_duration_seconds_bucket{query="FindSession",le="+Inf"} 1` + "\n",
+		`orange_query_duration_seconds_sum{query="FindSession"} 0.003` + "\n",
+		`orange_query_duration_seconds_count{query="FindSession"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"slices"
 	"strings"
@@ -13,17 +14,20 @@ import (
 // Notifier is a background process that notifies users of new
 // submissions and comments.
 //
-// When a PostLink or PostComment entry is found in the log,
-// a query is sent to the content module to ask which user should
-// receive notifications based on their subscription settings.
+// It reacts to the SubmissionPosted and CommentPosted events recorded
+// in the log, which list the users that were subscribed at the time.
 type Notifier struct {
-	Logger   *log.Logger
+	Logger   *slog.Logger
 	App      *App
 	Commands CommandLog
 	ToNotify ScheduledNotificationSet
 	Version  int
 	BaseURL  *url.URL
 	Active   bool
+
+	// handling is the command currently being handled by catchUp.
+	handling *PersistedCommand
+	progress Progress
 }
 
 type ScheduledNotificationSet map[string]*ScheduledNotification
@@ -42,6 +46,7 @@ type ScheduledNotification struct {
 	About     string
 	Recipient string
 	Event     string
+	CausedBy  *PersistedCommand
 }
 
 func (n *ScheduledNotification) Entity() string {
@@ -57,7 +62,7 @@ func (n *ScheduledNotification) Entity() string {
 
 func (n *ScheduledNotification) ID() string { return fmt.Sprintf("%s:%s", n.About, n.Recipient) }
 
-func NewNotifier(app *App, commands CommandLog, logger *log.Logger, baseURL *url.URL) *Notifier {
+func NewNotifier(app *App, commands CommandLog, logger *slog.Logger, baseURL *url.URL) *Notifier {
 	return &Notifier{
 		Logger:   logger,
 		App:      app,
@@ -69,35 +74,43 @@ func NewNotifier(app *App, commands CommandLog, logger *log.Logger, baseURL *url
 	}
 }
 func (n *Notifier) Start() func() {
-	stop := make(chan struct{})
+	wake, stopWaiting := n.App.WaitForCommands()
 
 	n.catchUp()
-	n.Logger.Printf("Notifier started at version %d", n.Version)
-	go n.loop(stop)
-	return func() { close(stop) }
+	n.Logger.Info("started", "version", n.Version)
+	stopLoop := startLoop(func(stop <-chan struct{}) { n.loop(stop, wake) })
+	return func() { stopWaiting(); stopLoop() }
 }
 
 func (n *Notifier) catchUp() {
 	commands, err := n.Commands.After(n.Version)
 	if err != nil {
-		n.Logger.Printf("failed to fetch commands: %v", err)
+		n.Logger.Error("failed to fetch commands", "version", n.Version, "error", err)
 		return
 	}
 	for command := range commands {
+		// The app records events after appending the command, so they
+		// might still be missing; catching up again later picks them up.
+		if command.Events == nil {
+			break
+		}
+		n.handling = command
 		n.HandleCommand(command.Message)
+		for _, event := range command.Events {
+			n.HandleEvent(event)
+		}
 		n.Version = command.ID
 	}
+	n.handling = nil
+	n.progress.Record(n.Version, time.Now())
 }
 
-func (n *Notifier) loop(stop <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	tick := ticker.C
+func (n *Notifier) loop(stop <-chan struct{}, wake <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
 			return
-		case <-tick:
+		case <-wake:
 			n.catchUp()
 			n.showWork()
 			n.notify()
@@ -111,23 +124,58 @@ func (n *Notifier) showWork() {
 	}
 
 	for _, notification := range n.ToNotify {
-		n.Logger.Printf("%q about %q(%q)", notification.Recipient, notification.Event, notification.About)
+		n.Logger.Debug("scheduled notification", "username", notification.Recipient, "event", notification.Event, "item_id", notification.About)
+	}
+}
+
+func (n *Notifier) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "notifier",
+		Consumes: []string{"QueueEmail", "SetNotifierConfig", "SubmissionPosted", "CommentPosted"},
+	}
+}
+
+func (n *Notifier) Progress() *Progress { return &n.progress }
+
+// Snapshot serializes whether the notifier is active and the notifications
+// it has scheduled, ordered by their ID, without the commands causing them.
+func (n *Notifier) Snapshot() ([]byte, error) {
+	ids := []string{}
+	for id := range n.ToNotify {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	scheduled := []*ScheduledNotification{}
+	for _, id := range ids {
+		withoutCommand := *n.ToNotify[id]
+		withoutCommand.CausedBy = nil
+		scheduled = append(scheduled, &withoutCommand)
 	}
+	data, err := json.Marshal(map[string]any{"active": n.Active, "scheduled": scheduled})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize notifier: %w", err)
+	}
+	return data, nil
 }
 
 func (n *Notifier) HandleCommand(cmd Command) {
 	switch cmd := cmd.(type) {
 	case *QueueEmail:
 		n.removeScheduleNotificationFor(cmd)
-	case *PostLink:
-		n.addScheduledNotificationForSubmission(cmd)
-	case *PostComment:
-		n.addScheduledNotificationForComment(cmd)
 	case *SetNotifierConfig:
 		n.Active = cmd.Enabled
 	}
 }
 
+func (n *Notifier) HandleEvent(event Event) {
+	switch event := event.(type) {
+	case *SubmissionPosted:
+		n.addScheduledNotificationForSubmission(event)
+	case *CommentPosted:
+		n.addScheduledNotificationForComment(event)
+	}
+}
+
 func (s *Notifier) notify() {
 	scheduled := []*ScheduledNotification{}
 	for _, n := range s.ToNotify {
@@ -148,7 +196,7 @@ func (n *Notifier) notifyAbout(notification *ScheduledNotification) {
 	}
 	q := NewFindSubmission(submissionID)
 	if err := n.App.HandleQuery(q); err != nil {
-		n.Logger.Printf("notifyAbout(%q): failed to find submission(%q): %s", notification.Event, notification.About, err)
+		n.Logger.Error("failed to find submission", "event", notification.Event, "item_id", notification.About, "error", err)
 		return
 	}
 	submission = q.Submission
@@ -161,7 +209,7 @@ func (n *Notifier) notifyAbout(notification *ScheduledNotification) {
 
 	recipientEmail, found := n.findRecipientEmail(notification.Recipient)
 	if !found {
-		n.Logger.Printf("skipping %q, no verified email found", notification.Recipient)
+		n.Logger.Info("skipping notification, no verified email found", "username", notification.Recipient)
 		return
 	}
 
@@ -171,7 +219,7 @@ func (n *Notifier) notifyAbout(notification *ScheduledNotification) {
 		return
 	}
 	if settingsError != nil {
-		n.Logger.Printf("error retrieving notification settings for user %q: %s", notification.Recipient, settingsError)
+		n.Logger.Error("failed to retrieve notification settings", "username", notification.Recipient, "error", settingsError)
 		return
 	}
 
@@ -184,7 +232,7 @@ func (n *Notifier) notifyAbout(notification *ScheduledNotification) {
 		return
 	}
 
-	n.Logger.Printf("notifying %q about %q", notification.Recipient, notification.Event)
+	n.Logger.Info("notifying", "username", notification.Recipient, "event", notification.Event, "item_id", notification.About)
 	queueEmail := &QueueEmail{
 		InternalID:   notification.ID(),
 		Recipients:   recipientEmail,
@@ -199,13 +247,13 @@ func (n *Notifier) notifyAbout(notification *ScheduledNotification) {
 			"entity":     entity,
 		},
 	}
-	n.App.HandleCommand(queueEmail)
+	n.App.HandleCommandWithMetadata(queueEmail, CausedBy(notification.CausedBy, "notifier"))
 }
 
 func (n *Notifier) findRecipientEmail(username string) (string, bool) {
 	q := NewFindUserByName(username)
 	if err := n.App.HandleQuery(q); err != nil {
-		n.Logger.Printf("findRecipientEmail: user %q not found: %s", username, err)
+		n.Logger.Warn("recipient not found", "username", username, "error", err)
 		return "", false
 	}
 
@@ -244,40 +292,23 @@ func (n *Notifier) schedule(not *ScheduledNotification) {
 	n.ToNotify.Add(not)
 }
 
-func (n *Notifier) addScheduledNotificationForSubmission(cmd *PostLink) {
-	for _, recipient := range n.recipientsFor(cmd) {
+func (n *Notifier) addScheduledNotificationForSubmission(event *SubmissionPosted) {
+	for _, recipient := range event.Subscribers {
 		n.schedule(&ScheduledNotification{
-			About:     cmd.ItemID,
+			About:     event.ItemID,
 			Event:     "PostLink",
 			Recipient: recipient,
+			CausedBy:  n.handling,
 		})
 	}
 }
-func (n *Notifier) addScheduledNotificationForComment(cmd *PostComment) {
-	for _, recipient := range n.recipientsFor(cmd) {
+func (n *Notifier) addScheduledNotificationForComment(event *CommentPosted) {
+	for _, recipient := range event.Subscribers {
 		n.schedule(&ScheduledNotification{
-			About:     cmd.ParentID.String(),
+			About:     event.ParentID.String(),
 			Event:     "PostComment",
 			Recipient: recipient,
+			CausedBy:  n.handling,
 		})
 	}
 }
-
-func (n *Notifier) recipientsFor(cmd Command) []string {
-	switch cmd := cmd.(type) {
-	case *PostLink:
-		q := NewFindSubscribersForNewSubmission()
-		n.App.HandleQuery(q)
-		return slices.DeleteFunc(q.Subscribers, func(subscriber string) bool {
-			return subscriber == cmd.Submitter
-		})
-	case *PostComment:
-		q := NewFindSubscribersForNewComment(cmd.ParentID.String())
-		n.App.HandleQuery(q)
-		return slices.DeleteFunc(q.Subscribers, func(subscriber string) bool {
-			return subscriber == cmd.Author
-		})
-	default:
-		return []string{}
-	}
-}
@@ -84,3 +84,62 @@ func Test_Notifier_does_not_schedule_notifications_when_disabled(t *testing.T) {
 		t.Fatalf("Expected an email to be queued, found none")
 	}
 }
+
+func Test_Notifier_records_which_command_caused_a_notification(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.signup("test-user", "password"))
+	scenario.must(scenario.linkVerifiedEmailToUser("test-user", "test-user@gmail.local"))
+	scenario.must(scenario.subscribeTo("test-user", SUBSCRIPTION_SCOPE_SUBMISSIONS))
+	scenario.must(scenario.enableNotifier())
+	scenario.Submitter = "someone-else"
+	if err := scenario.App.HandleCommandWithMetadata(
+		scenario.postLink("https://example.com", "A new entry"),
+		&CommandMetadata{Origin: "web", CorrelationID: "request-1"},
+	); err != nil {
+		t.Fatalf("failed to post link: %s", err)
+	}
+	postLinkID := scenario.App.version
+
+	notifier := scenario.Notifier()
+	notifier.catchUp()
+	notifier.notify()
+
+	found := scenario.LogContains(func(cmd *PersistedCommand) bool {
+		return notificationQueuedFor("test-user@gmail.local")(cmd) &&
+			cmd.Metadata.CausationID == postLinkID &&
+			cmd.Metadata.CorrelationID == "request-1" &&
+			cmd.Metadata.Origin == "notifier"
+	})
+	if !found {
+		scenario.DumpLog()
+		t.Fatalf("Expected a notification caused by command %d", postLinkID)
+	}
+}
+
+func Test_Notifier_waits_for_events_to_be_recorded(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.signup("sir-post-a-lot", "password"))
+	scenario.must(scenario.linkVerifiedEmailToUser("sir-post-a-lot", "sir-post-a-lot@gmail.local"))
+	scenario.must(scenario.subscribeTo("sir-post-a-lot", SUBSCRIPTION_SCOPE_SUBMISSIONS))
+	scenario.must(scenario.enableNotifier())
+	notifier := scenario.Notifier()
+	notifier.catchUp()
+	version := notifier.Version
+
+	// Appended like by a process that did not get to record its events.
+	if err := scenario.App.Commands.Append(scenario.postLink("https://example.com", "A new entry"), nil); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+	notifier.catchUp()
+	if notifier.Version != version || len(notifier.ToNotify) != 0 {
+		t.Fatalf("expected notifier to wait at version %d, got %d with %d notifications", version, notifier.Version, len(notifier.ToNotify))
+	}
+
+	if err := scenario.App.Replay(false); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	notifier.catchUp()
+	if len(notifier.ToNotify) == 0 {
+		t.Fatalf("Expected a notification to be scheduled once events were recorded, but there is none")
+	}
+}
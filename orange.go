@@ -2,20 +2,43 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/url"
-	"os"
+	"strconv"
+	"time"
 )
 
 type PlatformConfig struct {
 	SkipErrorsDuringReplay  bool
+	QuarantineReplayErrors  bool
 	EmailSender             *url.URL
 	ContentStore            *url.URL
 	AuthStore               *url.URL
 	CommandLog              *url.URL
+	Snapshots               *url.URL
 	Notifier                *url.URL
 	MagicLoginController    *url.URL
 	PasswordResetController *url.URL
+
+	// Follower makes the app forward commands to Primary instead of
+	// appending them, and skips starting background processes.
+	Follower bool
+	// Primary is where the primary accepts commands from followers,
+	// e.g. unix:///primary.sock or http://localhost:8089.
+	Primary *url.URL
+
+	// EditWindow is how long after posting authors can edit or delete what they posted.
+	EditWindow time.Duration
+	// DownvoteKarma is the karma users need to downvote, or DownvotesDisabled.
+	DownvoteKarma int
+
+	// LogLevel is the lowest level of records that are logged.
+	LogLevel slog.Level
+	// LogFormat is either LogFormatText or LogFormatJSON.
+	LogFormat string
+	// LogOutput is where logs are written to, os.Stdout if nil.
+	LogOutput io.Writer
 }
 
 func parseURL(u, field string) *url.URL {
@@ -44,15 +67,22 @@ func DefaultPlatformConfig() *PlatformConfig {
 		ContentStore:            parseURL("memory://", "ContentStore"),
 		AuthStore:               parseURL("memory://", "AuthStore"),
 		CommandLog:              parseURL("file:///commands.db", "CommandLog"),
+		Snapshots:               parseURL("file:///snapshots", "Snapshots"),
 		Notifier:                parseURL("service:///?baseUrl=http:%2f%2flocalhost:8081%2f", "Notifier"),
 		MagicLoginController:    parseURL("service:///?baseUrl=http:%2f%2flocalhost:8081%2f", "MagicLoginController"),
 		PasswordResetController: parseURL("service:///?baseUrl=http:%2f%2flocalhost:8081%2f", "PasswordResetController"),
+		Primary:                 parseURL("none://", "Primary"),
+		EditWindow:              DefaultEditWindow,
+		DownvoteKarma:           DownvotesDisabled,
+		LogLevel:                slog.LevelInfo,
+		LogFormat:               LogFormatText,
 	}
 }
 
 func NewPlatformConfigForTest() *PlatformConfig {
 	config := DefaultPlatformConfig()
 	config.CommandLog = parseURL("memory://", "CommandLog")
+	config.Snapshots = parseURL("memory://", "Snapshots")
 	config.EmailSender = parseURL("memory://", "EmailSender")
 	return config
 }
@@ -62,10 +92,12 @@ func NewPlatformConfigFromEnv(getenv func(key string) string) *PlatformConfig {
 		"CONTENT_STORE":             &config.ContentStore,
 		"AUTH_STORE":                &config.AuthStore,
 		"COMMAND_LOG":               &config.CommandLog,
+		"SNAPSHOTS":                 &config.Snapshots,
 		"EMAIL_SENDER":              &config.EmailSender,
 		"NOTIFIER":                  &config.Notifier,
 		"MAGIC_LOGIN_CONTROLLER":    &config.MagicLoginController,
 		"PASSWORD_RESET_CONTROLLER": &config.PasswordResetController,
+		"PRIMARY":                   &config.Primary,
 	}
 	for name, dest := range fields {
 		newURL := getenv("ORANGE_" + name)
@@ -76,13 +108,35 @@ func NewPlatformConfigFromEnv(getenv func(key string) string) *PlatformConfig {
 	}
 
 	config.SkipErrorsDuringReplay = getenv("ORANGE_SKIP_ERRORS") == "true"
+	config.QuarantineReplayErrors = getenv("ORANGE_QUARANTINE") == "true"
+	config.Follower = getenv("ORANGE_FOLLOWER") == "true"
+	if window := getenv("ORANGE_EDIT_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			panic(fmt.Errorf("Error parsing ORANGE_EDIT_WINDOW %q: %w", window, err))
+		}
+		config.EditWindow = d
+	}
+	if karma := getenv("ORANGE_DOWNVOTE_KARMA"); karma != "" {
+		n, err := strconv.Atoi(karma)
+		if err != nil || n < 0 {
+			panic(fmt.Errorf("Error parsing ORANGE_DOWNVOTE_KARMA %q: expected a non-negative number", karma))
+		}
+		config.DownvoteKarma = n
+	}
+	if level := getenv("ORANGE_LOG_LEVEL"); level != "" {
+		config.LogLevel = parseLogLevel(level)
+	}
+	if format := getenv("ORANGE_LOG_FORMAT"); format != "" {
+		config.LogFormat = parseLogFormat(format)
+	}
 
 	return config
 }
 
 func (c *PlatformConfig) NewEmailSender() EmailSender {
 	if c.EmailSender.Scheme == "memory" {
-		return &EmailLogger{log.New(os.Stdout, "[mailer] ", log.LstdFlags)}
+		return &EmailLogger{c.NewLogger("mailer")}
 	}
 	if c.EmailSender.Scheme == "https" && c.EmailSender.Host == "api.postmarkapp.com" {
 		serverToken := c.EmailSender.Query().Get("key")
@@ -93,8 +147,7 @@ func (c *PlatformConfig) NewEmailSender() EmailSender {
 		if from == "" {
 			panic("Missing from address in email sender URL")
 		}
-		logger := log.New(os.Stdout, "[postmark] ", log.LstdFlags)
-		return NewPostmarkEmailSender(logger, c.EmailSender.Query())
+		return NewPostmarkEmailSender(c.NewLogger("postmark"), c.EmailSender.Query())
 	}
 
 	panic("Unsupported email sender URL " + c.EmailSender.String())
@@ -105,11 +158,53 @@ func (c *PlatformConfig) NewCommandLog() CommandLog {
 		return NewFileCommandLog(toFilePath(c.CommandLog), DefaultSerializer)
 	} else if c.CommandLog.Scheme == "memory" {
 		return NewInMemoryCommandLog()
+	} else if c.CommandLog.Scheme == "segments" {
+		return c.newSegmentCommandLog()
 	} else {
 		panic("Unsupported command log URL " + c.CommandLog.String())
 	}
 }
 
+// newSegmentCommandLog configures a SegmentCommandLog from the query of the command log URL,
+// e.g. segments:///commands?fsync=interval&fsync_interval=100ms&segment_size=16777216
+func (c *PlatformConfig) newSegmentCommandLog() *SegmentCommandLog {
+	segments := NewSegmentCommandLog(toFilePath(c.CommandLog), DefaultSerializer)
+	query := c.CommandLog.Query()
+	if policy := query.Get("fsync"); policy != "" {
+		segments.Sync = SyncPolicy(policy)
+		if segments.Sync != SyncAlways && segments.Sync != SyncInterval && segments.Sync != SyncNever {
+			panic("Unsupported fsync policy " + policy)
+		}
+	}
+	if interval := query.Get("fsync_interval"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			panic(fmt.Errorf("Error parsing fsync_interval %q: %w", interval, err))
+		}
+		segments.SyncInterval = d
+	}
+	if size := query.Get("segment_size"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("Error parsing segment_size %q: %w", size, err))
+		}
+		segments.SegmentSize = n
+	}
+	return segments
+}
+
+func (c *PlatformConfig) NewSnapshotStore() SnapshotStore {
+	if c.Snapshots.Scheme == "file" {
+		return NewFileSnapshotStore(toFilePath(c.Snapshots))
+	} else if c.Snapshots.Scheme == "memory" {
+		return NewInMemorySnapshotStore()
+	} else if c.Snapshots.Scheme == "none" {
+		return nil
+	} else {
+		panic("Unsupported snapshot store URL " + c.Snapshots.String())
+	}
+}
+
 func (c *PlatformConfig) NewContentState() ContentState {
 	if c.ContentStore.Scheme == "file" {
 		return NewPersistentContentState(toFilePath(c.ContentStore))
@@ -121,10 +216,12 @@ func (c *PlatformConfig) NewContentState() ContentState {
 }
 
 func (c *PlatformConfig) NewAuthState() AuthState {
-	if c.AuthStore.Scheme == "memory" {
+	if c.AuthStore.Scheme == "file" {
+		return NewPersistentAuthState(toFilePath(c.AuthStore))
+	} else if c.AuthStore.Scheme == "memory" {
 		return NewInMemoryAuthState()
 	} else {
-		panic("Unsupported content store URL " + c.AuthStore.String())
+		panic("Unsupported auth store URL " + c.AuthStore.String())
 	}
 }
 
@@ -133,7 +230,7 @@ func (c *PlatformConfig) NewMagicLoginController(app *App) *MagicLoginController
 		return NewMagicLoginController(
 			app,
 			app.Commands,
-			log.New(os.Stdout, "[magic] ", log.LstdFlags),
+			c.NewLogger("magic"),
 			c.MagicLoginController.Query().Get("baseUrl"),
 		)
 	}
@@ -149,7 +246,7 @@ func (c *PlatformConfig) NewNotifier(app *App) *Notifier {
 		return NewNotifier(
 			app,
 			app.Commands,
-			log.New(os.Stdout, "[notifier] ", log.LstdFlags),
+			c.NewLogger("notifier"),
 			baseURL,
 		)
 	}
@@ -160,33 +257,44 @@ func (c *PlatformConfig) NewPasswordResetController(app *App) *PasswordResetCont
 	if c.PasswordResetController.Scheme == "service" {
 		return NewPasswordResetController(
 			app,
-			log.New(os.Stdout, "[password-reset] ", log.LstdFlags),
+			c.NewLogger("password-reset"),
 			c.PasswordResetController.Query().Get("baseUrl"),
 		)
 	}
 	panic("Unsupported password reset controller URL " + c.PasswordResetController.String())
 }
 
+func (c *PlatformConfig) NewCommandForwarder() CommandForwarder {
+	if c.Primary.Scheme == "http" || c.Primary.Scheme == "unix" {
+		return NewHTTPCommandForwarder(c.Primary, DefaultSerializer)
+	}
+	panic("Unsupported primary URL " + c.Primary.String())
+}
+
 func HackerNews(config *PlatformConfig) (*App, []Starter) {
 	commandLog := config.NewCommandLog()
 	contentState := config.NewContentState()
 	content := NewContent(contentState)
+	content.EditWindow = config.EditWindow
+	content.DownvoteKarma = config.DownvoteKarma
 	authState := config.NewAuthState()
 	auth := NewAuth(authState)
 
 	app := NewApp(commandLog)
+	app.Logger = config.NewLogger("app")
+	app.Snapshots = config.NewSnapshotStore()
+	// Only the primary writes to the command log.
+	app.Quarantine = config.QuarantineReplayErrors && !config.Follower
 
 	magicLoginController := config.NewMagicLoginController(app)
 	passwordResetController := config.NewPasswordResetController(app)
 
-	emailLogger := log.New(os.Stdout, "[mailer] ", log.LstdFlags)
 	emailSender := config.NewEmailSender()
-	mailer := NewMailer(emailSender, emailLogger, app)
+	mailer := NewMailer(emailSender, config.NewLogger("mailer"), app)
 
 	notifier := config.NewNotifier(app)
 
-	previewLogger := log.New(os.Stdout, "[preview] ", log.LstdFlags)
-	previewGenerator := NewPreviewGenerator(app, commandLog, previewLogger)
+	previewGenerator := NewPreviewGenerator(app, commandLog, config.NewLogger("preview"))
 	starters := []Starter{
 		previewGenerator,
 		mailer,
@@ -196,9 +304,27 @@ func HackerNews(config *PlatformConfig) (*App, []Starter) {
 	}
 
 	MustSetup(commandLog)
+	MustSetup(app.Snapshots)
 	MustSetup(auth)
+	MustSetup(authState)
 	MustSetup(content)
 	MustSetup(contentState)
 
-	return app.Mount(auth).Mount(content), starters
+	for _, module := range []any{auth, content} {
+		if err := app.Mount(module); err != nil {
+			panic(err)
+		}
+	}
+	for _, starter := range starters {
+		if owner, ok := starter.(Owner); ok {
+			if err := app.Declare(owner); err != nil {
+				panic(err)
+			}
+		}
+	}
+	if config.Follower {
+		app.Primary = config.NewCommandForwarder()
+		starters = []Starter{}
+	}
+	return app, starters
 }
@@ -0,0 +1,32 @@
+package pages
+
+import (
+	g "github.com/maragudk/gomponents"
+
+	. "github.com/maragudk/gomponents/html"
+)
+
+type EditHistoryEntry struct {
+	Field    string
+	Previous string
+	EditedBy string
+	EditedAt string
+}
+
+func EditHistoryPage(path string, itemID string, edits []*EditHistoryEntry, context *PageData) g.Node {
+	return Page("The Orange Website | Edit History", path, Div(
+		Class("flex min-h-full flex-col justify-center px-6 py-12 lg:px-8"),
+		H2(Class("font-bold mb-2"),
+			g.Textf("Earlier versions of "),
+			A(Class("underline"), Href(href("/item", q{"id": itemID})), g.Text(itemID)),
+			g.Textf(" (%d)", len(edits))),
+		g.Group(g.Map(edits, renderEditHistoryEntry)),
+	), context)
+}
+
+func renderEditHistoryEntry(edit *EditHistoryEntry) g.Node {
+	return Div(Class("font-mono mb-2"),
+		Div(Class("text-sm text-gray-400"), g.Textf("%s before %s changed it at %s", edit.Field, edit.EditedBy, edit.EditedAt)),
+		Pre(Class("whitespace-pre-wrap"), g.Text(edit.Previous)),
+	)
+}
@@ -13,6 +13,12 @@ type EventLogEntry struct {
 	ID      string
 	Kind    string
 	Payload interface{}
+
+	RecordedAt    string
+	Actor         string
+	Origin        string
+	CorrelationID string
+	CausationID   string
 }
 
 func EventLogPage(path string, data []*EventLogEntry, context *PageData) g.Node {
@@ -31,13 +37,32 @@ func renderEvent(logEntry *EventLogEntry) g.Node {
 		Span(Class("min-w-16 mr-2 text-right inline-block"), g.Text(logEntry.ID)),
 		Div(
 			Details(
-				Summary(g.Text(logEntry.Kind)),
+				Summary(
+					g.Text(logEntry.Kind),
+					renderEventMetadata(logEntry),
+				),
 				renderEventFields(logEntry.Payload),
 			),
 		),
 	)
 }
 
+func renderEventMetadata(logEntry *EventLogEntry) g.Node {
+	metadata := []g.Node{}
+	for _, field := range []struct{ label, value string }{
+		{"", logEntry.RecordedAt},
+		{"by ", logEntry.Actor},
+		{"via ", logEntry.Origin},
+		{"request ", logEntry.CorrelationID},
+		{"caused by #", logEntry.CausationID},
+	} {
+		if field.value != "" {
+			metadata = append(metadata, Span(Class("ml-1"), g.Text(field.label+field.value)))
+		}
+	}
+	return Span(Class("text-sm text-gray-400"), g.Group(metadata))
+}
+
 func renderEventFields(payload any) g.Node {
 	fields := []g.Node{}
 	addField := func(f g.Node) {
@@ -29,14 +29,23 @@ type Submission struct {
 	SubmittedAt    time.Time
 	Url            string
 	Title          string
+	BodyHTML       string
 	GeneratedTitle string
 	Hidden         bool
+	Edited         bool
 	VoteCount      int
 	CommentCount   int
-	CanVote        bool
+	ViewerVote     int
+	CanDownvote    bool
+	CanEdit        bool
 	Comments       []Comment
 }
 
+// IsText reports whether s is a discussion thread rather than a link.
+func (s *Submission) IsText() bool {
+	return s.Url == ""
+}
+
 func (s *Submission) Byline() string {
 	if s.GeneratedTitle == "" {
 		return s.Url
@@ -53,6 +62,11 @@ type Comment interface {
 	CommentParentID() string
 	CommentableID() string
 	CommentID() string
+	IsEdited() bool
+	CommentEditable() bool
+	CommentVoteCount() int
+	CommentViewerVote() int
+	CommentDownvotable() bool
 }
 
 type WithChildren interface {
@@ -143,6 +157,13 @@ func SubmissionListItem(submission *Submission, isAdmin bool) g.Node {
 }
 
 func SubmissionListItemLink(s *Submission) g.Node {
+	if s.IsText() {
+		return Div(
+			Class("flex flex-col"),
+			A(Href("/item?id="+s.ItemID), g.Textf("%d. %s", s.Index, s.Title)),
+			Span(Class("text-sm ml-1 text-gray-400"), g.Text("text")),
+		)
+	}
 	itemURL, err := url.Parse(s.Url)
 	if err != nil {
 		itemURL = &url.URL{
@@ -171,7 +192,7 @@ func SubmissionListItemFooter(s *Submission, isAdmin bool) g.Node {
 	return Div(
 		Class("prose max-w-full text-xs"),
 		g.Textf("%d points by %s | ", s.VoteCount, s.Submitter),
-		g.If(s.CanVote, UpvoteButton(s.ItemID)),
+		VoteButtons(s.ItemID, s.ViewerVote, s.CanDownvote),
 		TimeLabel(s.SubmittedAt),
 		g.Text(" | "),
 		A(Href("/item?id="+s.ItemID), g.Textf("%d comments", s.CommentCount)),
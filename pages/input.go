@@ -106,6 +106,29 @@ func InputWithLabel(name, label, kind string, state *FormState, attrs ...g.Node)
 	)
 }
 
+func TextareaWithLabel(name, label string, state *FormState, attrs ...g.Node) g.Node {
+	classes := "block w-full  border-0 py-1.5 text-gray-900 shadow-sm ring-1 ring-inset ring-gray-300 placeholder:text-gray-400 focus:ring-2 focus:ring-inset focus:ring-orange-600 sm:text-sm sm:leading-6"
+	return Div(
+		Label(For(name), Class("block text-sm font-medium leading-6 text-gray-900"),
+			g.Textf(label)),
+		Div(Class("mt-2"),
+			Textarea(append([]g.Node{
+				ID(name),
+				Name(name),
+				c.Classes{
+					classes: !state.HasErrorFor(name),
+					strings.ReplaceAll(classes, "ring-gray-300", "ring-red-500"): state.HasErrorFor(name),
+				},
+				g.Text(state.Values[name]),
+			},
+				attrs...,
+			)...,
+			),
+			g.If(state.HasErrorFor(name), P(Class("text-sm text-red-400"), g.Text(state.ErrorFor(name)))),
+		),
+	)
+}
+
 func InlineText(name string, state *FormState, attrs ...g.Node) g.Node {
 	classes := "block w-full  border-0 py-1.5 text-gray-900 shadow-sm ring-1 ring-inset ring-gray-300 placeholder:text-gray-400 focus:ring-2 focus:ring-inset focus:ring-orange-600 sm:text-sm sm:leading-6"
 	return Div(Class("inline-block flex-auto"),
@@ -114,6 +137,7 @@ func InlineText(name string, state *FormState, attrs ...g.Node) g.Node {
 			Name(name),
 			Type("text"),
 			state.ValueFor(name),
+			g.Text(state.Values[name]),
 			c.Classes{
 				classes: !state.HasErrorFor(name),
 				strings.ReplaceAll(classes, "ring-gray-300", "ring-red-500"): state.HasErrorFor(name),
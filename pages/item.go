@@ -30,15 +30,16 @@ func SubmissionDetail(s *Submission, with SubmissionDetailElement, isAdmin bool)
 		Class("flex flex-col space-y-2"),
 		Div(
 			Class("mb-4"),
-			P(Class("prose"),
-				A(Href(s.Url), g.Text(s.Title)),
-				Span(Class("text-sm ml-1 text-gray-400"),
-					g.Textf("(%s)", s.Url))),
+			SubmissionTitle(s),
+			g.If(s.IsText(), Div(Class("prose text-sm my-2 prose-stone"), g.Raw(s.BodyHTML))),
 			Div(Class("prose text-xs"),
 				g.Textf("%d points by %s | ", s.VoteCount, s.Submitter),
-				g.If(s.CanVote, UpvoteButton(s.ItemID)),
+				VoteButtons(s.ItemID, s.ViewerVote, s.CanDownvote),
 				TimeLabel(s.SubmittedAt),
-				g.Textf(" | %d comments", s.CommentCount)),
+				g.Textf(" | %d comments", s.CommentCount),
+				g.If(s.Edited, g.Text(" | edited")),
+				g.If(s.CanEdit, g.Group([]g.Node{g.Text(" | "), EditTitleLink(s.ItemID)})),
+				g.If(isAdmin && s.Edited, g.Group([]g.Node{g.Text(" | "), EditHistoryLink(s.ItemID)}))),
 			Div(
 				Class("my-2"),
 				g.If(with == WithCommentForm, CommentForm(s.ItemID, NewFormState())),
@@ -51,6 +52,49 @@ func SubmissionDetail(s *Submission, with SubmissionDetailElement, isAdmin bool)
 	)
 }
 
+// SubmissionTitle renders the title of s, linking to its URL unless it is a text submission.
+func SubmissionTitle(s *Submission) g.Node {
+	if s.IsText() {
+		return P(ID("submission-title"), Class("prose"), A(Href("/item?id="+s.ItemID), g.Text(s.Title)))
+	}
+	return P(ID("submission-title"), Class("prose"),
+		A(Href(s.Url), g.Text(s.Title)),
+		Span(Class("text-sm ml-1 text-gray-400"),
+			g.Textf("(%s)", s.Url)))
+}
+
+func EditTitleLink(itemID string) g.Node {
+	return A(
+		hx.Get(href("/submit/title", q{"itemID": itemID})),
+		hx.Swap("outerHTML"),
+		hx.Target("#submission-title"),
+		Href(href("/submit/title", q{"itemID": itemID})),
+		Span(Class("font-bold font-mono"), g.Text("[edit title]")),
+	)
+}
+
+func EditTitleForm(itemID string, state *FormState) g.Node {
+	return Form(
+		ID("submission-title"),
+		hx.Post("/submit/title"),
+		hx.Swap("outerHTML"),
+		Action("/submit/title"), Method("POST"),
+		Div(
+			Class("flex flex-row space-around"),
+			Input(Type("hidden"), Name("itemID"), Value(itemID)),
+			InputWithLabel("title", "Title", "text", state, Required()),
+			InlineSubmitButton("Save"),
+		),
+	)
+}
+
+func EditHistoryLink(itemID string) g.Node {
+	return A(
+		Href(href("/admin/edits", q{"id": itemID})),
+		Span(Class("font-mono font-bold text-red-500"), g.Text("[history]")),
+	)
+}
+
 func CommentParent(itemID string) g.Node {
 	return A(
 		Href("/item?id="+itemID),
@@ -88,9 +132,7 @@ func CommentWithChildren(c Comment, isAdmin bool) g.Node {
 	comment := CommentBlock(c, isAdmin)
 	if hasChildren, ok := c.(WithChildren); ok {
 		children := []g.Node{}
-		childComments := hasChildren.AllChildren()
-		for i := len(childComments) - 1; i >= 0; i-- {
-			child := childComments[i]
+		for _, child := range hasChildren.AllChildren() {
 			if child, ok := child.(Comment); ok {
 				children = append(children, CommentWithChildren(child, isAdmin))
 			}
@@ -109,13 +151,16 @@ func CommentBlock(c Comment, isAdmin bool) g.Node {
 			A(
 				Class("cursor-pointer"),
 				Href(href("/item", q{"id": c.CommentableID()})),
-				g.Textf("%s at ", c.CommentAuthor()),
+				g.Textf("%d points by %s at ", c.CommentVoteCount(), c.CommentAuthor()),
 				TimeLabel(c.WrittenAt())),
+			VoteButtons(c.CommentableID(), c.CommentViewerVote(), c.CommentDownvotable()),
 			CommentParent(c.CommentParentID()),
 			CommentLink(c.CommentableID(), "#"+commentFormTarget),
+			g.If(c.IsEdited(), Span(Class("text-xs mx-1 text-gray-400"), g.Text("(edited)"))),
+			g.If(c.CommentEditable(), CommentEditActions(c.CommentableID(), "#"+commentFormTarget+"-content")),
 			CommentAdminActions(isAdmin, c),
 		),
-		Div(Class("prose text-xs my-1 prose-stone"), g.Raw(c.CommentContent())),
+		Div(ID(commentFormTarget+"-content"), Class("prose text-xs my-1 prose-stone"), g.Raw(c.CommentContent())),
 		Div(ID(commentFormTarget)),
 	)
 }
@@ -127,6 +172,42 @@ func CommentAdminActions(isAdmin bool, c Comment) g.Node {
 			g.Text(" | "),
 			g.If(c.IsHidden(), UnhideCommentButton(c.CommentID())),
 			g.If(!c.IsHidden(), HideCommentButton(c.CommentID())),
+			g.If(c.IsEdited(), EditHistoryLink(c.CommentID())),
 		}),
 	)
 }
+
+// CommentEditActions lets authors edit the comment in place of contentTarget, or delete it.
+func CommentEditActions(itemID string, contentTarget string) g.Node {
+	return g.Group([]g.Node{
+		A(
+			hx.Get(href("/comment/edit", q{"itemID": itemID})),
+			hx.Swap("innerHTML"),
+			hx.Target(contentTarget),
+			Href(href("/item", q{"id": itemID})),
+			Span(Class("text-xs mx-1 font-bold font-mono"), g.Text("[edit]")),
+		),
+		Form(
+			Class("inline"),
+			hx.Post("/comment/delete"),
+			hx.Confirm("Delete this comment?"),
+			Action("/comment/delete"), Method("POST"),
+			Input(Type("hidden"), Name("itemID"), Value(itemID)),
+			Button(Type("submit"), Class("inline text-xs mx-1 font-bold font-mono"), g.Text("[delete]")),
+		),
+	})
+}
+
+func CommentEditForm(itemID string, state *FormState) g.Node {
+	return Form(
+		hx.Post("/comment/edit"),
+		hx.Swap("outerHTML"),
+		Action("/comment/edit"), Method("POST"),
+		Div(
+			Class("flex flex-row space-around"),
+			Input(Type("hidden"), Name("itemID"), Value(itemID)),
+			InlineText("text", state),
+			InlineSubmitButton("Save"),
+		),
+	)
+}
@@ -0,0 +1,65 @@
+package pages
+
+import (
+	g "github.com/maragudk/gomponents"
+
+	. "github.com/maragudk/gomponents/html"
+)
+
+type RankingPreviewEntry struct {
+	ItemID            string
+	Title             string
+	Position          int
+	Score             float64
+	CandidatePosition int
+	CandidateScore    float64
+}
+
+// RankingPolicyPage shows the current ranking policy and lets admins
+// preview the front page under a candidate policy before applying it.
+func RankingPolicyPage(path string, current string, algorithms []string, form *FormState, preview []*RankingPreviewEntry, context *PageData) g.Node {
+	return Page("The Orange Website | Ranking", path, Div(
+		Class("flex min-h-full flex-col justify-center px-6 py-12 lg:px-8"),
+		H2(Class("font-bold mb-2"), g.Textf("Ranking policy: %s", current)),
+		Form(Class("space-y-2 max-w-sm"), Action("/admin/ranking"), Method("GET"),
+			Label(For("algorithm"), Class("block text-sm font-medium leading-6 text-gray-900"), g.Text("Algorithm")),
+			Select(ID("algorithm"), Name("algorithm"), Class("block w-full border-0 py-1.5 ring-1 ring-inset ring-gray-300"),
+				g.Group(g.Map(algorithms, func(algorithm string) g.Node {
+					return Option(Value(algorithm), g.If(form.Values["algorithm"] == algorithm, Selected()), g.Text(algorithm))
+				})),
+			),
+			g.If(form.HasErrorFor("algorithm"), P(Class("text-sm text-red-400"), g.Text(form.ErrorFor("algorithm")))),
+			InputWithLabel("gravity", "Gravity", "text", form),
+			InputWithLabel("decay", "Decay per day", "text", form),
+			InputWithLabel("commentWeight", "Comment weight", "text", form),
+			SubmitButton("Preview"),
+		),
+		g.If(len(preview) > 0, Div(Class("mt-6"),
+			H2(Class("font-bold mb-2"), g.Textf("Top %d under the candidate policy", len(preview))),
+			g.Group(g.Map(preview, renderRankingPreviewEntry)),
+			Form(Class("max-w-sm"), Action("/admin/ranking"), Method("POST"),
+				Input(Type("hidden"), Name("algorithm"), Value(form.Values["algorithm"])),
+				Input(Type("hidden"), Name("gravity"), Value(form.Values["gravity"])),
+				Input(Type("hidden"), Name("decay"), Value(form.Values["decay"])),
+				Input(Type("hidden"), Name("commentWeight"), Value(form.Values["commentWeight"])),
+				SubmitButton("Apply"),
+			),
+		)),
+	), context)
+}
+
+func renderRankingPreviewEntry(entry *RankingPreviewEntry) g.Node {
+	moved := entry.Position - entry.CandidatePosition
+	return Div(Class("font-mono flex flex-row"),
+		Span(Class("min-w-16 mr-2 text-right inline-block"), g.Textf("%d.", entry.CandidatePosition)),
+		Span(Class("min-w-16 mr-2 inline-block"),
+			g.If(moved > 0, Span(Class("text-green-600"), g.Textf("+%d", moved))),
+			g.If(moved < 0, Span(Class("text-red-500"), g.Textf("%d", moved))),
+			g.If(moved == 0, Span(Class("text-gray-400"), g.Text("="))),
+		),
+		Div(
+			A(Class("underline"), Href(href("/item", q{"id": entry.ItemID})), g.Text(entry.Title)),
+			Span(Class("ml-1 text-sm text-gray-400"), g.Textf("was %d. | score %.3f → %.3f", entry.Position, entry.Score, entry.CandidateScore)),
+		),
+	)
+}
@@ -0,0 +1,48 @@
+package pages
+
+import (
+	"fmt"
+
+	g "github.com/maragudk/gomponents"
+
+	. "github.com/maragudk/gomponents/html"
+)
+
+type ReplayFailureEntry struct {
+	CommandID   int
+	CommandName string
+	Handler     string
+	Error       string
+	FailedAt    string
+	Quarantined bool
+}
+
+func ReplayReportPage(path string, failures []*ReplayFailureEntry, quarantined []*ReplayFailureEntry, context *PageData) g.Node {
+	return Page("The Orange Website | Replay Report", path, Div(
+		Class("flex min-h-full flex-col justify-center px-6 py-12 lg:px-8"),
+		H2(Class("font-bold mb-2"), g.Textf("Replay failures since start (%d)", len(failures))),
+		g.Group(g.Map(failures, renderReplayFailure)),
+		H2(Class("font-bold mt-6 mb-2"), g.Textf("Quarantined commands (%d)", len(quarantined))),
+		g.Group(g.Map(quarantined, renderReplayFailure)),
+	), context)
+}
+
+func renderReplayFailure(failure *ReplayFailureEntry) g.Node {
+	handler := failure.Handler
+	if handler == "" {
+		handler = "decoding"
+	}
+	return Div(Class("font-mono flex flex-row"),
+		A(
+			Class("min-w-16 mr-2 text-right inline-block underline"),
+			Href(fmt.Sprintf("/admin/events?after=%d&n=1", failure.CommandID-1)),
+			g.Textf("%d", failure.CommandID),
+		),
+		Div(
+			Span(g.Text(failure.CommandName)),
+			Span(Class("ml-1 text-sm text-gray-400"), g.Text(failure.FailedAt+" in "+handler)),
+			g.If(failure.Quarantined, Span(Class("ml-1 text-sm text-red-500"), g.Text("quarantined"))),
+			Div(Class("text-red-500"), g.Text(failure.Error)),
+		),
+	)
+}
@@ -10,18 +10,29 @@ func SubmitPage(path string, form *FormState, context *PageData) g.Node {
 	return Page("The Orange Website | Submit", path, SubmitForm(form), context)
 }
 
+// SubmitForm renders the form for submitting a link, or a text if the
+// form's kind is "text".
 func SubmitForm(form *FormState) g.Node {
+	isText := form.Values["kind"] == "text"
+	heading, other, otherPath := "Submit a link", "Start a discussion instead", "/submit?kind=text"
+	if isText {
+		heading, other, otherPath = "Start a discussion", "Submit a link instead", "/submit"
+	}
 	return Div(
 		Class("flex min-h-full flex-col justify-center px-6 py-12 lg:px-8"),
 		Div(
 			Class("sm:mx-auto sm:w-full sm:max-w-sm"),
 			H2(Class("mt-10 text-center text-2xl font-bold leading-9 tracking-tight text-gray-900"),
-				g.Textf("Submit a link"))),
+				g.Textf(heading)),
+			P(Class("mt-2 text-center text-sm text-gray-500"),
+				A(Href(otherPath), Class("underline"), g.Text(other)))),
 		Div(
 			Class("mt-10 sm:mx-auto sm:w-full sm:max-w-sm"),
 			Form(Class("space-y-6"), Action("/submit"), Method("POST"),
-				InputWithLabel("url", "URL", "text", form, Required()),
+				g.If(isText, Input(Type("hidden"), Name("kind"), Value("text"))),
+				g.If(!isText, InputWithLabel("url", "URL", "text", form, Required())),
 				InputWithLabel("title", "Title", "text", form, Required()),
+				g.If(isText, TextareaWithLabel("text", "Text", form, Required(), Rows("10"))),
 				SubmitButton("Submit"),
 			),
 		),
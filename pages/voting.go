@@ -7,16 +7,33 @@ import (
 	. "github.com/maragudk/gomponents/html"
 )
 
-func VotedIcon() g.Node {
-	return Span(Class("inline mx-1 font-mono"), g.Text("[Voted +1]"))
+func VotedIcon(vote int) g.Node {
+	return Span(Class("inline mx-1 font-mono"), g.Textf("[Voted %+d]", vote))
 }
-func UpvoteButton(itemID string) g.Node {
+
+// VoteButtons lets the viewer vote on itemID, or take back the vote they cast.
+func VoteButtons(itemID string, vote int, canDownvote bool) g.Node {
+	return Span(
+		Class("inline votes"),
+		g.If(vote == 0, g.Group([]g.Node{
+			VoteButton("/upvote", itemID, "[Upvote]"),
+			g.If(canDownvote, VoteButton("/downvote", itemID, "[Downvote]")),
+		})),
+		g.If(vote != 0, g.Group([]g.Node{
+			VotedIcon(vote),
+			VoteButton("/unvote", itemID, "[Unvote]"),
+		})),
+	)
+}
+
+func VoteButton(action string, itemID string, label string) g.Node {
 	return Form(
 		Class("inline"),
 		hx.Boost("true"),
-		hx.Target("this"),
+		hx.Target("closest .votes"),
+		hx.Swap("outerHTML"),
 		hx.PushURL("false"),
-		Action("/upvote"),
+		Action(action),
 		Method("POST"),
 		Input(
 			Type("hidden"),
@@ -26,7 +43,7 @@ func UpvoteButton(itemID string) g.Node {
 		Button(
 			Class("inline font-mono mx-1"),
 			Type("submit"),
-			g.Text("[Upvote]"),
+			g.Text(label),
 		),
 	)
 }
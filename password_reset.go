@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -11,22 +11,38 @@ import (
 
 type PasswordResetController struct {
 	BaseUrl         string
-	Logger          *log.Logger
+	Logger          *slog.Logger
 	App             *App
 	PendingRequests map[string]string
 	Version         int
+
+	// pendingCauses contains the command that caused each pending request, by email.
+	pendingCauses map[string]*PersistedCommand
+	// handling is the command currently being handled by catchUp.
+	handling *PersistedCommand
+	progress Progress
 }
 
-func NewPasswordResetController(app *App, logger *log.Logger, baseUrl string) *PasswordResetController {
+func NewPasswordResetController(app *App, logger *slog.Logger, baseUrl string) *PasswordResetController {
 	return &PasswordResetController{
 		BaseUrl:         baseUrl,
 		Logger:          logger,
 		App:             app,
 		PendingRequests: make(map[string]string),
 		Version:         0,
+		pendingCauses:   make(map[string]*PersistedCommand),
 	}
 }
 
+func (p *PasswordResetController) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "password-reset",
+		Consumes: []string{"RequestPasswordReset", "ResetPassword", "QueueEmail"},
+	}
+}
+
+func (p *PasswordResetController) Progress() *Progress { return &p.progress }
+
 func (p *PasswordResetController) HandleCommand(command Command, from time.Time) error {
 	switch c := command.(type) {
 	case *RequestPasswordReset:
@@ -41,35 +57,35 @@ func (p *PasswordResetController) HandleCommand(command Command, from time.Time)
 }
 
 func (p *PasswordResetController) Start() func() {
-	stop := make(chan struct{})
+	wake, stopWaiting := p.App.WaitForCommands()
 	p.catchUp()
-	go p.loop(stop)
-	return func() { close(stop) }
+	stopLoop := startLoop(func(stop <-chan struct{}) { p.loop(stop, wake) })
+	return func() { stopWaiting(); stopLoop() }
 }
 
 func (p *PasswordResetController) catchUp() {
 	commands, err := p.App.Commands.After(p.Version)
 	if err != nil {
-		p.Logger.Printf("failed to fetch commands: %v", err)
+		p.Logger.Error("failed to fetch commands", "version", p.Version, "error", err)
 		return
 	}
 
-	from := time.Now().Add(time.Duration(-1) * time.Hour)
+	from := time.Now().Add(-EmailRequestLifetime)
 	for c := range commands {
+		p.handling = c
 		p.HandleCommand(c.Message, from)
 		p.Version = c.ID
 	}
+	p.handling = nil
+	p.progress.Record(p.Version, time.Now())
 }
 
-func (p *PasswordResetController) loop(stop <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	tick := ticker.C
+func (p *PasswordResetController) loop(stop <-chan struct{}, wake <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
 			return
-		case <-tick:
+		case <-wake:
 			p.catchUp()
 			p.handlePasswordReset()
 		}
@@ -79,6 +95,7 @@ func (p *PasswordResetController) loop(stop <-chan struct{}) {
 func (p *PasswordResetController) addPendingRequest(c *RequestPasswordReset, from time.Time) error {
 	if c.RequestedAt.After(from) {
 		p.PendingRequests[c.Email] = c.Token
+		p.pendingCauses[c.Email] = p.handling
 	}
 	return nil
 }
@@ -87,6 +104,7 @@ func (p *PasswordResetController) removePendingRequest(token string) error {
 	for email, t := range p.PendingRequests {
 		if t == token {
 			delete(p.PendingRequests, email)
+			delete(p.pendingCauses, email)
 			return nil
 		}
 	}
@@ -123,13 +141,14 @@ func (p *PasswordResetController) handlePasswordReset() error {
 					"action_url": fmt.Sprintf("%s/reset-password/%s", p.BaseUrl, token),
 				},
 			}
-			p.App.HandleCommand(&queueEmail)
+			p.App.HandleCommandWithMetadata(&queueEmail, CausedBy(p.pendingCauses[email], "password-reset"))
 		}
 		toBeRemoved = append(toBeRemoved, email)
 	}
 
 	for _, email := range toBeRemoved {
 		delete(p.PendingRequests, email)
+		delete(p.pendingCauses, email)
 	}
 
 	return nil
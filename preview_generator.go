@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"net/url"
 	"time"
 
@@ -9,14 +9,16 @@ import (
 )
 
 type PreviewGenerator struct {
-	Logger            *log.Logger
+	Logger            *slog.Logger
 	App               *App
 	Commands          CommandLog
 	GeneratedPreviews map[string]time.Time
 	Version           int
+
+	progress Progress
 }
 
-func NewPreviewGenerator(app *App, commands CommandLog, logger *log.Logger) *PreviewGenerator {
+func NewPreviewGenerator(app *App, commands CommandLog, logger *slog.Logger) *PreviewGenerator {
 	return &PreviewGenerator{
 		Logger:            logger,
 		App:               app,
@@ -26,6 +28,15 @@ func NewPreviewGenerator(app *App, commands CommandLog, logger *log.Logger) *Pre
 	}
 }
 
+func (p *PreviewGenerator) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "preview",
+		Consumes: []string{"PostLink", "SetSubmissionPreview"},
+	}
+}
+
+func (p *PreviewGenerator) Progress() *Progress { return &p.progress }
+
 func (p *PreviewGenerator) HandleCommand(command Command) error {
 	switch c := command.(type) {
 	case *SetSubmissionPreview:
@@ -52,31 +63,30 @@ func (p *PreviewGenerator) shouldRegenerateFor(itemID string) bool {
 }
 
 func (p *PreviewGenerator) Start() func() {
-	stop := make(chan struct{})
+	wake, stopWaiting := p.App.WaitForCommands()
 
 	commands, err := p.Commands.After(0)
 	if err != nil {
-		p.Logger.Printf("failed to fetch commands: %v", err)
+		p.Logger.Error("failed to fetch commands", "version", 0, "error", err)
+		stopWaiting()
 		return func() {}
 	}
 	for command := range commands {
 		p.HandleCommand(command.Message)
 		p.Version = command.ID
 	}
-	p.Logger.Printf("PreviewGenerator started at version %d", p.Version)
-	go p.loop(stop)
-	return func() { close(stop) }
+	p.progress.Record(p.Version, time.Now())
+	p.Logger.Info("started", "version", p.Version)
+	stopLoop := startLoop(func(stop <-chan struct{}) { p.loop(stop, wake) })
+	return func() { stopWaiting(); stopLoop() }
 }
 
-func (p *PreviewGenerator) loop(stop <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	tick := ticker.C
+func (p *PreviewGenerator) loop(stop <-chan struct{}, wake <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
 			return
-		case <-tick:
+		case <-wake:
 			p.fetchPreviews()
 		}
 	}
@@ -85,31 +95,34 @@ func (p *PreviewGenerator) loop(stop <-chan struct{}) {
 func (p *PreviewGenerator) fetchPreviews() {
 	commands, err := p.Commands.After(p.Version)
 	if err != nil {
-		p.Logger.Printf("failed to fetch commands: %v", err)
+		p.Logger.Error("failed to fetch commands", "version", p.Version, "error", err)
 		return
 	}
 	for command := range commands {
 		if postLink, ok := command.Message.(*PostLink); ok {
 			if p.shouldRegenerateFor(postLink.ItemID) {
-				p.fetchPreview(postLink.ItemID, postLink.Url)
+				p.fetchPreview(postLink.ItemID, postLink.Url, command)
 			}
 		}
 		p.Version = command.ID
 	}
+	p.progress.Record(p.Version, time.Now())
 }
 
-func (p *PreviewGenerator) fetchPreview(itemID string, submissionURL string) {
+func (p *PreviewGenerator) fetchPreview(itemID string, submissionURL string, cause *PersistedCommand) {
 	_, err := url.Parse(submissionURL)
 	if err != nil {
-		p.Logger.Printf("Invalid url %q: %s", submissionURL, err)
+		p.Logger.Warn("invalid url", "item_id", itemID, "url", submissionURL, "error", err)
 		return
 	}
 
 	result, err := recon.Parse(submissionURL)
 	if err != nil {
-		p.Logger.Printf("fetchPreview(%q): %s", submissionURL, err)
+		p.App.Metrics.Count("orange_previews_fetched_total", "Previews fetched, by whether fetching succeeded.", "result", "failed")
+		p.Logger.Warn("failed to fetch preview", "item_id", itemID, "url", submissionURL, "error", err)
 		return
 	}
+	p.App.Metrics.Count("orange_previews_fetched_total", "Previews fetched, by whether fetching succeeded.", "result", "fetched")
 
 	var imageURL *string = nil
 	if len(result.Images) > 0 {
@@ -120,7 +133,7 @@ func (p *PreviewGenerator) fetchPreview(itemID string, submissionURL string) {
 		ExtractedTitle: result.Title,
 		ImageURL:       imageURL,
 	}
-	if err := p.App.HandleCommand(setPreview); err != nil {
-		p.Logger.Printf("fetchPreview(%q): %s", submissionURL, err)
+	if err := p.App.HandleCommandWithMetadata(setPreview, CausedBy(cause, "preview-generator")); err != nil {
+		p.Logger.Error("failed to set preview", "item_id", itemID, "url", submissionURL, "error", err)
 	}
 }
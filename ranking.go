@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	RankingDecay   = "decay"
+	RankingGravity = "gravity"
+	RankingNewest  = "newest"
+)
+
+// DefaultGravity is the gravity used by Hacker News.
+const DefaultGravity = 1.8
+
+var ErrInvalidRankingPolicy = errors.New("invalid ranking policy")
+
+// RankingPolicy decides the order of submissions on the front page.
+//
+// Which parameters are used depends on the algorithm.
+type RankingPolicy struct {
+	Algorithm string
+	// Gravity is the exponent of the age in hours of a submission dividing its points.
+	Gravity float64
+	// Decay is the factor by which the points of a submission are multiplied per day of age.
+	Decay float64
+	// CommentWeight is the number of points a comment is worth, compared to a vote.
+	CommentWeight float64
+}
+
+// DefaultRankingPolicy multiplies points by 0.9 for every day passed.
+var DefaultRankingPolicy = RankingPolicy{Algorithm: RankingDecay, Decay: 0.9, CommentWeight: 0.5}
+
+func NewDefaultRankingPolicy() *RankingPolicy {
+	policy := DefaultRankingPolicy
+	return &policy
+}
+
+// Ranking scores submissions, ordering those with higher scores first.
+type Ranking interface {
+	// Score returns the score of a submission at the time now.
+	Score(votes, comments int, submittedAt, now time.Time) float64
+}
+
+// StableRanking is implemented by rankings under which the order of two
+// submissions does not change as time passes, so that a rank can be
+// stored for every submission instead of scoring all of them again.
+type StableRanking interface {
+	Ranking
+	// Rank returns values ordering submissions like Score does: those in
+	// a higher group come first, and within a group those of higher rank.
+	Rank(votes, comments int, submittedAt time.Time) (group int, rank float64)
+}
+
+// RankingAlgorithms creates the ranking for a policy by the name of its algorithm.
+var RankingAlgorithms = map[string]func(policy *RankingPolicy) Ranking{
+	RankingDecay: func(policy *RankingPolicy) Ranking {
+		return &decayRanking{decay: policy.Decay, commentWeight: policy.CommentWeight}
+	},
+	RankingGravity: func(policy *RankingPolicy) Ranking {
+		return &gravityRanking{gravity: policy.Gravity, commentWeight: policy.CommentWeight}
+	},
+	RankingNewest: func(policy *RankingPolicy) Ranking {
+		return newestRanking{}
+	},
+}
+
+// Validate checks that the policy names a known algorithm and that the parameters it uses make sense.
+func (p *RankingPolicy) Validate() error {
+	if _, ok := RankingAlgorithms[p.Algorithm]; !ok {
+		return fmt.Errorf("unknown algorithm %q: %w", p.Algorithm, ErrInvalidRankingPolicy)
+	}
+	if p.CommentWeight < 0 {
+		return fmt.Errorf("comment weight must not be negative: %w", ErrInvalidRankingPolicy)
+	}
+	if p.Algorithm == RankingDecay && (p.Decay <= 0 || p.Decay > 1) {
+		return fmt.Errorf("decay must be greater than 0 and at most 1: %w", ErrInvalidRankingPolicy)
+	}
+	if p.Algorithm == RankingGravity && p.Gravity <= 0 {
+		return fmt.Errorf("gravity must be positive: %w", ErrInvalidRankingPolicy)
+	}
+	return nil
+}
+
+// Ranking returns the ranking described by the policy, or the default one if the policy is invalid.
+func (p *RankingPolicy) Ranking() Ranking {
+	if p == nil || p.Validate() != nil {
+		p = &DefaultRankingPolicy
+	}
+	return RankingAlgorithms[p.Algorithm](p)
+}
+
+// ParseRankingPolicy reads a policy from the parameters algorithm,
+// gravity, decay and commentWeight, using default values for missing ones.
+func ParseRankingPolicy(params Parameters) (*RankingPolicy, error) {
+	policy := NewDefaultRankingPolicy()
+	policy.Gravity = DefaultGravity
+	if algorithm := params.Get("algorithm"); algorithm != "" {
+		policy.Algorithm = algorithm
+	}
+	for name, dest := range map[string]*float64{
+		"gravity":       &policy.Gravity,
+		"decay":         &policy.Decay,
+		"commentWeight": &policy.CommentWeight,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		*dest = f
+	}
+	return policy, policy.Validate()
+}
+
+func (p *RankingPolicy) String() string {
+	switch p.Algorithm {
+	case RankingDecay:
+		return fmt.Sprintf("%s (decay %g, comment weight %g)", p.Algorithm, p.Decay, p.CommentWeight)
+	case RankingGravity:
+		return fmt.Sprintf("%s (gravity %g, comment weight %g)", p.Algorithm, p.Gravity, p.CommentWeight)
+	}
+	return p.Algorithm
+}
+
+// decayRanking scores a submission by (votes + comments*commentWeight) * decay^days.
+type decayRanking struct {
+	decay         float64
+	commentWeight float64
+}
+
+func (r *decayRanking) points(votes, comments int) float64 {
+	return float64(votes) + float64(comments)*r.commentWeight
+}
+
+func (r *decayRanking) Score(votes, comments int, submittedAt, now time.Time) float64 {
+	age := float64(now.Sub(submittedAt)) / float64(24*time.Hour)
+	return r.points(votes, comments) * math.Pow(r.decay, age)
+}
+
+// Rank groups submissions by the sign of their points, which decay does
+// not change.  Within a group, it takes the logarithm of the magnitude of
+// the score without the factor common to all submissions, which leaves
+// log(|points|) - log(decay)*days since the epoch; negative scores closer
+// to zero come first, so their rank is negated.
+func (r *decayRanking) Rank(votes, comments int, submittedAt time.Time) (int, float64) {
+	points := r.points(votes, comments)
+	if points == 0 {
+		return 0, 0
+	}
+	days := float64(submittedAt.Unix()) / float64(24*60*60)
+	magnitude := math.Log(math.Abs(points)) - math.Log(r.decay)*days
+	if points < 0 {
+		return -1, -magnitude
+	}
+	return 1, magnitude
+}
+
+// gravityRanking scores a submission like Hacker News does, by
+// (votes + comments*commentWeight) / (hours + 2)^gravity.
+//
+// Older submissions lose points faster than newer ones, so the order of
+// submissions changes as time passes.  It is therefore no StableRanking:
+// content states keep no index for it and score and sort all submissions
+// every time the front page is read.
+type gravityRanking struct {
+	gravity       float64
+	commentWeight float64
+}
+
+func (r *gravityRanking) Score(votes, comments int, submittedAt, now time.Time) float64 {
+	points := float64(votes) + float64(comments)*r.commentWeight
+	hours := math.Max(now.Sub(submittedAt).Hours(), 0)
+	return points / math.Pow(hours+2, r.gravity)
+}
+
+// newestRanking shows the most recent submissions first.
+type newestRanking struct{}
+
+func (newestRanking) Score(votes, comments int, submittedAt, now time.Time) float64 {
+	return -now.Sub(submittedAt).Hours()
+}
+
+func (newestRanking) Rank(votes, comments int, submittedAt time.Time) (int, float64) {
+	return 0, float64(submittedAt.Unix())
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	rankingIndexMaxLevel = 24
+	// rankingIndexP is the chance of a node to reach the next level.
+	rankingIndexP = 0.25
+)
+
+// rankingKey orders submissions under a StableRanking.
+//
+// Submissions of a higher group come first, and within a group those of
+// higher rank.  Submissions of equal rank follow newest first, like they
+// do in sqlite.
+type rankingKey struct {
+	itemID      string
+	group       int
+	rank        float64
+	submittedAt time.Time
+}
+
+func (a rankingKey) less(b rankingKey) bool {
+	if a.group != b.group {
+		return a.group > b.group
+	}
+	if a.rank != b.rank {
+		return a.rank > b.rank
+	}
+	if !a.submittedAt.Equal(b.submittedAt) {
+		return a.submittedAt.After(b.submittedAt)
+	}
+	return strings.Compare(a.itemID, b.itemID) < 0
+}
+
+type rankingNode struct {
+	key  rankingKey
+	next []*rankingNode
+	// span[i] is the number of positions next[i] is ahead of this node.
+	span []int
+}
+
+// rankingIndex is a skip list of submissions in front page order.
+//
+// Inserting, removing and finding the submission at a position all take
+// O(log n), so a vote only moves the submission it was cast on.
+type rankingIndex struct {
+	head   *rankingNode
+	level  int
+	length int
+	byID   map[string]rankingKey
+	random *rand.Rand
+}
+
+func newRankingIndex() *rankingIndex {
+	return &rankingIndex{
+		head: &rankingNode{
+			next: make([]*rankingNode, rankingIndexMaxLevel),
+			span: make([]int, rankingIndexMaxLevel),
+		},
+		level: 1,
+		byID:  map[string]rankingKey{},
+		// A fixed seed keeps the shape of the list the same on every replay.
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (self *rankingIndex) Len() int {
+	return self.length
+}
+
+func (self *rankingIndex) randomLevel() int {
+	level := 1
+	for level < rankingIndexMaxLevel && self.random.Float64() < rankingIndexP {
+		level++
+	}
+	return level
+}
+
+// Put inserts key, replacing the key of the same item if there is one.
+func (self *rankingIndex) Put(key rankingKey) {
+	if existing, ok := self.byID[key.itemID]; ok {
+		if existing == key {
+			return
+		}
+		self.remove(existing)
+	}
+	self.byID[key.itemID] = key
+
+	var update [rankingIndexMaxLevel]*rankingNode
+	var position [rankingIndexMaxLevel]int
+	node := self.head
+	for i := self.level - 1; i >= 0; i-- {
+		if i < self.level-1 {
+			position[i] = position[i+1]
+		}
+		for node.next[i] != nil && node.next[i].key.less(key) {
+			position[i] += node.span[i]
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	level := self.randomLevel()
+	if level > self.level {
+		for i := self.level; i < level; i++ {
+			update[i] = self.head
+			self.head.span[i] = self.length
+		}
+		self.level = level
+	}
+
+	inserted := &rankingNode{key: key, next: make([]*rankingNode, level), span: make([]int, level)}
+	for i := 0; i < level; i++ {
+		inserted.next[i] = update[i].next[i]
+		update[i].next[i] = inserted
+		inserted.span[i] = update[i].span[i] - (position[0] - position[i])
+		update[i].span[i] = position[0] - position[i] + 1
+	}
+	for i := level; i < self.level; i++ {
+		update[i].span[i]++
+	}
+	self.length++
+}
+
+// Remove removes the item identified by itemID, if it is in the index.
+func (self *rankingIndex) Remove(itemID string) {
+	if key, ok := self.byID[itemID]; ok {
+		self.remove(key)
+		delete(self.byID, itemID)
+	}
+}
+
+func (self *rankingIndex) remove(key rankingKey) {
+	var update [rankingIndexMaxLevel]*rankingNode
+	node := self.head
+	for i := self.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key.less(key) {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	removed := node.next[0]
+	if removed == nil || removed.key != key {
+		return
+	}
+
+	for i := 0; i < self.level; i++ {
+		if update[i].next[i] == removed {
+			update[i].span[i] += removed.span[i] - 1
+			update[i].next[i] = removed.next[i]
+		} else {
+			update[i].span[i]--
+		}
+	}
+	for self.level > 1 && self.head.next[self.level-1] == nil {
+		self.level--
+	}
+	self.length--
+}
+
+// Range returns the item IDs of at most n items, skipping the first after ones.
+func (self *rankingIndex) Range(after, n int) []string {
+	if after < 0 {
+		after = 0
+	}
+	if n <= 0 || after >= self.length {
+		return []string{}
+	}
+
+	// Descend to the node at position after, counting from 1.
+	node := self.head
+	traversed := 0
+	for i := self.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && traversed+node.span[i] <= after+1 {
+			traversed += node.span[i]
+			node = node.next[i]
+		}
+	}
+
+	result := make([]string, 0, min(n, self.length-after))
+	for ; node != nil && len(result) < n; node = node.next[0] {
+		result = append(result, node.key.itemID)
+	}
+	return result
+}
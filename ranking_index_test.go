@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_RankingIndex_KeepsItemsInOrder(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	index := newRankingIndex()
+	expected := map[string]rankingKey{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5000; i++ {
+		itemID := fmt.Sprintf("item-%d", random.Intn(500))
+		if random.Intn(5) == 0 {
+			index.Remove(itemID)
+			delete(expected, itemID)
+		} else {
+			key := rankingKey{
+				itemID:      itemID,
+				group:       random.Intn(3) - 1,
+				rank:        float64(random.Intn(20)),
+				submittedAt: start.Add(time.Duration(random.Intn(100)) * time.Hour),
+			}
+			index.Put(key)
+			expected[itemID] = key
+		}
+	}
+
+	sorted := []rankingKey{}
+	for _, key := range expected {
+		sorted = append(sorted, key)
+	}
+	slices.SortFunc(sorted, func(a, b rankingKey) int {
+		if a.less(b) {
+			return -1
+		}
+		return 1
+	})
+	if act, exp := index.Len(), len(sorted); act != exp {
+		t.Fatalf("expected %d items, got %d", exp, act)
+	}
+	for _, window := range [][2]int{{0, 10}, {0, len(sorted)}, {17, 30}, {len(sorted) - 5, 10}, {len(sorted), 10}} {
+		after, n := window[0], window[1]
+		act := index.Range(after, n)
+		exp := []string{}
+		for _, key := range sorted[after:min(after+n, len(sorted))] {
+			exp = append(exp, key.itemID)
+		}
+		if !slices.Equal(act, exp) {
+			t.Fatalf("expected %d items after %d to be %v, got %v", n, after, exp, act)
+		}
+	}
+}
+
+func Test_InMemoryContentState_RanksSubmissionsIncrementally(t *testing.T) {
+	state := NewInMemoryContentState()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		submission := &Submission{ItemID: fmt.Sprintf("item-%d", i), SubmittedAt: start.Add(time.Duration(i) * time.Hour)}
+		if err := state.PutSubmission(submission); err != nil {
+			t.Fatalf("failed to put submission: %s", err)
+		}
+	}
+	frontpage := func() []string {
+		top, err := state.TopNSubmissions(10, 0)
+		if err != nil {
+			t.Fatalf("failed to get front page: %s", err)
+		}
+		result := []string{}
+		for _, s := range top {
+			result = append(result, s.ItemID)
+		}
+		return result
+	}
+
+	if act, exp := frontpage(), []string{"item-2", "item-1", "item-0"}; !slices.Equal(act, exp) {
+		t.Fatalf("expected unvoted submissions newest first, got %v", act)
+	}
+	state.RecordVote(&Vote{By: "alice", For: "item-0", Direction: Upvote})
+	if act, exp := frontpage(), []string{"item-0", "item-2", "item-1"}; !slices.Equal(act, exp) {
+		t.Fatalf("expected voted submission first, got %v", act)
+	}
+	state.RecordVote(&Vote{By: "alice", For: "item-0", Direction: NoVote})
+	if act, exp := frontpage(), []string{"item-2", "item-1", "item-0"}; !slices.Equal(act, exp) {
+		t.Fatalf("expected retracted vote to restore the order, got %v", act)
+	}
+}
+
+func Test_InMemoryContentState_IndexesOnlyStableRankings(t *testing.T) {
+	policies := map[string]RankingPolicy{
+		RankingDecay:   DefaultRankingPolicy,
+		RankingNewest:  {Algorithm: RankingNewest},
+		RankingGravity: {Algorithm: RankingGravity, Gravity: DefaultGravity},
+	}
+	indexed := map[string]bool{RankingDecay: true, RankingNewest: true, RankingGravity: false}
+	for algorithm, policy := range policies {
+		t.Run(algorithm, func(t *testing.T) {
+			state := NewInMemoryContentState()
+			if err := state.PutRankingPolicy(&policy); err != nil {
+				t.Fatalf("failed to put ranking policy: %s", err)
+			}
+			if act, exp := state.frontpage != nil, indexed[algorithm]; act != exp {
+				t.Fatalf("expected front page index %v, got %v", exp, act)
+			}
+			if _, act := policy.Ranking().(StableRanking); act != indexed[algorithm] {
+				t.Fatalf("expected StableRanking %v, got %v", indexed[algorithm], act)
+			}
+		})
+	}
+}
+
+func BenchmarkInMemoryContentState_TopNSubmissions(b *testing.B) {
+	state := NewInMemoryContentState()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100_000; i++ {
+		itemID := fmt.Sprintf("item-%d", i)
+		state.PutSubmission(&Submission{ItemID: itemID, SubmittedAt: start.Add(time.Duration(i) * time.Minute)})
+		for j := 0; j < i%7; j++ {
+			state.RecordVote(&Vote{By: fmt.Sprintf("voter-%d", j), For: itemID, Direction: Upvote})
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := state.TopNSubmissions(10, 50_000); err != nil {
+			b.Fatalf("failed to get front page: %s", err)
+		}
+	}
+}
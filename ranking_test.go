@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (t *TestContext) setRankingPolicy(policy RankingPolicy) Command {
+	return &SetRankingPolicy{
+		Algorithm:     policy.Algorithm,
+		Gravity:       policy.Gravity,
+		Decay:         policy.Decay,
+		CommentWeight: policy.CommentWeight,
+		SetAt:         time.Now(),
+	}
+}
+
+// postOldAndNew posts a link two days old with ten votes, and a new one with a single vote.
+func postOldAndNew(scenario *TestContext) {
+	old := scenario.postLink("https://err.ee", "old")
+	old.SubmittedAt = old.SubmittedAt.Add(-48 * time.Hour)
+	scenario.must(old)
+	scenario.upvoteN(old.ItemID, 10)
+	scenario.must(scenario.postLink("https://err.ee", "new"))
+	scenario.upvoteN(scenario.PostIDs[1], 1)
+}
+
+func Test_SetRankingPolicy_ReordersFrontpage(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		postOldAndNew(scenario)
+		if first := scenario.frontpage()[0].Title; first != "old" {
+			t.Fatalf("expected upvoted submission first under the default policy, got %q", first)
+		}
+
+		scenario.must(scenario.setRankingPolicy(RankingPolicy{Algorithm: RankingGravity, Gravity: DefaultGravity}))
+		if first := scenario.frontpage()[0].Title; first != "new" {
+			t.Fatalf("expected gravity to favor the new submission, got %q", first)
+		}
+
+		scenario.must(scenario.setRankingPolicy(DefaultRankingPolicy))
+		scenario.must(scenario.setRankingPolicy(RankingPolicy{Algorithm: RankingNewest}))
+		if first := scenario.frontpage()[0].Title; first != "new" {
+			t.Fatalf("expected newest submission first, got %q", first)
+		}
+	})
+}
+
+func Test_SetRankingPolicy_RejectsInvalidPolicies(t *testing.T) {
+	scenario := setup(t)
+	for _, policy := range []RankingPolicy{
+		{Algorithm: "random"},
+		{Algorithm: RankingGravity},
+		{Algorithm: RankingDecay, Decay: 1.5},
+		{Algorithm: RankingNewest, CommentWeight: -1},
+	} {
+		scenario.mustFailWith(scenario.setRankingPolicy(policy), ErrInvalidRankingPolicy)
+	}
+}
+
+func Test_PreviewRankingPolicy_DoesNotChangeFrontpage(t *testing.T) {
+	withEachContentBackend(t, func(t *testing.T, scenario *TestContext) {
+		postOldAndNew(scenario)
+
+		q := NewPreviewRankingPolicy(&RankingPolicy{Algorithm: RankingNewest}, time.Now())
+		if err := scenario.App.HandleQuery(q); err != nil {
+			t.Fatal(err)
+		}
+		if len(q.Entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(q.Entries))
+		}
+		first := q.Entries[0]
+		if first.Submission.Title != "new" || first.Position != 2 || first.CandidatePosition != 1 {
+			t.Fatalf("expected new submission to move up, got %#v", first)
+		}
+		if q.Current.Algorithm != RankingDecay {
+			t.Fatalf("expected current policy to be reported, got %v", q.Current)
+		}
+		if title := scenario.frontpage()[0].Title; title != "old" {
+			t.Fatalf("expected preview to leave frontpage untouched, got %q first", title)
+		}
+
+		invalid := NewPreviewRankingPolicy(&RankingPolicy{Algorithm: "random"}, time.Now())
+		if err := scenario.App.HandleQuery(invalid); !errors.Is(err, ErrInvalidRankingPolicy) {
+			t.Fatalf("expected %v, got %v", ErrInvalidRankingPolicy, err)
+		}
+	})
+}
+
+func Test_ParseRankingPolicy_UsesDefaultsForMissingParameters(t *testing.T) {
+	policy, err := ParseRankingPolicy(Dict{"algorithm": RankingGravity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Gravity != DefaultGravity || policy.CommentWeight != DefaultRankingPolicy.CommentWeight {
+		t.Fatalf("expected default parameters, got %v", policy)
+	}
+	if _, err := ParseRankingPolicy(Dict{"decay": "lots"}); err == nil {
+		t.Fatalf("expected malformed decay to be rejected")
+	}
+}
+
+func Test_DecayRanking_OrdersNegativePointsByScore(t *testing.T) {
+	for name, contentStore := range contentBackends {
+		t.Run(name, func(t *testing.T) {
+			config := NewPlatformConfigForTest()
+			config.ContentStore = contentStore(t)
+			state := config.NewContentState()
+			MustSetup(state)
+			t.Cleanup(func() {
+				if closer, ok := state.(interface{ Close() error }); ok {
+					closer.Close()
+				}
+			})
+
+			old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			recent := old.Add(48 * time.Hour)
+			votes := map[string][]VoteDirection{
+				"upvoted":          {Upvote},
+				"old":              {},
+				"recent":           {},
+				"old downvoted":    {Downvote},
+				"recent downvoted": {Downvote},
+				"twice downvoted":  {Downvote, Downvote},
+			}
+			for _, itemID := range []string{"old", "old downvoted", "recent", "recent downvoted", "twice downvoted", "upvoted"} {
+				submittedAt := recent
+				if strings.HasPrefix(itemID, "old") {
+					submittedAt = old
+				}
+				if err := state.PutSubmission(&Submission{ItemID: itemID, SubmittedAt: submittedAt}); err != nil {
+					t.Fatalf("failed to put submission: %s", err)
+				}
+				for i, direction := range votes[itemID] {
+					if err := state.RecordVote(&Vote{By: fmt.Sprintf("voter-%d", i), For: itemID, Direction: direction}); err != nil {
+						t.Fatalf("failed to record vote: %s", err)
+					}
+				}
+			}
+
+			top, err := state.TopNSubmissions(10, 0)
+			if err != nil {
+				t.Fatalf("failed to get front page: %s", err)
+			}
+			act := []string{}
+			for _, s := range top {
+				act = append(act, s.ItemID)
+			}
+			// Decay brings the negative score of the older submission closer to zero.
+			exp := []string{"upvoted", "recent", "old", "old downvoted", "recent downvoted", "twice downvoted"}
+			if !slices.Equal(act, exp) {
+				t.Fatalf("expected front page %v, got %v", exp, act)
+			}
+		})
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"bufio"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"strings"
 
@@ -16,25 +17,27 @@ import (
 //go:embed repl.js
 var prelude string
 
-func replServer(shell *Shell, logger *log.Logger) {
+// replServer evaluates JavaScript sent by connections accepted by
+// listener, until listener is closed.
+func replServer(shell *Shell, listener net.Listener, logger *slog.Logger) error {
 	vm := goja.New()
 	globals := map[string]any{}
 	shell.App.ExposeState(globals)
-	vm.Set("shell", shell)
+	replShell := *shell
+	replShell.Origin = "repl"
+	vm.Set("shell", &replShell)
 	vm.Set("g", globals)
 	vm.RunString(prelude)
-	listener, err := net.Listen("tcp", "127.0.0.1:8088")
-	if err != nil {
-		logger.Printf("failed to listen: %s\n", err)
-		return
-	}
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
-			logger.Printf("failed to accept: %s\n", err)
+			logger.Error("failed to accept", "error", err)
 			continue
 		}
-		logger.Printf("accepted connection from %s\n", conn.RemoteAddr())
+		logger.Info("accepted connection", "remote_addr", conn.RemoteAddr().String())
 		go repl(vm, conn)
 	}
 }
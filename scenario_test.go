@@ -54,6 +54,18 @@ func (t *TestContext) postLink(url, title string) *PostLink {
 	}
 }
 
+func (t *TestContext) postText(title, body string) *PostText {
+	itemID := fmt.Sprintf("post-%d", len(t.PostIDs)+1)
+	t.PostIDs = append(t.PostIDs, itemID)
+	return &PostText{
+		ItemID:      itemID,
+		Submitter:   t.Submitter,
+		Title:       title,
+		Body:        body,
+		SubmittedAt: time.Now(),
+	}
+}
+
 func (t *TestContext) commentOn(itemID string, content string) Command {
 	return &PostComment{
 		ParentID: NewTreeID(itemID),
@@ -258,7 +270,10 @@ func (t *TestContext) LogContains(f func(*PersistedCommand) bool) bool {
 }
 
 func setup(t *testing.T) *TestContext {
-	config := NewPlatformConfigForTest()
+	return setupWithConfig(t, NewPlatformConfigForTest())
+}
+
+func setupWithConfig(t *testing.T, config *PlatformConfig) *TestContext {
 	app, starters := HackerNews(config)
 	return &TestContext{
 		t:         t,
@@ -20,6 +20,9 @@ type CommandBuilder = func(shell *Shell, req *Request, ctx context.Context) (Com
 type ContextBuilder = func(shell *Shell, req *Request, ctx context.Context) (context.Context, error)
 
 type Shell struct {
+	// Origin is recorded with every command handled by the shell,
+	// unless the context passed to Do specifies another origin.
+	Origin          string
 	CurrentTime     func() time.Time
 	NewID           func() string
 	App             *App
@@ -53,6 +56,7 @@ func NewDefaultShell(app *App) *Shell {
 
 func init() {
 	DefaultShellCommands["PostLink"] = BuildPostLinkCommand
+	DefaultShellCommands["PostText"] = BuildPostTextCommand
 	DefaultShellCommands["Signup"] = BuildSignupCommand
 	DefaultShellCommands["LogIn"] = BuildLoginCommand
 	DefaultShellCommands["RequestMagicLinkLogin"] = BuildRequestMagicLinkLoginCommand
@@ -61,6 +65,9 @@ func init() {
 	DefaultShellCommands["SetAdminUsers"] = BuildSetAdminUsersCommand
 	DefaultShellCommands["SetMagicDomains"] = BuildSetMagicDomainsCommand
 	DefaultShellCommands["Upvote"] = BuildUpvoteCommand
+	DefaultShellCommands["Downvote"] = BuildDownvoteCommand
+	DefaultShellCommands["RetractVote"] = BuildRetractVoteCommand
+	DefaultShellCommands["SetRankingPolicy"] = BuildSetRankingPolicyCommand
 	DefaultShellCommands["HideSubmission"] = BuildHideSubmissionCommand
 	DefaultShellCommands["UnhideSubmission"] = BuildUnhideSubmissionCommand
 	DefaultShellCommands["HideComment"] = BuildHideCommentCommand
@@ -73,6 +80,9 @@ func init() {
 	DefaultShellCommands["RequestPasswordReset"] = BuildRequestPasswordResetCommand
 	DefaultShellCommands["ResetPassword"] = BuildResetPasswordCommand
 	DefaultShellCommands["Comment"] = BuildCommentCommand
+	DefaultShellCommands["EditComment"] = BuildEditCommentCommand
+	DefaultShellCommands["DeleteOwnComment"] = BuildDeleteOwnCommentCommand
+	DefaultShellCommands["EditSubmissionTitle"] = BuildEditSubmissionTitleCommand
 	DefaultShellCommands["SetDefaultUsernamePolicy"] = BuildSetDefaultUsernamePolicyCommand
 	DefaultShellCommands["SetChangeUsernamePolicy"] = BuildChangeUsernamePolicyCommand
 
@@ -248,10 +258,6 @@ func BuildFindSubscribersForNewCommentQuery(shell *Shell, req *Request, ctx cont
 
 func (s *Shell) UnskipCommands(params Parameters) error {
 	ids := GetAllValues(params, "id")
-	reviser, ok := s.App.Commands.(CommandReviser)
-	if !ok {
-		return fmt.Errorf("Command log type %T does not support revision", s.App.Commands)
-	}
 	intIDs := []int{}
 	for _, id := range ids {
 		i, err := strconv.Atoi(id)
@@ -260,16 +266,11 @@ func (s *Shell) UnskipCommands(params Parameters) error {
 		}
 		intIDs = append(intIDs, i)
 	}
-	reviser.ReviseCommands(intIDs, func(id int) Command { return nil })
-	return nil
+	return s.App.ReviseCommands(intIDs, func(id int) Command { return nil })
 }
 
 func (s *Shell) SkipCommands(params Parameters) error {
 	ids := GetAllValues(params, "id")
-	reviser, ok := s.App.Commands.(CommandReviser)
-	if !ok {
-		return fmt.Errorf("Command log type %T does not support revision", s.App.Commands)
-	}
 	intIDs := []int{}
 	for _, id := range ids {
 		i, err := strconv.Atoi(id)
@@ -279,7 +280,93 @@ func (s *Shell) SkipCommands(params Parameters) error {
 		intIDs = append(intIDs, i)
 	}
 	skip := new(SkipCommand)
-	reviser.ReviseCommands(intIDs, func(id int) Command { return skip })
+	return s.App.ReviseCommands(intIDs, func(id int) Command { return skip })
+}
+
+func (s *Shell) TakeSnapshot(out io.Writer) error {
+	snapshot, err := s.App.TakeSnapshot()
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	fmt.Fprintf(out, "snapshot taken at version %d\n", snapshot.Version)
+	return nil
+}
+
+// VerifyLog decodes every entry in the command log and reports the ones that cannot be decoded.
+func (s *Shell) VerifyLog(out io.Writer) error {
+	commands, err := s.App.Commands.After(0)
+	if err != nil {
+		return fmt.Errorf("verify-log: %w", err)
+	}
+	total, failed := 0, 0
+	for command := range commands {
+		total++
+		if undecodable, ok := command.Message.(*UndecodableCommand); ok {
+			failed++
+			fmt.Fprintf(out, "% 5d %s\n      raw: %s\n", command.ID, undecodable.Err, undecodable.Raw)
+		}
+	}
+	fmt.Fprintf(out, "%d commands, %d failed to decode\n", total, failed)
+	if failed > 0 {
+		return fmt.Errorf("verify-log: %d of %d commands failed to decode", failed, total)
+	}
+	return nil
+}
+
+// Export writes the command log as JSON Lines to out.
+//
+// The parameters after and until limit the IDs of exported commands,
+// type limits them to a comma-separated list of command names.
+func (s *Shell) Export(params Parameters, out io.Writer) error {
+	filter := &ExportFilter{}
+	for key, dest := range map[string]*int{"after": &filter.After, "until": &filter.Until} {
+		if value := params.Get(key); value != "" {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("export: %s: %w", key, err)
+			}
+			*dest = i
+		}
+	}
+	if types := params.Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if _, err := ExportCommands(s.App.Commands, DefaultSerializer, filter, out); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// Modules prints which module owns which commands and queries, and which commands no module owns.
+func (s *Shell) Modules(out io.Writer) error {
+	for _, module := range s.App.Modules() {
+		if _, err := module.WriteTo(out); err != nil {
+			return fmt.Errorf("modules: %w", err)
+		}
+	}
+	if unowned := s.App.UnownedCommands(); len(unowned) > 0 {
+		fmt.Fprintf(out, "unowned commands: %s\n", strings.Join(unowned, ", "))
+	}
+	return nil
+}
+
+// ReplayReport prints the commands that failed to replay and the commands that are quarantined.
+func (s *Shell) ReplayReport(out io.Writer) error {
+	failures := s.App.ReplayFailures()
+	fmt.Fprintf(out, "%d replay failures\n", len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(out, "  %s\n", failure)
+	}
+
+	quarantined, err := s.App.QuarantinedCommands()
+	if err != nil {
+		return fmt.Errorf("replay-report: %w", err)
+	}
+	fmt.Fprintf(out, "%d quarantined commands\n", len(quarantined))
+	for _, command := range quarantined {
+		cmd := command.Message.(*QuarantinedCommand)
+		fmt.Fprintf(out, "  #%d %s in %s at %s: %s\n", command.ID, cmd.Command, cmd.Handler, cmd.QuarantinedAt.Format(time.RFC3339), cmd.Error)
+	}
 	return nil
 }
 
@@ -334,6 +421,9 @@ func (s *Shell) List(params Parameters, out io.Writer) error {
 
 	for command := range commands {
 		fmt.Fprintf(out, "%3d %20s %s\n", command.ID, command.Message.CommandName(), formatPayload(command.Message))
+		if metadata := command.Metadata.String(); metadata != "" {
+			fmt.Fprintf(out, "%3s %20s %s\n", "", "", metadata)
+		}
 	}
 	return nil
 }
@@ -348,13 +438,63 @@ func BuildUpvoteCommand(shell *Shell, req *Request, ctx context.Context) (Comman
 	if session == nil {
 		return nil, ErrSessionNotFound
 	}
+	itemID := NewTreeID(req.Parameters.Get("itemID"))
+	if len(itemID) > 1 {
+		return &UpvoteComment{
+			CommentID: itemID,
+			Voter:     session.Username,
+			VotedAt:   votedAt,
+		}, nil
+	}
 	return &UpvoteSubmission{
-		ItemID:  req.Parameters.Get("itemID"),
+		ItemID:  itemID.String(),
 		Voter:   session.Username,
 		VotedAt: votedAt,
 	}, nil
 }
 
+func BuildDownvoteCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	votedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("downvote: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	itemID := NewTreeID(req.Parameters.Get("itemID"))
+	if len(itemID) > 1 {
+		return &DownvoteComment{
+			CommentID: itemID,
+			Voter:     session.Username,
+			VotedAt:   votedAt,
+		}, nil
+	}
+	return &DownvoteSubmission{
+		ItemID:  itemID.String(),
+		Voter:   session.Username,
+		VotedAt: votedAt,
+	}, nil
+}
+
+func BuildRetractVoteCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	retractedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("retract-vote: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return &RetractVote{
+		ItemID:      req.Parameters.Get("itemID"),
+		Voter:       session.Username,
+		RetractedAt: retractedAt,
+	}, nil
+}
+
 func BuildHideSubmissionCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
 	env := NewRequestEnv(ctx)
 	hiddenAt, err := env.CurrentTime()
@@ -480,6 +620,25 @@ func BuildPostLinkCommand(shell *Shell, req *Request, ctx context.Context) (Comm
 	}, nil
 }
 
+func BuildPostTextCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	submittedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("post-text: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return &PostText{
+		ItemID:      req.Parameters.Get("itemID"),
+		Submitter:   session.Username,
+		Title:       req.Parameters.Get("title"),
+		Body:        req.Parameters.Get("text"),
+		SubmittedAt: submittedAt,
+	}, nil
+}
+
 func BuildRequestPasswordResetCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
 	env := NewRequestEnv(ctx)
 	requestedAt, err := env.CurrentTime()
@@ -537,6 +696,59 @@ func BuildCommentCommand(shell *Shell, req *Request, ctx context.Context) (Comma
 	}, nil
 }
 
+func BuildEditCommentCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	editedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("edit-comment: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return &EditComment{
+		CommentID: NewTreeID(req.Parameters.Get("itemID")),
+		Content:   req.Parameters.Get("text"),
+		EditedBy:  session.Username,
+		EditedAt:  editedAt,
+	}, nil
+}
+
+func BuildDeleteOwnCommentCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	deletedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("delete-own-comment: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return &DeleteOwnComment{
+		CommentID: NewTreeID(req.Parameters.Get("itemID")),
+		DeletedBy: session.Username,
+		DeletedAt: deletedAt,
+	}, nil
+}
+
+func BuildEditSubmissionTitleCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	editedAt, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("edit-submission-title: %w", err)
+	}
+	session := env.CurrentSession()
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	return &EditSubmissionTitle{
+		ItemID:   req.Parameters.Get("itemID"),
+		Title:    req.Parameters.Get("title"),
+		EditedBy: session.Username,
+		EditedAt: editedAt,
+	}, nil
+}
+
 func BuildSetDefaultUsernamePolicyCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
 	return &ChangeUsernamePolicy{
 		MinLength: 5,
@@ -563,6 +775,30 @@ func BuildChangeUsernamePolicyCommand(shell *Shell, req *Request, ctx context.Co
 	}, nil
 }
 
+func BuildSetRankingPolicyCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
+	env := NewRequestEnv(ctx)
+	now, err := env.CurrentTime()
+	if err != nil {
+		return nil, fmt.Errorf("set-ranking-policy: %w", err)
+	}
+	policy, err := ParseRankingPolicy(req.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("set-ranking-policy: %w", err)
+	}
+	setBy := ""
+	if session := env.CurrentSession(); session != nil {
+		setBy = session.Username
+	}
+	return &SetRankingPolicy{
+		Algorithm:     policy.Algorithm,
+		Gravity:       policy.Gravity,
+		Decay:         policy.Decay,
+		CommentWeight: policy.CommentWeight,
+		SetBy:         setBy,
+		SetAt:         now,
+	}, nil
+}
+
 func BuildSetNotifierConfigCommand(shell *Shell, req *Request, ctx context.Context) (Command, error) {
 	env := NewRequestEnv(ctx)
 	now, err := env.CurrentTime()
@@ -643,11 +879,11 @@ func (s *Shell) Do(ctx context.Context, req *Request) (any, error) {
 	}
 
 	if kind == CommandRequest {
-		command, err := s.buildCommand(req, ctx)
+		command, enhancedCtx, err := s.buildCommand(req, ctx)
 		if err != nil {
 			return nil, err
 		}
-		return nil, s.App.HandleCommand(command)
+		return nil, s.App.HandleCommandWithMetadata(command, s.commandMetadata(enhancedCtx))
 	}
 
 	if kind == QueryRequest {
@@ -655,7 +891,18 @@ func (s *Shell) Do(ctx context.Context, req *Request) (any, error) {
 		if err != nil {
 			return nil, err
 		}
-		if err := s.App.HandleQuery(query); err != nil {
+		app := s.App
+		if at := req.Headers.Get("at"); at != "" {
+			id, err := ResolveCommandID(s.App.Commands, at)
+			if err != nil {
+				return nil, err
+			}
+			if app, err = HistoricalApp(s.App.Commands, id); err != nil {
+				return nil, err
+			}
+			defer app.Close()
+		}
+		if err := app.HandleQuery(query); err != nil {
 			return nil, err
 		}
 		return query.Result(), nil
@@ -663,17 +910,31 @@ func (s *Shell) Do(ctx context.Context, req *Request) (any, error) {
 	panic("unreachable")
 }
 
-func (s *Shell) buildCommand(req *Request, ctx context.Context) (Command, error) {
+func (s *Shell) buildCommand(req *Request, ctx context.Context) (Command, context.Context, error) {
 	name := req.Name()
 	commandBuilder, ok := s.CommandBuilders[name]
 	if !ok {
-		return nil, fmt.Errorf("BuildCommand(%q): %w", name, ErrCommandNotAccepted)
+		return nil, nil, fmt.Errorf("BuildCommand(%q): %w", name, ErrCommandNotAccepted)
 	}
 	enhancedCtx, err := s.buildContext(req, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("BuildCommand(%q): %w", name, err)
+		return nil, nil, fmt.Errorf("BuildCommand(%q): %w", name, err)
 	}
-	return commandBuilder(s, req, enhancedCtx)
+	command, err := commandBuilder(s, req, enhancedCtx)
+	return command, enhancedCtx, err
+}
+
+// commandMetadata describes a command built in ctx.
+func (s *Shell) commandMetadata(ctx context.Context) *CommandMetadata {
+	metadata := &CommandMetadata{Origin: s.Origin}
+	if origin, found := CommandOriginFromEnv(ctx); found {
+		metadata.Origin = origin.Origin
+		metadata.CorrelationID = origin.CorrelationID
+	}
+	if session := CurrentSessionFromEnv(ctx); session != nil {
+		metadata.Actor = session.Username
+	}
+	return metadata
 }
 
 func (s *Shell) buildQuery(req *Request, ctx context.Context) (Query, error) {
@@ -722,6 +983,7 @@ var ErrNotInEnv = errors.New("not in environment")
 const (
 	EnvCurrentTime requestEnv = iota
 	EnvCurrentSession
+	EnvCommandOrigin
 )
 
 type RequestEnv struct{ context.Context }
@@ -780,3 +1042,22 @@ func CurrentSessionFromEnv(ctx context.Context) *Session {
 	}
 	return nil
 }
+
+// CommandOrigin identifies where commands handled by the shell come from.
+type CommandOrigin struct {
+	Origin        string
+	CorrelationID string
+}
+
+// WithCommandOrigin adds `EnvCommandOrigin` to the context, marking all
+// commands handled with it as coming from origin as part of the request
+// identified by correlationID.
+func WithCommandOrigin(ctx context.Context, origin string, correlationID string) context.Context {
+	return context.WithValue(ctx, EnvCommandOrigin, &CommandOrigin{Origin: origin, CorrelationID: correlationID})
+}
+
+// CommandOriginFromEnv returns the command origin from the context.
+func CommandOriginFromEnv(ctx context.Context) (*CommandOrigin, bool) {
+	origin, ok := ctx.Value(EnvCommandOrigin).(*CommandOrigin)
+	return origin, ok
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSnapshotNotSupported = errors.New("snapshot not supported")
+	ErrSnapshotInvalid      = errors.New("snapshot invalid")
+)
+
+// Snapshotter is implemented by derived state that can be serialized
+// and restored later.
+//
+// Restoring a snapshot allows App.Replay to continue from the command
+// the snapshot covers instead of starting from the beginning of the log.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Snapshot is the serialized state of all mounted modules after applying
+// all commands up to and including Version.
+type Snapshot struct {
+	Version  int                        `json:"version"`
+	Commands string                     `json:"commands"`
+	TakenAt  time.Time                  `json:"taken_at"`
+	Modules  map[string]json.RawMessage `json:"modules"`
+}
+
+type SnapshotStore interface {
+	Save(snapshot *Snapshot) error
+	// All returns all stored snapshots, most recent first.
+	All() (iter.Seq2[*Snapshot, error], error)
+	// DropFrom removes all snapshots at or after version.
+	DropFrom(version int) error
+}
+
+// Fingerprint identifies the set of registered commands and the shape of their payloads.
+//
+// A snapshot taken with a different fingerprint might have been derived
+// by different handlers and cannot be trusted.
+func (s CommandRegistry) Fingerprint() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fields := []string{}
+		t := reflect.TypeOf(s[name]())
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				fields = append(fields, t.Field(i).Name+" "+t.Field(i).Type.String())
+			}
+		}
+		fmt.Fprintf(hash, "%s{%s}\n", name, strings.Join(fields, ";"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// InMemorySnapshotStore keeps snapshots for the lifetime of the process.
+type InMemorySnapshotStore struct {
+	snapshots []*Snapshot
+}
+
+func NewInMemorySnapshotStore() *InMemorySnapshotStore {
+	return &InMemorySnapshotStore{snapshots: []*Snapshot{}}
+}
+
+func (self *InMemorySnapshotStore) Save(snapshot *Snapshot) error {
+	self.snapshots = append(self.snapshots, snapshot)
+	return nil
+}
+
+func (self *InMemorySnapshotStore) All() (iter.Seq2[*Snapshot, error], error) {
+	snapshots := slices.Clone(self.snapshots)
+	slices.SortStableFunc(snapshots, func(a, b *Snapshot) int { return b.Version - a.Version })
+	return func(yield func(*Snapshot, error) bool) {
+		for _, snapshot := range snapshots {
+			if !yield(snapshot, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+func (self *InMemorySnapshotStore) DropFrom(version int) error {
+	self.snapshots = slices.DeleteFunc(self.snapshots, func(snapshot *Snapshot) bool { return snapshot.Version >= version })
+	return nil
+}
+
+// FileSnapshotStore stores every snapshot as a JSON file in a directory.
+type FileSnapshotStore struct {
+	dir string
+}
+
+func NewFileSnapshotStore(dir string) *FileSnapshotStore {
+	return &FileSnapshotStore{dir: dir}
+}
+
+func (self *FileSnapshotStore) Setup() error {
+	if err := os.MkdirAll(self.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return nil
+}
+
+func (self *FileSnapshotStore) filename(version int) string {
+	return filepath.Join(self.dir, fmt.Sprintf("snapshot-%010d.json", version))
+}
+
+func (self *FileSnapshotStore) Save(snapshot *Snapshot) error {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	tmp, err := os.CreateTemp(self.dir, "snapshot-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), self.filename(snapshot.Version)); err != nil {
+		return fmt.Errorf("failed to move snapshot into place: %w", err)
+	}
+	return nil
+}
+
+func (self *FileSnapshotStore) All() (iter.Seq2[*Snapshot, error], error) {
+	filenames, err := filepath.Glob(filepath.Join(self.dir, "snapshot-*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	slices.Sort(filenames)
+	slices.Reverse(filenames)
+	return func(yield func(*Snapshot, error) bool) {
+		for _, filename := range filenames {
+			snapshot := new(Snapshot)
+			data, err := os.ReadFile(filename)
+			if err == nil {
+				err = json.Unmarshal(data, snapshot)
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to read snapshot %q: %w", filename, err)
+			}
+			if !yield(snapshot, err) {
+				return
+			}
+		}
+	}, nil
+}
+
+func (self *FileSnapshotStore) DropFrom(version int) error {
+	filenames, err := filepath.Glob(filepath.Join(self.dir, "snapshot-*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, filename := range filenames {
+		var snapshotVersion int
+		if _, err := fmt.Sscanf(filepath.Base(filename), "snapshot-%d.json", &snapshotVersion); err != nil {
+			return fmt.Errorf("failed to parse version of snapshot %q: %w", filename, err)
+		}
+		if snapshotVersion < version {
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			return fmt.Errorf("failed to remove snapshot %q: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// TakeSnapshot serializes the state of all mounted snapshotters at the current version.
+func (app *App) TakeSnapshot() (*Snapshot, error) {
+	app.lock.RLock()
+	defer app.lock.RUnlock()
+
+	if app.Snapshots == nil {
+		return nil, ErrSnapshotNotSupported
+	}
+
+	modules, err := app.moduleStates()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		Version:  app.version,
+		Commands: app.commandSet,
+		TakenAt:  time.Now(),
+		Modules:  modules,
+	}
+
+	if err := app.Snapshots.Save(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// moduleStates serializes the state of all mounted snapshotters.  The caller must hold the lock.
+func (app *App) moduleStates() (map[string]json.RawMessage, error) {
+	modules := map[string]json.RawMessage{}
+	for name, snapshotter := range app.snapshotters {
+		data, err := snapshotter.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", name, err)
+		}
+		modules[name] = data
+	}
+	return modules, nil
+}
+
+// validateSnapshot checks whether snapshot can be restored given the
+// current command set and log.
+func (app *App) validateSnapshot(snapshot *Snapshot, length int) error {
+	if snapshot.Commands != app.commandSet {
+		return fmt.Errorf("registered commands have changed: %w", ErrSnapshotInvalid)
+	}
+	if snapshot.Version > length {
+		return fmt.Errorf("snapshot version %d is ahead of command log (%d): %w", snapshot.Version, length, ErrSnapshotInvalid)
+	}
+	for name := range app.snapshotters {
+		if _, found := snapshot.Modules[name]; !found {
+			return fmt.Errorf("module %s is missing: %w", name, ErrSnapshotInvalid)
+		}
+	}
+	return nil
+}
+
+// restoreLatestSnapshot restores the most recent valid snapshot and
+// moves the app's version to the version covered by the snapshot.
+//
+// Snapshots which fail to restore are skipped like invalid ones. If no
+// snapshot can be restored, the app is left untouched.
+func (app *App) restoreLatestSnapshot() error {
+	if app.Snapshots == nil || len(app.snapshotters) == 0 {
+		return nil
+	}
+
+	length, err := app.Commands.Length()
+	if err != nil {
+		return fmt.Errorf("failed to determine length of command log: %w", err)
+	}
+	// A snapshot can fail to restore after some modules were restored
+	// already; those are reset to their initial state before moving on.
+	initial, err := app.moduleStates()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := app.Snapshots.All()
+	if err != nil {
+		return err
+	}
+	for snapshot, err := range snapshots {
+		if err != nil {
+			app.Logger.Warn("skipping snapshot", "error", err)
+			continue
+		}
+		if err := app.validateSnapshot(snapshot, length); err != nil {
+			app.Logger.Warn("skipping snapshot", "version", snapshot.Version, "error", err)
+			continue
+		}
+		if err := app.restoreModules(snapshot.Modules); err != nil {
+			app.Logger.Warn("skipping snapshot", "version", snapshot.Version, "error", err)
+			if err := app.restoreModules(initial); err != nil {
+				return fmt.Errorf("failed to reset modules after snapshot %d: %w", snapshot.Version, err)
+			}
+			continue
+		}
+		app.version = snapshot.Version
+		return nil
+	}
+	return nil
+}
+
+// restoreModules restores all mounted snapshotters from modules, in the order of their names.
+func (app *App) restoreModules(modules map[string]json.RawMessage) error {
+	names := make([]string, 0, len(app.snapshotters))
+	for name := range app.snapshotters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := app.snapshotters[name].Restore(modules[name]); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func restartFrom(t *testing.T, scenario *TestContext) *TestContext {
+	t.Helper()
+	restarted := setup(t)
+	restarted.App.Commands = scenario.App.Commands
+	restarted.App.Snapshots = scenario.App.Snapshots
+	if err := restarted.App.Replay(false); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	return restarted
+}
+
+func Test_Snapshot_RestoresStateAndResumesReplay(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.signup("admin", "admin"))
+	scenario.must(scenario.postLink("https://example.com", "Before"))
+	scenario.must(scenario.upvote(scenario.PostIDs[0], "admin"))
+
+	snapshot, err := scenario.App.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	if act, exp := snapshot.Version, 3; act != exp {
+		t.Fatalf("expected snapshot at version %d, got %d", exp, act)
+	}
+
+	scenario.must(scenario.postLink("https://example.com", "After"))
+
+	restarted := restartFrom(t, scenario)
+	if act, exp := restarted.App.version, 4; act != exp {
+		t.Fatalf("expected version %d, got %d", exp, act)
+	}
+	submissions := restarted.frontpage()
+	if act, exp := len(submissions), 2; act != exp {
+		t.Fatalf("expected %d submissions, got %d", exp, act)
+	}
+	before := mustFind(submissions, "Title", "Before")
+	if act, exp := before.VoteCount, 1; act != exp {
+		t.Fatalf("expected %d votes, got %d", exp, act)
+	}
+	if _, err := restarted.findPasswordHash("admin", "admin"); err != nil {
+		t.Fatalf("expected user to be restored, got %s", err)
+	}
+}
+
+func Test_Snapshot_IsRejected_WhenCommandSetChanged(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.postLink("https://example.com", "Before"))
+	snapshot, err := scenario.App.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+
+	snapshot.Commands = "outdated"
+	if err := scenario.App.validateSnapshot(snapshot, 1); !errors.Is(err, ErrSnapshotInvalid) {
+		t.Fatalf("expected %s, got %v", ErrSnapshotInvalid, err)
+	}
+
+	restarted := restartFrom(t, scenario)
+	if act, exp := len(restarted.frontpage()), 1; act != exp {
+		t.Fatalf("expected %d submissions after full replay, got %d", exp, act)
+	}
+}
+
+func Test_Snapshot_FallsBack_WhenLatestSnapshotFailsToRestore(t *testing.T) {
+	scenario := setup(t)
+	scenario.must(scenario.signup("admin", "admin"))
+	scenario.must(scenario.postLink("https://example.com", "First"))
+	older, err := scenario.App.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	scenario.must(scenario.signup("alice", "alice"))
+	scenario.must(scenario.postLink("https://example.com", "Second"))
+	latest, err := scenario.App.TakeSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	scenario.must(scenario.upvote(scenario.PostIDs[0], "admin"))
+	// Auth is restored before content fails to decode.
+	latest.Modules["content"] = json.RawMessage(`[]`)
+
+	restarted := restartFrom(t, scenario)
+	if act, exp := restarted.App.version, 5; act != exp {
+		t.Fatalf("expected version %d, got %d", exp, act)
+	}
+	if act, exp := len(restarted.frontpage()), 2; act != exp {
+		t.Fatalf("expected %d submissions, got %d", exp, act)
+	}
+
+	older.Modules["content"] = json.RawMessage(`[]`)
+	restarted = restartFrom(t, scenario)
+	if act, exp := len(restarted.frontpage()), 2; act != exp {
+		t.Fatalf("expected %d submissions after full replay, got %d", exp, act)
+	}
+	if _, err := restarted.findPasswordHash("alice", "alice"); err != nil {
+		t.Fatalf("expected user to be replayed, got %s", err)
+	}
+}
+
+func Test_Snapshot_IsDropped_WhenCoveredCommandsAreRevised(t *testing.T) {
+	scenario := setup(t)
+	log := NewFileCommandLog(filepath.Join(t.TempDir(), "commands.db"), DefaultSerializer)
+	if err := log.Setup(); err != nil {
+		t.Fatalf("failed to set up command log: %s", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	snapshots := NewFileSnapshotStore(t.TempDir())
+	if err := snapshots.Setup(); err != nil {
+		t.Fatalf("failed to set up snapshot store: %s", err)
+	}
+	scenario.App.Commands = log
+	scenario.App.Snapshots = snapshots
+
+	scenario.must(scenario.postLink("https://example.com", "First"))
+	if _, err := scenario.App.TakeSnapshot(); err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	scenario.must(scenario.postLink("https://example.com", "Second"))
+	if _, err := scenario.App.TakeSnapshot(); err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	scenario.must(scenario.postLink("https://example.com", "Third"))
+
+	if err := scenario.App.ReviseCommands([]int{2}, func(id int) Command { return &SkipCommand{} }); err != nil {
+		t.Fatalf("failed to skip command: %s", err)
+	}
+
+	versions := []int{}
+	all, err := snapshots.All()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	for snapshot, err := range all {
+		if err != nil {
+			t.Fatalf("failed to read snapshot: %s", err)
+		}
+		versions = append(versions, snapshot.Version)
+	}
+	if act, exp := fmt.Sprint(versions), "[1]"; act != exp {
+		t.Fatalf("expected snapshots %s to remain, got %s", exp, act)
+	}
+
+	restarted := restartFrom(t, scenario)
+	submissions := restarted.frontpage()
+	if act, exp := len(submissions), 2; act != exp {
+		t.Fatalf("expected %d submissions, got %d", exp, act)
+	}
+	for _, submission := range submissions {
+		if submission.Title == "Second" {
+			t.Fatalf("expected skipped submission to be gone")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// sqlHandle is implemented by *sql.DB and *sql.Tx.
+type sqlHandle interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// sqliteState is embedded by persistent states to implement VersionedState.
+//
+// Between Begin and Commit all statements run in a single transaction,
+// which also stores the version of the command, so that a crash cannot
+// leave the state ahead of the version it records.
+type sqliteState struct {
+	db *sql.DB
+	tx *sql.Tx
+	// versionTable has a single row holding the version.
+	versionTable string
+}
+
+// handle returns the transaction of the current command, or the database outside of commands.
+func (self *sqliteState) handle() sqlHandle {
+	if self.tx != nil {
+		return self.tx
+	}
+	return self.db
+}
+
+// update runs fn in the transaction of the current command, or in a transaction of its own.
+func (self *sqliteState) update(fn func(tx sqlHandle) error) error {
+	if self.tx != nil {
+		return fn(self.tx)
+	}
+	tx, err := self.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (self *sqliteState) Close() error {
+	if self.db == nil {
+		return nil
+	}
+	return self.db.Close()
+}
+
+func (self *sqliteState) Version() (int, error) {
+	version := 0
+	err := self.handle().QueryRow("SELECT version FROM " + self.versionTable + " WHERE id = 1").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query version: %w", err)
+	}
+	return version, nil
+}
+
+func (self *sqliteState) SetVersion(version int) error {
+	if _, err := self.handle().Exec(`INSERT INTO `+self.versionTable+` (id, version) VALUES (1, ?)
+    ON CONFLICT (id) DO UPDATE SET version = excluded.version`, version); err != nil {
+		return fmt.Errorf("failed to store version: %w", err)
+	}
+	return nil
+}
+
+func (self *sqliteState) Begin() error {
+	if self.tx != nil {
+		return fmt.Errorf("transaction already in progress")
+	}
+	tx, err := self.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	self.tx = tx
+	return nil
+}
+
+func (self *sqliteState) Commit(version int) error {
+	if self.tx == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	defer self.Rollback()
+	if err := self.SetVersion(version); err != nil {
+		return err
+	}
+	if err := self.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (self *sqliteState) Rollback() {
+	if self.tx != nil {
+		self.tx.Rollback()
+		self.tx = nil
+	}
+}
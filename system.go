@@ -5,16 +5,52 @@ import (
 	"fmt"
 	"io"
 	"iter"
+	"strings"
+	"time"
 )
 
+// Starter is implemented by background processes.
+//
+// Start returns once the process is running; calling stop makes it
+// finish what it is doing and waits for it to return.
 type Starter interface {
 	Start() (stop func())
 }
 
+// startLoop runs loop in a new goroutine until the returned function is
+// called, which closes stop and waits for loop to return.
+func startLoop(loop func(stop <-chan struct{})) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		loop(stop)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
 type Setupper interface {
 	Setup() error
 }
 
+// VersionedState is implemented by persistent state that remembers
+// which commands have already been applied to it.
+type VersionedState interface {
+	// Version returns the ID of the last command applied to the state.
+	Version() (int, error)
+	// SetVersion records that all commands up to and including version have been applied.
+	SetVersion(version int) error
+	// Begin collects all changes until Commit or Rollback, so that they
+	// are applied together with the version of the command making them.
+	Begin() error
+	// Commit applies the changes collected since Begin and records version.
+	Commit(version int) error
+	// Rollback discards the changes collected since Begin.
+	Rollback()
+}
+
 func MustSetup(s interface{}) {
 	if setupper, ok := s.(Setupper); ok {
 		if err := setupper.Setup(); err != nil {
@@ -24,8 +60,70 @@ func MustSetup(s interface{}) {
 }
 
 type PersistedCommand struct {
-	ID      int
-	Message Command
+	ID       int
+	Message  Command
+	Metadata *CommandMetadata
+	// Original is the command as it was appended, if it has been revised
+	// since.  Message is the revision in that case.
+	Original Command
+	// Events are the events handling the command resulted in, or nil if
+	// they have not been recorded yet.
+	Events []Event
+}
+
+// CommandMetadata describes who issued a command, when it was stored, and why.
+//
+// Commands appended before metadata was recorded have an empty CommandMetadata.
+type CommandMetadata struct {
+	// RecordedAt is the time the command was appended to the log.
+	RecordedAt time.Time `json:"recorded_at"`
+	// Actor is the username of the user who issued the command, if any.
+	Actor string `json:"actor,omitempty"`
+	// Origin names the part of the system that issued the command,
+	// e.g. web, cli, repl or notifier.
+	Origin string `json:"origin,omitempty"`
+	// CorrelationID is shared by all commands resulting from the same
+	// web request or cli invocation.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// CausationID is the ID of the command that caused this command, or 0.
+	CausationID int `json:"causation_id,omitempty"`
+}
+
+// String formats all fields of the metadata that are set as key=value pairs.
+func (m *CommandMetadata) String() string {
+	if m == nil {
+		return ""
+	}
+	fields := []string{}
+	if !m.RecordedAt.IsZero() {
+		fields = append(fields, "recorded_at="+m.RecordedAt.Format(time.RFC3339))
+	}
+	for _, field := range []struct{ key, value string }{
+		{"actor", m.Actor},
+		{"origin", m.Origin},
+		{"correlation_id", m.CorrelationID},
+	} {
+		if field.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%q", field.key, field.value))
+		}
+	}
+	if m.CausationID != 0 {
+		fields = append(fields, fmt.Sprintf("causation_id=%d", m.CausationID))
+	}
+	return strings.Join(fields, " ")
+}
+
+// CausedBy returns metadata for a command issued by origin in reaction to cause.
+func CausedBy(cause *PersistedCommand, origin string) *CommandMetadata {
+	metadata := &CommandMetadata{Origin: origin}
+	if cause == nil {
+		return metadata
+	}
+	metadata.CausationID = cause.ID
+	if cause.Metadata != nil {
+		metadata.CorrelationID = cause.Metadata.CorrelationID
+	}
+	return metadata
 }
 
 type Command interface {
@@ -38,9 +136,20 @@ func (cmd *SkipCommand) CommandName() string { return "SkipCommand" }
 
 type SkipHandler struct{}
 
+func (self *SkipHandler) Ownership() *Ownership {
+	return &Ownership{
+		Module:   "system",
+		Commands: []string{"SkipCommand", "QuarantinedCommand"},
+	}
+}
+
+func (self *SkipHandler) ValidateCommand(cmd Command) error {
+	return self.HandleCommand(cmd)
+}
+
 func (self *SkipHandler) HandleCommand(cmd Command) error {
 	switch cmd.(type) {
-	case *SkipCommand:
+	case *SkipCommand, *QuarantinedCommand:
 		return nil
 	default:
 		return ErrCommandNotAccepted
@@ -65,6 +174,15 @@ type CommandHandler interface {
 	HandleCommand(command Command) error
 }
 
+// CommandValidator is implemented by command handlers that can check
+// whether they would accept a command without changing their state.
+//
+// ValidateCommand returns ErrCommandNotAccepted for commands the handler
+// does not handle, and the error HandleCommand would return otherwise.
+type CommandValidator interface {
+	ValidateCommand(command Command) error
+}
+
 type QueryHandler interface {
 	HandleQuery(query Query) error
 }
@@ -89,18 +207,70 @@ func (s CommandRegistry) New(name string) (Command, bool) {
 var DefaultCommandRegistry = make(CommandRegistry)
 
 type NewCommand func() Command
+
+// Upcaster migrates the JSON payload of a command from one version to the next.
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// UpcasterRegistry holds the upcasters of every command, in order:
+// the first one migrates version 1 to version 2, and so on.
+//
+// The current version of a command is one more than the number of its upcasters.
+type UpcasterRegistry map[string][]Upcaster
+
+// Register adds upcaster to migrate payloads of the command called name
+// from version from to version from+1.
+//
+// Upcasters need to be registered in order, starting at version 1.
+func (s UpcasterRegistry) Register(name string, from int, upcaster Upcaster) {
+	if from != len(s[name])+1 {
+		panic(fmt.Sprintf("upcaster for %s: expected version %d, got %d", name, len(s[name])+1, from))
+	}
+	s[name] = append(s[name], upcaster)
+}
+
+// CurrentVersion returns the version of the command called name that its struct corresponds to.
+func (s UpcasterRegistry) CurrentVersion(name string) int {
+	return len(s[name]) + 1
+}
+
+// Upcast migrates payload of the command called name from version to the current version.
+func (s UpcasterRegistry) Upcast(name string, version int, payload json.RawMessage) (json.RawMessage, error) {
+	if version < 1 || version > s.CurrentVersion(name) {
+		return nil, fmt.Errorf("%s version %d: %w", name, version, ErrUnknownCommandVersion)
+	}
+	for i, upcaster := range s[name][version-1:] {
+		var err error
+		if payload, err = upcaster(payload); err != nil {
+			return nil, fmt.Errorf("failed to upcast %s from version %d: %w", name, version+i, err)
+		}
+	}
+	return payload, nil
+}
+
+var DefaultUpcasters = make(UpcasterRegistry)
+
+var ErrUnknownCommandType = fmt.Errorf("unknown command type")
+var ErrUnknownCommandVersion = fmt.Errorf("unknown command version")
+
 type JSONSerializer struct {
-	commands CommandRegistry
+	commands  CommandRegistry
+	upcasters UpcasterRegistry
 }
 
+// RawJSONMessage is how commands are stored in the command log.
+//
+// Entries written before commands were versioned have no version,
+// they are treated as version 1.
 type RawJSONMessage struct {
 	Type    string          `json:"type"`
+	Version int             `json:"version,omitempty"`
 	Message json.RawMessage `json:"message"`
 }
 
-func NewJSONSerializer(registry CommandRegistry) *JSONSerializer {
+func NewJSONSerializer(registry CommandRegistry, upcasters UpcasterRegistry) *JSONSerializer {
 	return &JSONSerializer{
-		registry,
+		commands:  registry,
+		upcasters: upcasters,
 	}
 }
 
@@ -112,27 +282,59 @@ func (s *JSONSerializer) Encode(message Command) ([]byte, error) {
 
 	withType := RawJSONMessage{
 		Type:    message.CommandName(),
+		Version: s.upcasters.CurrentVersion(message.CommandName()),
 		Message: bytes,
 	}
 
 	return json.Marshal(withType)
 }
 
+// Decode decodes a command encoded by Encode, upcasting it to its current version.
+//
+// Decoding fails with ErrUnknownCommandType or ErrUnknownCommandVersion
+// for commands this version of the application does not know about.
 func (s *JSONSerializer) Decode(data []byte, message *Command) error {
 	m := &RawJSONMessage{}
 	if err := json.Unmarshal(data, &m); err != nil {
 		return fmt.Errorf("failed to unmarshal message: %w", err)
 	}
-	if newMessage, ok := s.commands.New(m.Type); ok {
-		*message = newMessage
+	newMessage, ok := s.commands.New(m.Type)
+	if !ok {
+		return fmt.Errorf("%q: %w", m.Type, ErrUnknownCommandType)
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	payload, err := s.upcasters.Upcast(m.Type, m.Version, m.Message)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, newMessage); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", m.Type, err)
 	}
-	return json.Unmarshal(m.Message, message)
+	*message = newMessage
+	return nil
+}
+
+// UndecodableCommand stands in for an entry of the command log that could not be decoded.
+type UndecodableCommand struct {
+	Raw []byte
+	Err error
 }
 
-var DefaultSerializer = NewJSONSerializer(DefaultCommandRegistry)
+func (cmd *UndecodableCommand) CommandName() string { return "UndecodableCommand" }
+
+var DefaultSerializer = NewJSONSerializer(DefaultCommandRegistry, DefaultUpcasters)
+
+// ErrVersionConflict is returned by AppendIfVersion if the log has grown
+// past the expected version.
+var ErrVersionConflict = fmt.Errorf("command log version conflict")
 
 type CommandLog interface {
-	Append(command Command) error
+	Append(command Command, metadata *CommandMetadata) error
+	// AppendIfVersion appends command only if the ID of the last command
+	// in the log is expected, and returns ErrVersionConflict otherwise.
+	AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error
 	Length() (int, error)
 	After(id int) (iter.Seq[*PersistedCommand], error)
 }
@@ -141,10 +343,21 @@ type CommandReviser interface {
 	ReviseCommands(id []int, as func(id int) Command) error
 }
 
+// CommandBatchAppender is implemented by command logs that can append
+// several commands at once, more efficiently than one at a time.
+//
+// The IDs of the appended entries are ignored, they are assigned by the log.
+type CommandBatchAppender interface {
+	AppendBatch(entries []*PersistedCommand) error
+}
+
 // NullCommand log implements an empty log that does not store any messages nor return any.
 type NullCommandLog struct{}
 
-func (l *NullCommandLog) Append(command Command) error { return nil }
+func (l *NullCommandLog) Append(command Command, metadata *CommandMetadata) error { return nil }
+func (l *NullCommandLog) AppendIfVersion(expected int, command Command, metadata *CommandMetadata) error {
+	return nil
+}
 func (l *NullCommandLog) After(id int) (iter.Seq[*PersistedCommand], error) {
 	return func(yield func(c *PersistedCommand) bool) {}, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type RenamedFieldCommand struct {
+	Headline string
+}
+
+func (cmd *RenamedFieldCommand) CommandName() string { return "RenamedFieldCommand" }
+
+// newUpcastingSerializer returns a serializer for RenamedFieldCommand,
+// whose field was called Title in version 1.
+func newUpcastingSerializer() *JSONSerializer {
+	commands := CommandRegistry{}
+	commands.Register("RenamedFieldCommand", func() Command { return &RenamedFieldCommand{} })
+	upcasters := UpcasterRegistry{}
+	upcasters.Register("RenamedFieldCommand", 1, func(payload json.RawMessage) (json.RawMessage, error) {
+		v1 := struct{ Title string }{}
+		if err := json.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(RenamedFieldCommand{Headline: v1.Title})
+	})
+	return NewJSONSerializer(commands, upcasters)
+}
+
+func Test_JSONSerializer_Encode_RecordsCurrentVersion(t *testing.T) {
+	encoded, err := newUpcastingSerializer().Encode(&RenamedFieldCommand{Headline: "hello"})
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	if act, exp := string(encoded), `{"type":"RenamedFieldCommand","version":2,"message":{"Headline":"hello"}}`; act != exp {
+		t.Fatalf("expected %s, got %s", exp, act)
+	}
+}
+
+func Test_JSONSerializer_Decode_UpcastsOldVersions(t *testing.T) {
+	serializer := newUpcastingSerializer()
+	for _, data := range []string{
+		`{"type":"RenamedFieldCommand","message":{"Title":"hello"}}`,
+		`{"type":"RenamedFieldCommand","version":1,"message":{"Title":"hello"}}`,
+		`{"type":"RenamedFieldCommand","version":2,"message":{"Headline":"hello"}}`,
+	} {
+		var cmd Command
+		if err := serializer.Decode([]byte(data), &cmd); err != nil {
+			t.Fatalf("failed to decode %s: %s", data, err)
+		}
+		if act, exp := cmd.(*RenamedFieldCommand).Headline, "hello"; act != exp {
+			t.Fatalf("decoding %s: expected %q, got %q", data, exp, act)
+		}
+	}
+}
+
+func Test_JSONSerializer_Decode_RejectsUnknownTypesAndVersions(t *testing.T) {
+	serializer := newUpcastingSerializer()
+	for data, expected := range map[string]error{
+		`{"type":"Unknown","message":{}}`:                          ErrUnknownCommandType,
+		`{"type":"RenamedFieldCommand","version":3,"message":{}}`:  ErrUnknownCommandVersion,
+		`{"type":"RenamedFieldCommand","version":-1,"message":{}}`: ErrUnknownCommandVersion,
+	} {
+		var cmd Command
+		if err := serializer.Decode([]byte(data), &cmd); !errors.Is(err, expected) {
+			t.Fatalf("decoding %s: expected %s, got %v", data, expected, err)
+		}
+	}
+}
+
+func Test_UpcasterRegistry_Register_RequiresConsecutiveVersions(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "expected version 1") {
+			t.Fatalf("expected panic about version 1, got %v", r)
+		}
+	}()
+	UpcasterRegistry{}.Register("RenamedFieldCommand", 2, nil)
+}
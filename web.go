@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"io/fs"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
 	"orange/pages"
-	"os"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -27,11 +26,14 @@ type WebApp struct {
 	app    *App
 	shell  *Shell
 	mux    *http.ServeMux
-	logger *log.Logger
+	logger *slog.Logger
 
 	SessionIDGenerator func() string
 	ItemIDGenerator    func() string
 	CurrentTime        func() time.Time
+
+	// Starters are the background processes reported on by /healthz and /metrics.
+	Starters []Starter
 }
 
 func NewWebApp(app *App, shell *Shell) *WebApp {
@@ -39,7 +41,7 @@ func NewWebApp(app *App, shell *Shell) *WebApp {
 		app:                app,
 		shell:              shell,
 		mux:                http.NewServeMux(),
-		logger:             log.New(os.Stdout, "[web] ", log.LstdFlags),
+		logger:             slog.Default().With("component", "web"),
 		SessionIDGenerator: uuid.NewString,
 		ItemIDGenerator:    uuid.NewString,
 		CurrentTime:        time.Now,
@@ -58,10 +60,18 @@ func (web *WebApp) registerRoutes() {
 	}
 
 	routes := web.mux
+	routes.HandleFunc("/healthz", web.PageHealthz)
+	routes.HandleFunc("/readyz", web.PageReadyz)
+	routes.HandleFunc("/metrics", web.PageMetrics)
 	routes.HandleFunc("/notify", web.DoNotify)
 	routes.HandleFunc("/comment", web.DoComment)
+	routes.HandleFunc("/comment/edit", web.DoEditComment)
+	routes.HandleFunc("/comment/delete", web.DoDeleteOwnComment)
+	routes.HandleFunc("/submit/title", web.DoEditSubmissionTitle)
 	routes.HandleFunc("/item", web.PageItem)
 	routes.HandleFunc("/upvote", web.DoUpvote)
+	routes.HandleFunc("/downvote", web.DoDownvote)
+	routes.HandleFunc("/unvote", web.DoRetractVote)
 	routes.HandleFunc("/submit", web.PageSubmit)
 	routes.HandleFunc("/logout", web.DoLogOut)
 	routes.HandleFunc("/login", web.PageLogin)
@@ -75,18 +85,70 @@ func (web *WebApp) registerRoutes() {
 	routes.HandleFunc("/admin/a/unhide-comment", web.AdminOnly(web.DoUnhideComment))
 	routes.HandleFunc("/admin/a/hide-comment", web.AdminOnly(web.DoHideComment))
 	routes.HandleFunc("/admin/events", web.AdminOnly(web.PageEventLog))
+	routes.HandleFunc("/admin/replay", web.AdminOnly(web.PageReplayReport))
+	routes.HandleFunc("/admin/edits", web.AdminOnly(web.PageEditHistory))
+	routes.HandleFunc("/admin/ranking", web.AdminOnly(web.PageRankingPolicy))
 	routes.Handle("/favicon.ico", http.FileServer(http.FS(staticFiles)))
 	routes.Handle("/s/", http.StripPrefix("/s/", staticFileServer))
 	routes.HandleFunc("/", web.PageIndex)
 }
 
 func (web *WebApp) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	web.logger.Printf("%s %s", req.Method, req.URL)
+	started := time.Now()
 	web.app.Replay(true)
 	w.Header().Set("X-T", web.CurrentTime().Format(time.RFC3339))
-	web.mux.ServeHTTP(w, req)
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	web.mux.ServeHTTP(recorder, req.WithContext(WithCommandOrigin(req.Context(), "web", requestID)))
+	web.logger.Info("request",
+		"method", req.Method,
+		"path", req.URL.String(),
+		"status", recorder.status,
+		"duration", time.Since(started),
+		"request_id", requestID,
+	)
+}
+
+// requestLogger returns the logger of the web app with the ID of the request req.
+func (web *WebApp) requestLogger(req *http.Request) *slog.Logger {
+	if origin, ok := CommandOriginFromEnv(req.Context()); ok {
+		return web.logger.With("request_id", origin.CorrelationID)
+	}
+	return web.logger
+}
+
+// statusRecorder remembers the status code written to the response, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(data)
 }
 
+// Flush supports streaming responses like /notify.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
 type WithGzipFS struct {
 	fileServer   http.Handler
 	fs           fs.FS
@@ -208,6 +270,25 @@ func (web *WebApp) PageData(req *http.Request) *pages.PageData {
 	return pageData
 }
 
+// appAt returns the app to run page queries against.  Admins can pass an
+// "at" parameter, a command ID or RFC 3339 time, to view the site as it was
+// at that point in the command log.  The returned function releases the app.
+func (web *WebApp) appAt(req *http.Request, pageData *pages.PageData) (*App, func(), error) {
+	at := req.FormValue("at")
+	if at == "" || !pageData.IsAdmin {
+		return web.app, func() {}, nil
+	}
+	id, err := ResolveCommandID(web.app.Commands, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	app, err := HistoricalApp(web.app.Commands, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, func() { app.Close() }, nil
+}
+
 func isHX(req *http.Request) bool {
 	return req.Header.Get("HX-Request") != ""
 }
@@ -27,7 +27,7 @@ func (web *WebApp) DoHideComment(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if err != nil {
-		web.logger.Printf("error hiding comment: %s", err)
+		web.requestLogger(req).Error("failed to hide comment", "item_id", itemID, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"orange/pages"
+	"slices"
+	"strconv"
+)
+
+// PageRankingPolicy shows the ranking policy and previews a candidate
+// policy given in the query, which is applied when posted.
+func (web *WebApp) PageRankingPolicy(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	if req.Method == "POST" {
+		sessionID, _ := req.Cookie("session_id")
+		if sessionID != nil {
+			req.Form.Set("sessionID", sessionID.Value)
+		}
+		_, err := web.shell.Do(req.Context(), &Request{
+			Headers:    Dict{"Name": "SetRankingPolicy", "Kind": "command"},
+			Parameters: req.Form,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, req, "/admin/ranking", http.StatusSeeOther)
+		return
+	}
+
+	pageData := web.PageData(req)
+	current := &GetRankingPolicy{}
+	if err := web.app.HandleQuery(current); err != nil {
+		web.requestLogger(req).Error("failed to load ranking policy", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	form := pages.NewFormState()
+	preview := []*pages.RankingPreviewEntry{}
+	candidate := current.Policy
+	if req.Form.Get("algorithm") != "" {
+		policy, err := ParseRankingPolicy(req.Form)
+		if err != nil {
+			form.AddError("algorithm", err.Error())
+		} else {
+			candidate = policy
+			preview, err = web.previewRanking(candidate)
+			if err != nil {
+				web.requestLogger(req).Error("failed to preview ranking policy", "error", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	form.SetValue("algorithm", candidate.Algorithm)
+	form.SetValue("gravity", strconv.FormatFloat(candidate.Gravity, 'g', -1, 64))
+	form.SetValue("decay", strconv.FormatFloat(candidate.Decay, 'g', -1, 64))
+	form.SetValue("commentWeight", strconv.FormatFloat(candidate.CommentWeight, 'g', -1, 64))
+
+	algorithms := []string{}
+	for algorithm := range RankingAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	slices.Sort(algorithms)
+	pages.RankingPolicyPage(req.URL.Path, current.Policy.String(), algorithms, form, preview, pageData).Render(w)
+}
+
+func (web *WebApp) previewRanking(candidate *RankingPolicy) ([]*pages.RankingPreviewEntry, error) {
+	q := NewPreviewRankingPolicy(candidate, web.CurrentTime())
+	if err := web.app.HandleQuery(q); err != nil {
+		return nil, err
+	}
+	entries := make([]*pages.RankingPreviewEntry, len(q.Entries))
+	for i, entry := range q.Entries {
+		entries[i] = &pages.RankingPreviewEntry{
+			ItemID:            entry.Submission.ItemID,
+			Title:             entry.Submission.Title,
+			Position:          entry.Position,
+			Score:             entry.Score,
+			CandidatePosition: entry.CandidatePosition,
+			CandidateScore:    entry.CandidateScore,
+		}
+	}
+	return entries, nil
+}
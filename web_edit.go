@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"orange/pages"
+	"time"
+)
+
+// DoEditComment shows the form for editing a comment on GET and edits it on POST.
+func (web *WebApp) DoEditComment(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	sessionID, _ := req.Cookie("session_id")
+	if sessionID == nil || sessionID.Value == "" {
+		web.LogInFirst(w, req)
+		return
+	}
+	itemID := req.FormValue("itemID")
+
+	if req.Method == "GET" {
+		id := NewTreeID(itemID)
+		submission, err := web.findEditable(req, id.Root())
+		if err != nil {
+			web.refuseToEdit(w, req, err)
+			return
+		}
+		comment := submission.Comment(id)
+		if comment == nil {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		if !comment.ViewerCanEdit {
+			http.Error(w, ErrNotAuthor.Error(), http.StatusForbidden)
+			return
+		}
+		state := pages.NewFormState()
+		state.SetValue("text", comment.Content)
+		pages.CommentEditForm(itemID, state).Render(w)
+		return
+	}
+
+	req.Form.Set("sessionID", sessionID.Value)
+	_, err := web.shell.Do(req.Context(), &Request{
+		Headers:    Dict{"Name": "EditComment", "Kind": "command"},
+		Parameters: req.Form,
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		web.LogInFirst(w, req)
+		return
+	}
+	if err != nil {
+		state := pages.NewFormState()
+		state.SetValue("text", req.FormValue("text"))
+		state.AddError("text", err.Error())
+		pages.CommentEditForm(itemID, state).Render(w)
+		return
+	}
+	web.backToItem(w, req, itemID)
+}
+
+// DoDeleteOwnComment deletes a comment of the current user.
+func (web *WebApp) DoDeleteOwnComment(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	}
+	req.ParseForm()
+	sessionID, _ := req.Cookie("session_id")
+	if sessionID == nil || sessionID.Value == "" {
+		web.LogInFirst(w, req)
+		return
+	}
+	itemID := req.FormValue("itemID")
+	req.Form.Set("sessionID", sessionID.Value)
+	_, err := web.shell.Do(req.Context(), &Request{
+		Headers:    Dict{"Name": "DeleteOwnComment", "Kind": "command"},
+		Parameters: req.Form,
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		web.LogInFirst(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	web.backToItem(w, req, itemID)
+}
+
+// DoEditSubmissionTitle shows the form for editing the title of a submission on GET and edits it on POST.
+func (web *WebApp) DoEditSubmissionTitle(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	sessionID, _ := req.Cookie("session_id")
+	if sessionID == nil || sessionID.Value == "" {
+		web.LogInFirst(w, req)
+		return
+	}
+	itemID := req.FormValue("itemID")
+
+	if req.Method == "GET" {
+		submission, err := web.findEditable(req, itemID)
+		if err != nil {
+			web.refuseToEdit(w, req, err)
+			return
+		}
+		if !submission.ViewerCanEdit {
+			http.Error(w, ErrNotAuthor.Error(), http.StatusForbidden)
+			return
+		}
+		state := pages.NewFormState()
+		state.SetValue("title", submission.Title)
+		pages.EditTitleForm(itemID, state).Render(w)
+		return
+	}
+
+	req.Form.Set("sessionID", sessionID.Value)
+	_, err := web.shell.Do(req.Context(), &Request{
+		Headers:    Dict{"Name": "EditSubmissionTitle", "Kind": "command"},
+		Parameters: req.Form,
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		web.LogInFirst(w, req)
+		return
+	}
+	if err != nil {
+		state := pages.NewFormState()
+		state.SetValue("title", req.FormValue("title"))
+		state.AddError("title", err.Error())
+		pages.EditTitleForm(itemID, state).Render(w)
+		return
+	}
+	web.backToItem(w, req, itemID)
+}
+
+// PageEditHistory shows the earlier versions of a submission or comment to admins.
+func (web *WebApp) PageEditHistory(w http.ResponseWriter, req *http.Request) {
+	pageData := web.PageData(req)
+	itemID := req.FormValue("id")
+	q := NewGetEditHistory(itemID)
+	if err := web.app.HandleQuery(q); err != nil {
+		web.requestLogger(req).Error("failed to load edit history", "item_id", itemID, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	edits := []*pages.EditHistoryEntry{}
+	for _, edit := range q.Edits {
+		edits = append(edits, &pages.EditHistoryEntry{
+			Field:    edit.Field,
+			Previous: edit.Previous,
+			EditedBy: edit.EditedBy,
+			EditedAt: edit.EditedAt.Format(time.RFC3339),
+		})
+	}
+	pages.EditHistoryPage(req.URL.Path, itemID, edits, pageData).Render(w)
+}
+
+// findEditable returns the submission itemID with everything the current
+// user can edit marked, like it is on the item page.
+func (web *WebApp) findEditable(req *http.Request, itemID string) (*Submission, error) {
+	currentUser := web.CurrentUser(req)
+	if currentUser == nil {
+		return nil, ErrSessionNotFound
+	}
+	q := NewFindSubmission(itemID)
+	q.Viewer = &currentUser.Username
+	q.At = web.CurrentTime()
+	if err := web.app.HandleQuery(q); err != nil {
+		return nil, err
+	}
+	return q.Submission, nil
+}
+
+// refuseToEdit responds to a request for an edit form that findEditable failed for.
+func (web *WebApp) refuseToEdit(w http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		web.LogInFirst(w, req)
+	case errors.Is(err, ErrItemNotFound):
+		http.Error(w, "item not found", http.StatusNotFound)
+	default:
+		web.requestLogger(req).Error("failed to load item to edit", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// backToItem sends the browser back to the page of the submission itemID belongs to.
+func (web *WebApp) backToItem(w http.ResponseWriter, req *http.Request, itemID string) {
+	location := "/item?id=" + NewTreeID(itemID).Root()
+	if isHX(req) {
+		w.Header().Set("HX-Redirect", location)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, req, location, http.StatusSeeOther)
+}
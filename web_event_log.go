@@ -7,6 +7,7 @@ import (
 	"orange/pages"
 	"slices"
 	"strconv"
+	"time"
 )
 
 func (web *WebApp) PageEventLog(w http.ResponseWriter, req *http.Request) {
@@ -51,16 +52,28 @@ func (web *WebApp) PageEventLog(w http.ResponseWriter, req *http.Request) {
 		} else {
 			n--
 		}
-		allCommands = append(allCommands, &pages.EventLogEntry{
+		entry := &pages.EventLogEntry{
 			ID:      fmt.Sprintf("%d", command.ID),
 			Kind:    command.Message.CommandName(),
 			Payload: command.Message,
-		})
+		}
+		if metadata := command.Metadata; metadata != nil {
+			if !metadata.RecordedAt.IsZero() {
+				entry.RecordedAt = metadata.RecordedAt.Format(time.RFC3339)
+			}
+			entry.Actor = metadata.Actor
+			entry.Origin = metadata.Origin
+			entry.CorrelationID = metadata.CorrelationID
+			if metadata.CausationID != 0 {
+				entry.CausationID = fmt.Sprintf("%d", metadata.CausationID)
+			}
+		}
+		allCommands = append(allCommands, entry)
 	}
 	slices.Reverse(allCommands)
 	pages.EventLogPage(req.URL.Path, allCommands, pageData).Render(w)
 	return
 internalError:
-	web.logger.Printf("failed to load commands: %v", err)
+	web.requestLogger(req).Error("failed to load commands", "error", err)
 	http.Error(w, "internal server error", http.StatusInternalServerError)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// PageHealthz reports the health of the app as JSON, failing with 503 if
+// a background process is stuck.
+func (web *WebApp) PageHealthz(w http.ResponseWriter, req *http.Request) {
+	web.writeHealthReport(w, req, (*HealthReport).Healthy)
+}
+
+// PageReadyz reports the health of the app as JSON, failing with 503
+// until the command log has been replayed.
+func (web *WebApp) PageReadyz(w http.ResponseWriter, req *http.Request) {
+	web.writeHealthReport(w, req, (*HealthReport).Ready)
+}
+
+func (web *WebApp) writeHealthReport(w http.ResponseWriter, req *http.Request, ok func(*HealthReport) bool) {
+	report, err := web.app.Health(web.Starters, web.CurrentTime())
+	if err != nil {
+		web.requestLogger(req).Error("failed to determine health", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ok(report) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// PageMetrics writes the metrics of the app in the Prometheus text format.
+func (web *WebApp) PageMetrics(w http.ResponseWriter, req *http.Request) {
+	metrics := web.app.Metrics
+	report, err := web.app.Health(web.Starters, web.CurrentTime())
+	if err != nil {
+		web.requestLogger(req).Error("failed to determine health", "error", err)
+	} else {
+		metrics.Set("orange_app_version", "ID of the last command applied by the app.", float64(report.Version))
+		metrics.Set("orange_command_log_length", "Number of commands in the command log.", float64(report.Length))
+		for _, starter := range report.Starters {
+			metrics.Set("orange_starter_version", "ID of the last command processed by a background process.", float64(starter.Version), "starter", starter.Name)
+			metrics.Set("orange_starter_lag", "Number of commands a background process has yet to process.", float64(starter.Lag), "starter", starter.Name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteTo(w)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type reportingStarter struct {
+	progress Progress
+}
+
+func (s *reportingStarter) Start() func()       { return func() {} }
+func (s *reportingStarter) Progress() *Progress { return &s.progress }
+
+func Test_WebApp_Readyz_reports_replay_status(t *testing.T) {
+	web := NewWebTest(t)
+	res := web.send("GET", "/readyz")
+	if status := res.raw.Result().StatusCode; status != http.StatusOK {
+		t.Fatalf("expected app to be ready after replaying, got %d", status)
+	}
+	report := &HealthReport{}
+	if err := json.NewDecoder(res.raw.Body).Decode(report); err != nil {
+		t.Fatalf("failed to decode health report: %s", err)
+	}
+	if !report.Replayed || report.Lag != 0 {
+		t.Fatalf("expected replayed app without lag, got %+v", report)
+	}
+}
+
+func Test_WebApp_Healthz_reports_stuck_starters(t *testing.T) {
+	web := NewWebTest(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	web.web.CurrentTime = func() time.Time { return now }
+	starter := &reportingStarter{}
+	starter.progress.Record(0, now.Add(-2*StuckAfter))
+	web.web.Starters = []Starter{starter}
+
+	if status := web.send("GET", "/healthz").raw.Result().StatusCode; status != http.StatusOK {
+		t.Fatalf("expected starter without lag to be healthy, got %d", status)
+	}
+
+	if err := web.web.app.HandleCommand(&SignUpUser{Username: "admin"}); err != nil {
+		t.Fatalf("failed to sign up: %s", err)
+	}
+	if status := web.send("GET", "/healthz").raw.Result().StatusCode; status != http.StatusServiceUnavailable {
+		t.Fatalf("expected lagging starter to be stuck, got %d", status)
+	}
+}
+
+func Test_WebApp_Metrics_counts_commands(t *testing.T) {
+	web := NewWebTest(t)
+	web.web.app.HandleCommand(&SignUpUser{Username: "admin"})
+	web.web.app.HandleCommand(&SignUpUser{Username: "admin"})
+
+	body := web.send("GET", "/metrics").raw.Body.String()
+	for _, line := range []string{
+		`orange_commands_handled_total{command="SignUpUser",result="accepted"} 1`,
+		`orange_commands_handled_total{command="SignUpUser",result="rejected"} 1`,
+		`orange_app_version 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -14,7 +14,14 @@ func (web *WebApp) PageIndex(w http.ResponseWriter, req *http.Request) {
 		q.After = after
 	}
 
-	if err := web.app.HandleQuery(q); err != nil {
+	app, release, err := web.appAt(req, pageData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer release()
+
+	if err := app.HandleQuery(q); err != nil {
 		http.Error(w, "failed to load front page", http.StatusInternalServerError)
 		return
 	}
@@ -46,14 +53,19 @@ func (web *WebApp) PageIndex(w http.ResponseWriter, req *http.Request) {
 			Submitter:      submission.Submitter,
 			VoteCount:      submission.VoteCount,
 			CommentCount:   submission.CommentCount,
-			CanVote:        !submission.ViewerHasVoted,
+			ViewerVote:     int(submission.ViewerVote),
+			CanDownvote:    submission.ViewerCanDownvote,
 		})
 		index++
 	}
 
 	if len(templateData) >= 10 {
 		pageData.LoadMore = &url.URL{Path: req.URL.Path}
-		pageData.LoadMore.RawQuery = (&url.Values{"after": []string{strconv.Itoa(q.After + 10)}}).Encode()
+		loadMore := url.Values{"after": []string{strconv.Itoa(q.After + 10)}}
+		if at := req.FormValue("at"); at != "" && pageData.IsAdmin {
+			loadMore.Set("at", at)
+		}
+		pageData.LoadMore.RawQuery = loadMore.Encode()
 	}
 
 	pageData.OpenGraph.Title = "The Orange Website"
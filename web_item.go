@@ -10,7 +10,15 @@ func (web *WebApp) PageItem(w http.ResponseWriter, req *http.Request) {
 	pageData := web.PageData(req)
 	treeID := NewTreeID(req.FormValue("id"))
 	q := NewFindSubmission(treeID.Root())
-	if err := web.app.HandleQuery(q); err != nil {
+	q.Viewer = pageData.Username()
+	q.At = web.CurrentTime()
+	app, release, err := web.appAt(req, pageData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer release()
+	if err := app.HandleQuery(q); err != nil {
 		if errors.Is(err, ErrItemNotFound) {
 			http.Error(w, "item not found", http.StatusNotFound)
 			return
@@ -25,9 +33,9 @@ func (web *WebApp) PageItem(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "item not found", http.StatusNotFound)
 			return
 		}
-		comments = []pages.Comment{comment}
+		comments = []pages.Comment{rankComments([]*Comment{comment})[0]}
 	} else {
-		for _, c := range q.Submission.Comments {
+		for _, c := range rankComments(q.Submission.Comments) {
 			comments = append(comments, c)
 		}
 	}
@@ -35,10 +43,15 @@ func (web *WebApp) PageItem(w http.ResponseWriter, req *http.Request) {
 		ItemID:       q.Submission.ItemID,
 		Title:        q.Submission.Title,
 		Url:          q.Submission.Url,
+		BodyHTML:     q.Submission.BodyHTML(),
 		SubmittedAt:  q.Submission.SubmittedAt,
 		Submitter:    q.Submission.Submitter,
 		VoteCount:    q.Submission.VoteCount,
+		ViewerVote:   int(q.Submission.ViewerVote),
+		CanDownvote:  q.Submission.ViewerCanDownvote,
 		CommentCount: q.Submission.CommentCount,
+		Edited:       !q.Submission.EditedAt.IsZero(),
+		CanEdit:      q.Submission.ViewerCanEdit,
 		Comments:     comments,
 	}
 	pages.ItemPage("/item", templateData, pageData).Render(w)
@@ -42,7 +42,7 @@ func (web *WebApp) handleLogIn(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	if err != nil {
-		web.logger.Printf("failed to log in: %v", err)
+		web.requestLogger(req).Error("failed to log in", "username", req.FormValue("username"), "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 
@@ -19,7 +19,7 @@ func (web *WebApp) PageRequestLoginWithMagic(w http.ResponseWriter, req *http.Re
 		}
 		// ignore errors, as we don't want to leak if an email is registered
 		if _, err := web.shell.Do(req.Context(), requestLoginWithMagic); err != nil {
-			web.logger.Printf("failed to request magic link: %v", err)
+			web.requestLogger(req).Warn("failed to request magic link", "error", err)
 		}
 
 		if isHX(req) {
@@ -44,7 +44,7 @@ func (web *WebApp) PageLoginWithMagic(w http.ResponseWriter, req *http.Request)
 	}
 	_, err := web.shell.Do(req.Context(), logInWithMagic)
 	if err != nil {
-		web.logger.Printf("failed to log in with magic: %s", err)
+		web.requestLogger(req).Warn("failed to log in with magic link", "error", err)
 		pageData := web.PageData(req)
 		pages.ForbiddenMagicPage(req.URL.Path, pageData).Render(w)
 		return
@@ -28,7 +28,7 @@ func (web *WebApp) PageMe(w http.ResponseWriter, req *http.Request) {
 	}
 	q := NewSubscriptionSettingsForUserQuery(currentUser.Username)
 	if err := web.app.HandleQuery(q); err != nil && !errors.Is(err, ErrSubscriptionSettingsNotFound) {
-		web.logger.Printf("Failed to retrieve subscription settings for %q: %s", currentUser.Username, err)
+		web.requestLogger(req).Error("failed to retrieve subscription settings", "username", currentUser.Username, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	} else if err == nil {
@@ -77,14 +77,14 @@ func (web *WebApp) pageMeUpdateSettings(w http.ResponseWriter, req *http.Request
 
 	if len(paramsEnable) > 0 {
 		if _, err := web.shell.Do(req.Context(), updateEnable); err != nil {
-			web.logger.Printf("pageMeUpdateSettings: %s", err)
+			web.requestLogger(req).Error("failed to update subscription settings", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	}
 	if len(paramsDisable) > 0 {
 		if _, err := web.shell.Do(req.Context(), updateDisable); err != nil {
-			web.logger.Printf("pageMeUpdateSettings: %s", err)
+			web.requestLogger(req).Error("failed to update subscription settings", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
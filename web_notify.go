@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"time"
 )
 
 type Notification struct {
@@ -37,6 +36,8 @@ func NewNotificationFromCommand(cmd Command, app *App) (*Notification, bool) {
 func (web *WebApp) DoNotify(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
+	wake, stopWaiting := web.app.WaitForCommands()
+	defer stopWaiting()
 	commands, err := web.app.Commands.After(0)
 	if err != nil {
 		http.Error(w, "failed to subscribe", http.StatusInternalServerError)
@@ -46,14 +47,13 @@ func (web *WebApp) DoNotify(w http.ResponseWriter, req *http.Request) {
 	for cmd := range commands {
 		lastSeen = cmd.ID
 	}
-	web.logger.Printf("notify(%d): from %s", lastSeen, req.RemoteAddr)
-	everySecond := time.NewTicker(1 * time.Second)
+	web.requestLogger(req).Debug("subscribed to notifications", "version", lastSeen, "remote_addr", req.RemoteAddr)
 	for {
 		select {
 		case <-req.Context().Done():
-			web.logger.Printf("notify(%d): from %s: done", lastSeen, req.RemoteAddr)
+			web.requestLogger(req).Debug("unsubscribed from notifications", "version", lastSeen, "remote_addr", req.RemoteAddr)
 			return
-		case <-everySecond.C:
+		case <-wake:
 			newCommands, err := web.app.Commands.After(lastSeen)
 			if err != nil {
 				continue
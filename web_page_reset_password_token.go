@@ -37,7 +37,7 @@ func (web *WebApp) PageResetPasswordToken(w http.ResponseWriter, req *http.Reque
 		}
 		_, err := web.shell.Do(req.Context(), resetPassword)
 		if err != nil && !errors.Is(err, ErrUserNotFound) {
-			web.logger.Printf("error resetting password: %s", err)
+			web.requestLogger(req).Error("failed to reset password", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		if isHX(req) {
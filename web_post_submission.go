@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"orange/pages"
@@ -12,10 +13,11 @@ func (web *WebApp) PageSubmit(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
 		if currentUser == nil {
-			http.Redirect(w, req, "/login?back_to="+url.QueryEscape("/submit"), http.StatusSeeOther)
+			http.Redirect(w, req, "/login?back_to="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
 			return
 		}
 		form := pages.NewFormState()
+		form.SetValue("kind", req.FormValue("kind"))
 		pages.SubmitPage(req.URL.Path, form, web.PageData(req)).Render(w)
 	case "POST":
 		web.handleSubmission(w, req)
@@ -31,6 +33,10 @@ func (web *WebApp) handleSubmission(w http.ResponseWriter, req *http.Request) {
 
 	form := pages.NewFormState()
 	form.SetValue("title", req.Form.Get("title"))
+	if req.Form.Get("kind") == "text" {
+		web.handleTextSubmission(w, req, form)
+		return
+	}
 	form.SetValue("url", req.Form.Get("url"))
 
 	if !LinkIsLive(form.Values["url"]) {
@@ -61,3 +67,32 @@ func (web *WebApp) handleSubmission(w http.ResponseWriter, req *http.Request) {
 
 	http.Redirect(w, req, "/", http.StatusSeeOther)
 }
+
+// handleTextSubmission posts a discussion thread with a Markdown body instead of a link.
+func (web *WebApp) handleTextSubmission(w http.ResponseWriter, req *http.Request, form *pages.FormState) {
+	pageData := web.PageData(req)
+	form.SetValue("kind", "text")
+	form.SetValue("text", req.Form.Get("text"))
+
+	submit := &Request{
+		Headers:    Dict{"Name": "PostText", "Kind": "command"},
+		Parameters: req.Form,
+	}
+	_, err := web.shell.Do(req.Context(), submit)
+	if errors.Is(err, ErrEmptyTitle) {
+		form.AddError("title", ErrEmptyTitle.Error())
+	}
+	if errors.Is(err, ErrEmptyText) {
+		form.AddError("text", ErrEmptyText.Error())
+	}
+	if errors.Is(err, ErrTextTooLong) {
+		form.AddError("text", fmt.Sprintf("Text must be at most %d characters", MAX_TEXT_LENGTH_IN_CHARACTERS))
+	}
+
+	if form.HasErrors() {
+		pages.SubmitPage(req.URL.Path, form, pageData).Render(w)
+		return
+	}
+
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"orange/pages"
+	"time"
+)
+
+func (web *WebApp) PageReplayReport(w http.ResponseWriter, req *http.Request) {
+	pageData := web.PageData(req)
+
+	failures := []*pages.ReplayFailureEntry{}
+	for _, failure := range web.app.ReplayFailures() {
+		failures = append(failures, &pages.ReplayFailureEntry{
+			CommandID:   failure.CommandID,
+			CommandName: failure.CommandName,
+			Handler:     failure.Handler,
+			Error:       failure.Error,
+			FailedAt:    failure.FailedAt.Format(time.RFC3339),
+			Quarantined: failure.Quarantined,
+		})
+	}
+
+	quarantinedCommands, err := web.app.QuarantinedCommands()
+	if err != nil {
+		web.requestLogger(req).Error("failed to load quarantined commands", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	quarantined := []*pages.ReplayFailureEntry{}
+	for _, command := range quarantinedCommands {
+		cmd := command.Message.(*QuarantinedCommand)
+		quarantined = append(quarantined, &pages.ReplayFailureEntry{
+			CommandID:   command.ID,
+			CommandName: cmd.Command,
+			Handler:     cmd.Handler,
+			Error:       cmd.Error,
+			FailedAt:    cmd.QuarantinedAt.Format(time.RFC3339),
+			Quarantined: true,
+		})
+	}
+
+	pages.ReplayReportPage(req.URL.Path, failures, quarantined, pageData).Render(w)
+}
@@ -30,7 +30,7 @@ func (web *WebApp) PageResetPassword(w http.ResponseWriter, req *http.Request) {
 		}
 		_, err := web.shell.Do(req.Context(), requestPasswordReset)
 		if err != nil && !errors.Is(err, ErrUserNotFound) {
-			web.logger.Printf("error requesting password reset: %s", err)
+			web.requestLogger(req).Error("failed to request password reset", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 
@@ -3,10 +3,11 @@ package main
 import (
 	"bytes"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,9 +34,12 @@ type WebTest struct {
 	test *testing.T
 }
 
-func (w *WebTest) send(method, path string) *Response {
+func (w *WebTest) send(method, path string, options ...RequestOption) *Response {
 	w.test.Logf("-> %s %s", method, path)
 	req := httptest.NewRequest(method, path, nil)
+	for _, opt := range options {
+		opt.BuildRequest(req)
+	}
 	res := httptest.NewRecorder()
 	w.web.ServeHTTP(res, req)
 	w.test.Logf("<- %s %s %d", method, path, res.Result().StatusCode)
@@ -140,7 +144,7 @@ func NewWebTest(t *testing.T) *WebTest {
 	shell := NewDefaultShell(app)
 	web := NewWebApp(app, shell)
 	logs := bytes.NewBuffer(nil)
-	web.logger = log.New(logs, "[web]", log.LstdFlags)
+	web.logger = slog.New(slog.NewTextHandler(logs, nil))
 	return &WebTest{web: web, logs: logs, test: t}
 }
 
@@ -208,3 +212,86 @@ func (s *WebSession) GoToSubmission(index int) {
 		s.location = response.Location()
 	}
 }
+
+func TestWebApp_RecordsCommandMetadata(t *testing.T) {
+	w := NewWebTest(t)
+	w.RegisterUser("alice")
+	session := w.LogInAs("alice")
+	w.post("/upvote", url.Values{"itemID": []string{"item-1"}}, SetCookie("session_id", session.sessionID))
+
+	commands, err := w.web.app.Commands.After(0)
+	if err != nil {
+		t.Fatalf("failed to read log: %s", err)
+	}
+	var upvote *PersistedCommand
+	for command := range commands {
+		if command.Message.CommandName() == "UpvoteSubmission" {
+			upvote = command
+		}
+	}
+	if upvote == nil {
+		t.Fatalf("expected upvote to be recorded")
+	}
+	if act, exp := upvote.Metadata.Actor, "alice"; act != exp {
+		t.Errorf("expected actor %q, got %q", exp, act)
+	}
+	if act, exp := upvote.Metadata.Origin, "web"; act != exp {
+		t.Errorf("expected origin %q, got %q", exp, act)
+	}
+	if upvote.Metadata.CorrelationID == "" || upvote.Metadata.RecordedAt.IsZero() {
+		t.Errorf("expected correlation ID and time to be recorded, got %s", upvote.Metadata)
+	}
+}
+
+func TestWebApp_PageItem_displays_text_submissions(t *testing.T) {
+	w := NewWebTest(t)
+	w.web.ItemIDGenerator = func() string { return "text-1" }
+	w.RegisterUser("alice")
+	session := w.LogInAs("alice")
+	response := w.post("/submit", url.Values{
+		"kind":  []string{"text"},
+		"title": []string{"Ask: editors"},
+		"text":  []string{"Which *one* do you use?"},
+	}, SetCookie("session_id", session.sessionID))
+	if loc := response.Location(); loc == nil || loc.Path != "/" {
+		t.Fatalf("expected redirect to front page, got %v: %s", loc, response.raw.Body.String())
+	}
+
+	body := w.send("GET", "/item?id=text-1").raw.Body.String()
+	if !strings.Contains(body, "Ask: editors") || !strings.Contains(body, "<em>one</em>") {
+		t.Fatalf("expected item page to show title and rendered text, got %s", body)
+	}
+}
+
+func TestWebApp_DoEditComment_OnlyShowsFormForOwnVisibleComments(t *testing.T) {
+	w := NewWebTest(t)
+	w.RegisterUser("alice")
+	w.RegisterUser("bob")
+	for _, command := range []Command{
+		&PostLink{ItemID: "post-1", Submitter: "alice", Url: "https://example.com", Title: "Example", SubmittedAt: time.Now()},
+		&PostComment{ParentID: NewTreeID("post-1"), Author: "alice", Content: "alice's secret draft", PostedAt: time.Now()},
+		&PostComment{ParentID: NewTreeID("post-1"), Author: "bob", Content: "bob's hidden remark", PostedAt: time.Now()},
+		&HideComment{CommentID: NewTreeID("post-1").And(1), HiddenAt: time.Now(), HiddenBy: "admin"},
+	} {
+		if err := w.web.app.HandleCommand(command); err != nil {
+			t.Fatalf("failed to handle %s: %s", command.CommandName(), err)
+		}
+	}
+	alice, bob := w.LogInAs("alice"), w.LogInAs("bob")
+	editForm := func(session *WebSession, commentID TreeID) *Response {
+		return w.send("GET", "/comment/edit?itemID="+url.QueryEscape(commentID.String()), SetCookie("session_id", session.sessionID))
+	}
+
+	if res := editForm(alice, NewTreeID("post-1").And(0)); res.raw.Code != http.StatusOK || !strings.Contains(res.raw.Body.String(), "alice&#39;s secret draft") {
+		t.Fatalf("expected author to get the form, got %d: %s", res.raw.Code, res.raw.Body.String())
+	}
+	for name, res := range map[string]*Response{
+		"another user's comment": editForm(bob, NewTreeID("post-1").And(0)),
+		"hidden comment":         editForm(bob, NewTreeID("post-1").And(1)),
+	} {
+		body := res.raw.Body.String()
+		if res.raw.Code != http.StatusForbidden || strings.Contains(body, "secret draft") || strings.Contains(body, "hidden remark") {
+			t.Fatalf("expected %s to be refused, got %d: %s", name, res.raw.Code, body)
+		}
+	}
+}
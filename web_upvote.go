@@ -7,34 +7,57 @@ import (
 )
 
 func (web *WebApp) DoUpvote(w http.ResponseWriter, req *http.Request) {
+	web.doVote(w, req, "Upvote", Upvote)
+}
+
+func (web *WebApp) DoDownvote(w http.ResponseWriter, req *http.Request) {
+	web.doVote(w, req, "Downvote", Downvote)
+}
+
+func (web *WebApp) DoRetractVote(w http.ResponseWriter, req *http.Request) {
+	web.doVote(w, req, "RetractVote", NoVote)
+}
+
+// doVote runs the shell command name on the item being voted on and
+// renders the buttons for the vote the user has cast afterwards.
+func (web *WebApp) doVote(w http.ResponseWriter, req *http.Request, name string, direction VoteDirection) {
 	if req.Method != "POST" {
 		http.Redirect(w, req, "/", http.StatusSeeOther)
 		return
 	}
 	req.ParseForm()
-	sessionID, _ := req.Cookie("session_id")
 	itemID := req.Form.Get("itemID")
+	sessionID, _ := req.Cookie("session_id")
+	if sessionID == nil {
+		pages.VoteButtons(itemID, 0, false).Render(w)
+		return
+	}
 	req.Form.Set("sessionID", sessionID.Value)
 
-	upvote := &Request{
-		Headers:    Dict{"Name": "Upvote", "Kind": "command"},
+	vote := &Request{
+		Headers:    Dict{"Name": name, "Kind": "command"},
 		Parameters: req.Form,
 	}
-	_, err := web.shell.Do(req.Context(), upvote)
+	_, err := web.shell.Do(req.Context(), vote)
 	if errors.Is(err, ErrSessionNotFound) {
-		pages.UpvoteButton(itemID).Render(w)
+		pages.VoteButtons(itemID, 0, false).Render(w)
 		return
 	}
-
-	if err == ErrAlreadyVoted {
-		pages.VotedIcon().Render(w)
+	if errors.Is(err, ErrDownvotesDisabled) || errors.Is(err, ErrNotEnoughKarma) {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrAlreadyVoted) && !errors.Is(err, ErrNotVoted) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	pages.VotedIcon().Render(w)
-	return
+	canDownvote := false
+	if username := web.PageData(req).Username(); username != nil {
+		karma := NewGetKarma(*username)
+		if err := web.app.HandleQuery(karma); err == nil {
+			canDownvote = karma.CanDownvote
+		}
+	}
+	pages.VoteButtons(itemID, int(direction), canDownvote).Render(w)
 }